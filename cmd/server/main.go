@@ -8,16 +8,32 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	appconfig "github.com/kyiku/hackz-ptera-back/internal/config"
+	"github.com/kyiku/hackz-ptera-back/internal/dino"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/game"
 	"github.com/kyiku/hackz-ptera-back/internal/handler"
+	appmiddleware "github.com/kyiku/hackz-ptera-back/internal/middleware"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/penalty"
 	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/room"
 	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/snapshot"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+	"github.com/kyiku/hackz-ptera-back/internal/timeout"
+	"github.com/kyiku/hackz-ptera-back/internal/token"
+	ws "github.com/kyiku/hackz-ptera-back/internal/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
 )
 
 // S3Adapter adapts AWS S3 client to our interface
@@ -112,21 +128,19 @@ func stringPtr(s string) *string {
 	return &s
 }
 
-// QueueAdapter adapts WaitingQueue to QueueInterfaceForCaptcha
-type QueueAdapter struct {
-	queue *queue.WaitingQueue
-}
-
-func (a *QueueAdapter) Add(userID string, conn model.WebSocketConn) {
-	a.queue.Add(userID, conn)
-}
-
-func (a *QueueAdapter) Remove(userID string) {
-	a.queue.Remove(userID)
-}
-
-func (a *QueueAdapter) BroadcastPositions() {
-	a.queue.BroadcastPositions()
+// roomStages runs the stage pipeline of one room: its failure pipeline,
+// deadlines and gate handlers all follow the room's state machine.
+type roomStages struct {
+	failures  *failure.FailureHandler
+	deadlines *game.Deadlines
+	tokens    *token.TokenMonitor
+
+	dino      *handler.DinoHandler
+	register  *handler.RegisterHandler
+	dashboard *handler.DashboardHandler
+	captcha   *handler.CaptchaHandler  // nil without S3
+	otp       *handler.OTPHandler      // nil without S3
+	password  *handler.PasswordHandler // nil without Bedrock
 }
 
 func main() {
@@ -155,9 +169,222 @@ func main() {
 		AllowCredentials: true,
 	}))
 
-	// Initialize dependencies
-	sessionStore := session.NewSessionStore()
-	waitingQueue := queue.NewWaitingQueue()
+	appCfg, err := appconfig.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Initialize dependencies. Everything time-based reads this clock
+	var clk clock.Clock = clock.Real{}
+	sessionStore, err := session.NewBackend(session.Options{
+		Backend:       appCfg.SessionBackend,
+		Path:          appCfg.SessionFilePath,
+		MaxAge:        appCfg.SessionMaxAge,
+		IdleTimeout:   appCfg.SessionIdleTimeout,
+		FlushInterval: appCfg.SessionFlushInterval,
+		Clock:         clk,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open session backend: %v", err)
+	}
+	sessionJanitor := session.NewJanitor(sessionStore, appCfg.SessionSweepInterval)
+	sessionJanitor.SetClock(clk)
+	wsPolicy, err := ws.ParsePolicy(appCfg.WSSessionPolicy)
+	if err != nil {
+		log.Fatalf("Invalid WS_SESSION_POLICY: %v", err)
+	}
+	wsConns := ws.NewRegistry(wsPolicy)
+
+	// Session cookies carry the session ID, issue time and an HMAC
+	var cookieSigner *session.CookieSigner
+	if len(appCfg.SessionCookieKeys) > 0 {
+		keys := make([][]byte, len(appCfg.SessionCookieKeys))
+		for i, key := range appCfg.SessionCookieKeys {
+			keys[i] = []byte(key)
+		}
+		cookieSigner, err = session.NewCookieSigner(keys...)
+	} else {
+		log.Println("Warning: SESSION_COOKIE_KEYS not set, using a random key (sessions won't survive restarts)")
+		cookieSigner, err = session.NewRandomCookieSigner()
+	}
+	if err != nil {
+		log.Fatalf("Invalid SESSION_COOKIE_KEYS: %v", err)
+	}
+	cookieSigner.SetClock(clk)
+	cookieSigner.SetMaxAge(appCfg.SessionMaxAge)
+
+	// Wait time estimate inflation (applies to every queue)
+	var pessimism *queue.PessimismFormula
+	if appCfg.ETAPessimistic {
+		pessimism = &queue.PessimismFormula{
+			Multiplier:  appCfg.ETAPessimismMultiplier,
+			PerPosition: appCfg.ETAPessimismPerUser,
+		}
+	}
+
+	// Named queues, each with its own Dino slots, tease delay, dispatcher and
+	// stage pipeline (every status change goes through the room's state machine)
+	rooms := room.NewManager(sessionStore)
+	for _, qc := range appCfg.Queues {
+		gates := make([]stage.Gate, len(qc.Stages))
+		for i, sc := range qc.Stages {
+			gates[i] = stage.Gate{
+				Name:        sc.Name,
+				Kind:        sc.Kind,
+				Timeout:     sc.Timeout,
+				MaxAttempts: sc.MaxAttempts,
+			}
+		}
+		pipeline, err := stage.NewPipeline(gates)
+		if err != nil {
+			log.Fatalf("Invalid stage pipeline of queue %q: %v", qc.Name, err)
+		}
+
+		rooms.Register(room.New(room.Config{
+			Name:              qc.Name,
+			DinoSlots:         qc.DinoSlots,
+			DelayMinSec:       qc.TeaseDelayMinSec,
+			DelayMaxSec:       qc.TeaseDelayMaxSec,
+			PollInterval:      qc.PollInterval,
+			BroadcastInterval: appCfg.BroadcastInterval,
+			Pessimism:         pessimism,
+			Phantoms: queue.PhantomConfig{
+				Initial:   qc.PhantomInitial,
+				PerJoin:   qc.PhantomPerJoin,
+				MeanLeave: appCfg.PhantomMeanLeave,
+			},
+			Cookies:  cookieSigner,
+			Pipeline: pipeline,
+			Clock:    clk,
+		}, sessionStore, wsConns))
+	}
+
+	// Side effects of every failure, in every queue
+	failureMetrics := failure.NewMetrics()
+	var failureEffects []failure.Effect
+	for _, name := range appCfg.FailureEffects {
+		switch name {
+		case "metrics":
+			failureEffects = append(failureEffects, failureMetrics.Record)
+		case "audit":
+			failureEffects = append(failureEffects, failure.Audit)
+		case "requeue":
+			failureEffects = append(failureEffects, failure.Requeue(rooms))
+		default:
+			log.Printf("Warning: Unknown FAILURE_EFFECTS entry %q (ignored)", name)
+		}
+	}
+
+	// What a failure costs, per stage and reason (admins can change it at runtime)
+	penaltyFallback, err := penalty.Parse(appCfg.PenaltyPolicy)
+	if err != nil {
+		log.Fatalf("Invalid PENALTY_POLICY: %v", err)
+	}
+	penaltyRules := penalty.NewRules(penaltyFallback)
+	if err := penaltyRules.Load(appCfg.PenaltyRules); err != nil {
+		log.Fatalf("Invalid PENALTY_RULES: %v", err)
+	}
+
+	// Every deadline (gate time limits, registration, dashboard tasks) runs on one timing wheel
+	scheduler := timeout.NewScheduler(appCfg.TimeoutTick, timeout.DefaultSlots)
+	scheduler.SetClock(clk)
+	scheduler.Start()
+	for task := range appCfg.RegisterTaskTimeouts {
+		if !model.IsRegisterTask(task) {
+			log.Printf("Warning: Unknown REGISTER_TASK_TIMEOUTS task %q (ignored)", task)
+			delete(appCfg.RegisterTaskTimeouts, task)
+		}
+	}
+
+	// Failures and deadlines follow the state machine of the room a user is in
+	stages := make(map[*room.Room]*roomStages, len(rooms.Rooms()))
+	for _, r := range rooms.Rooms() {
+		machine := r.Machine
+		failures := failure.NewFailureHandler(rooms)
+		failures.SetMachine(machine)
+		failures.Use(failureEffects...)
+		failures.SetPolicy(penaltyRules)
+		failures.SetPlacer(rooms)
+		failures.SetClock(clk)
+
+		deadlines := game.NewDeadlines(scheduler, sessionStore)
+		deadlines.SetFailureHandler(failures)
+		deadlines.SetTaskTimeouts(appCfg.RegisterTaskTimeouts)
+		deadlines.Watch(machine)
+
+		// Registration deadline: clearing Dino issues the register token, and the
+		// scheduler pushes the countdown and fails users whose token runs out
+		pipelineGates := machine.Pipeline().Gates()
+		registerGate := pipelineGates[len(pipelineGates)-1]
+		tokenMonitor := token.NewTokenMonitor(time.Second)
+		tokenMonitor.SetScheduler(scheduler)
+		tokenMonitor.SetExpiry(registerGate.Timeout)
+		tokenMonitor.SetCountdown(appCfg.RegisterCountdown)
+		tokenMonitor.SetWarnings(appCfg.RegisterWarnings...)
+		tokenMonitor.SetOnExpire(failures.HandleTokenExpired)
+		issueToken := func(user *model.User, _ stage.Transition) {
+			if user.RegisterToken == "" {
+				tokenMonitor.Issue(user)
+			}
+		}
+		for _, gate := range pipelineGates {
+			if gate.Kind == stage.KindDino {
+				machine.OnExit(gate.Status, func(user *model.User, tr stage.Transition) {
+					if tr.Event == stage.EventPass {
+						issueToken(user, tr)
+					}
+				})
+			}
+		}
+		// Pipelines without a Dino gate start the deadline on the dashboard
+		machine.OnEnter(registerGate.Status, issueToken)
+		machine.OnEnter(model.StatusWaiting, func(user *model.User, _ stage.Transition) {
+			tokenMonitor.Unwatch(user)
+		})
+
+		stages[r] = &roomStages{failures: failures, deadlines: deadlines, tokens: tokenMonitor}
+	}
+
+	// Expired sessions leave their queue and have their socket closed
+	sessionStore.SetOnEvict(rooms.Evict)
+
+	// Snapshot (restores sessions and queue order saved before the last shutdown)
+	var snapshotter *snapshot.Snapshotter
+	if appCfg.SnapshotPath != "" {
+		snapshotter = snapshot.NewSnapshotter(appCfg.SnapshotPath, sessionStore, rooms)
+		snapshotter.SetInterval(appCfg.SnapshotInterval)
+		snapshotter.SetGraceWindow(appCfg.SnapshotGrace)
+		snapshotter.SetClock(clk)
+
+		restored, err := snapshotter.Restore()
+		if err != nil {
+			log.Printf("Warning: Failed to restore snapshot: %v (starting fresh)", err)
+		} else if restored > 0 {
+			log.Printf("Restored %d sessions from %s", restored, appCfg.SnapshotPath)
+		}
+	}
+
+	// Users who were mid-game keep their stage slot until they leave the gate.
+	// ForEach must not call back into the store, so restore each user under
+	// its lock afterwards
+	var restored []string
+	sessionStore.ForEach(func(sessionID string, _ *model.User, _ time.Time) {
+		restored = append(restored, sessionID)
+	})
+	for _, sessionID := range restored {
+		r := rooms.ForSession(sessionID)
+		_ = sessionStore.Update(sessionID, func(user *model.User) error {
+			if user.Status == r.Machine.Pipeline().First().Status {
+				r.Slots.Acquire(sessionID)
+			}
+			// Restored registration deadlines keep running, gate deadlines start over
+			if user.RegisterToken != "" {
+				stages[r].tokens.Watch(user)
+			}
+			stages[r].deadlines.Resume(user)
+			return nil
+		})
+	}
 
 	// Load AWS config
 	region := os.Getenv("AWS_REGION")
@@ -199,33 +426,60 @@ func main() {
 		}
 	}
 
-	// Queue adapter
-	queueAdapter := &QueueAdapter{queue: waitingQueue}
-
-	// Initialize handlers
-	wsHandler := handler.NewWebSocketHandler(sessionStore, waitingQueue)
-	dinoHandler := handler.NewDinoHandler(sessionStore)
-	dinoHandler.SetQueue(queueAdapter)
-	registerHandler := handler.NewRegisterHandler(sessionStore)
-	registerHandler.SetQueue(queueAdapter)
+	// Initialize each room's gate handlers (the room manager routes queue and slot calls to each session's queue)
+	for _, r := range rooms.Rooms() {
+		st := stages[r]
+		st.dino = handler.NewDinoHandler(sessionStore)
+		// The Dino handler checks the head of its own room's queue
+		st.dino.SetQueue(r.Queue)
+		st.dino.SetSlots(r.Slots)
+		st.dino.SetMachine(r.Machine)
+		st.dino.SetFailureHandler(st.failures)
+		st.dino.SetClock(clk)
+		levelCfg := dino.DefaultConfig()
+		levelCfg.Duration = appCfg.DinoRunDuration
+		st.dino.SetLevelConfig(levelCfg)
+		for _, gate := range r.Machine.Pipeline().Gates() {
+			if gate.Kind == stage.KindDino && gate.Timeout > 0 && gate.Timeout <= appCfg.DinoRunDuration {
+				log.Printf("Warning: DINO_RUN_SEC (%s) is not shorter than the %s gate's timeout (%s) in queue %q", appCfg.DinoRunDuration, gate.Name, gate.Timeout, r.Name)
+			}
+		}
 
-	// Handlers that require S3
-	var captchaHandler *handler.CaptchaHandler
-	var otpHandler *handler.OTPHandler
-	if s3Adapter != nil {
-		captchaHandler = handler.NewCaptchaHandler(sessionStore, s3Adapter)
-		captchaHandler.SetCloudfrontURL(cloudfrontURL)
-		captchaHandler.SetQueue(queueAdapter)
+		st.register = handler.NewRegisterHandler(sessionStore)
+		st.register.SetQueue(rooms)
+		st.register.SetMachine(r.Machine)
+		st.register.SetFailureHandler(st.failures)
+		st.register.SetClock(clk)
+		st.dashboard = handler.NewDashboardHandler(sessionStore)
+		st.dashboard.SetMachine(r.Machine)
+		st.dashboard.SetClock(clk)
+
+		// Handlers that require S3
+		if s3Adapter != nil {
+			st.captcha = handler.NewCaptchaHandler(sessionStore, s3Adapter)
+			st.captcha.SetCloudfrontURL(cloudfrontURL)
+			st.captcha.SetQueue(rooms)
+			st.captcha.SetMachine(r.Machine)
+			st.captcha.SetFailureHandler(st.failures)
+			st.captcha.SetClock(clk)
+
+			st.otp = handler.NewOTPHandler(sessionStore, s3Adapter)
+			st.otp.SetQueue(rooms)
+			st.otp.SetMachine(r.Machine)
+			st.otp.SetFailureHandler(st.failures)
+			st.otp.SetClock(clk)
+		}
 
-		otpHandler = handler.NewOTPHandler(sessionStore, s3Adapter)
-		otpHandler.SetQueue(queueAdapter)
+		// Handlers that require Bedrock
+		if bedrockAdapter != nil {
+			st.password = handler.NewPasswordHandler(sessionStore, bedrockAdapter)
+			st.password.EnableFallback(true) // Use fallback if Bedrock fails
+			st.password.SetMachine(r.Machine)
+		}
 	}
-
-	// Handlers that require Bedrock
-	var passwordHandler *handler.PasswordHandler
-	if bedrockAdapter != nil {
-		passwordHandler = handler.NewPasswordHandler(sessionStore, bedrockAdapter)
-		passwordHandler.EnableFallback(true) // Use fallback if Bedrock fails
+	// Gate endpoints run the handlers of the room the session is bound to
+	byStages := func(h func(st *roomStages) echo.HandlerFunc) echo.HandlerFunc {
+		return rooms.BySession(func(r *room.Room) echo.HandlerFunc { return h(stages[r]) })
 	}
 
 	// Health check (root level for ALB)
@@ -235,11 +489,17 @@ func main() {
 		})
 	})
 
-	// WebSocket endpoint
-	e.GET("/ws", wsHandler.Connect)
+	// WebSocket endpoint (queue chosen by /ws/<name> or /ws?queue=<name>)
+	wsConnect := rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.WebSocket.Connect })
+	e.GET("/ws", wsConnect)
+	e.GET("/ws/:queue", wsConnect)
 
-	// API routes
-	api := e.Group("/api")
+	// API routes (the session cookie is verified before any handler runs,
+	// and any request refreshes the session's idle timeout)
+	api := e.Group("/api",
+		appmiddleware.SessionCookieMiddleware(cookieSigner),
+		appmiddleware.SessionActivityMiddleware(sessionStore),
+	)
 
 	// Health check
 	api.GET("/health", func(c echo.Context) error {
@@ -248,44 +508,101 @@ func main() {
 		})
 	})
 
-	// Queue status (debug)
-	api.GET("/queue/status", func(c echo.Context) error {
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"queue_length": waitingQueue.Len(),
-		})
-	})
+	// Queue status (debug), per queue with ?queue=<name>; the full metrics are under /api/admin/queues
+	api.GET("/queue/status", rooms.ByName(func(r *room.Room) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"queue":        r.Name,
+				"queue_length": r.Queue.Len(),
+			})
+		}
+	}))
+
+	// Per-user queue status (in the queue the session is bound to)
+	api.GET("/queue/me", rooms.BySession(func(r *room.Room) echo.HandlerFunc { return r.Status.Status }))
 
 	// Game endpoints
-	api.POST("/game/dino/start", dinoHandler.Start)
-	api.POST("/game/dino/result", dinoHandler.Result)
+	api.POST("/game/dino/start", byStages(func(st *roomStages) echo.HandlerFunc { return st.dino.Start }))
+	api.POST("/game/dino/result", byStages(func(st *roomStages) echo.HandlerFunc { return st.dino.Result }))
 
 	// CAPTCHA endpoints
-	if captchaHandler != nil {
-		api.POST("/captcha/generate", captchaHandler.Generate)
-		api.POST("/captcha/verify", captchaHandler.Verify)
+	if s3Adapter != nil {
+		api.POST("/captcha/generate", byStages(func(st *roomStages) echo.HandlerFunc { return st.captcha.Generate }))
+		api.POST("/captcha/verify", byStages(func(st *roomStages) echo.HandlerFunc { return st.captcha.Verify }))
 	} else {
 		api.POST("/captcha/generate", unavailableHandler("S3"))
 		api.POST("/captcha/verify", unavailableHandler("S3"))
 	}
 
 	// OTP endpoints
-	if otpHandler != nil {
-		api.POST("/otp/send", otpHandler.Send)
-		api.POST("/otp/verify", otpHandler.Verify)
+	if s3Adapter != nil {
+		api.POST("/otp/send", byStages(func(st *roomStages) echo.HandlerFunc { return st.otp.Send }))
+		api.POST("/otp/verify", byStages(func(st *roomStages) echo.HandlerFunc { return st.otp.Verify }))
 	} else {
 		api.POST("/otp/send", unavailableHandler("S3"))
 		api.POST("/otp/verify", unavailableHandler("S3"))
 	}
 
 	// Password analysis endpoint
-	if passwordHandler != nil {
-		api.POST("/password/analyze", passwordHandler.Analyze)
+	if bedrockAdapter != nil {
+		api.POST("/password/analyze", byStages(func(st *roomStages) echo.HandlerFunc { return st.password.Analyze }))
 	} else {
 		api.POST("/password/analyze", unavailableHandler("Bedrock"))
 	}
 
-	// Registration endpoint
-	api.POST("/register", registerHandler.Submit)
+	// Registration dashboard (task ledger) and registration endpoint
+	api.GET("/register/dashboard", byStages(func(st *roomStages) echo.HandlerFunc { return st.dashboard.State }))
+	api.POST("/register/tasks/:task", byStages(func(st *roomStages) echo.HandlerFunc { return st.dashboard.Submit }))
+	api.POST("/register", byStages(func(st *roomStages) echo.HandlerFunc { return st.register.Submit }))
+
+	// Admin endpoints
+	admin := api.Group("/admin", appmiddleware.AdminAuthMiddleware(appCfg.AdminToken))
+	admin.GET("/queues/:queue", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.State }))
+	admin.POST("/queues/:queue/pause", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Pause }))
+	admin.POST("/queues/:queue/resume", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Resume }))
+	admin.POST("/queues/:queue/freeze", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Freeze }))
+	admin.POST("/queues/:queue/unfreeze", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Unfreeze }))
+	admin.POST("/queues/:queue/move", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Move }))
+	admin.POST("/queues/:queue/remove", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Remove }))
+	admin.POST("/queues/:queue/drain", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Drain }))
+	admin.POST("/queues/:queue/announce", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Announce }))
+	admin.GET("/queues/:queue/audit", rooms.ByName(func(r *room.Room) echo.HandlerFunc { return r.Admin.Audit }))
+	penaltyHandler := handler.NewPenaltyHandler(penaltyRules, rooms.Default().Audit)
+	admin.GET("/penalties", penaltyHandler.State)
+	admin.POST("/penalties", penaltyHandler.Set)
+
+	// Metrics, with session counts, failure reasons and per-queue internals
+	admin.GET("/queues", func(c echo.Context) error {
+		stats := make([]map[string]interface{}, 0, len(rooms.Rooms()))
+		for _, r := range rooms.Rooms() {
+			stats = append(stats, r.Stats())
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"queues": stats,
+		})
+	})
+
+	// Session janitor (eviction counts)
+	admin.GET("/sessions/status", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, sessionJanitor.Stats())
+	})
+
+	// Failures by reason and stage
+	admin.GET("/failures/status", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, failureMetrics.Stats())
+	})
+
+	// Timeout scheduler (pending and fired deadlines)
+	admin.GET("/timeouts/status", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, scheduler.Stats())
+	})
+
+	// State machine (applied transitions and rejected events), per queue with ?queue=<name>
+	admin.GET("/stages/status", rooms.ByName(func(r *room.Room) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return c.JSON(http.StatusOK, r.Machine.Stats())
+		}
+	}))
 
 	// Get port from environment or default
 	port := os.Getenv("PORT")
@@ -296,9 +613,10 @@ func main() {
 	// Log registered endpoints
 	log.Println("Registered endpoints:")
 	log.Println("  GET  /health")
-	log.Println("  GET  /ws")
+	log.Println("  GET  /ws, /ws/:queue")
 	log.Println("  GET  /api/health")
 	log.Println("  GET  /api/queue/status")
+	log.Println("  GET  /api/queue/me")
 	log.Println("  POST /api/game/dino/start")
 	log.Println("  POST /api/game/dino/result")
 	log.Println("  POST /api/captcha/generate")
@@ -306,11 +624,58 @@ func main() {
 	log.Println("  POST /api/otp/send")
 	log.Println("  POST /api/otp/verify")
 	log.Println("  POST /api/password/analyze")
+	log.Println("  GET  /api/register/dashboard")
+	log.Println("  POST /api/register/tasks/:task")
 	log.Println("  POST /api/register")
+	log.Println("  GET  /api/admin/queues/:queue")
+	log.Println("  POST /api/admin/queues/:queue/{pause,resume,freeze,unfreeze,move,remove,drain,announce}")
+	log.Println("  GET  /api/admin/queues/:queue/audit")
+	log.Println("  GET  /api/admin/penalties")
+	log.Println("  POST /api/admin/penalties")
+	log.Println("  GET  /api/admin/queues")
+	log.Println("  GET  /api/admin/{sessions,failures,timeouts,stages}/status")
+
+	// Start background workers
+	rooms.Start()
+	sessionJanitor.Start()
+	if snapshotter != nil {
+		snapshotter.Start()
+	}
 
 	// Start server
-	log.Printf("Starting server on :%s", port)
-	e.Logger.Fatal(e.Start(":" + port))
+	go func() {
+		log.Printf("Starting server on :%s", port)
+		if err := e.Start(":" + port); err != nil && err != http.ErrServerClosed {
+			e.Logger.Fatal(err)
+		}
+	}()
+
+	// Wait for shutdown signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	<-ctx.Done()
+
+	log.Println("Shutting down server...")
+	rooms.Stop()
+	for _, st := range stages {
+		st.tokens.Stop()
+	}
+	scheduler.Stop()
+	sessionJanitor.Stop()
+	if snapshotter != nil {
+		if err := snapshotter.Stop(); err != nil {
+			log.Printf("Failed to save snapshot: %v", err)
+		}
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Server shutdown failed: %v", err)
+	}
+	if err := sessionStore.Close(); err != nil {
+		log.Printf("Failed to close session backend: %v", err)
+	}
 }
 
 // unavailableHandler returns a handler that responds with service unavailable
@@ -0,0 +1,74 @@
+// Package audit records administrative actions.
+package audit
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+)
+
+// DefaultCapacity is the number of entries kept in memory.
+const DefaultCapacity = 1000
+
+// Entry is a single recorded action.
+type Entry struct {
+	Time   time.Time         `json:"time"`
+	Actor  string            `json:"actor"`
+	Action string            `json:"action"`
+	Target string            `json:"target,omitempty"`
+	Detail map[string]string `json:"detail,omitempty"`
+}
+
+// Trail keeps the most recent entries in memory and mirrors each one to the log.
+type Trail struct {
+	mu       sync.Mutex
+	entries  []Entry
+	capacity int
+	clock    clock.Clock
+}
+
+// NewTrail creates a new Trail keeping up to capacity entries.
+// A capacity below 1 falls back to DefaultCapacity.
+func NewTrail(capacity int) *Trail {
+	if capacity < 1 {
+		capacity = DefaultCapacity
+	}
+	return &Trail{capacity: capacity, clock: clock.Real{}}
+}
+
+// SetClock sets the clock entries are stamped with.
+func (t *Trail) SetClock(c clock.Clock) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.clock = c
+}
+
+// Record appends an entry, dropping the oldest one when full.
+func (t *Trail) Record(actor, action, target string, detail map[string]string) Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry := Entry{
+		Time:   t.clock.Now(),
+		Actor:  actor,
+		Action: action,
+		Target: target,
+		Detail: detail,
+	}
+	log.Printf("[Audit] actor=%s action=%s target=%s detail=%v", actor, action, target, detail)
+
+	if len(t.entries) == t.capacity {
+		t.entries = append(t.entries[:0], t.entries[1:]...)
+	}
+	t.entries = append(t.entries, entry)
+	return entry
+}
+
+// Entries returns a copy of the recorded entries, oldest first.
+func (t *Trail) Entries() []Entry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Entry(nil), t.entries...)
+}
@@ -0,0 +1,39 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrail_Record(t *testing.T) {
+	trail := NewTrail(10)
+
+	entry := trail.Record("admin", "queue.move", "user1", map[string]string{"position": "1"})
+	assert.Equal(t, "admin", entry.Actor)
+	assert.False(t, entry.Time.IsZero())
+
+	entries := trail.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "queue.move", entries[0].Action)
+	assert.Equal(t, "user1", entries[0].Target)
+	assert.Equal(t, "1", entries[0].Detail["position"])
+}
+
+func TestTrail_DropsOldest(t *testing.T) {
+	trail := NewTrail(2)
+	trail.Record("admin", "queue.pause", "", nil)
+	trail.Record("admin", "queue.resume", "", nil)
+	trail.Record("admin", "queue.drain", "", nil)
+
+	entries := trail.Entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "queue.resume", entries[0].Action)
+	assert.Equal(t, "queue.drain", entries[1].Action)
+}
+
+func TestNewTrail_DefaultCapacity(t *testing.T) {
+	trail := NewTrail(0)
+	assert.Equal(t, DefaultCapacity, trail.capacity)
+}
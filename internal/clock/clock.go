@@ -0,0 +1,74 @@
+// Package clock lets time-based code take its clock as a dependency, so
+// tests can move time by hand instead of sleeping.
+package clock
+
+import "time"
+
+// Clock tells the time and creates timers. Real reads the wall clock; Fake
+// only moves when a test advances it.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Since returns the time elapsed since t.
+	Since(t time.Time) time.Duration
+	// Sleep blocks until d has passed.
+	Sleep(d time.Duration)
+	// NewTimer creates a timer that sends the time on its channel after d.
+	NewTimer(d time.Duration) Timer
+	// AfterFunc calls f on its own goroutine after d. The timer has no channel.
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker creates a ticker that sends the time on its channel every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a single event, like time.Timer.
+type Timer interface {
+	// C returns the channel the time is sent on when the timer fires.
+	C() <-chan time.Time
+	// Stop prevents the timer from firing. Returns false if it already fired or was stopped.
+	Stop() bool
+	// Reset makes the timer fire after d. Returns true if it was still pending.
+	Reset(d time.Duration) bool
+}
+
+// Ticker sends the time at regular intervals, like time.Ticker.
+type Ticker interface {
+	// C returns the channel the ticks are sent on.
+	C() <-chan time.Time
+	// Stop turns the ticker off.
+	Stop()
+}
+
+// Real is the wall clock, backed by the time package.
+type Real struct{}
+
+var _ Clock = Real{}
+
+// Now returns time.Now().
+func (Real) Now() time.Time { return time.Now() }
+
+// Since returns time.Since(t).
+func (Real) Since(t time.Time) time.Duration { return time.Since(t) }
+
+// Sleep calls time.Sleep.
+func (Real) Sleep(d time.Duration) { time.Sleep(d) }
+
+// NewTimer wraps time.NewTimer.
+func (Real) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+// AfterFunc wraps time.AfterFunc.
+func (Real) AfterFunc(d time.Duration, f func()) Timer { return realTimer{time.AfterFunc(d, f)} }
+
+// NewTicker wraps time.NewTicker.
+func (Real) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time        { return r.t.C }
+func (r realTimer) Stop() bool                 { return r.t.Stop() }
+func (r realTimer) Reset(d time.Duration) bool { return r.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (r realTicker) C() <-chan time.Time { return r.t.C }
+func (r realTicker) Stop()               { r.t.Stop() }
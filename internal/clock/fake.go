@@ -0,0 +1,202 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a clock that only moves when Advance or Set is called. Timers,
+// tickers and sleepers whose time comes fire during the call, earliest
+// first, with Now set to their deadline. Timers started with a duration of 0
+// or less fire on the next Advance or Set.
+type Fake struct {
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when a timer is added
+	now    time.Time
+	timers map[*fakeTimer]struct{} // pending timers and tickers
+	seq    uint64                  // orders timers due at the same time
+}
+
+var _ Clock = (*Fake)(nil)
+
+// NewFake creates a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// Now returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Since returns the fake time elapsed since t.
+func (f *Fake) Since(t time.Time) time.Duration {
+	return f.Now().Sub(t)
+}
+
+// Sleep blocks until the clock is advanced by d.
+func (f *Fake) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	<-f.NewTimer(d).C()
+}
+
+// NewTimer creates a timer that fires once the clock is advanced by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{clock: f, ch: make(chan time.Time, 1)}
+	f.start(t, d)
+	return t
+}
+
+// AfterFunc creates a timer that calls f once the clock is advanced by d.
+// Unlike time.AfterFunc, f runs on the goroutine advancing the clock, so it
+// has finished when Advance returns.
+func (f *Fake) AfterFunc(d time.Duration, fn func()) Timer {
+	t := &fakeTimer{clock: f, fn: fn}
+	f.start(t, d)
+	return t
+}
+
+// NewTicker creates a ticker that ticks every d the clock is advanced by.
+// Like time.Ticker, ticks are dropped while the channel is full.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	t := &fakeTimer{clock: f, ch: make(chan time.Time, 1), period: d}
+	f.start(t, d)
+	return fakeTicker{t}
+}
+
+// Advance moves the clock forward by d and fires everything that comes due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	end := f.now.Add(d)
+	f.mu.Unlock()
+	f.Set(end)
+}
+
+// Set moves the clock to t and fires everything that comes due. The clock
+// never moves backwards; an earlier t is ignored.
+func (f *Fake) Set(t time.Time) {
+	for {
+		f.mu.Lock()
+		next := f.nextLocked(t)
+		if next == nil {
+			if t.After(f.now) {
+				f.now = t
+			}
+			f.mu.Unlock()
+			return
+		}
+		if next.deadline.After(f.now) {
+			f.now = next.deadline
+		}
+		now := f.now
+		if next.period > 0 {
+			next.deadline = next.deadline.Add(next.period)
+		} else {
+			delete(f.timers, next)
+		}
+		f.mu.Unlock()
+
+		next.fire(now)
+	}
+}
+
+// Pending returns the number of timers and tickers waiting to fire.
+func (f *Fake) Pending() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.timers)
+}
+
+// BlockUntil waits until at least n timers, tickers or sleepers are pending.
+// Tests call it before Advance to make sure a goroutine started waiting.
+func (f *Fake) BlockUntil(n int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+// start schedules t to fire after d.
+func (f *Fake) start(t *fakeTimer, d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.deadline = f.now.Add(d)
+	f.seq++
+	t.seq = f.seq
+	f.timers[t] = struct{}{}
+	f.cond.Broadcast()
+}
+
+// nextLocked returns the earliest pending timer due by t, or nil. Timers
+// due at the same time fire in the order they were started.
+// Caller must hold f.mu.
+func (f *Fake) nextLocked(t time.Time) *fakeTimer {
+	var next *fakeTimer
+	for timer := range f.timers {
+		if timer.deadline.After(t) {
+			continue
+		}
+		if next == nil || timer.deadline.Before(next.deadline) ||
+			timer.deadline.Equal(next.deadline) && timer.seq < next.seq {
+			next = timer
+		}
+	}
+	return next
+}
+
+// fakeTimer is a timer or ticker of a Fake clock.
+type fakeTimer struct {
+	clock    *Fake
+	deadline time.Time
+	seq      uint64
+	period   time.Duration // > 0 for tickers
+	ch       chan time.Time
+	fn       func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	_, pending := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	return pending
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	pending := t.Stop()
+	t.clock.start(t, d)
+	return pending
+}
+
+// fire sends the time or calls the function. It runs without the clock's lock.
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		t.fn()
+		return
+	}
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+// fakeTicker is a fakeTimer whose Stop returns nothing, as Ticker wants.
+type fakeTicker struct{ t *fakeTimer }
+
+func (t fakeTicker) C() <-chan time.Time { return t.t.ch }
+func (t fakeTicker) Stop()               { t.t.Stop() }
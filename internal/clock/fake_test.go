@@ -0,0 +1,117 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var epoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFake_Advance(t *testing.T) {
+	c := NewFake(epoch)
+	var fired []string
+	c.AfterFunc(3*time.Second, func() { fired = append(fired, "3s") })
+	c.AfterFunc(time.Second, func() { fired = append(fired, "1s") })
+	late := c.AfterFunc(10*time.Second, func() { fired = append(fired, "10s") })
+	timer := c.NewTimer(2 * time.Second)
+
+	c.Advance(time.Second - time.Nanosecond)
+	assert.Empty(t, fired, "期限前は発火しない")
+
+	// 期限の早い順に、その時刻で発火する
+	c.Advance(5 * time.Second)
+	assert.Equal(t, []string{"1s", "3s"}, fired)
+	select {
+	case at := <-timer.C():
+		assert.Equal(t, epoch.Add(2*time.Second), at)
+	default:
+		t.Fatal("タイマーが発火するべき")
+	}
+	assert.Equal(t, epoch.Add(6*time.Second-time.Nanosecond), c.Now())
+
+	// 止めたタイマーは発火しない
+	assert.True(t, late.Stop())
+	assert.False(t, late.Stop())
+	c.Advance(time.Minute)
+	assert.Len(t, fired, 2)
+	assert.Equal(t, 0, c.Pending())
+}
+
+func TestFake_Reset(t *testing.T) {
+	c := NewFake(epoch)
+	count := 0
+	timer := c.AfterFunc(time.Second, func() { count++ })
+
+	c.Advance(500 * time.Millisecond)
+	assert.True(t, timer.Reset(time.Second), "まだ発火していない")
+	c.Advance(700 * time.Millisecond)
+	assert.Equal(t, 0, count)
+	c.Advance(300 * time.Millisecond)
+	assert.Equal(t, 1, count)
+
+	// 発火済みのタイマーもResetで再び使える
+	assert.False(t, timer.Reset(time.Second))
+	c.Advance(time.Second)
+	assert.Equal(t, 2, count)
+}
+
+func TestFake_Ticker(t *testing.T) {
+	c := NewFake(epoch)
+	ticker := c.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for i := 1; i <= 3; i++ {
+		c.Advance(time.Second)
+		select {
+		case at := <-ticker.C():
+			assert.Equal(t, epoch.Add(time.Duration(i)*time.Second), at)
+		default:
+			t.Fatalf("%d回目のティックが届くべき", i)
+		}
+	}
+
+	// 読まれないティックは捨てられる
+	c.Advance(5 * time.Second)
+	assert.Len(t, ticker.C(), 1)
+
+	ticker.Stop()
+	<-ticker.C()
+	c.Advance(time.Second)
+	assert.Empty(t, ticker.C())
+}
+
+func TestFake_Sleep(t *testing.T) {
+	c := NewFake(epoch)
+	done := make(chan time.Time)
+	go func() {
+		c.Sleep(time.Minute)
+		done <- c.Now()
+	}()
+
+	// ゴルーチンが眠るのを待ってから時計を進める
+	c.BlockUntil(1)
+	c.Advance(time.Minute)
+	select {
+	case at := <-done:
+		assert.Equal(t, epoch.Add(time.Minute), at)
+	case <-time.After(time.Second):
+		t.Fatal("Sleepが戻るべき")
+	}
+}
+
+func TestFake_Set(t *testing.T) {
+	c := NewFake(epoch)
+	fired := false
+	c.AfterFunc(time.Hour, func() { fired = true })
+
+	// 過去には戻らない
+	c.Set(epoch.Add(-time.Hour))
+	assert.Equal(t, epoch, c.Now())
+
+	c.Set(epoch.Add(2 * time.Hour))
+	require.True(t, fired)
+	assert.Equal(t, 2*time.Hour, c.Since(epoch))
+}
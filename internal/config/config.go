@@ -5,6 +5,8 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Config holds the application configuration.
@@ -14,21 +16,222 @@ type Config struct {
 	AWSRegion        string
 	S3Bucket         string
 	CloudfrontDomain string
+	AdminToken       string // Bearer token for the admin API (empty disables it)
+
+	// Queue settings
+	DinoSlots         int           // Number of users who can play Dino Run at the same time
+	BroadcastInterval time.Duration // Coalescing tick for queueUpdate broadcasts
+	WSSessionPolicy   string        // "takeover" or "reject" when a session opens a second socket
+
+	// Wait time estimate settings
+	ETAPessimistic         bool          // Inflate estimates on purpose
+	ETAPessimismMultiplier float64       // Pessimistic estimate = estimate * multiplier + position * per-user
+	ETAPessimismPerUser    time.Duration // Extra wait added per position in pessimistic mode
+
+	// Phantom queue population (0 disables)
+	PhantomInitial   int           // Phantoms injected at startup
+	PhantomPerJoin   int           // Phantoms injected ahead of each real user
+	PhantomMeanLeave time.Duration // Mean time between phantom departures
+
+	// Stage and dispatcher settings, defaults for every queue
+	StageTimeout     time.Duration // Default time limit of the Dino gate
+	TeaseDelayMinSec int           // Tease delay range before each promotion
+	TeaseDelayMaxSec int
+	PollInterval     time.Duration // How often the dispatcher re-checks the queue
+
+	// Named queues, each overriding the defaults above
+	Queues []QueueConfig
+
+	// Gates a promoted user goes through, in order (the last one is the registration dashboard).
+	// Queues use this pipeline unless they set their own
+	Stages []StageConfig
+
+	// Side effects run on every failure: "metrics", "audit" and/or "requeue"
+	FailureEffects []string
+
+	// Penalty policies (see penalty.Parse); both can be changed at runtime by admins
+	PenaltyPolicy string   // Policy for failures no rule matches
+	PenaltyRules  []string // "STAGE:REASON=POLICY" rules, * matching every stage or reason
+
+	// Registration deadline (its length is the register gate's timeout)
+	RegisterCountdown    time.Duration            // How often the remaining time is pushed over WebSocket (0 disables)
+	RegisterWarnings     []time.Duration          // Remaining times at which a warning is pushed
+	RegisterTaskTimeouts map[string]time.Duration // Optional time limits of dashboard tasks, from entering the dashboard
+
+	// Dino Run levels, generated by the server for every run
+	DinoRunDuration time.Duration // How long a level lasts; surviving it clears the Dino gate
+
+	// Timeout scheduler (gate deadlines, registration deadline and task deadlines)
+	TimeoutTick time.Duration // How often the scheduler's wheel moves, i.e. how late a deadline may fire
+
+	// Session storage
+	SessionBackend       string        // "memory" or "file"
+	SessionFilePath      string        // File the "file" backend persists sessions to
+	SessionFlushInterval time.Duration // How often the "file" backend writes changed sessions
+	SessionCookieKeys    []string      // HMAC keys for session cookies, first signs (empty uses a random key)
+
+	// Session expiry (0 disables)
+	SessionMaxAge        time.Duration // Absolute session lifetime
+	SessionIdleTimeout   time.Duration // Sliding timeout refreshed on REST or WebSocket activity
+	SessionSweepInterval time.Duration // How often the janitor removes expired sessions
+
+	// Snapshot settings (off unless a path is set)
+	SnapshotPath     string        // File sessions and the queue are persisted to
+	SnapshotInterval time.Duration // How often a snapshot is written
+	SnapshotGrace    time.Duration // How long restored users have to reconnect and reclaim their place
+}
+
+// QueueConfig holds the settings of one named queue.
+type QueueConfig struct {
+	Name             string
+	DinoSlots        int
+	StageTimeout     time.Duration
+	TeaseDelayMinSec int
+	TeaseDelayMaxSec int
+	PollInterval     time.Duration
+	PhantomInitial   int
+	PhantomPerJoin   int
+	Stages           []StageConfig // gates after this queue, in order
+}
+
+// StageConfig holds the settings of one gate of the stage pipeline.
+type StageConfig struct {
+	Name        string
+	Kind        string        // "dino", "captcha" or "register"
+	Timeout     time.Duration // 0 for no limit
+	MaxAttempts int           // failures allowed before the user goes back to the queue
+}
+
+// stageDefaults are the per-kind defaults of a gate: timeout in seconds and max attempts.
+var stageDefaults = map[string]struct{ timeoutSec, maxAttempts int }{
+	"dino":     {180, 1},
+	"captcha":  {0, 3},
+	"register": {600, 1},
 }
 
 // LoadConfig loads configuration from environment variables.
 func LoadConfig() (*Config, error) {
 	cfg := &Config{
-		Port:             getEnv("PORT", "8080"),
-		AllowedOrigin:    getEnv("ALLOWED_ORIGIN", "http://localhost:5173"),
-		AWSRegion:        getEnv("AWS_REGION", "ap-northeast-1"),
-		S3Bucket:         getEnv("S3_BUCKET", ""),
-		CloudfrontDomain: getEnv("CLOUDFRONT_DOMAIN", ""),
+		Port:              getEnv("PORT", "8080"),
+		AllowedOrigin:     getEnv("ALLOWED_ORIGIN", "http://localhost:5173"),
+		AWSRegion:         getEnv("AWS_REGION", "ap-northeast-1"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		CloudfrontDomain:  getEnv("CLOUDFRONT_DOMAIN", ""),
+		AdminToken:        getEnv("ADMIN_TOKEN", ""),
+		DinoSlots:         getEnvInt("DINO_SLOTS", 1),
+		BroadcastInterval: time.Duration(getEnvInt("QUEUE_BROADCAST_INTERVAL_MS", 500)) * time.Millisecond,
+		WSSessionPolicy:   getEnv("WS_SESSION_POLICY", "takeover"),
+
+		ETAPessimistic:         getEnvBool("ETA_PESSIMISTIC", false),
+		ETAPessimismMultiplier: getEnvFloat("ETA_PESSIMISM_MULTIPLIER", 1.5),
+		ETAPessimismPerUser:    time.Duration(getEnvInt("ETA_PESSIMISM_PER_USER_SEC", 30)) * time.Second,
+
+		PhantomInitial:   getEnvInt("PHANTOM_INITIAL", 0),
+		PhantomPerJoin:   getEnvInt("PHANTOM_PER_JOIN", 0),
+		PhantomMeanLeave: time.Duration(getEnvInt("PHANTOM_MEAN_LEAVE_MS", 3000)) * time.Millisecond,
+
+		SnapshotPath:     getEnv("SNAPSHOT_PATH", ""),
+		SnapshotInterval: time.Duration(getEnvInt("SNAPSHOT_INTERVAL_SEC", 30)) * time.Second,
+		SnapshotGrace:    time.Duration(getEnvInt("SNAPSHOT_GRACE_SEC", 120)) * time.Second,
+
+		SessionBackend:       getEnv("SESSION_BACKEND", "memory"),
+		SessionFilePath:      getEnv("SESSION_FILE_PATH", "sessions.db"),
+		SessionFlushInterval: time.Duration(getEnvInt("SESSION_FLUSH_INTERVAL_MS", 1000)) * time.Millisecond,
+		SessionCookieKeys:    getEnvList("SESSION_COOKIE_KEYS"),
+
+		SessionMaxAge:        time.Duration(getEnvInt("SESSION_MAX_AGE_SEC", 21600)) * time.Second,
+		SessionIdleTimeout:   time.Duration(getEnvInt("SESSION_IDLE_TIMEOUT_SEC", 900)) * time.Second,
+		SessionSweepInterval: time.Duration(getEnvInt("SESSION_SWEEP_INTERVAL_SEC", 60)) * time.Second,
+
+		FailureEffects: getEnvList("FAILURE_EFFECTS"),
+
+		PenaltyPolicy: getEnv("PENALTY_POLICY", "back"),
+		PenaltyRules:  getEnvRules("PENALTY_RULES"),
+
+		RegisterCountdown: time.Duration(getEnvInt("REGISTER_COUNTDOWN_INTERVAL_SEC", 1)) * time.Second,
+		RegisterWarnings:  getEnvSeconds("REGISTER_WARNING_SEC", "300,60,10"),
+
+		DinoRunDuration: time.Duration(getEnvInt("DINO_RUN_SEC", 60)) * time.Second,
+
+		RegisterTaskTimeouts: getEnvDurations("REGISTER_TASK_TIMEOUTS"),
+		TimeoutTick:          time.Duration(getEnvInt("TIMEOUT_TICK_MS", 100)) * time.Millisecond,
+
+		StageTimeout:     time.Duration(getEnvInt("STAGE_TIMEOUT_SEC", 180)) * time.Second,
+		TeaseDelayMinSec: getEnvInt("TEASE_DELAY_MIN_SEC", 10),
+		TeaseDelayMaxSec: getEnvInt("TEASE_DELAY_MAX_SEC", 30),
+		PollInterval:     time.Duration(getEnvInt("DISPATCH_POLL_INTERVAL_MS", 500)) * time.Millisecond,
+	}
+	if cfg.FailureEffects == nil {
+		cfg.FailureEffects = []string{"metrics", "audit"}
 	}
+	pipeline := getEnv("STAGE_PIPELINE", "dino,register")
+	cfg.Queues = cfg.loadQueues(getEnv("QUEUES", "default"), pipeline)
+	cfg.Stages = cfg.loadStages(pipeline, cfg.StageTimeout)
 
 	return cfg, nil
 }
 
+// loadQueues reads the settings of each comma-separated queue name.
+// QUEUE_<NAME>_<SETTING> overrides the global setting for that queue,
+// e.g. QUEUE_STAGING_DINO_SLOTS=3. Dashes in names become underscores.
+// QUEUE_<NAME>_STAGE_PIPELINE replaces the default pipeline for that queue.
+func (c *Config) loadQueues(names, pipeline string) []QueueConfig {
+	var queues []QueueConfig
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		prefix := "QUEUE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		stageTimeout := time.Duration(getEnvInt(prefix+"STAGE_TIMEOUT_SEC", int(c.StageTimeout/time.Second))) * time.Second
+		queues = append(queues, QueueConfig{
+			Name:             name,
+			DinoSlots:        getEnvInt(prefix+"DINO_SLOTS", c.DinoSlots),
+			StageTimeout:     stageTimeout,
+			TeaseDelayMinSec: getEnvInt(prefix+"TEASE_DELAY_MIN_SEC", c.TeaseDelayMinSec),
+			TeaseDelayMaxSec: getEnvInt(prefix+"TEASE_DELAY_MAX_SEC", c.TeaseDelayMaxSec),
+			PollInterval:     time.Duration(getEnvInt(prefix+"DISPATCH_POLL_INTERVAL_MS", int(c.PollInterval/time.Millisecond))) * time.Millisecond,
+			PhantomInitial:   getEnvInt(prefix+"PHANTOM_INITIAL", c.PhantomInitial),
+			PhantomPerJoin:   getEnvInt(prefix+"PHANTOM_PER_JOIN", c.PhantomPerJoin),
+			Stages:           c.loadStages(getEnv(prefix+"STAGE_PIPELINE", pipeline), stageTimeout),
+		})
+	}
+	return queues
+}
+
+// loadStages reads the settings of each comma-separated gate name, in order.
+// STAGE_<NAME>_KIND sets the kind of a gate (defaulting to its name), and
+// STAGE_<NAME>_TIMEOUT_SEC and STAGE_<NAME>_MAX_ATTEMPTS override the kind's
+// defaults, e.g. STAGE_PIPELINE=dino,captcha,register with
+// STAGE_CAPTCHA_MAX_ATTEMPTS=5. Dashes in names become underscores.
+// The Dino gate's timeout defaults to dinoTimeout, the queue's STAGE_TIMEOUT_SEC.
+func (c *Config) loadStages(names string, dinoTimeout time.Duration) []StageConfig {
+	var stages []StageConfig
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "STAGE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		kind := getEnv(prefix+"KIND", name)
+		defaults := stageDefaults[kind]
+		if kind == "dino" {
+			defaults.timeoutSec = int(dinoTimeout / time.Second)
+		}
+		stages = append(stages, StageConfig{
+			Name:        name,
+			Kind:        kind,
+			Timeout:     time.Duration(getEnvInt(prefix+"TIMEOUT_SEC", defaults.timeoutSec)) * time.Second,
+			MaxAttempts: getEnvInt(prefix+"MAX_ATTEMPTS", defaults.maxAttempts),
+		})
+	}
+	return stages
+}
+
 // Validate validates the configuration.
 func (c *Config) Validate() error {
 	// Validate port is a number
@@ -46,3 +249,97 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+// getEnvList returns the non-empty comma-separated values of an environment variable.
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvRules returns the semicolon-separated entries of an environment variable,
+// for lists whose entries contain commas.
+func getEnvRules(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ";") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getEnvSeconds returns the comma-separated durations in seconds of an environment variable
+// or a default value. Values that are not positive integers are skipped.
+func getEnvSeconds(key, defaultValue string) []time.Duration {
+	var durations []time.Duration
+	for _, value := range strings.Split(getEnv(key, defaultValue), ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || n <= 0 {
+			continue
+		}
+		durations = append(durations, time.Duration(n)*time.Second)
+	}
+	return durations
+}
+
+// getEnvDurations returns the comma-separated NAME=DURATION pairs of an
+// environment variable, e.g. "captcha=2m,otp=90s". Invalid pairs are skipped.
+func getEnvDurations(key string) map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, value := range getEnvList(key) {
+		name, raw, ok := strings.Cut(value, "=")
+		d, err := time.ParseDuration(strings.TrimSpace(raw))
+		if !ok || err != nil || d <= 0 {
+			continue
+		}
+		durations[strings.TrimSpace(name)] = d
+	}
+	return durations
+}
+
+// getEnvInt returns the integer value of an environment variable or a default value.
+// The default is also used when the value is not a valid integer.
+func getEnvInt(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return n
+}
+
+// getEnvFloat returns the float value of an environment variable or a default value.
+// The default is also used when the value is not a valid number.
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return defaultValue
+	}
+	return f
+}
+
+// getEnvBool returns the boolean value of an environment variable or a default value.
+// The default is also used when the value is not a valid boolean.
+func getEnvBool(key string, defaultValue bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return b
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -151,3 +152,463 @@ func TestConfig_Validation(t *testing.T) {
 		})
 	}
 }
+
+func TestConfig_DinoSlots(t *testing.T) {
+	tests := []struct {
+		name      string
+		value     string
+		wantSlots int
+	}{
+		{
+			name:      "デフォルトは1枠",
+			value:     "",
+			wantSlots: 1,
+		},
+		{
+			name:      "カスタム枠数",
+			value:     "3",
+			wantSlots: 3,
+		},
+		{
+			name:      "不正な値はデフォルト",
+			value:     "many",
+			wantSlots: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			saved := os.Getenv("DINO_SLOTS")
+			defer os.Setenv("DINO_SLOTS", saved)
+
+			if tt.value != "" {
+				os.Setenv("DINO_SLOTS", tt.value)
+			} else {
+				os.Unsetenv("DINO_SLOTS")
+			}
+
+			cfg, err := LoadConfig()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSlots, cfg.DinoSlots)
+		})
+	}
+}
+
+func TestConfig_BroadcastInterval(t *testing.T) {
+	saved := os.Getenv("QUEUE_BROADCAST_INTERVAL_MS")
+	defer os.Setenv("QUEUE_BROADCAST_INTERVAL_MS", saved)
+
+	os.Unsetenv("QUEUE_BROADCAST_INTERVAL_MS")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, cfg.BroadcastInterval)
+
+	os.Setenv("QUEUE_BROADCAST_INTERVAL_MS", "0")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), cfg.BroadcastInterval)
+}
+
+func TestConfig_ETAPessimism(t *testing.T) {
+	keys := []string{"ETA_PESSIMISTIC", "ETA_PESSIMISM_MULTIPLIER", "ETA_PESSIMISM_PER_USER_SEC"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.False(t, cfg.ETAPessimistic)
+	assert.Equal(t, 1.5, cfg.ETAPessimismMultiplier)
+	assert.Equal(t, 30*time.Second, cfg.ETAPessimismPerUser)
+
+	os.Setenv("ETA_PESSIMISTIC", "true")
+	os.Setenv("ETA_PESSIMISM_MULTIPLIER", "3")
+	os.Setenv("ETA_PESSIMISM_PER_USER_SEC", "60")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.True(t, cfg.ETAPessimistic)
+	assert.Equal(t, 3.0, cfg.ETAPessimismMultiplier)
+	assert.Equal(t, time.Minute, cfg.ETAPessimismPerUser)
+}
+
+func TestConfig_Phantoms(t *testing.T) {
+	keys := []string{"PHANTOM_INITIAL", "PHANTOM_PER_JOIN", "PHANTOM_MEAN_LEAVE_MS"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	// デフォルトは無効
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 0, cfg.PhantomInitial)
+	assert.Equal(t, 0, cfg.PhantomPerJoin)
+	assert.Equal(t, 3*time.Second, cfg.PhantomMeanLeave)
+
+	os.Setenv("PHANTOM_INITIAL", "150")
+	os.Setenv("PHANTOM_PER_JOIN", "10")
+	os.Setenv("PHANTOM_MEAN_LEAVE_MS", "1500")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 150, cfg.PhantomInitial)
+	assert.Equal(t, 10, cfg.PhantomPerJoin)
+	assert.Equal(t, 1500*time.Millisecond, cfg.PhantomMeanLeave)
+}
+
+func TestConfig_Snapshot(t *testing.T) {
+	keys := []string{"SNAPSHOT_PATH", "SNAPSHOT_INTERVAL_SEC", "SNAPSHOT_GRACE_SEC"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.SnapshotPath, "スナップショットは明示的に有効にする")
+	assert.Equal(t, 30*time.Second, cfg.SnapshotInterval)
+	assert.Equal(t, 2*time.Minute, cfg.SnapshotGrace)
+
+	os.Setenv("SNAPSHOT_PATH", "/var/lib/ptera/snapshot.json")
+	os.Setenv("SNAPSHOT_INTERVAL_SEC", "10")
+	os.Setenv("SNAPSHOT_GRACE_SEC", "60")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "/var/lib/ptera/snapshot.json", cfg.SnapshotPath)
+	assert.Equal(t, 10*time.Second, cfg.SnapshotInterval)
+	assert.Equal(t, time.Minute, cfg.SnapshotGrace)
+}
+
+func TestConfig_WSSessionPolicy(t *testing.T) {
+	original := os.Getenv("WS_SESSION_POLICY")
+	defer os.Setenv("WS_SESSION_POLICY", original)
+
+	os.Unsetenv("WS_SESSION_POLICY")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "takeover", cfg.WSSessionPolicy)
+
+	os.Setenv("WS_SESSION_POLICY", "reject")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "reject", cfg.WSSessionPolicy)
+}
+
+func TestConfig_AdminToken(t *testing.T) {
+	original := os.Getenv("ADMIN_TOKEN")
+	defer os.Setenv("ADMIN_TOKEN", original)
+
+	// デフォルトは無効
+	os.Unsetenv("ADMIN_TOKEN")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.AdminToken)
+
+	os.Setenv("ADMIN_TOKEN", "secret")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "secret", cfg.AdminToken)
+}
+
+func TestConfig_Queues(t *testing.T) {
+	keys := []string{
+		"QUEUES", "DINO_SLOTS", "STAGE_TIMEOUT_SEC", "TEASE_DELAY_MIN_SEC", "TEASE_DELAY_MAX_SEC",
+		"DISPATCH_POLL_INTERVAL_MS", "QUEUE_STAGING_DINO_SLOTS", "QUEUE_STAGING_TEASE_DELAY_MAX_SEC",
+		"QUEUE_VENUE_B_STAGE_TIMEOUT_SEC", "STAGE_PIPELINE", "QUEUE_STAGING_STAGE_PIPELINE",
+	}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	// デフォルトは1つの待機列
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	require.Len(t, cfg.Queues, 1)
+	assert.Equal(t, QueueConfig{
+		Name:             "default",
+		DinoSlots:        1,
+		StageTimeout:     3 * time.Minute,
+		TeaseDelayMinSec: 10,
+		TeaseDelayMaxSec: 30,
+		PollInterval:     500 * time.Millisecond,
+		Stages: []StageConfig{
+			{Name: "dino", Kind: "dino", Timeout: 3 * time.Minute, MaxAttempts: 1},
+			{Name: "register", Kind: "register", Timeout: 10 * time.Minute, MaxAttempts: 1},
+		},
+	}, cfg.Queues[0])
+
+	os.Setenv("QUEUES", "public, staging,venue-b,public")
+	os.Setenv("QUEUE_STAGING_DINO_SLOTS", "3")
+	os.Setenv("QUEUE_STAGING_TEASE_DELAY_MAX_SEC", "5")
+	os.Setenv("QUEUE_VENUE_B_STAGE_TIMEOUT_SEC", "60")
+	os.Setenv("QUEUE_STAGING_STAGE_PIPELINE", "dino,captcha,register")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	require.Len(t, cfg.Queues, 3)
+
+	assert.Equal(t, "public", cfg.Queues[0].Name)
+	assert.Equal(t, 1, cfg.Queues[0].DinoSlots)
+
+	assert.Equal(t, "staging", cfg.Queues[1].Name)
+	assert.Equal(t, 3, cfg.Queues[1].DinoSlots)
+	assert.Equal(t, 5, cfg.Queues[1].TeaseDelayMaxSec)
+	assert.Equal(t, 10, cfg.Queues[1].TeaseDelayMinSec)
+
+	assert.Equal(t, "venue-b", cfg.Queues[2].Name)
+	assert.Equal(t, time.Minute, cfg.Queues[2].StageTimeout)
+
+	// 待機列ごとのパイプライン（Dino の制限時間は待機列の設定に従う）
+	assert.Len(t, cfg.Queues[0].Stages, 2)
+	require.Len(t, cfg.Queues[1].Stages, 3)
+	assert.Equal(t, "captcha", cfg.Queues[1].Stages[1].Kind)
+	require.Len(t, cfg.Queues[2].Stages, 2)
+	assert.Equal(t, time.Minute, cfg.Queues[2].Stages[0].Timeout)
+}
+
+func TestConfig_Stages(t *testing.T) {
+	keys := []string{
+		"STAGE_PIPELINE", "STAGE_TIMEOUT_SEC", "STAGE_CAPTCHA_MAX_ATTEMPTS",
+		"STAGE_NIGHT_RUN_KIND", "STAGE_NIGHT_RUN_TIMEOUT_SEC", "STAGE_REGISTER_TIMEOUT_SEC",
+	}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	// デフォルトは Dino -> 登録
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []StageConfig{
+		{Name: "dino", Kind: "dino", Timeout: 3 * time.Minute, MaxAttempts: 1},
+		{Name: "register", Kind: "register", Timeout: 10 * time.Minute, MaxAttempts: 1},
+	}, cfg.Stages)
+
+	// CAPTCHA ゲートと2つ目のゲームを追加
+	os.Setenv("STAGE_PIPELINE", "dino, captcha,night-run,register")
+	os.Setenv("STAGE_TIMEOUT_SEC", "120")
+	os.Setenv("STAGE_CAPTCHA_MAX_ATTEMPTS", "5")
+	os.Setenv("STAGE_NIGHT_RUN_KIND", "dino")
+	os.Setenv("STAGE_NIGHT_RUN_TIMEOUT_SEC", "60")
+	os.Setenv("STAGE_REGISTER_TIMEOUT_SEC", "300")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []StageConfig{
+		{Name: "dino", Kind: "dino", Timeout: 2 * time.Minute, MaxAttempts: 1},
+		{Name: "captcha", Kind: "captcha", Timeout: 0, MaxAttempts: 5},
+		{Name: "night-run", Kind: "dino", Timeout: time.Minute, MaxAttempts: 1},
+		{Name: "register", Kind: "register", Timeout: 5 * time.Minute, MaxAttempts: 1},
+	}, cfg.Stages)
+}
+
+func TestConfig_SessionExpiry(t *testing.T) {
+	keys := []string{"SESSION_MAX_AGE_SEC", "SESSION_IDLE_TIMEOUT_SEC", "SESSION_SWEEP_INTERVAL_SEC"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 6*time.Hour, cfg.SessionMaxAge)
+	assert.Equal(t, 15*time.Minute, cfg.SessionIdleTimeout)
+	assert.Equal(t, time.Minute, cfg.SessionSweepInterval)
+
+	os.Setenv("SESSION_MAX_AGE_SEC", "0")
+	os.Setenv("SESSION_IDLE_TIMEOUT_SEC", "300")
+	os.Setenv("SESSION_SWEEP_INTERVAL_SEC", "10")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), cfg.SessionMaxAge)
+	assert.Equal(t, 5*time.Minute, cfg.SessionIdleTimeout)
+	assert.Equal(t, 10*time.Second, cfg.SessionSweepInterval)
+}
+
+func TestConfig_SessionBackend(t *testing.T) {
+	keys := []string{"SESSION_BACKEND", "SESSION_FILE_PATH", "SESSION_FLUSH_INTERVAL_MS"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "memory", cfg.SessionBackend)
+	assert.Equal(t, "sessions.db", cfg.SessionFilePath)
+	assert.Equal(t, time.Second, cfg.SessionFlushInterval)
+
+	os.Setenv("SESSION_BACKEND", "file")
+	os.Setenv("SESSION_FILE_PATH", "/var/lib/app/sessions.db")
+	os.Setenv("SESSION_FLUSH_INTERVAL_MS", "250")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "file", cfg.SessionBackend)
+	assert.Equal(t, "/var/lib/app/sessions.db", cfg.SessionFilePath)
+	assert.Equal(t, 250*time.Millisecond, cfg.SessionFlushInterval)
+}
+
+func TestConfig_SessionCookieKeys(t *testing.T) {
+	saved := os.Getenv("SESSION_COOKIE_KEYS")
+	defer os.Setenv("SESSION_COOKIE_KEYS", saved)
+
+	os.Unsetenv("SESSION_COOKIE_KEYS")
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Empty(t, cfg.SessionCookieKeys)
+
+	os.Setenv("SESSION_COOKIE_KEYS", "new-key-0123456789, old-key-0123456789 ,")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"new-key-0123456789", "old-key-0123456789"}, cfg.SessionCookieKeys)
+}
+
+func TestConfig_RegisterDeadline(t *testing.T) {
+	keys := []string{"REGISTER_COUNTDOWN_INTERVAL_SEC", "REGISTER_WARNING_SEC"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, cfg.RegisterCountdown)
+	assert.Equal(t, []time.Duration{5 * time.Minute, time.Minute, 10 * time.Second}, cfg.RegisterWarnings)
+
+	// 不正な値は無視される
+	os.Setenv("REGISTER_COUNTDOWN_INTERVAL_SEC", "0")
+	os.Setenv("REGISTER_WARNING_SEC", "120, x,-5,30")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), cfg.RegisterCountdown)
+	assert.Equal(t, []time.Duration{2 * time.Minute, 30 * time.Second}, cfg.RegisterWarnings)
+}
+
+func TestConfig_Penalty(t *testing.T) {
+	keys := []string{"PENALTY_POLICY", "PENALTY_RULES"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "back", cfg.PenaltyPolicy)
+	assert.Empty(t, cfg.PenaltyRules)
+
+	// ルールはセミコロン区切り（ポリシーにカンマを含められる）
+	os.Setenv("PENALTY_POLICY", "lives:1>back")
+	os.Setenv("PENALTY_RULES", "stage1_dino:GAME_OVER=escalate:back,random; *:TOKEN_EXPIRED=cooldown:1m ;")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, "lives:1>back", cfg.PenaltyPolicy)
+	assert.Equal(t, []string{"stage1_dino:GAME_OVER=escalate:back,random", "*:TOKEN_EXPIRED=cooldown:1m"}, cfg.PenaltyRules)
+}
+
+func TestConfig_Timeouts(t *testing.T) {
+	keys := []string{"REGISTER_TASK_TIMEOUTS", "TIMEOUT_TICK_MS"}
+	saved := make(map[string]string)
+	for _, key := range keys {
+		saved[key] = os.Getenv(key)
+		os.Unsetenv(key)
+	}
+	defer func() {
+		for k, v := range saved {
+			os.Setenv(k, v)
+		}
+	}()
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, cfg.TimeoutTick)
+	assert.Empty(t, cfg.RegisterTaskTimeouts)
+
+	// 不正な組は無視される
+	os.Setenv("TIMEOUT_TICK_MS", "250")
+	os.Setenv("REGISTER_TASK_TIMEOUTS", "captcha=2m, otp = 90s,email,phone=soon,name=-1s")
+
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 250*time.Millisecond, cfg.TimeoutTick)
+	assert.Equal(t, map[string]time.Duration{
+		"captcha": 2 * time.Minute,
+		"otp":     90 * time.Second,
+	}, cfg.RegisterTaskTimeouts)
+}
+
+func TestConfig_DinoRun(t *testing.T) {
+	saved := os.Getenv("DINO_RUN_SEC")
+	os.Unsetenv("DINO_RUN_SEC")
+	defer os.Setenv("DINO_RUN_SEC", saved)
+
+	cfg, err := LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, cfg.DinoRunDuration)
+
+	os.Setenv("DINO_RUN_SEC", "90")
+	cfg, err = LoadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 90*time.Second, cfg.DinoRunDuration)
+}
@@ -5,6 +5,8 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 )
 
 // DelayGenerator generates random delays within a specified range.
@@ -36,16 +38,22 @@ func (g *DelayGenerator) Generate() time.Duration {
 	return time.Duration(randomSec) * time.Second
 }
 
+// Average returns the mean delay of the configured range.
+func (g *DelayGenerator) Average() time.Duration {
+	return time.Duration(g.minSec+g.maxSec) * time.Second / 2
+}
+
 // DelayExecutor executes delays with optional callbacks.
 type DelayExecutor struct {
 	mu       sync.Mutex
 	canceled bool
-	timer    *time.Timer
+	timer    clock.Timer
+	clock    clock.Clock
 }
 
-// NewDelayExecutor creates a new DelayExecutor.
-func NewDelayExecutor() *DelayExecutor {
-	return &DelayExecutor{}
+// NewDelayExecutor creates a new DelayExecutor that waits on c.
+func NewDelayExecutor(c clock.Clock) *DelayExecutor {
+	return &DelayExecutor{clock: c}
 }
 
 // Execute waits for the specified duration.
@@ -58,7 +66,7 @@ func (e *DelayExecutor) Execute(delay time.Duration) {
 		return
 	}
 
-	time.Sleep(delay)
+	e.clock.Sleep(delay)
 }
 
 // ExecuteWithCallback waits for the specified duration and then calls the callback.
@@ -66,10 +74,11 @@ func (e *DelayExecutor) Execute(delay time.Duration) {
 func (e *DelayExecutor) ExecuteWithCallback(delay time.Duration, callback func()) {
 	e.mu.Lock()
 	e.canceled = false
-	e.timer = time.NewTimer(delay)
+	timer := e.clock.NewTimer(delay)
+	e.timer = timer
 	e.mu.Unlock()
 
-	<-e.timer.C
+	<-timer.C()
 
 	e.mu.Lock()
 	wasCanceled := e.canceled
@@ -4,7 +4,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRandomDelay_Range(t *testing.T) {
@@ -74,7 +76,7 @@ func TestDelayExecutor_Execute(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			executor := NewDelayExecutor()
+			executor := NewDelayExecutor(clock.Real{})
 
 			start := time.Now()
 			executor.Execute(tt.delay)
@@ -86,7 +88,7 @@ func TestDelayExecutor_Execute(t *testing.T) {
 }
 
 func TestDelayExecutor_WithCallback(t *testing.T) {
-	executor := NewDelayExecutor()
+	executor := NewDelayExecutor(clock.Real{})
 	called := false
 
 	executor.ExecuteWithCallback(50*time.Millisecond, func() {
@@ -97,7 +99,7 @@ func TestDelayExecutor_WithCallback(t *testing.T) {
 }
 
 func TestDelayExecutor_Cancel(t *testing.T) {
-	executor := NewDelayExecutor()
+	executor := NewDelayExecutor(clock.Real{})
 	called := false
 
 	go func() {
@@ -114,6 +116,48 @@ func TestDelayExecutor_Cancel(t *testing.T) {
 	assert.False(t, called, "キャンセル後はコールバックが呼ばれないべき")
 }
 
+func TestDelayExecutor_FakeClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	executor := NewDelayExecutor(clk)
+
+	done := make(chan struct{})
+	go func() {
+		executor.ExecuteWithCallback(30*time.Second, func() { close(done) })
+	}()
+
+	// 実時間を待たずに30秒の遅延を終える
+	clk.BlockUntil(1)
+	clk.Advance(29 * time.Second)
+	select {
+	case <-done:
+		t.Fatal("遅延完了前にコールバックが呼ばれた")
+	default:
+	}
+	clk.Advance(time.Second)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("コールバックが呼ばれるべき")
+	}
+}
+
+func TestDelayExecutor_CancelFakeClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	executor := NewDelayExecutor(clk)
+	called := make(chan struct{}, 1)
+
+	go func() {
+		executor.ExecuteWithCallback(30*time.Second, func() { called <- struct{}{} })
+	}()
+
+	// 遅延完了前にキャンセルすると時計を進めても呼ばれない
+	clk.BlockUntil(1)
+	executor.Cancel()
+	require.Equal(t, 0, clk.Pending())
+	clk.Advance(time.Minute)
+	assert.Empty(t, called, "キャンセル後はコールバックが呼ばれないべき")
+}
+
 func TestDefaultDelay(t *testing.T) {
 	// デフォルト設定の確認
 	generator := NewDefaultDelayGenerator()
@@ -124,3 +168,8 @@ func TestDefaultDelay(t *testing.T) {
 	assert.GreaterOrEqual(t, delay.Seconds(), float64(10))
 	assert.LessOrEqual(t, delay.Seconds(), float64(30))
 }
+
+func TestDelayGenerator_Average(t *testing.T) {
+	assert.Equal(t, 20*time.Second, NewDefaultDelayGenerator().Average())
+	assert.Equal(t, 15*time.Second, NewDelayGenerator(15, 15).Average())
+}
@@ -0,0 +1,302 @@
+// Package dino generates Dino Run levels on the server.
+//
+// A level is the obstacle schedule and speed curve of one run. It is derived
+// from a seed alone, so the server only has to remember the seed of a run to
+// rebuild the level and check the result the client reports for it. The seed
+// never leaves the server.
+package dino
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Obstacle kinds.
+const (
+	KindCactusSmall = "cactus_small"
+	KindCactusLarge = "cactus_large"
+	KindPterodactyl = "pterodactyl"
+)
+
+// PterodactylHeights are the flight heights in px above the ground:
+// low ones are jumped over, middle ones ducked under, high ones run under.
+var PterodactylHeights = []int{20, 50, 80}
+
+// Moves that clear an obstacle.
+const (
+	ActionJump = "jump" // cacti and low pterodactyls
+	ActionDuck = "duck" // middle pterodactyls
+	ActionRun  = "run"  // high pterodactyls, nothing to do
+)
+
+// ActionWindow is how long before an obstacle reaches the dino the move
+// clearing it may start.
+const ActionWindow = 500 * time.Millisecond
+
+// PixelsPerPoint is how far the dino runs for one point of score.
+const PixelsPerPoint = 40
+
+// Slack is how much earlier than the level's end a clear is accepted, to
+// allow for a client clock running slightly fast.
+const Slack = time.Second
+
+// ScoreTolerance is the relative difference allowed between the reported
+// score and the one the speed curve gives, to allow for frame timing.
+const ScoreTolerance = 0.05
+
+// Errors returned by Level.Check.
+var (
+	ErrTooEarly = errors.New("dino: cleared before the level ended")
+	ErrMoves    = errors.New("dino: moves do not clear the level's obstacles")
+	ErrScore    = errors.New("dino: score does not match the level")
+)
+
+// Config shapes the generated levels. Zero fields use DefaultConfig's, except
+// PterodactylRate: a level without pterodactyls leaves it at zero.
+type Config struct {
+	Duration         time.Duration // how long a run lasts; surviving it clears the gate
+	StartSpeed       float64       // px per second at the start
+	MaxSpeed         float64       // px per second the speed never exceeds
+	Acceleration     float64       // average px per second gained every second
+	SpeedStep        time.Duration // time between the points of the speed curve
+	LeadIn           time.Duration // obstacle-free time at the start
+	MinGap           time.Duration // shortest time between two obstacles
+	MaxGap           time.Duration // longest time between two obstacles
+	PterodactylAfter time.Duration // pterodactyls only appear after this
+	PterodactylRate  float64       // chance for an obstacle to be a pterodactyl once they appear, 0 for none
+}
+
+// DefaultConfig returns the settings of the default level: one minute,
+// speeding up from 360 to 780 px/s.
+func DefaultConfig() Config {
+	return Config{
+		Duration:         time.Minute,
+		StartSpeed:       360,
+		MaxSpeed:         780,
+		Acceleration:     8,
+		SpeedStep:        5 * time.Second,
+		LeadIn:           2 * time.Second,
+		MinGap:           700 * time.Millisecond,
+		MaxGap:           1800 * time.Millisecond,
+		PterodactylAfter: 15 * time.Second,
+		PterodactylRate:  0.2,
+	}
+}
+
+// withDefaults fills the zero fields from DefaultConfig.
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+	if c.Duration <= 0 {
+		c.Duration = d.Duration
+	}
+	if c.StartSpeed <= 0 {
+		c.StartSpeed = d.StartSpeed
+	}
+	if c.MaxSpeed < c.StartSpeed {
+		c.MaxSpeed = max(d.MaxSpeed, c.StartSpeed)
+	}
+	if c.Acceleration <= 0 {
+		c.Acceleration = d.Acceleration
+	}
+	if c.SpeedStep <= 0 {
+		c.SpeedStep = d.SpeedStep
+	}
+	if c.LeadIn <= 0 {
+		c.LeadIn = d.LeadIn
+	}
+	if c.MinGap <= 0 {
+		c.MinGap = d.MinGap
+	}
+	if c.MaxGap < c.MinGap {
+		c.MaxGap = max(d.MaxGap, c.MinGap)
+	}
+	if c.PterodactylAfter <= 0 {
+		c.PterodactylAfter = d.PterodactylAfter
+	}
+	return c
+}
+
+// Obstacle is one obstacle of a level.
+type Obstacle struct {
+	Kind   string `json:"kind"`
+	AtMs   int64  `json:"at_ms"`            // when it reaches the dino, from the start of the run
+	Count  int    `json:"count,omitempty"`  // cacti in the group
+	Height int    `json:"height,omitempty"` // pterodactyl flight height in px above the ground
+}
+
+// Action returns the move that clears the obstacle.
+func (o Obstacle) Action() string {
+	switch {
+	case o.Kind != KindPterodactyl || o.Height <= PterodactylHeights[0]:
+		return ActionJump
+	case o.Height <= PterodactylHeights[1]:
+		return ActionDuck
+	default:
+		return ActionRun
+	}
+}
+
+// Move is what the dino did at one obstacle of a run.
+type Move struct {
+	AtMs   int64  `json:"at_ms"`  // when the move started, from the start of the run (ignored for ActionRun)
+	Action string `json:"action"` // ActionJump, ActionDuck or ActionRun
+}
+
+// SpeedPoint is a point of the speed curve. The speed changes linearly
+// between points and stays at the last point's after it.
+type SpeedPoint struct {
+	AtMs  int64   `json:"at_ms"`
+	Speed float64 `json:"speed"` // px per second
+}
+
+// Level is the obstacle schedule and speed curve of one run.
+type Level struct {
+	Seed       int64        `json:"-"` // kept on the server, so clients can't replay a level from it
+	DurationMs int64        `json:"duration_ms"`
+	Obstacles  []Obstacle   `json:"obstacles"`
+	Speed      []SpeedPoint `json:"speed"`
+}
+
+// MaxSeed bounds the seeds handed out with NewSeed, so they survive a
+// JSON round trip through JavaScript numbers.
+const MaxSeed = 1 << 53
+
+// NewSeed returns a random seed for a new run.
+func NewSeed() int64 {
+	return rand.Int63n(MaxSeed)
+}
+
+// Generate builds the level of a seed. The same seed and config always give
+// the same level.
+func Generate(seed int64, cfg Config) *Level {
+	cfg = cfg.withDefaults()
+	rng := rand.New(rand.NewSource(seed))
+	level := &Level{
+		Seed:       seed,
+		DurationMs: cfg.Duration.Milliseconds(),
+	}
+
+	// Speed curve: the speed gains a random share of the acceleration every step
+	speed := cfg.StartSpeed
+	step := cfg.SpeedStep.Seconds()
+	for at := time.Duration(0); ; at += cfg.SpeedStep {
+		if at > cfg.Duration {
+			at = cfg.Duration
+		}
+		level.Speed = append(level.Speed, SpeedPoint{AtMs: at.Milliseconds(), Speed: math.Round(speed)})
+		if at == cfg.Duration {
+			break
+		}
+		speed = math.Min(cfg.MaxSpeed, speed+cfg.Acceleration*step*(0.5+rng.Float64()))
+	}
+
+	// Obstacles until shortly before the end, so the last one can be cleared in time
+	gapRange := int64(cfg.MaxGap - cfg.MinGap)
+	for at := cfg.LeadIn; at < cfg.Duration-cfg.MinGap; {
+		obstacle := Obstacle{AtMs: at.Milliseconds()}
+		switch {
+		case cfg.PterodactylRate > 0 && at >= cfg.PterodactylAfter && rng.Float64() < cfg.PterodactylRate:
+			obstacle.Kind = KindPterodactyl
+			obstacle.Height = PterodactylHeights[rng.Intn(len(PterodactylHeights))]
+		case rng.Intn(2) == 0:
+			obstacle.Kind = KindCactusSmall
+			obstacle.Count = 1 + rng.Intn(3)
+		default:
+			obstacle.Kind = KindCactusLarge
+			obstacle.Count = 1 + rng.Intn(2)
+		}
+		level.Obstacles = append(level.Obstacles, obstacle)
+
+		gap := cfg.MinGap
+		if gapRange > 0 {
+			gap += time.Duration(rng.Int63n(gapRange + 1))
+		}
+		// A group of cacti takes longer to land behind
+		if obstacle.Count > 1 {
+			gap += time.Duration(obstacle.Count-1) * 150 * time.Millisecond
+		}
+		at += gap
+	}
+	return level
+}
+
+// Duration returns how long the run lasts.
+func (l *Level) Duration() time.Duration {
+	return time.Duration(l.DurationMs) * time.Millisecond
+}
+
+// SpeedAt returns the speed in px per second at ms from the start of the run.
+func (l *Level) SpeedAt(ms int64) float64 {
+	if len(l.Speed) == 0 {
+		return 0
+	}
+	for i := 1; i < len(l.Speed); i++ {
+		a, b := l.Speed[i-1], l.Speed[i]
+		if ms < b.AtMs {
+			if ms <= a.AtMs {
+				return a.Speed
+			}
+			return a.Speed + (b.Speed-a.Speed)*float64(ms-a.AtMs)/float64(b.AtMs-a.AtMs)
+		}
+	}
+	return l.Speed[len(l.Speed)-1].Speed
+}
+
+// DistanceAt returns how many px the dino has run at ms from the start.
+func (l *Level) DistanceAt(ms int64) float64 {
+	distance := 0.0
+	for i := 1; i < len(l.Speed) && l.Speed[i-1].AtMs < ms; i++ {
+		a, b := l.Speed[i-1], l.Speed[i]
+		end := min(ms, b.AtMs)
+		distance += (a.Speed + l.SpeedAt(end)) / 2 * float64(end-a.AtMs) / 1000
+	}
+	if n := len(l.Speed); n > 0 && ms > l.Speed[n-1].AtMs {
+		distance += l.Speed[n-1].Speed * float64(ms-l.Speed[n-1].AtMs) / 1000
+	}
+	return distance
+}
+
+// Score returns the score of a run that survived the whole level.
+func (l *Level) Score() int {
+	return int(l.DistanceAt(l.DurationMs) / PixelsPerPoint)
+}
+
+// Check reports whether a clear with the given moves and score, elapsed
+// after the level was handed out, is possible. It returns ErrTooEarly if the
+// level cannot have been played to its end yet, ErrMoves if the moves don't
+// clear every obstacle and ErrScore if the score is not the level's.
+func (l *Level) Check(elapsed time.Duration, score int, moves []Move) error {
+	if elapsed < l.Duration()-Slack {
+		return ErrTooEarly
+	}
+	if !l.cleared(moves) {
+		return ErrMoves
+	}
+	want := l.Score()
+	if math.Abs(float64(score-want)) > float64(want)*ScoreTolerance {
+		return ErrScore
+	}
+	return nil
+}
+
+// cleared reports whether moves, one per obstacle in schedule order, clear
+// every obstacle: each must be the obstacle's action, started within
+// ActionWindow before it reaches the dino.
+func (l *Level) cleared(moves []Move) bool {
+	if len(moves) != len(l.Obstacles) {
+		return false
+	}
+	window := ActionWindow.Milliseconds()
+	for i, o := range l.Obstacles {
+		move := moves[i]
+		if move.Action != o.Action() {
+			return false
+		}
+		if move.Action != ActionRun && (move.AtMs > o.AtMs || move.AtMs < o.AtMs-window) {
+			return false
+		}
+	}
+	return true
+}
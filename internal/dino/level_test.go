@@ -0,0 +1,186 @@
+package dino
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerate_Deterministic(t *testing.T) {
+	cfg := DefaultConfig()
+
+	// 同じシードからは同じレベルが生成される
+	assert.Equal(t, Generate(42, cfg), Generate(42, cfg))
+	assert.NotEqual(t, Generate(42, cfg).Obstacles, Generate(43, cfg).Obstacles)
+}
+
+func TestGenerate_Schedule(t *testing.T) {
+	cfg := DefaultConfig()
+
+	for seed := int64(0); seed < 50; seed++ {
+		level := Generate(seed, cfg)
+		require.NotEmpty(t, level.Obstacles)
+		assert.Equal(t, cfg.Duration.Milliseconds(), level.DurationMs)
+
+		// 障害物は開始直後と終了直前を避け、最短間隔以上空いている
+		prev := int64(0)
+		for i, o := range level.Obstacles {
+			assert.GreaterOrEqual(t, o.AtMs, cfg.LeadIn.Milliseconds())
+			assert.Less(t, o.AtMs, (cfg.Duration - cfg.MinGap).Milliseconds())
+			if i > 0 {
+				assert.GreaterOrEqual(t, o.AtMs-prev, cfg.MinGap.Milliseconds())
+			}
+			prev = o.AtMs
+
+			switch o.Kind {
+			case KindPterodactyl:
+				assert.GreaterOrEqual(t, o.AtMs, cfg.PterodactylAfter.Milliseconds(), "プテラノドンは序盤に出ない")
+				assert.Contains(t, PterodactylHeights, o.Height)
+			case KindCactusSmall, KindCactusLarge:
+				assert.GreaterOrEqual(t, o.Count, 1)
+				assert.LessOrEqual(t, o.Count, 3)
+			default:
+				t.Fatalf("unknown obstacle kind %q", o.Kind)
+			}
+		}
+
+		// 速度は加速のみで上限を超えない
+		require.NotEmpty(t, level.Speed)
+		assert.Equal(t, cfg.StartSpeed, level.Speed[0].Speed)
+		assert.Equal(t, level.DurationMs, level.Speed[len(level.Speed)-1].AtMs)
+		for i := 1; i < len(level.Speed); i++ {
+			assert.GreaterOrEqual(t, level.Speed[i].Speed, level.Speed[i-1].Speed)
+			assert.LessOrEqual(t, level.Speed[i].Speed, cfg.MaxSpeed)
+		}
+	}
+}
+
+func TestGenerate_Defaults(t *testing.T) {
+	// ゼロ値の設定はデフォルトで補われる
+	assert.Equal(t, Generate(7, DefaultConfig()), Generate(7, Config{PterodactylRate: DefaultConfig().PterodactylRate}))
+
+	level := Generate(7, Config{Duration: 10 * time.Second})
+	assert.Equal(t, int64(10000), level.DurationMs)
+}
+
+func TestGenerate_NoPterodactyls(t *testing.T) {
+	// 出現率0ならプテラノドンは出ない
+	for seed := int64(0); seed < 50; seed++ {
+		for _, o := range Generate(seed, Config{}).Obstacles {
+			assert.NotEqual(t, KindPterodactyl, o.Kind)
+		}
+	}
+}
+
+func TestLevel_SeedNotSerialized(t *testing.T) {
+	// シードはサーバーだけが持つ
+	raw, err := json.Marshal(Generate(42, DefaultConfig()))
+	require.NoError(t, err)
+	var fields map[string]interface{}
+	require.NoError(t, json.Unmarshal(raw, &fields))
+	assert.NotContains(t, fields, "seed")
+	assert.Contains(t, fields, "obstacles")
+}
+
+func TestObstacle_Action(t *testing.T) {
+	tests := []struct {
+		obstacle Obstacle
+		want     string
+	}{
+		{obstacle: Obstacle{Kind: KindCactusSmall, Count: 2}, want: ActionJump},
+		{obstacle: Obstacle{Kind: KindCactusLarge, Count: 1}, want: ActionJump},
+		{obstacle: Obstacle{Kind: KindPterodactyl, Height: 20}, want: ActionJump},
+		{obstacle: Obstacle{Kind: KindPterodactyl, Height: 50}, want: ActionDuck},
+		{obstacle: Obstacle{Kind: KindPterodactyl, Height: 80}, want: ActionRun},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.obstacle.Action(), "%+v", tt.obstacle)
+	}
+}
+
+// clearingMoves returns the moves clearing every obstacle of the level,
+// each started just before the obstacle reaches the dino.
+func clearingMoves(level *Level) []Move {
+	moves := make([]Move, len(level.Obstacles))
+	for i, o := range level.Obstacles {
+		moves[i] = Move{AtMs: o.AtMs - 100, Action: o.Action()}
+	}
+	return moves
+}
+
+func TestLevel_Distance(t *testing.T) {
+	level := &Level{
+		DurationMs: 20000,
+		Speed: []SpeedPoint{
+			{AtMs: 0, Speed: 100},
+			{AtMs: 10000, Speed: 300},
+			{AtMs: 20000, Speed: 300},
+		},
+	}
+
+	assert.Equal(t, 100.0, level.SpeedAt(0))
+	assert.Equal(t, 200.0, level.SpeedAt(5000))
+	assert.Equal(t, 300.0, level.SpeedAt(25000))
+
+	assert.InDelta(t, 750.0, level.DistanceAt(5000), 1e-9)
+	assert.InDelta(t, 2000.0, level.DistanceAt(10000), 1e-9)
+	assert.InDelta(t, 5000.0, level.DistanceAt(20000), 1e-9)
+	assert.InDelta(t, 5600.0, level.DistanceAt(22000), 1e-9)
+	assert.Equal(t, 5000/PixelsPerPoint, level.Score())
+}
+
+func TestLevel_Check(t *testing.T) {
+	level := Generate(1, DefaultConfig())
+	score := level.Score()
+	moves := clearingMoves(level)
+
+	// 操作記録を1つだけ書き換える
+	with := func(i int, move Move) []Move {
+		changed := append([]Move(nil), moves...)
+		changed[i] = move
+		return changed
+	}
+	first := level.Obstacles[0]
+
+	tests := []struct {
+		name    string
+		elapsed time.Duration
+		score   int
+		moves   []Move
+		want    error
+	}{
+		{name: "正常系: 最後まで走った", elapsed: level.Duration() + 3*time.Second, score: score},
+		{name: "正常系: 時計の誤差の範囲", elapsed: level.Duration() - Slack, score: score},
+		{name: "正常系: スコアの誤差の範囲", elapsed: level.Duration(), score: score * 102 / 100},
+		{name: "異常系: 終了前のクリア", elapsed: level.Duration() / 2, score: score, want: ErrTooEarly},
+		{name: "異常系: スコアが高すぎる", elapsed: level.Duration(), score: score * 2, want: ErrScore},
+		{name: "異常系: スコアが低すぎる", elapsed: level.Duration(), score: 1, want: ErrScore},
+		{name: "正常系: 受付時間の最初に操作", elapsed: level.Duration(), score: score, moves: with(0, Move{AtMs: first.AtMs - ActionWindow.Milliseconds(), Action: ActionJump})},
+		{name: "異常系: 操作記録なし", elapsed: level.Duration(), score: score, moves: []Move{}, want: ErrMoves},
+		{name: "異常系: 障害物より操作が少ない", elapsed: level.Duration(), score: score, moves: moves[1:], want: ErrMoves},
+		{name: "異常系: 避け方が違う", elapsed: level.Duration(), score: score, moves: with(0, Move{AtMs: first.AtMs - 100, Action: ActionDuck}), want: ErrMoves},
+		{name: "異常系: 障害物の後に操作", elapsed: level.Duration(), score: score, moves: with(0, Move{AtMs: first.AtMs + 1, Action: ActionJump}), want: ErrMoves},
+		{name: "異常系: 操作が早すぎる", elapsed: level.Duration(), score: score, moves: with(0, Move{AtMs: first.AtMs - ActionWindow.Milliseconds() - 1, Action: ActionJump}), want: ErrMoves},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.moves == nil {
+				tt.moves = moves
+			}
+			assert.Equal(t, tt.want, level.Check(tt.elapsed, tt.score, tt.moves))
+		})
+	}
+}
+
+func TestNewSeed(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		seed := NewSeed()
+		assert.GreaterOrEqual(t, seed, int64(0))
+		assert.Less(t, seed, int64(MaxSeed))
+	}
+}
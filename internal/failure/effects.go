@@ -0,0 +1,85 @@
+package failure
+
+import (
+	"log"
+	"sync"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// Effect is a side effect of a failure. It runs with the user's lock held,
+// after the user was sent back to waiting and before their socket is closed.
+// It also runs for failures absorbed by a life (see Event.Penalty).
+type Effect func(e Event, user *model.User)
+
+// Audit logs every failure.
+func Audit(e Event, _ *model.User) {
+	log.Printf("[Failure] user=%s stage=%s reason=%s attempts=%d penalty=%+v", e.UserID, e.Stage, e.Reason, e.Attempts, e.Penalty)
+}
+
+// Requeuer puts a failed user back at the end of their queue.
+// It is called with the user's lock held.
+type Requeuer interface {
+	Requeue(user *model.User)
+}
+
+// Requeue returns an effect that puts failed users back at the end of their
+// queue right away, instead of when they reconnect. Reconnecting keeps that place.
+// Users who stay in their gate or wait out a cooldown are left alone.
+func Requeue(q Requeuer) Effect {
+	return func(e Event, user *model.User) {
+		if !e.Penalty.Absorbed && e.Penalty.Cooldown == 0 {
+			q.Requeue(user)
+		}
+	}
+}
+
+// Metrics counts failures by reason and by stage, and those absorbed by lives.
+type Metrics struct {
+	mu       sync.Mutex
+	total    int64
+	byReason map[Reason]int64
+	byStage  map[string]int64
+	absorbed int64
+}
+
+// NewMetrics creates empty failure metrics.
+func NewMetrics() *Metrics {
+	return &Metrics{
+		byReason: make(map[Reason]int64),
+		byStage:  make(map[string]int64),
+	}
+}
+
+// Record counts a failure. It has the signature of an Effect.
+func (m *Metrics) Record(e Event, _ *model.User) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.total++
+	m.byReason[e.Reason]++
+	m.byStage[e.Stage]++
+	if e.Penalty.Absorbed {
+		m.absorbed++
+	}
+}
+
+// Stats returns the failure counts for the status endpoint.
+func (m *Metrics) Stats() map[string]interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	byReason := make(map[string]int64, len(m.byReason))
+	for reason, n := range m.byReason {
+		byReason[string(reason)] = n
+	}
+	byStage := make(map[string]int64, len(m.byStage))
+	for stage, n := range m.byStage {
+		byStage[stage] = n
+	}
+	return map[string]interface{}{
+		"total":     m.total,
+		"absorbed":  m.absorbed,
+		"by_reason": byReason,
+		"by_stage":  byStage,
+	}
+}
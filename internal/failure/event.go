@@ -0,0 +1,121 @@
+package failure
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/penalty"
+)
+
+// Reason is the code of a failure. It is sent to the client as "code".
+type Reason string
+
+// Failure reasons.
+const (
+	ReasonGameOver      Reason = "GAME_OVER"      // Dino Run lost with no attempts left
+	ReasonTimeout       Reason = "STAGE_TIMEOUT"  // a gate's time limit ran out
+	ReasonCaptchaFailed Reason = "CAPTCHA_FAILED" // too many wrong CAPTCHA answers
+	ReasonOTPFailed     Reason = "OTP_FAILED"     // too many wrong OTP answers
+	ReasonServerError   Reason = "SERVER_ERROR"   // the registration's (fake) server error
+	ReasonTokenExpired  Reason = "TOKEN_EXPIRED"  // the registration deadline passed
+	ReasonOther         Reason = "FAILED"         // any other failure, with its own message
+)
+
+// retryable are the reasons a life can absorb: the user can stay in their gate and try again.
+var retryable = map[Reason]bool{
+	ReasonGameOver:      true,
+	ReasonCaptchaFailed: true,
+	ReasonOTPFailed:     true,
+	ReasonServerError:   true,
+}
+
+// RedirectDelay is how many seconds the client waits before going back to the queue.
+const RedirectDelay = 3
+
+// Event describes one failure of a user.
+type Event struct {
+	Reason   Reason          `json:"reason"`
+	UserID   string          `json:"user_id"`
+	Stage    string          `json:"stage"`              // status the user failed in
+	Attempts int             `json:"attempts,omitempty"` // failed attempts that led to it, if counted
+	Message  string          `json:"message"`
+	Penalty  penalty.Penalty `json:"penalty"`
+	At       time.Time       `json:"at"`
+}
+
+// Message returns the message shown to the user for a failure and its penalty.
+func Message(reason Reason, attempts int, p penalty.Penalty) string {
+	return cause(reason, attempts) + p.Describe()
+}
+
+// cause describes what went wrong.
+func cause(reason Reason, attempts int) string {
+	switch reason {
+	case ReasonGameOver:
+		return "ゲームオーバー。"
+	case ReasonTimeout:
+		return "タイムアウト！"
+	case ReasonCaptchaFailed, ReasonOTPFailed:
+		return fmt.Sprintf("%d回失敗しました。", attempts)
+	case ReasonServerError:
+		return "サーバーエラーが発生しました。"
+	case ReasonTokenExpired:
+		return "登録の制限時間を過ぎました。"
+	default:
+		return "失敗しました。"
+	}
+}
+
+// WSMessage returns the message pushed over the user's WebSocket: a failure,
+// or a life_lost when a life absorbed it and the user stays where they are.
+func (e Event) WSMessage() map[string]interface{} {
+	if e.Penalty.Absorbed {
+		return map[string]interface{}{
+			"type":       "life_lost",
+			"code":       string(e.Reason),
+			"stage":      e.Stage,
+			"message":    e.Message,
+			"lives_left": e.Penalty.LivesLeft,
+		}
+	}
+	msg := map[string]interface{}{
+		"type":           "failure",
+		"code":           string(e.Reason),
+		"stage":          e.Stage,
+		"message":        e.Message,
+		"redirect_delay": float64(RedirectDelay),
+	}
+	e.addPenalty(msg)
+	return msg
+}
+
+// Response returns the body of the HTTP response to the request that failed the user.
+func (e Event) Response() map[string]interface{} {
+	if e.Penalty.Absorbed {
+		return map[string]interface{}{
+			"error":      true,
+			"code":       string(e.Reason),
+			"message":    e.Message,
+			"lives_left": e.Penalty.LivesLeft,
+		}
+	}
+	resp := map[string]interface{}{
+		"error":          true,
+		"code":           string(e.Reason),
+		"message":        e.Message,
+		"redirect_delay": float64(RedirectDelay),
+	}
+	e.addPenalty(resp)
+	return resp
+}
+
+// addPenalty adds where the user goes back in line and when to a message.
+func (e Event) addPenalty(msg map[string]interface{}) {
+	if e.Penalty.Placement != "" {
+		msg["placement"] = string(e.Penalty.Placement)
+	}
+	if e.Penalty.Cooldown > 0 {
+		msg["cooldown_sec"] = int(e.Penalty.Cooldown / time.Second)
+		msg["rejoin_at"] = e.At.Add(e.Penalty.Cooldown)
+	}
+}
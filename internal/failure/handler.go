@@ -1,8 +1,18 @@
 // Package failure provides failure handling for the application.
+// Every failure goes through FailureHandler.Fail: the penalty policy decides
+// what it costs, the user gets the same failure message, goes back to
+// waiting, the configured side effects run and their socket is closed so
+// they reconnect fresh.
 package failure
 
 import (
+	"math/rand/v2"
+	"sync"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/penalty"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 )
 
 // QueueInterface defines the interface for the waiting queue.
@@ -10,35 +20,167 @@ type QueueInterface interface {
 	Add(userID string, conn model.WebSocketConn)
 }
 
+// Placer puts a penalized user back in their queue ahead of time.
+// It is called with the user's lock held.
+type Placer interface {
+	// Place reserves a place for the user at the 1-indexed position.
+	Place(user *model.User, position int)
+	// QueueLen returns the number of entries in the user's queue, phantoms included.
+	QueueLen(user *model.User) int
+}
+
 // FailureHandler handles user failures and resets their state.
 type FailureHandler struct {
-	queue QueueInterface
+	mu      sync.Mutex
+	queue   QueueInterface
+	machine *stage.Machine
+	effects []Effect
+	policy  penalty.Policy
+	placer  Placer
+	clock   clock.Clock
 }
 
 // NewFailureHandler creates a new FailureHandler.
+// Every failure sends the user to the back of the line until SetPolicy says otherwise.
 func NewFailureHandler(queue QueueInterface) *FailureHandler {
 	return &FailureHandler{
-		queue: queue,
+		queue:   queue,
+		machine: stage.NewMachine(),
+		policy:  penalty.BackOfLine{},
+		clock:   clock.Real{},
 	}
 }
 
-// HandleFailure processes a user failure, sends notification, closes connection,
-// and adds the user back to the waiting queue.
+// SetPolicy sets the penalty policy, e.g. a *penalty.Rules changed at runtime.
+func (h *FailureHandler) SetPolicy(policy penalty.Policy) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.policy = policy
+}
+
+// SetPlacer sets where penalized users are placed when the penalty names a position.
+// Without one they join the back of the line when they reconnect.
+func (h *FailureHandler) SetPlacer(placer Placer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.placer = placer
+}
+
+// SetMachine sets the state machine that applies status changes.
+func (h *FailureHandler) SetMachine(machine *stage.Machine) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.machine = machine
+}
+
+// SetClock sets the clock failures are stamped with.
+func (h *FailureHandler) SetClock(c clock.Clock) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.clock = c
+}
+
+// Use adds side effects run on every failure, in order.
+func (h *FailureHandler) Use(effects ...Effect) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.effects = append(h.effects, effects...)
+}
+
+// Fail sends the user back to the end of the queue for the given reason.
+// attempts is the number of failed attempts that led to it (0 if not counted).
+// The caller must hold the user's lock, e.g. by calling it from the session store's Update.
+func (h *FailureHandler) Fail(user *model.User, reason Reason, attempts int) Event {
+	return h.fail(user, Event{Reason: reason, Attempts: attempts})
+}
+
+// HandleFailure processes a user failure with its own message, sends notification,
+// closes connection and sends the user back to waiting.
+// The caller must hold the user's lock, e.g. by calling it from the session store's Update.
 func (h *FailureHandler) HandleFailure(user *model.User, message string) error {
+	h.fail(user, Event{Reason: ReasonOther, Message: message})
+	return nil
+}
+
+// HandleTokenExpired handles a register token that ran out before registering.
+// It has the signature of token.TokenMonitor's expiry callback.
+func (h *FailureHandler) HandleTokenExpired(user *model.User) error {
+	h.Fail(user, ReasonTokenExpired, 0)
+	return nil
+}
+
+// fail runs the failure pipeline. The caller must hold the user's lock.
+func (h *FailureHandler) fail(user *model.User, e Event) Event {
+	h.mu.Lock()
+	machine, effects, policy, placer, clk := h.machine, h.effects, h.policy, h.placer, h.clock
+	h.mu.Unlock()
+
+	e.UserID = user.ID
+	e.Stage = user.Status
+	e.At = clk.Now()
+
+	// Decide what the failure costs
+	user.Failures++
+	if retryable[e.Reason] {
+		user.Retries++
+	}
+	e.Penalty = policy.Penalty(penalty.Failure{
+		Stage:     e.Stage,
+		Reason:    string(e.Reason),
+		Count:     user.Failures,
+		Retries:   user.Retries,
+		Retryable: retryable[e.Reason],
+	})
+	if e.Message == "" {
+		e.Message = Message(e.Reason, e.Attempts, e.Penalty)
+	}
+
+	// A life absorbed it: the user stays in their gate with fresh attempts
+	if e.Penalty.Absorbed {
+		user.StageAttempts = 0
+		user.CaptchaAttempts = 0
+		user.OTPAttempts = 0
+		if user.Conn != nil {
+			_ = user.Conn.WriteJSON(e.WSMessage())
+		}
+		for _, effect := range effects {
+			effect(e, user)
+		}
+		return e
+	}
+
 	// Send failure message via WebSocket
 	if user.Conn != nil {
-		_ = user.Conn.WriteJSON(map[string]interface{}{
-			"type":           "failure",
-			"message":        message,
-			"redirect_delay": float64(3),
-		})
+		_ = user.Conn.WriteJSON(e.WSMessage())
 	}
 
 	// Reset user state
-	user.ResetToWaiting()
+	_ = machine.Fire(user, stage.EventFail)
+
+	// Apply the penalty: place the user now, or when they rejoin after a cooldown
+	position := 0
+	if placer != nil {
+		switch e.Penalty.Placement {
+		case penalty.PlacePosition:
+			position = e.Penalty.Position
+		case penalty.PlaceRandom:
+			position = rand.IntN(placer.QueueLen(user)+1) + 1
+		}
+	}
+	if e.Penalty.Cooldown > 0 {
+		user.RejoinAt = e.At.Add(e.Penalty.Cooldown)
+		user.RejoinPosition = position
+	} else if position > 0 {
+		placer.Place(user, position)
+	}
+
+	for _, effect := range effects {
+		effect(e, user)
+	}
 
 	// Close WebSocket connection - user needs to reconnect fresh
 	// Don't add to queue here - the user will be added when they reconnect via WebSocket
+	// (unless the penalty or the Requeue effect already placed them)
 	if user.Conn != nil {
 		conn := user.Conn // Capture for goroutine
 		user.Conn = nil   // Clear the connection reference
@@ -47,25 +189,5 @@ func (h *FailureHandler) HandleFailure(user *model.User, message string) error {
 		}()
 	}
 
-	return nil
-}
-
-// HandleCaptchaFailure handles CAPTCHA verification failure.
-func (h *FailureHandler) HandleCaptchaFailure(user *model.User) error {
-	return h.HandleFailure(user, "3回失敗しました。待機列の最後尾からやり直しです。")
-}
-
-// HandleDinoFailure handles Dino Run game failure.
-func (h *FailureHandler) HandleDinoFailure(user *model.User) error {
-	return h.HandleFailure(user, "ゲームオーバー。待機列の最後尾からやり直しです。")
-}
-
-// HandleOTPFailure handles OTP verification failure.
-func (h *FailureHandler) HandleOTPFailure(user *model.User) error {
-	return h.HandleFailure(user, "魚の名前を3回間違えました。")
-}
-
-// HandleTimeoutFailure handles timeout failures.
-func (h *FailureHandler) HandleTimeoutFailure(user *model.User, message string) error {
-	return h.HandleFailure(user, message)
+	return e
 }
@@ -0,0 +1,169 @@
+// Package game runs the time limits of the stage gates.
+package game
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+	"github.com/kyiku/hackz-ptera-back/internal/timeout"
+)
+
+// SessionUpdater runs a function with a session's user locked.
+// session.Backend satisfies this interface.
+type SessionUpdater interface {
+	Update(sessionID string, fn func(user *model.User) error) error
+}
+
+// TaskDeadline returns the name of a dashboard task's deadline on the scheduler.
+func TaskDeadline(task string) string {
+	return "task:" + task
+}
+
+// Deadlines fails users who stay in a gate longer than its time limit.
+//
+// Entering a gate with a Timeout starts a deadline named after the gate on the
+// shared scheduler, and leaving it cancels the deadline. The register gate's
+// limit is the register token's (see token.TokenMonitor), but its dashboard
+// tasks can have limits of their own, counted from entering the gate.
+type Deadlines struct {
+	mu        sync.Mutex
+	scheduler *timeout.Scheduler
+	store     SessionUpdater
+	failures  *failure.FailureHandler
+	tasks     map[string]time.Duration // task -> time limit
+	pipeline  *stage.Pipeline          // set by Watch
+}
+
+// NewDeadlines creates gate deadlines on the scheduler.
+func NewDeadlines(scheduler *timeout.Scheduler, store SessionUpdater) *Deadlines {
+	return &Deadlines{
+		scheduler: scheduler,
+		store:     store,
+		failures:  failure.NewFailureHandler(nil),
+	}
+}
+
+// SetFailureHandler sets the failure pipeline users go through on timeout.
+func (d *Deadlines) SetFailureHandler(failures *failure.FailureHandler) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.failures = failures
+}
+
+// SetTaskTimeouts sets time limits for dashboard tasks, keyed by task.
+// They apply to users entering the register gate after the call.
+func (d *Deadlines) SetTaskTimeouts(tasks map[string]time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tasks = tasks
+}
+
+// Watch starts and cancels the deadlines as the machine moves users between gates.
+func (d *Deadlines) Watch(machine *stage.Machine) {
+	d.mu.Lock()
+	d.pipeline = machine.Pipeline()
+	d.mu.Unlock()
+
+	for _, gate := range machine.Pipeline().Gates() {
+		if gate.Kind == stage.KindRegister {
+			machine.OnEnter(gate.Status, d.startTasks)
+			machine.OnExit(gate.Status, d.cancelTasks)
+			continue
+		}
+		if gate.Timeout > 0 {
+			machine.OnEnter(gate.Status, d.startGate(gate))
+			machine.OnExit(gate.Status, d.cancelGate(gate))
+		}
+	}
+}
+
+// Resume starts the deadlines of the gate a restored user is in, as if they
+// had just entered it. The caller must hold the user's lock.
+func (d *Deadlines) Resume(user *model.User) {
+	d.mu.Lock()
+	pipeline := d.pipeline
+	d.mu.Unlock()
+	if pipeline == nil {
+		return
+	}
+
+	gate, ok := pipeline.Gate(user.Status)
+	switch {
+	case !ok:
+	case gate.Kind == stage.KindRegister:
+		d.startTasks(user, stage.Transition{To: gate.Status})
+	case gate.Timeout > 0:
+		d.startGate(gate)(user, stage.Transition{To: gate.Status})
+	}
+}
+
+// Extend moves the named deadline of the user by the given duration.
+// Returns false if the user has no such deadline.
+func (d *Deadlines) Extend(user *model.User, name string, by time.Duration) bool {
+	return d.scheduler.Extend(user.ID, name, by)
+}
+
+// startGate returns the hook starting a gate's deadline.
+func (d *Deadlines) startGate(gate stage.Gate) stage.Hook {
+	return func(user *model.User, _ stage.Transition) {
+		sessionID := user.SessionID
+		d.scheduler.Schedule(user.ID, gate.Name, gate.Timeout, func() {
+			d.expire(sessionID, gate.Status, func(*model.User) bool { return true })
+		})
+	}
+}
+
+// cancelGate returns the hook cancelling a gate's deadline.
+func (d *Deadlines) cancelGate(gate stage.Gate) stage.Hook {
+	return func(user *model.User, _ stage.Transition) {
+		d.scheduler.Cancel(user.ID, gate.Name)
+	}
+}
+
+// startTasks starts the deadlines of the dashboard tasks with a time limit.
+func (d *Deadlines) startTasks(user *model.User, tr stage.Transition) {
+	d.mu.Lock()
+	tasks := d.tasks
+	d.mu.Unlock()
+
+	sessionID := user.SessionID
+	for task, limit := range tasks {
+		d.scheduler.Schedule(user.ID, TaskDeadline(task), limit, func() {
+			d.expire(sessionID, tr.To, func(user *model.User) bool {
+				return !user.Task(task).Completed()
+			})
+		})
+	}
+}
+
+// cancelTasks cancels the deadlines of the dashboard tasks.
+func (d *Deadlines) cancelTasks(user *model.User, _ stage.Transition) {
+	d.mu.Lock()
+	tasks := d.tasks
+	d.mu.Unlock()
+
+	for task := range tasks {
+		d.scheduler.Cancel(user.ID, TaskDeadline(task))
+	}
+}
+
+// expire fails the user if they are still in the gate's status and missed
+// its deadline. It runs on the scheduler's goroutine.
+func (d *Deadlines) expire(sessionID, status string, missed func(user *model.User) bool) {
+	d.mu.Lock()
+	failures := d.failures
+	d.mu.Unlock()
+
+	_ = d.store.Update(sessionID, func(user *model.User) error {
+		if user.Status != status || !missed(user) {
+			return nil
+		}
+		log.Printf("[Deadlines] User %s timed out in %s", user.ID, user.Status)
+		failures.Fail(user, failure.ReasonTimeout, 0)
+		return nil
+	})
+}
@@ -0,0 +1,193 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/kyiku/hackz-ptera-back/internal/timeout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// deadlineTest is a default pipeline whose gate deadlines run on a fake clock.
+type deadlineTest struct {
+	store     *session.SessionStore
+	machine   *stage.Machine
+	scheduler *timeout.Scheduler
+	clock     *clock.Fake
+	deadlines *Deadlines
+}
+
+func newDeadlineTest(t *testing.T) *deadlineTest {
+	t.Helper()
+
+	clk := clock.NewFake(time.Now())
+	scheduler := timeout.NewScheduler(time.Second, 64)
+	scheduler.SetClock(clk)
+
+	store := session.NewSessionStore()
+	machine := stage.NewMachine()
+	failures := failure.NewFailureHandler(nil)
+	failures.SetMachine(machine)
+
+	deadlines := NewDeadlines(scheduler, store)
+	deadlines.SetFailureHandler(failures)
+	deadlines.Watch(machine)
+	return &deadlineTest{store, machine, scheduler, clk, deadlines}
+}
+
+// advance moves the fake clock second by second and runs the due deadlines.
+func (dt *deadlineTest) advance(d time.Duration) {
+	for step := time.Duration(0); step < d; step += time.Second {
+		dt.clock.Advance(time.Second)
+		dt.scheduler.Advance()
+	}
+}
+
+// promote creates a connected user and moves them into the first gate.
+func (dt *deadlineTest) promote(t *testing.T) (*model.User, string, *testutil.MockWebSocketConn) {
+	t.Helper()
+
+	user, sessionID := dt.store.Create()
+	conn := testutil.NewMockWebSocketConn()
+	require.NoError(t, dt.store.Update(sessionID, func(u *model.User) error {
+		u.Conn = conn
+		return dt.machine.Fire(u, stage.EventPromote)
+	}))
+	return user, sessionID, conn
+}
+
+func TestDeadlines_GateTimeout(t *testing.T) {
+	dt := newDeadlineTest(t)
+	user, sessionID, conn := dt.promote(t)
+
+	// Dinoゲートに入ると3分の期限が始まる
+	deadline, ok := dt.scheduler.Deadline(user.ID, "dino")
+	require.True(t, ok)
+	assert.Equal(t, dt.clock.Now().Add(3*time.Minute), deadline)
+
+	dt.advance(3*time.Minute - time.Second)
+	assert.Equal(t, model.StatusStage1Dino, user.Status)
+
+	// 期限切れで失敗通知・切断・待機状態へ
+	dt.advance(time.Second)
+	require.NoError(t, dt.store.View(sessionID, func(u *model.User) error {
+		assert.Equal(t, model.StatusWaiting, u.Status)
+		return nil
+	}))
+	msg := testutil.WaitForMessage(conn, 100*time.Millisecond)
+	require.NotNil(t, msg)
+	assert.Equal(t, "failure", msg["type"])
+	assert.Equal(t, string(failure.ReasonTimeout), msg["code"])
+	assert.Contains(t, msg["message"], "タイムアウト")
+	err := testutil.WaitFor(100*time.Millisecond, 10*time.Millisecond, conn.GetIsClosed)
+	require.NoError(t, err, "WebSocket接続が閉じられるべき")
+	assert.Equal(t, 0, dt.scheduler.Pending())
+}
+
+func TestDeadlines_CancelOnExit(t *testing.T) {
+	dt := newDeadlineTest(t)
+	user, sessionID, conn := dt.promote(t)
+
+	// クリアしてゲートを出ると期限はキャンセルされる
+	require.NoError(t, dt.store.Update(sessionID, func(u *model.User) error {
+		return dt.machine.Fire(u, stage.EventPass)
+	}))
+	_, ok := dt.scheduler.Deadline(user.ID, "dino")
+	assert.False(t, ok)
+
+	dt.advance(5 * time.Minute)
+	assert.Equal(t, model.StatusRegistering, user.Status)
+	assert.False(t, conn.GetIsClosed())
+}
+
+func TestDeadlines_Extend(t *testing.T) {
+	dt := newDeadlineTest(t)
+	user, _, _ := dt.promote(t)
+
+	user.Lock()
+	assert.True(t, dt.deadlines.Extend(user, "dino", time.Minute))
+	assert.False(t, dt.deadlines.Extend(user, "register", time.Minute))
+	user.Unlock()
+
+	dt.advance(3 * time.Minute)
+	assert.Equal(t, model.StatusStage1Dino, user.Status)
+	dt.advance(time.Minute)
+	assert.Equal(t, model.StatusWaiting, user.Status)
+}
+
+func TestDeadlines_MultipleUsers(t *testing.T) {
+	dt := newDeadlineTest(t)
+
+	// 多数のユーザーの期限が1つのスケジューラーに載る
+	var conns []*testutil.MockWebSocketConn
+	for i := 0; i < 100; i++ {
+		_, _, conn := dt.promote(t)
+		conns = append(conns, conn)
+	}
+	assert.Equal(t, 100, dt.scheduler.Pending())
+
+	dt.advance(3 * time.Minute)
+	err := testutil.WaitFor(200*time.Millisecond, 10*time.Millisecond, func() bool {
+		for _, conn := range conns {
+			if !conn.GetIsClosed() {
+				return false
+			}
+		}
+		return true
+	})
+	require.NoError(t, err, "全ユーザーのタイムアウト処理が完了しなかった")
+	assert.Equal(t, 0, dt.scheduler.Pending())
+}
+
+func TestDeadlines_TaskTimeout(t *testing.T) {
+	dt := newDeadlineTest(t)
+	dt.deadlines.SetTaskTimeouts(map[string]time.Duration{
+		model.TaskCaptcha: time.Minute,
+		model.TaskOTP:     2 * time.Minute,
+	})
+
+	user, sessionID, _ := dt.promote(t)
+	require.NoError(t, dt.store.Update(sessionID, func(u *model.User) error {
+		return dt.machine.Fire(u, stage.EventPass)
+	}))
+	_, ok := dt.scheduler.Deadline(user.ID, TaskDeadline(model.TaskCaptcha))
+	require.True(t, ok)
+
+	// 期限内に完了したタスクでは失敗しない
+	require.NoError(t, dt.store.Update(sessionID, func(u *model.User) error {
+		u.CompleteTask(model.TaskCaptcha, time.Now())
+		return nil
+	}))
+	dt.advance(time.Minute)
+	assert.Equal(t, model.StatusRegistering, user.Status)
+
+	// 未完了のタスクの期限が切れると失敗
+	dt.advance(time.Minute)
+	assert.Equal(t, model.StatusWaiting, user.Status)
+	assert.Equal(t, 0, dt.scheduler.Pending())
+}
+
+func TestDeadlines_Resume(t *testing.T) {
+	dt := newDeadlineTest(t)
+
+	// スナップショットから復元されたユーザーの期限は復元時点から数え直す
+	user, sessionID := dt.store.Create()
+	require.NoError(t, dt.store.Update(sessionID, func(u *model.User) error {
+		u.Status = model.StatusStage1Dino
+		dt.deadlines.Resume(u)
+		return nil
+	}))
+	deadline, ok := dt.scheduler.Deadline(user.ID, "dino")
+	require.True(t, ok)
+	assert.Equal(t, dt.clock.Now().Add(3*time.Minute), deadline)
+
+	dt.advance(3 * time.Minute)
+	assert.Equal(t, model.StatusWaiting, user.Status)
+}
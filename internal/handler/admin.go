@@ -0,0 +1,294 @@
+// Package handler provides HTTP handlers for the API.
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/kyiku/hackz-ptera-back/internal/audit"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/labstack/echo/v4"
+)
+
+// PromotionControl pauses and resumes promotion out of the queue.
+// queue.Dispatcher satisfies this interface.
+type PromotionControl interface {
+	SetPaused(paused bool)
+	IsPaused() bool
+}
+
+// AdminHandler handles manual queue control during demos.
+// Every action is written to the audit trail and announced to affected users.
+type AdminHandler struct {
+	queue     *queue.WaitingQueue
+	promotion PromotionControl
+	audit     *audit.Trail
+}
+
+// NewAdminHandler creates a new AdminHandler.
+func NewAdminHandler(q *queue.WaitingQueue, promotion PromotionControl, trail *audit.Trail) *AdminHandler {
+	return &AdminHandler{
+		queue:     q,
+		promotion: promotion,
+		audit:     trail,
+	}
+}
+
+// AdminMoveRequest is the request body for moving a user.
+type AdminMoveRequest struct {
+	UserID   string `json:"user_id"`
+	Position int    `json:"position"`
+}
+
+// AdminMessageRequest is the request body for actions that carry a message.
+type AdminMessageRequest struct {
+	UserID  string `json:"user_id,omitempty"`
+	Message string `json:"message"`
+}
+
+// adminQueueEntry is a user in the admin queue view.
+type adminQueueEntry struct {
+	UserID    string `json:"user_id"`
+	Position  int    `json:"position"`
+	Reserved  bool   `json:"reserved"`
+	Connected bool   `json:"connected"`
+}
+
+// State returns the queue as seen by an admin.
+func (h *AdminHandler) State(c echo.Context) error {
+	users := h.queue.Users()
+	entries := make([]adminQueueEntry, 0, len(users))
+	for _, u := range users {
+		position, _ := h.queue.GetPosition(u.ID)
+		entries = append(entries, adminQueueEntry{
+			UserID:    u.ID,
+			Position:  position,
+			Reserved:  u.Reserved,
+			Connected: u.Conn != nil,
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":  false,
+		"paused": h.promotion.IsPaused(),
+		"frozen": h.queue.IsFrozen(),
+		"total":  h.queue.Total(),
+		"users":  entries,
+	})
+}
+
+// Pause holds promotion out of the queue.
+func (h *AdminHandler) Pause(c echo.Context) error {
+	h.promotion.SetPaused(true)
+	h.record(c, "queue.pause", "", nil)
+	h.queue.Announce(map[string]interface{}{
+		"type":    "queue_paused",
+		"message": "待機列は一時停止中です",
+	})
+	return h.ok(c)
+}
+
+// Resume resumes promotion out of the queue.
+func (h *AdminHandler) Resume(c echo.Context) error {
+	h.promotion.SetPaused(false)
+	h.record(c, "queue.resume", "", nil)
+	h.queue.Announce(map[string]interface{}{
+		"type":    "queue_resumed",
+		"message": "待機列が再開しました",
+	})
+	return h.ok(c)
+}
+
+// Freeze stops position broadcasts.
+func (h *AdminHandler) Freeze(c echo.Context) error {
+	h.queue.SetFrozen(true)
+	h.record(c, "queue.freeze", "", nil)
+	h.queue.Announce(map[string]interface{}{
+		"type": "queue_frozen",
+	})
+	return h.ok(c)
+}
+
+// Unfreeze resumes position broadcasts and sends everyone their current position.
+func (h *AdminHandler) Unfreeze(c echo.Context) error {
+	h.record(c, "queue.unfreeze", "", nil)
+	h.queue.Announce(map[string]interface{}{
+		"type": "queue_unfrozen",
+	})
+	h.queue.SetFrozen(false)
+	return h.ok(c)
+}
+
+// Move moves a user to the given position.
+func (h *AdminHandler) Move(c echo.Context) error {
+	var req AdminMoveRequest
+	if err := c.Bind(&req); err != nil || req.UserID == "" || req.Position < 1 {
+		return h.badRequest(c)
+	}
+
+	if !h.queue.MoveTo(req.UserID, req.Position) {
+		return h.userNotFound(c)
+	}
+	position, _ := h.queue.GetPosition(req.UserID)
+	h.record(c, "queue.move", req.UserID, map[string]string{
+		"requested": strconv.Itoa(req.Position),
+		"position":  strconv.Itoa(position),
+	})
+
+	if conn := h.connOf(req.UserID); conn != nil {
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":     "queue_moved",
+			"position": position,
+		})
+	}
+	h.queue.BroadcastPositions()
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":    false,
+		"position": position,
+	})
+}
+
+// Remove takes a user out of the queue and closes their connection.
+func (h *AdminHandler) Remove(c echo.Context) error {
+	var req AdminMessageRequest
+	if err := c.Bind(&req); err != nil || req.UserID == "" {
+		return h.badRequest(c)
+	}
+
+	conn := h.connOf(req.UserID)
+	if _, ok := h.queue.GetPosition(req.UserID); !ok {
+		return h.userNotFound(c)
+	}
+	h.queue.Remove(req.UserID)
+	h.record(c, "queue.remove", req.UserID, messageDetail(req.Message))
+
+	if conn != nil {
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "queue_removed",
+			"message": messageOr(req.Message, "待機列から削除されました"),
+		})
+		_ = conn.Close()
+	}
+	h.queue.BroadcastPositions()
+
+	return h.ok(c)
+}
+
+// Drain empties the queue, telling every waiting user why.
+func (h *AdminHandler) Drain(c echo.Context) error {
+	var req AdminMessageRequest
+	if err := c.Bind(&req); err != nil {
+		return h.badRequest(c)
+	}
+
+	drained := h.queue.Drain()
+	detail := messageDetail(req.Message)
+	detail["drained"] = strconv.Itoa(len(drained))
+	h.record(c, "queue.drain", "", detail)
+
+	message := messageOr(req.Message, "待機列は終了しました")
+	for _, u := range drained {
+		if u.Conn == nil {
+			continue
+		}
+		_ = u.Conn.WriteJSON(map[string]interface{}{
+			"type":    "queue_drained",
+			"message": message,
+		})
+		_ = u.Conn.Close()
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":   false,
+		"drained": len(drained),
+	})
+}
+
+// Announce sends a message to everyone waiting.
+func (h *AdminHandler) Announce(c echo.Context) error {
+	var req AdminMessageRequest
+	if err := c.Bind(&req); err != nil || req.Message == "" {
+		return h.badRequest(c)
+	}
+
+	sent := h.queue.Announce(map[string]interface{}{
+		"type":    "announcement",
+		"message": req.Message,
+	})
+	detail := messageDetail(req.Message)
+	detail["sent"] = strconv.Itoa(sent)
+	h.record(c, "queue.announce", "", detail)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error": false,
+		"sent":  sent,
+	})
+}
+
+// Audit returns the audit trail.
+func (h *AdminHandler) Audit(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":   false,
+		"entries": h.audit.Entries(),
+	})
+}
+
+// connOf returns the connection of a queued user, if any.
+func (h *AdminHandler) connOf(userID string) model.WebSocketConn {
+	for _, u := range h.queue.Users() {
+		if u.ID == userID && u.Conn != nil {
+			return u.Conn
+		}
+	}
+	return nil
+}
+
+// record writes an action to the audit trail under the authenticated admin's name.
+func (h *AdminHandler) record(c echo.Context, action, target string, detail map[string]string) {
+	actor, _ := c.Get(middleware.AdminActorKey).(string)
+	if actor == "" {
+		actor = "admin"
+	}
+	h.audit.Record(actor, action, target, detail)
+}
+
+func (h *AdminHandler) ok(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error": false,
+	})
+}
+
+func (h *AdminHandler) badRequest(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":   true,
+		"message": "リクエストの解析に失敗しました",
+		"code":    "BAD_REQUEST",
+	})
+}
+
+func (h *AdminHandler) userNotFound(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":   true,
+		"message": "待機列にいないユーザーです",
+		"code":    "USER_NOT_IN_QUEUE",
+	})
+}
+
+// messageDetail returns audit detail holding the message, if any.
+func messageDetail(message string) map[string]string {
+	if message == "" {
+		return map[string]string{}
+	}
+	return map[string]string{"message": message}
+}
+
+// messageOr returns message, or fallback if it is empty.
+func messageOr(message, fallback string) string {
+	if message == "" {
+		return fallback
+	}
+	return message
+}
@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kyiku/hackz-ptera-back/internal/audit"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockPromotion is a PromotionControl that records its state.
+type mockPromotion struct {
+	paused bool
+}
+
+func (p *mockPromotion) SetPaused(paused bool) { p.paused = paused }
+func (p *mockPromotion) IsPaused() bool        { return p.paused }
+
+// newTestAdminHandler returns an AdminHandler over a queue of three users.
+func newTestAdminHandler() (*AdminHandler, *queue.WaitingQueue, *mockPromotion, *audit.Trail, []*testutil.MockWebSocketConn) {
+	q := queue.NewWaitingQueue()
+	var conns []*testutil.MockWebSocketConn
+	for _, id := range []string{"user1", "user2", "user3"} {
+		conn := testutil.NewMockWebSocketConn()
+		q.Add(id, conn)
+		conns = append(conns, conn)
+	}
+
+	promotion := &mockPromotion{}
+	trail := audit.NewTrail(10)
+	return NewAdminHandler(q, promotion, trail), q, promotion, trail, conns
+}
+
+func TestAdminHandler_PauseResume(t *testing.T) {
+	h, _, promotion, trail, conns := newTestAdminHandler()
+
+	tc := testutil.NewTestContext(http.MethodPost, "/api/admin/queue/pause", nil)
+	tc.Context.Set(middleware.AdminActorKey, "kyiku")
+	require.NoError(t, h.Pause(tc.Context))
+	assert.True(t, promotion.paused)
+	assert.Equal(t, "queue_paused", conns[0].GetLastMessageAsMap()["type"])
+
+	tc = testutil.NewTestContext(http.MethodPost, "/api/admin/queue/resume", nil)
+	require.NoError(t, h.Resume(tc.Context))
+	assert.False(t, promotion.paused)
+	assert.Equal(t, "queue_resumed", conns[2].GetLastMessageAsMap()["type"])
+
+	entries := trail.Entries()
+	require.Len(t, entries, 2)
+	assert.Equal(t, "kyiku", entries[0].Actor)
+	assert.Equal(t, "queue.pause", entries[0].Action)
+	assert.Equal(t, "admin", entries[1].Actor)
+	assert.Equal(t, "queue.resume", entries[1].Action)
+}
+
+func TestAdminHandler_FreezeUnfreeze(t *testing.T) {
+	h, q, _, _, conns := newTestAdminHandler()
+
+	tc := testutil.NewTestContext(http.MethodPost, "/api/admin/queue/freeze", nil)
+	require.NoError(t, h.Freeze(tc.Context))
+	assert.True(t, q.IsFrozen())
+
+	// 凍結中は順位が変わっても通知されない
+	q.Remove("user1")
+	q.BroadcastPositions()
+	assert.Equal(t, "queue_frozen", conns[1].GetLastMessageAsMap()["type"])
+
+	tc = testutil.NewTestContext(http.MethodPost, "/api/admin/queue/unfreeze", nil)
+	require.NoError(t, h.Unfreeze(tc.Context))
+	assert.False(t, q.IsFrozen())
+
+	msg := conns[1].GetLastMessageAsMap()
+	assert.Equal(t, "queueUpdate", msg["type"])
+	assert.Equal(t, float64(1), msg["position"])
+}
+
+func TestAdminHandler_Move(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         map[string]interface{}
+		wantError    bool
+		wantCode     string
+		wantPosition float64
+	}{
+		{
+			name:         "正常系: 先頭へ移動",
+			body:         map[string]interface{}{"user_id": "user3", "position": 1},
+			wantPosition: 1,
+		},
+		{
+			name:      "異常系: 存在しないユーザー",
+			body:      map[string]interface{}{"user_id": "unknown", "position": 1},
+			wantError: true,
+			wantCode:  "USER_NOT_IN_QUEUE",
+		},
+		{
+			name:      "異常系: 不正な位置",
+			body:      map[string]interface{}{"user_id": "user3", "position": 0},
+			wantError: true,
+			wantCode:  "BAD_REQUEST",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, q, _, trail, conns := newTestAdminHandler()
+
+			tc := testutil.NewTestContextWithJSON(http.MethodPost, "/api/admin/queue/move", tt.body)
+			require.NoError(t, h.Move(tc.Context))
+
+			resp := tc.GetResponseBody()
+			assert.Equal(t, tt.wantError, resp["error"])
+			if tt.wantError {
+				assert.Equal(t, tt.wantCode, resp["code"])
+				assert.Empty(t, trail.Entries())
+				return
+			}
+
+			assert.Equal(t, tt.wantPosition, resp["position"])
+			assert.Equal(t, []string{"user3", "user1", "user2"}, q.IDs())
+
+			// 移動したユーザーに通知
+			msgs := conns[2].GetMessages()
+			require.NotEmpty(t, msgs)
+			assert.Contains(t, string(msgs[0]), "queue_moved")
+
+			entries := trail.Entries()
+			require.Len(t, entries, 1)
+			assert.Equal(t, "queue.move", entries[0].Action)
+			assert.Equal(t, "user3", entries[0].Target)
+		})
+	}
+}
+
+func TestAdminHandler_Remove(t *testing.T) {
+	h, q, _, trail, conns := newTestAdminHandler()
+
+	tc := testutil.NewTestContextWithJSON(http.MethodPost, "/api/admin/queue/remove", map[string]interface{}{
+		"user_id": "user2",
+		"message": "不正な操作を検出しました",
+	})
+	require.NoError(t, h.Remove(tc.Context))
+	assert.Equal(t, false, tc.GetResponseBody()["error"])
+
+	assert.Equal(t, []string{"user1", "user3"}, q.IDs())
+	assert.True(t, conns[1].GetIsClosed())
+
+	msgs := conns[1].GetMessages()
+	require.NotEmpty(t, msgs)
+	assert.Contains(t, string(msgs[len(msgs)-1]), "不正な操作を検出しました")
+
+	entries := trail.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "queue.remove", entries[0].Action)
+	assert.Equal(t, "不正な操作を検出しました", entries[0].Detail["message"])
+}
+
+func TestAdminHandler_Drain(t *testing.T) {
+	h, q, _, trail, conns := newTestAdminHandler()
+
+	tc := testutil.NewTestContextWithJSON(http.MethodPost, "/api/admin/queue/drain", map[string]interface{}{
+		"message": "本日の受付は終了しました",
+	})
+	require.NoError(t, h.Drain(tc.Context))
+	assert.Equal(t, float64(3), tc.GetResponseBody()["drained"])
+	assert.Equal(t, 0, q.Len())
+
+	for _, conn := range conns {
+		msg := conn.GetLastMessageAsMap()
+		require.NotNil(t, msg)
+		assert.Equal(t, "queue_drained", msg["type"])
+		assert.Equal(t, "本日の受付は終了しました", msg["message"])
+		assert.True(t, conn.GetIsClosed())
+	}
+
+	entries := trail.Entries()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "3", entries[0].Detail["drained"])
+}
+
+func TestAdminHandler_Announce(t *testing.T) {
+	h, _, _, trail, conns := newTestAdminHandler()
+
+	tc := testutil.NewTestContextWithJSON(http.MethodPost, "/api/admin/queue/announce", map[string]interface{}{
+		"message": "まもなくデモを再開します",
+	})
+	require.NoError(t, h.Announce(tc.Context))
+	assert.Equal(t, float64(3), tc.GetResponseBody()["sent"])
+
+	for _, conn := range conns {
+		msg := conn.GetLastMessageAsMap()
+		assert.Equal(t, "announcement", msg["type"])
+		assert.Equal(t, "まもなくデモを再開します", msg["message"])
+	}
+	require.Len(t, trail.Entries(), 1)
+
+	// 空メッセージは拒否
+	tc = testutil.NewTestContextWithJSON(http.MethodPost, "/api/admin/queue/announce", map[string]interface{}{})
+	require.NoError(t, h.Announce(tc.Context))
+	assert.Equal(t, "BAD_REQUEST", tc.GetResponseBody()["code"])
+}
+
+func TestAdminHandler_State(t *testing.T) {
+	h, _, promotion, _, _ := newTestAdminHandler()
+	promotion.paused = true
+
+	tc := testutil.NewTestContext(http.MethodGet, "/api/admin/queue", nil)
+	require.NoError(t, h.State(tc.Context))
+
+	resp := tc.GetResponseBody()
+	assert.Equal(t, true, resp["paused"])
+	assert.Equal(t, false, resp["frozen"])
+	users, ok := resp["users"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, users, 3)
+	assert.Equal(t, "user1", users[0].(map[string]interface{})["user_id"])
+}
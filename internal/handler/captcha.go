@@ -2,6 +2,7 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"math"
@@ -9,13 +10,16 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/kyiku/hackz-ptera-back/internal/captcha"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 )
 
-// SessionStoreInterface defines the interface for session storage.
-type SessionStoreInterface interface {
-	Get(sessionID string) (*model.User, bool)
-}
+// SessionStoreInterface is the session backend shared by all handlers.
+type SessionStoreInterface = session.Backend
 
 // S3ClientInterface defines the interface for S3 operations.
 type S3ClientInterface interface {
@@ -36,6 +40,9 @@ type CaptchaHandler struct {
 	queue         QueueInterfaceForCaptcha
 	tolerance     int
 	cloudfrontURL string
+	machine       *stage.Machine
+	failures      *failure.FailureHandler
+	clock         clock.Clock
 }
 
 // NewCaptchaHandler creates a new CaptchaHandler.
@@ -45,14 +52,33 @@ func NewCaptchaHandler(store SessionStoreInterface, s3Client S3ClientInterface)
 		s3Client:      s3Client,
 		tolerance:     25, // default tolerance (half of 50x50 character size)
 		cloudfrontURL: "https://test.cloudfront.net",
+		machine:       stage.NewMachine(),
+		failures:      failure.NewFailureHandler(nil),
+		clock:         clock.Real{},
 	}
 }
 
+// SetClock sets the clock task completions are timestamped with.
+func (h *CaptchaHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
 // SetQueue sets the waiting queue.
 func (h *CaptchaHandler) SetQueue(queue QueueInterfaceForCaptcha) {
 	h.queue = queue
 }
 
+// SetMachine sets the state machine that applies status changes.
+func (h *CaptchaHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+	h.failures.SetMachine(machine)
+}
+
+// SetFailureHandler sets the failure pipeline users who fail here go through.
+func (h *CaptchaHandler) SetFailureHandler(failures *failure.FailureHandler) {
+	h.failures = failures
+}
+
 // SetTolerance sets the click tolerance in pixels.
 func (h *CaptchaHandler) SetTolerance(tolerance int) {
 	h.tolerance = tolerance
@@ -64,10 +90,12 @@ func (h *CaptchaHandler) SetCloudfrontURL(url string) {
 }
 
 // Generate creates a new CAPTCHA image.
+// The user is only locked to check their stage and to save the target:
+// building and uploading the image can be slow.
 func (h *CaptchaHandler) Generate(c echo.Context) error {
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
 		// CloudFrontのcustom_error_responseがHTMLを返すのを防ぐため、常に200を返す
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
@@ -76,22 +104,25 @@ func (h *CaptchaHandler) Generate(c echo.Context) error {
 		})
 	}
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
+	// Check user status - CAPTCHA is one of the 9 tasks of the registration
+	// dashboard, or a gate of its own when the pipeline has one
+	inGate := false
+	err := h.store.View(sessionID, func(user *model.User) error {
+		_, inGate = h.captchaGate(user)
+		return nil
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "無効なセッション",
 			"code":    "INVALID_SESSION",
 		})
 	}
-
-	// Check user status - CAPTCHA is one of the 9 tasks in "registering" stage
-	if user.Status != "registering" {
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"error":   true,
-			"message": "登録ステージではありません",
-			"code":    "WRONG_STAGE",
-		})
+	if err != nil {
+		return err
+	}
+	if !inGate {
+		return c.JSON(http.StatusOK, wrongCaptchaStage())
 	}
 
 	// Generate CAPTCHA image
@@ -105,9 +136,24 @@ func (h *CaptchaHandler) Generate(c echo.Context) error {
 		})
 	}
 
-	// Save target position
-	user.CaptchaTargetX = result.TargetX
-	user.CaptchaTargetY = result.TargetY
+	// Save target position, unless the user left the stage meanwhile
+	err = h.store.Update(sessionID, func(user *model.User) error {
+		if _, inGate = h.captchaGate(user); inGate {
+			user.CaptchaTargetX = result.TargetX
+			user.CaptchaTargetY = result.TargetY
+		}
+		return nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "無効なセッション",
+			"code":    "INVALID_SESSION",
+		})
+	}
+	if !inGate {
+		return c.JSON(http.StatusOK, wrongCaptchaStage())
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"error":            false,
@@ -116,6 +162,15 @@ func (h *CaptchaHandler) Generate(c echo.Context) error {
 	})
 }
 
+// wrongCaptchaStage is the response to a user who is not solving a CAPTCHA.
+func wrongCaptchaStage() map[string]interface{} {
+	return map[string]interface{}{
+		"error":   true,
+		"message": "登録ステージではありません",
+		"code":    "WRONG_STAGE",
+	}
+}
+
 // VerifyRequest represents the CAPTCHA verification request.
 type VerifyRequest struct {
 	X int `json:"x"`
@@ -125,8 +180,8 @@ type VerifyRequest struct {
 // Verify checks the CAPTCHA answer.
 func (h *CaptchaHandler) Verify(c echo.Context) error {
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "セッションが見つかりません",
@@ -134,24 +189,54 @@ func (h *CaptchaHandler) Verify(c echo.Context) error {
 		})
 	}
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
+	// Parse request (before locking the user, the body may be slow to arrive)
+	var req VerifyRequest
+	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
-			"message": "無効なセッション",
-			"code":    "INVALID_SESSION",
+			"message": "リクエストの解析に失敗しました",
+			"code":    "BAD_REQUEST",
 		})
 	}
 
-	// Parse request
-	var req VerifyRequest
-	if err := c.Bind(&req); err != nil {
+	var resp map[string]interface{}
+	retry := false
+	attempts := 0
+	err := h.store.Update(sessionID, func(user *model.User) error {
+		var err error
+		resp, retry, err = h.verify(user, req)
+		attempts = user.CaptchaAttempts
+		return err
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
-			"message": "リクエストの解析に失敗しました",
-			"code":    "BAD_REQUEST",
+			"message": "無効なセッション",
+			"code":    "INVALID_SESSION",
 		})
 	}
+	if err != nil {
+		return err
+	}
+	if retry {
+		resp = h.retry(sessionID, attempts, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// verify checks the answer of the user and returns the response. retry
+// reports a wrong answer with attempts left, which needs a new CAPTCHA.
+// It runs with the user locked.
+func (h *CaptchaHandler) verify(user *model.User, req VerifyRequest) (resp map[string]interface{}, retry bool, err error) {
+	gate, ok := h.captchaGate(user)
+	if !ok {
+		return wrongCaptchaStage(), false, nil
+	}
+
+	// On the dashboard every answer counts as an attempt at the CAPTCHA task
+	if gate.Kind == stage.KindRegister {
+		user.RecordTaskAttempt(model.TaskCaptcha)
+	}
 
 	// Check if click is within tolerance
 	dx := float64(req.X - user.CaptchaTargetX)
@@ -159,76 +244,81 @@ func (h *CaptchaHandler) Verify(c echo.Context) error {
 	distance := math.Sqrt(dx*dx + dy*dy)
 
 	if distance <= float64(h.tolerance) {
-		// Success - advance to registering stage
-		user.Status = "registering"
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"error":      false,
-			"next_stage": "registering",
-			"message":    "CAPTCHA成功！登録フォームに進みます",
-		})
+		// Success - users in a CAPTCHA gate advance to the next gate,
+		// on the dashboard the CAPTCHA task is marked as completed
+		if gate.Kind == stage.KindCaptcha {
+			next, _ := h.machine.Pipeline().Next(user.Status)
+			if err := h.machine.Fire(user, stage.EventPass); err != nil {
+				return nil, false, err
+			}
+			return map[string]interface{}{
+				"error":      false,
+				"next_stage": next.Name,
+				"message":    "CAPTCHA成功！次のステージに進みます",
+			}, false, nil
+		}
+		completeTask(user, model.TaskCaptcha, h.clock.Now())
+		return map[string]interface{}{
+			"error":          false,
+			"next_stage":     model.StatusRegistering,
+			"task_completed": model.TaskCaptcha,
+			"message":        "CAPTCHA成功！登録フォームに進みます",
+		}, false, nil
 	}
 
-	// Failed attempt
-	exceeded := user.IncrementCaptchaAttempts()
+	// Failed attempt (a CAPTCHA gate sets its own retry limit)
+	maxAttempts := model.MaxCaptchaAttempts
+	if gate.Kind == stage.KindCaptcha {
+		maxAttempts = gate.MaxAttempts
+	}
+	user.CaptchaAttempts++
 
-	if exceeded {
+	if user.CaptchaAttempts >= maxAttempts {
 		// 3 failures - reset to waiting
-		return h.handleMaxAttempts(c, user)
+		e := h.failures.Fail(user, failure.ReasonCaptchaFailed, user.CaptchaAttempts)
+		return e.Response(), false, nil
 	}
 
-	// Generate new CAPTCHA for retry
+	return map[string]interface{}{
+		"error":              true,
+		"message":            "不正解です。もう一度試してください",
+		"attempts_remaining": maxAttempts - user.CaptchaAttempts,
+	}, true, nil
+}
+
+// retry generates the new CAPTCHA after a wrong answer and adds it to resp.
+// The image is built without holding the user's lock. attempts is the
+// attempt count the wrong answer left: if another answer came in meanwhile,
+// the target that answer set is kept.
+func (h *CaptchaHandler) retry(sessionID string, attempts int, resp map[string]interface{}) map[string]interface{} {
 	newResult, err := h.generateCaptchaImage()
 	if err != nil {
-		return c.JSON(http.StatusOK, map[string]interface{}{
+		return map[string]interface{}{
 			"error":   true,
 			"message": "CAPTCHA再生成に失敗しました",
 			"code":    "REGENERATION_FAILED",
-		})
+		}
 	}
 
-	user.CaptchaTargetX = newResult.TargetX
-	user.CaptchaTargetY = newResult.TargetY
-	remaining := model.MaxCaptchaAttempts - user.CaptchaAttempts
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"error":                  true,
-		"message":                "不正解です。もう一度試してください",
-		"attempts_remaining":     remaining,
-		"new_image_url":          newResult.ImageURL,
-		"new_target_image_url":   newResult.TargetImageURL,
+	_ = h.store.Update(sessionID, func(user *model.User) error {
+		if _, ok := h.captchaGate(user); ok && user.CaptchaAttempts == attempts {
+			user.CaptchaTargetX = newResult.TargetX
+			user.CaptchaTargetY = newResult.TargetY
+		}
+		return nil
 	})
+	resp["new_image_url"] = newResult.ImageURL
+	resp["new_target_image_url"] = newResult.TargetImageURL
+	return resp
 }
 
-// handleMaxAttempts handles the case when max attempts are exceeded.
-func (h *CaptchaHandler) handleMaxAttempts(c echo.Context, user *model.User) error {
-	// Send failure notification via WebSocket
-	if user.Conn != nil {
-		_ = user.Conn.WriteJSON(map[string]interface{}{
-			"type":           "failure",
-			"message":        "3回失敗しました。待機列の最後尾からやり直しです。",
-			"redirect_delay": float64(3),
-		})
-	}
-
-	// Reset user state (this clears CAPTCHA attempts, OTP state, etc.)
-	user.ResetToWaiting()
-
-	// Close WebSocket connection - user needs to reconnect fresh
-	// Don't add to queue here - the user will be added when they reconnect via WebSocket
-	if user.Conn != nil {
-		conn := user.Conn // Capture for goroutine
-		user.Conn = nil   // Clear the connection reference
-		go func() {
-			// Small delay to ensure message is sent before closing
-			conn.Close()
-		}()
+// captchaGate returns the gate the user solves CAPTCHAs in: a CAPTCHA gate or the registration dashboard.
+func (h *CaptchaHandler) captchaGate(user *model.User) (stage.Gate, bool) {
+	gate, ok := h.machine.Current(user)
+	if !ok || (gate.Kind != stage.KindCaptcha && gate.Kind != stage.KindRegister) {
+		return stage.Gate{}, false
 	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"error":          true,
-		"message":        "3回失敗しました。待機列の最後尾からやり直しです。",
-		"redirect_delay": float64(3),
-	})
+	return gate, true
 }
 
 // CaptchaImageResult holds the result of CAPTCHA image generation.
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/kyiku/hackz-ptera-back/internal/model"
 	"github.com/kyiku/hackz-ptera-back/internal/session"
@@ -138,3 +139,67 @@ func TestCaptchaHandler_Generate_TargetPosition(t *testing.T) {
 	assert.GreaterOrEqual(t, user.CaptchaTargetY, 0)
 	assert.Less(t, user.CaptchaTargetY, 1536)
 }
+
+// blockingS3 is an S3 client whose uploads wait until released.
+type blockingS3 struct {
+	*testutil.MockS3Client
+	uploading chan struct{}
+	release   chan struct{}
+}
+
+func (s *blockingS3) PutObject(key string, data []byte) error {
+	s.uploading <- struct{}{}
+	<-s.release
+	return s.MockS3Client.PutObject(key, data)
+}
+
+func TestCaptchaHandler_Generate_UnlockedDuringUpload(t *testing.T) {
+	store := session.NewSessionStore()
+	mockS3 := &blockingS3{
+		MockS3Client: testutil.NewMockS3Client(),
+		uploading:    make(chan struct{}),
+		release:      make(chan struct{}),
+	}
+	mockS3.Objects = map[string][]byte{
+		"static/backgrounds/bg1.png": testutil.CreateTestPNG(2816, 1536),
+		"static/character/char1.png": testutil.CreateTestPNG(100, 100),
+		"static/character/char2.png": testutil.CreateTestPNG(100, 100),
+		"static/character/char3.png": testutil.CreateTestPNG(100, 100),
+		"static/character/char4.png": testutil.CreateTestPNG(100, 100),
+	}
+
+	user, sessionID := store.Create()
+	user.Status = model.StatusRegistering
+	h := NewCaptchaHandler(store, mockS3)
+
+	tc := testutil.NewTestContext(http.MethodPost, "/api/captcha/generate", nil)
+	tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	done := make(chan error, 1)
+	go func() { done <- h.Generate(tc.Context) }()
+
+	// アップロード中もユーザーはロックされない
+	select {
+	case <-mockS3.uploading:
+	case err := <-done:
+		t.Fatalf("アップロード前に終了した: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("アップロードが始まらない")
+	}
+	viewed := make(chan struct{})
+	go func() {
+		_ = store.View(sessionID, func(*model.User) error { return nil })
+		close(viewed)
+	}()
+	select {
+	case <-viewed:
+	case <-time.After(time.Second):
+		t.Fatal("CAPTCHAのアップロード中にユーザーがロックされている")
+	}
+
+	close(mockS3.release)
+	require.NoError(t, <-done)
+	require.NoError(t, store.View(sessionID, func(u *model.User) error {
+		assert.NotZero(t, u.CaptchaTargetX)
+		return nil
+	}))
+}
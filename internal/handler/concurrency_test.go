@@ -0,0 +1,191 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/game"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/kyiku/hackz-ptera-back/internal/timeout"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postJSON calls a handler with a JSON body and the session cookie, and decodes the response.
+func postJSON(t *testing.T, h echo.HandlerFunc, sessionID, body string) map[string]interface{} {
+	tc := testutil.NewTestContext(http.MethodPost, "/", strings.NewReader(body))
+	tc.Request.Header.Set("Content-Type", "application/json")
+	tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+
+	if err := h(tc.Context); err != nil {
+		t.Errorf("handler error: %v", err)
+		return nil
+	}
+	var resp map[string]interface{}
+	if err := json.Unmarshal(tc.Recorder.Body.Bytes(), &resp); err != nil {
+		t.Errorf("decode response: %v", err)
+	}
+	return resp
+}
+
+// dinoDeadline runs the Dino gate's deadline of h's state machine on a fake
+// clock. It returns a func that starts the user's deadline and one that runs it out.
+func dinoDeadline(store *session.SessionStore, h *DinoHandler) (start func(sessionID string), expire func()) {
+	clk := clock.NewFake(time.Now())
+	scheduler := timeout.NewScheduler(time.Second, 64)
+	scheduler.SetClock(clk)
+
+	deadlines := game.NewDeadlines(scheduler, store)
+	deadlines.SetFailureHandler(h.failures)
+	deadlines.Watch(h.machine)
+
+	start = func(sessionID string) {
+		_ = store.Update(sessionID, func(u *model.User) error {
+			deadlines.Resume(u)
+			return nil
+		})
+	}
+	expire = func() {
+		clk.Advance(h.machine.Pipeline().First().Timeout)
+		scheduler.Advance()
+	}
+	return start, expire
+}
+
+func TestOTPHandler_ConcurrentVerify(t *testing.T) {
+	store := session.NewSessionStore()
+	user, sessionID := store.Create()
+	user.Status = model.StatusRegistering
+	user.OTPCode = 123456
+	user.Conn = testutil.NewMockWebSocketConn()
+
+	h := NewOTPHandler(store, nil)
+
+	// 同時に間違えても試行回数は失われず、3回ごとにちょうど1回リセットされる
+	const requests = 30
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	resets := 0
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp := postJSON(t, h.Verify, sessionID, `{"answer": "000001"}`)
+			if resp["redirect_delay"] != nil {
+				mu.Lock()
+				resets++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, requests/model.MaxOTPAttempts, resets)
+	require.NoError(t, store.View(sessionID, func(u *model.User) error {
+		assert.Equal(t, 0, u.OTPAttempts)
+		assert.Nil(t, u.Conn)
+		return nil
+	}))
+}
+
+func TestDinoHandler_ConcurrentResultAndTimeout(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		store := session.NewSessionStore()
+		user, sessionID := store.Create()
+		user.Status = model.StatusStage1Dino
+		user.Conn = testutil.NewMockWebSocketConn()
+		h := NewDinoHandler(store)
+		status := NewQueueHandler(store, queue.NewWaitingQueue())
+		clear := playRun(t, h, user)
+		startDeadline, expire := dinoDeadline(store, h)
+		startDeadline(sessionID)
+
+		var wg sync.WaitGroup
+		var resp map[string]interface{}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			resp = postJSON(t, h.Result, sessionID, clear)
+		}()
+		go func() {
+			defer wg.Done()
+			expire()
+		}()
+		go func() {
+			defer wg.Done()
+			tc := testutil.NewTestContext(http.MethodGet, "/api/queue/me", nil)
+			tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+			assert.NoError(t, status.Status(tc.Context))
+		}()
+		wg.Wait()
+
+		// クリアとタイムアウトのどちらか一方だけが反映される
+		require.NoError(t, store.View(sessionID, func(u *model.User) error {
+			if resp["error"] == false {
+				assert.Equal(t, model.StatusRegistering, u.Status)
+				assert.NotNil(t, u.Conn)
+			} else {
+				assert.Equal(t, "WRONG_STAGE", resp["code"])
+				assert.Equal(t, model.StatusWaiting, u.Status)
+				assert.Nil(t, u.Conn)
+			}
+			return nil
+		}))
+	}
+}
+
+func TestWebSocketHandler_ConcurrentDisconnectAndTimeout(t *testing.T) {
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+	q.SetBroadcastInterval(0)
+	ws := NewWebSocketHandler(store, q)
+	dino := NewDinoHandler(store)
+	startDeadline, expire := dinoDeadline(store, dino)
+
+	e := echo.New()
+	e.GET("/ws", ws.Connect)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	_, cookie := dialSession(t, server, "")
+
+	// 再接続・切断とタイムアウトが同時に起きてもデータ競合しない
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			conn, _ := dialSession(t, server, cookie)
+			conn.Close()
+		}()
+		go func() {
+			defer wg.Done()
+			_ = store.Update(cookie, func(u *model.User) error {
+				u.Status = model.StatusStage1Dino
+				return nil
+			})
+			startDeadline(cookie)
+			expire()
+		}()
+		go func() {
+			defer wg.Done()
+			postJSON(t, dino.Result, cookie, `{"result": "gameover", "score": 1}`)
+		}()
+	}
+	wg.Wait()
+
+	require.NoError(t, store.View(cookie, func(u *model.User) error {
+		assert.Contains(t, []string{model.StatusWaiting, model.StatusStage1Dino}, u.Status)
+		return nil
+	}))
+}
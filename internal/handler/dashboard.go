@@ -0,0 +1,211 @@
+// Package handler provides HTTP handlers for the API.
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+)
+
+// verifiedTasks are the dashboard tasks completed by their own verification
+// endpoint rather than reported through DashboardHandler.Submit.
+var verifiedTasks = map[string]bool{
+	model.TaskCaptcha: true,
+	model.TaskOTP:     true,
+}
+
+// DashboardHandler serves the registration dashboard and its task ledger.
+type DashboardHandler struct {
+	store   SessionStoreInterface
+	machine *stage.Machine
+	clock   clock.Clock
+}
+
+// NewDashboardHandler creates a new DashboardHandler.
+func NewDashboardHandler(store SessionStoreInterface) *DashboardHandler {
+	return &DashboardHandler{
+		store:   store,
+		machine: stage.NewMachine(),
+		clock:   clock.Real{},
+	}
+}
+
+// SetClock sets the clock task completions are timestamped with.
+func (h *DashboardHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// SetMachine sets the state machine whose pipeline tells which gate a user is in.
+func (h *DashboardHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+}
+
+// State returns the progress of every dashboard task.
+func (h *DashboardHandler) State(c echo.Context) error {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "セッションが見つかりません",
+			"code":    "SESSION_NOT_FOUND",
+		})
+	}
+
+	var resp map[string]interface{}
+	err := h.store.View(sessionID, func(user *model.User) error {
+		if !onDashboard(h.machine, user.Status) {
+			resp = map[string]interface{}{
+				"error":   true,
+				"message": "登録ステージではありません",
+				"code":    "WRONG_STAGE",
+			}
+			return nil
+		}
+		resp = dashboardState(user)
+		resp["error"] = false
+		return nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "無効なセッション",
+			"code":    "INVALID_SESSION",
+		})
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// TaskSubmitRequest represents an input task submission.
+type TaskSubmitRequest struct {
+	Value string `json:"value"`
+}
+
+// Submit completes an input task (name, birthday, phone, address, email, terms or password).
+// CAPTCHA and OTP are completed by their own verify endpoints.
+func (h *DashboardHandler) Submit(c echo.Context) error {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "セッションが見つかりません",
+			"code":    "SESSION_NOT_FOUND",
+		})
+	}
+
+	// Parse request (before locking the user, the body may be slow to arrive)
+	var req TaskSubmitRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "リクエストの解析に失敗しました",
+			"code":    "BAD_REQUEST",
+		})
+	}
+
+	var resp map[string]interface{}
+	err := h.store.Update(sessionID, func(user *model.User) error {
+		resp = h.submit(user, c.Param("task"), req)
+		return nil
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "無効なセッション",
+			"code":    "INVALID_SESSION",
+		})
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// submit records an input task of the user and returns the response.
+// It runs with the user locked.
+func (h *DashboardHandler) submit(user *model.User, task string, req TaskSubmitRequest) map[string]interface{} {
+	if !onDashboard(h.machine, user.Status) {
+		return map[string]interface{}{
+			"error":   true,
+			"message": "登録ステージではありません",
+			"code":    "WRONG_STAGE",
+		}
+	}
+
+	if !model.IsRegisterTask(task) {
+		return map[string]interface{}{
+			"error":   true,
+			"message": "存在しないタスクです",
+			"code":    "UNKNOWN_TASK",
+		}
+	}
+	if verifiedTasks[task] {
+		return map[string]interface{}{
+			"error":   true,
+			"message": "このタスクは専用の画面で完了してください",
+			"code":    "TASK_NOT_SUBMITTABLE",
+		}
+	}
+
+	user.RecordTaskAttempt(task)
+	if strings.TrimSpace(req.Value) == "" {
+		return map[string]interface{}{
+			"error":   true,
+			"message": "入力が空です。もう一度やり直してください",
+			"code":    "EMPTY_VALUE",
+		}
+	}
+
+	completeTask(user, task, h.clock.Now())
+	resp := dashboardState(user)
+	resp["error"] = false
+	resp["task_completed"] = task
+	return resp
+}
+
+// completeTask marks a dashboard task as completed at now and, the first time,
+// pushes a task_completed message over the user's WebSocket.
+// The caller must hold the user's lock.
+func completeTask(user *model.User, task string, now time.Time) {
+	if !user.CompleteTask(task, now) || user.Conn == nil {
+		return
+	}
+	_ = user.Conn.WriteJSON(map[string]interface{}{
+		"type":      "task_completed",
+		"task":      task,
+		"completed": user.CompletedTasks(),
+		"total":     len(model.RegisterTasks),
+	})
+}
+
+// dashboardState describes the user's dashboard tasks. The caller must hold the user's lock.
+func dashboardState(user *model.User) map[string]interface{} {
+	tasks := make([]map[string]interface{}, 0, len(model.RegisterTasks))
+	for _, task := range model.RegisterTasks {
+		state := user.Task(task)
+		entry := map[string]interface{}{
+			"id":        task,
+			"completed": state.Completed(),
+			"attempts":  state.Attempts,
+		}
+		if state.Completed() {
+			entry["completed_at"] = state.CompletedAt
+		}
+		tasks = append(tasks, entry)
+	}
+
+	completed := user.CompletedTasks()
+	return map[string]interface{}{
+		"tasks":         tasks,
+		"completed":     completed,
+		"total":         len(model.RegisterTasks),
+		"all_completed": completed == len(model.RegisterTasks),
+	}
+}
@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// submitTask posts a task submission to the dashboard handler and decodes the response.
+func submitTask(t *testing.T, h *DashboardHandler, sessionID, task, body string) map[string]interface{} {
+	tc := testutil.NewTestContext(http.MethodPost, "/api/register/tasks/"+task, strings.NewReader(body))
+	tc.Request.Header.Set("Content-Type", "application/json")
+	tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	tc.Context.SetParamNames("task")
+	tc.Context.SetParamValues(task)
+
+	require.NoError(t, h.Submit(tc.Context))
+	var resp map[string]interface{}
+	require.NoError(t, json.Unmarshal(tc.Recorder.Body.Bytes(), &resp))
+	return resp
+}
+
+func TestDashboardHandler_Submit(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    string
+		task      string
+		body      string
+		wantError bool
+		wantCode  string
+	}{
+		{name: "正常系: 名前入力", status: model.StatusRegistering, task: model.TaskName, body: `{"value": "ptera"}`},
+		{name: "異常系: 空の入力", status: model.StatusRegistering, task: model.TaskPhone, body: `{"value": "  "}`, wantError: true, wantCode: "EMPTY_VALUE"},
+		{name: "異常系: 存在しないタスク", status: model.StatusRegistering, task: "nickname", body: `{"value": "x"}`, wantError: true, wantCode: "UNKNOWN_TASK"},
+		{name: "異常系: CAPTCHAは専用画面", status: model.StatusRegistering, task: model.TaskCaptcha, body: `{"value": "x"}`, wantError: true, wantCode: "TASK_NOT_SUBMITTABLE"},
+		{name: "異常系: 待機中", status: model.StatusWaiting, task: model.TaskName, body: `{"value": "x"}`, wantError: true, wantCode: "WRONG_STAGE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewSessionStore()
+			user, sessionID := store.Create()
+			user.Status = tt.status
+
+			h := NewDashboardHandler(store)
+			resp := submitTask(t, h, sessionID, tt.task, tt.body)
+
+			assert.Equal(t, tt.wantError, resp["error"])
+			if tt.wantError {
+				assert.Equal(t, tt.wantCode, resp["code"])
+				assert.False(t, user.Task(tt.task).Completed())
+				return
+			}
+			assert.Equal(t, tt.task, resp["task_completed"])
+			assert.Equal(t, float64(1), resp["completed"])
+			assert.True(t, user.Task(tt.task).Completed())
+		})
+	}
+}
+
+func TestDashboardHandler_Submit_Attempts(t *testing.T) {
+	store := session.NewSessionStore()
+	mockConn := testutil.NewMockWebSocketConn()
+	user, sessionID := store.Create()
+	user.Status = model.StatusRegistering
+	user.Conn = mockConn
+
+	h := NewDashboardHandler(store)
+
+	// 空の入力も試行回数に数えられる
+	submitTask(t, h, sessionID, model.TaskEmail, `{"value": ""}`)
+	resp := submitTask(t, h, sessionID, model.TaskEmail, `{"value": "ptera@example.com"}`)
+	assert.Equal(t, false, resp["error"])
+	assert.Equal(t, 2, user.Task(model.TaskEmail).Attempts)
+
+	// 完了通知はWebSocketで1回だけ送られる
+	submitTask(t, h, sessionID, model.TaskEmail, `{"value": "again@example.com"}`)
+	require.Len(t, mockConn.GetMessages(), 1)
+	msg := mockConn.GetLastMessageAsMap()
+	assert.Equal(t, "task_completed", msg["type"])
+	assert.Equal(t, model.TaskEmail, msg["task"])
+	assert.Equal(t, float64(len(model.RegisterTasks)), msg["total"])
+}
+
+func TestDashboardHandler_State(t *testing.T) {
+	store := session.NewSessionStore()
+	user, sessionID := store.Create()
+	user.Status = model.StatusRegistering
+	user.CompleteTask(model.TaskTerms, time.Now())
+	user.RecordTaskAttempt(model.TaskOTP)
+
+	h := NewDashboardHandler(store)
+	tc := testutil.NewTestContext(http.MethodGet, "/api/register/dashboard", nil)
+	tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+	require.NoError(t, h.State(tc.Context))
+
+	resp := tc.GetResponseBody()
+	assert.Equal(t, false, resp["error"])
+	assert.Equal(t, float64(1), resp["completed"])
+	assert.Equal(t, false, resp["all_completed"])
+
+	tasks, ok := resp["tasks"].([]interface{})
+	require.True(t, ok)
+	require.Len(t, tasks, len(model.RegisterTasks))
+	for i, raw := range tasks {
+		task := raw.(map[string]interface{})
+		assert.Equal(t, model.RegisterTasks[i], task["id"])
+		switch task["id"] {
+		case model.TaskTerms:
+			assert.Equal(t, true, task["completed"])
+			assert.NotEmpty(t, task["completed_at"])
+		case model.TaskOTP:
+			assert.Equal(t, false, task["completed"])
+			assert.Equal(t, float64(1), task["attempts"])
+		}
+	}
+
+	// 全タスク完了後はMissingTasksが空になる
+	for _, task := range model.RegisterTasks {
+		user.CompleteTask(task, time.Now())
+	}
+	assert.Empty(t, user.MissingTasks())
+}
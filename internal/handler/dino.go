@@ -2,46 +2,105 @@
 package handler
 
 import (
+	"errors"
 	"log"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/dino"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 )
 
 // QueueInterfaceForDino is the queue interface for DinoHandler
 type QueueInterfaceForDino interface {
 	Remove(userID string)
+	GetPosition(userID string) (int, bool)
+	Peek() *queue.QueueUser
 	BroadcastPositions()
 }
 
+// StageSlotsInterface defines the stage slot manager interface for handlers.
+type StageSlotsInterface interface {
+	Acquire(sessionID string) (held, acquired bool)
+	Release(sessionID string) bool
+}
+
 // DinoHandler handles Dino Run game related requests.
+//
+// The server is authoritative over the levels: a start hands out a run with a
+// level generated from a fresh seed, kept until the run ends, and a clear is
+// only accepted for that run once its level can have been played to the end
+// with moves clearing every obstacle.
 type DinoHandler struct {
-	store SessionStoreInterface
-	queue QueueInterfaceForDino
+	store    SessionStoreInterface
+	queue    QueueInterfaceForDino
+	slots    StageSlotsInterface
+	machine  *stage.Machine
+	failures *failure.FailureHandler
+	level    dino.Config
+	clock    clock.Clock
 }
 
 // NewDinoHandler creates a new DinoHandler.
 func NewDinoHandler(store SessionStoreInterface) *DinoHandler {
 	return &DinoHandler{
-		store: store,
+		store:    store,
+		machine:  stage.NewMachine(),
+		failures: failure.NewFailureHandler(nil),
+		level:    dino.DefaultConfig(),
+		clock:    clock.Real{},
 	}
 }
 
+// SetLevelConfig sets how the levels of new runs are generated.
+func (h *DinoHandler) SetLevelConfig(cfg dino.Config) {
+	h.level = cfg
+}
+
+// SetClock sets the clock runs are timed with.
+func (h *DinoHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
 // SetQueue sets the waiting queue.
 func (h *DinoHandler) SetQueue(queue QueueInterfaceForDino) {
 	h.queue = queue
 }
 
+// SetSlots sets the stage slot manager.
+// When set, a waiting user can only start if they are first in line and a slot is free.
+func (h *DinoHandler) SetSlots(slots StageSlotsInterface) {
+	h.slots = slots
+}
+
+// SetMachine sets the state machine that applies status changes.
+// The machine is expected to release the stage slot when a user leaves the first gate.
+func (h *DinoHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+	h.failures.SetMachine(machine)
+}
+
+// SetFailureHandler sets the failure pipeline users who fail here go through.
+func (h *DinoHandler) SetFailureHandler(failures *failure.FailureHandler) {
+	h.failures = failures
+}
+
 // Start handles the game start request.
-// This promotes the user from waiting to stage1_dino status.
+// This promotes the user from waiting to the first gate of the pipeline.
 func (h *DinoHandler) Start(c echo.Context) error {
 	log.Println("[DinoHandler.Start] Request received")
 
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
-		log.Printf("[DinoHandler.Start] No session cookie found: %v", err)
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
+		log.Println("[DinoHandler.Start] No session cookie found")
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "セッションが見つかりません",
@@ -49,11 +108,15 @@ func (h *DinoHandler) Start(c echo.Context) error {
 		})
 	}
 
-	log.Printf("[DinoHandler.Start] Session cookie: %s", cookie.Value[:8]+"...")
+	log.Printf("[DinoHandler.Start] Session cookie: %s", session.ShortID(sessionID))
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
-		log.Printf("[DinoHandler.Start] Session not found in store: %s", cookie.Value[:8]+"...")
+	var userID, status string
+	err := h.store.View(sessionID, func(user *model.User) error {
+		userID, status = user.ID, user.Status
+		return nil
+	})
+	if err != nil {
+		log.Printf("[DinoHandler.Start] Session not found in store: %s", session.ShortID(sessionID))
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "無効なセッション",
@@ -61,49 +124,135 @@ func (h *DinoHandler) Start(c echo.Context) error {
 		})
 	}
 
-	log.Printf("[DinoHandler.Start] User found: %s, Status: %s", user.ID, user.Status)
+	log.Printf("[DinoHandler.Start] User found: %s, Status: %s", userID, status)
 
 	// Check if user is in waiting status (can be promoted)
-	if user.Status != model.StatusWaiting {
-		// Already promoted or in another stage - that's fine, just return success
-		if user.Status == model.StatusStage1Dino {
-			log.Printf("[DinoHandler.Start] User already in stage1_dino: %s", user.ID)
+	if status != model.StatusWaiting {
+		return h.notWaiting(c, sessionID, userID, status)
+	}
+
+	// Only the head of the queue may take a free slot. Like the dispatcher,
+	// the head is the first entry that can be promoted: phantoms and reserved
+	// places ahead of it don't count (queue and slots are used without holding
+	// the user's lock)
+	acquired := false
+	if h.slots != nil {
+		if h.queue != nil {
+			if head := h.queue.Peek(); head == nil || head.ID != sessionID {
+				position, _ := h.queue.GetPosition(sessionID)
+				log.Printf("[DinoHandler.Start] User is not first in line: %s (position=%d)", userID, position)
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"error":    true,
+					"message":  "まだあなたの番ではありません",
+					"code":     "NOT_YOUR_TURN",
+					"position": position,
+				})
+			}
+		}
+		var held bool
+		if held, acquired = h.slots.Acquire(sessionID); !held {
+			log.Printf("[DinoHandler.Start] No free slot for user: %s", userID)
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"error":   false,
-				"message": "ゲーム開始準備完了",
-				"status":  user.Status,
+				"error":   true,
+				"message": "前の人がプレイ中です。しばらくお待ちください",
+				"code":    "STAGE_BUSY",
 			})
 		}
-		log.Printf("[DinoHandler.Start] User not in waiting status: %s (status=%s)", user.ID, user.Status)
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"error":   true,
-			"message": "待機中ではありません",
-			"code":    "NOT_WAITING",
-		})
 	}
 
-	// Promote user to stage1_dino
-	user.Status = model.StatusStage1Dino
-	log.Printf("[DinoHandler.Start] User promoted to stage1_dino: %s", user.ID)
+	// Promote user to the first gate, unless a concurrent request got there first
+	first := h.machine.Pipeline().First()
+	promoted := false
+	var resp map[string]interface{}
+	err = h.store.Update(sessionID, func(user *model.User) error {
+		status = user.Status
+		if status == model.StatusWaiting {
+			promoted = h.machine.Fire(user, stage.EventPromote) == nil
+		}
+		if promoted {
+			resp = h.started(user)
+		}
+		return nil
+	})
+	if !promoted {
+		// Give back the slot taken above, unless a concurrent promotion now relies on it
+		if acquired && status != first.Status {
+			h.slots.Release(sessionID)
+		}
+		if err != nil {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"error":   true,
+				"message": "無効なセッション",
+				"code":    "INVALID_SESSION",
+			})
+		}
+		return h.notWaiting(c, sessionID, userID, status)
+	}
+	log.Printf("[DinoHandler.Start] User promoted to %s: %s", first.Status, userID)
 
 	// Remove from queue and broadcast to other users
 	if h.queue != nil {
-		h.queue.Remove(cookie.Value)
+		h.queue.Remove(sessionID)
 		h.queue.BroadcastPositions()
-		log.Printf("[DinoHandler.Start] User removed from queue and positions broadcasted: %s", user.ID)
+		log.Printf("[DinoHandler.Start] User removed from queue and positions broadcasted: %s", userID)
 	}
 
+	return c.JSON(http.StatusOK, resp)
+}
+
+// notWaiting answers a start request from a user who is not waiting.
+func (h *DinoHandler) notWaiting(c echo.Context, sessionID, userID, status string) error {
+	// Already promoted - that's fine, start another run (e.g. a retry after a game over)
+	var resp map[string]interface{}
+	_ = h.store.Update(sessionID, func(user *model.User) error {
+		gate, ok := h.machine.Current(user)
+		if user.Status == h.machine.Pipeline().First().Status || ok && gate.Kind == stage.KindDino {
+			resp = h.started(user)
+		}
+		return nil
+	})
+	if resp != nil {
+		log.Printf("[DinoHandler.Start] User already in %s: %s", resp["status"], userID)
+		return c.JSON(http.StatusOK, resp)
+	}
+	log.Printf("[DinoHandler.Start] User not in waiting status: %s (status=%s)", userID, status)
 	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":   true,
+		"message": "待機中ではありません",
+		"code":    "NOT_WAITING",
+	})
+}
+
+// started builds the start response of a user who just entered a gate or
+// is already in it. In a Dino gate it hands out the run in progress, or a new
+// one if the last run ended. It runs with the user locked.
+func (h *DinoHandler) started(user *model.User) map[string]interface{} {
+	resp := map[string]interface{}{
 		"error":   false,
 		"message": "ゲーム開始準備完了",
 		"status":  user.Status,
-	})
+	}
+	if gate, ok := h.machine.Current(user); ok && gate.Kind == stage.KindDino {
+		if user.DinoRunID == "" {
+			user.DinoRunID = uuid.New().String()
+			user.DinoSeed = dino.NewSeed()
+			user.DinoStartedAt = h.clock.Now()
+			log.Printf("[DinoHandler.Start] Run %s handed out to user %s (seed=%d)", user.DinoRunID, user.ID, user.DinoSeed)
+		}
+		resp["run_id"] = user.DinoRunID
+		resp["level"] = dino.Generate(user.DinoSeed, h.level)
+	}
+	return resp
 }
 
 // DinoResultRequest represents the game result request.
+// RunID is the run_id returned by start for the run being reported, and a
+// clear lists the move made at each obstacle of its level, in order.
 type DinoResultRequest struct {
-	Result string `json:"result"`
-	Score  int    `json:"score"`
+	Result string      `json:"result"`
+	Score  int         `json:"score"`
+	RunID  string      `json:"run_id"`
+	Moves  []dino.Move `json:"moves"`
 }
 
 // Result handles the Dino Run game result.
@@ -111,9 +260,9 @@ func (h *DinoHandler) Result(c echo.Context) error {
 	log.Println("[DinoHandler.Result] Request received")
 
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
-		log.Printf("[DinoHandler.Result] No session cookie found: %v", err)
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
+		log.Println("[DinoHandler.Result] No session cookie found")
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "セッションが見つかりません",
@@ -121,70 +270,123 @@ func (h *DinoHandler) Result(c echo.Context) error {
 		})
 	}
 
-	log.Printf("[DinoHandler.Result] Session cookie: %s", cookie.Value[:8]+"...")
+	log.Printf("[DinoHandler.Result] Session cookie: %s", session.ShortID(sessionID))
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
-		log.Printf("[DinoHandler.Result] Session not found in store: %s", cookie.Value[:8]+"...")
+	// Parse request (before locking the user, the body may be slow to arrive)
+	var req DinoResultRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "リクエストの解析に失敗しました",
+			"code":    "BAD_REQUEST",
+		})
+	}
+
+	var resp map[string]interface{}
+	err := h.store.Update(sessionID, func(user *model.User) error {
+		var err error
+		resp, err = h.result(user, req)
+		return err
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
+		log.Printf("[DinoHandler.Result] Session not found in store: %s", session.ShortID(sessionID))
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "無効なセッション",
 			"code":    "INVALID_SESSION",
 		})
 	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
 
+// result applies a Dino Run result and returns the response.
+// It runs with the user locked. Leaving the gate either way frees the
+// user's Dino slot through the state machine.
+func (h *DinoHandler) result(user *model.User, req DinoResultRequest) (map[string]interface{}, error) {
 	log.Printf("[DinoHandler.Result] User found: %s, Status: %s", user.ID, user.Status)
 
-	// Check user status
-	if user.Status != "stage1_dino" {
-		log.Printf("[DinoHandler.Result] WRONG_STAGE: User %s has status %s (expected stage1_dino)", user.ID, user.Status)
-		return c.JSON(http.StatusOK, map[string]interface{}{
+	// Check user is in a Dino gate
+	gate, ok := h.machine.Current(user)
+	if !ok || gate.Kind != stage.KindDino {
+		log.Printf("[DinoHandler.Result] WRONG_STAGE: User %s has status %s (expected a Dino gate)", user.ID, user.Status)
+		return map[string]interface{}{
 			"error":   true,
 			"message": "Dino Runステージではありません",
 			"code":    "WRONG_STAGE",
-		})
-	}
-
-	// Parse request
-	var req DinoResultRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusOK, map[string]interface{}{
-			"error":   true,
-			"message": "リクエストの解析に失敗しました",
-			"code":    "BAD_REQUEST",
-		})
+		}, nil
 	}
 
 	log.Printf("[DinoHandler.Result] Game result: %s, Score: %d", req.Result, req.Score)
 
 	// Handle result
 	if req.Result == "clear" {
-		// Success - advance to registration dashboard (hub & spoke)
-		user.Status = model.StatusRegistering
-		log.Printf("[DinoHandler.Result] User %s cleared! Status changed to registering", user.ID)
-		return c.JSON(http.StatusOK, map[string]interface{}{
+		// The clear must be the run handed out last, played to the end of its level
+		if code, message := h.checkRun(user, req); code != "" {
+			log.Printf("[DinoHandler.Result] Clear of user %s rejected: %s", user.ID, code)
+			user.EndDinoRun()
+			return map[string]interface{}{
+				"error":   true,
+				"message": message,
+				"code":    code,
+			}, nil
+		}
+		user.EndDinoRun()
+
+		// Success - advance to the next gate (the registration dashboard by default)
+		next, _ := h.machine.Pipeline().Next(user.Status)
+		if err := h.machine.Fire(user, stage.EventPass); err != nil {
+			return nil, err
+		}
+		log.Printf("[DinoHandler.Result] User %s cleared! Status changed to %s", user.ID, user.Status)
+		resp := map[string]interface{}{
 			"error":      false,
-			"next_stage": "register",
+			"next_stage": next.Name,
 			"message":    "ゲームクリア！登録フォームに進みます",
 			"score":      req.Score,
-		})
+		}
+		// Clearing Dino opens the registration window (the token is issued by a machine hook)
+		if user.RegisterToken != "" {
+			resp["register_token"] = user.RegisterToken
+			resp["register_deadline"] = user.RegisterTokenExp
+		}
+		return resp, nil
 	}
 
-	// Game over - reset to waiting
-	user.ResetToWaiting()
+	// Game over - retry while the gate allows it, with a new run from start
+	user.EndDinoRun()
+	user.StageAttempts++
+	if user.StageAttempts < gate.MaxAttempts {
+		return map[string]interface{}{
+			"error":              true,
+			"message":            "ゲームオーバー。もう一度挑戦してください",
+			"attempts_remaining": gate.MaxAttempts - user.StageAttempts,
+		}, nil
+	}
 
-	// Send failure notification via WebSocket
-	if user.Conn != nil {
-		_ = user.Conn.WriteJSON(map[string]interface{}{
-			"type":           "failure",
-			"message":        "ゲームオーバー。待機列の最後尾からやり直しです。",
-			"redirect_delay": float64(3),
-		})
+	// Out of attempts - reset to waiting
+	e := h.failures.Fail(user, failure.ReasonGameOver, user.StageAttempts)
+	return e.Response(), nil
+}
+
+// checkRun checks a reported clear against the user's run.
+// Returns an error code and message, or "" if the clear is accepted.
+func (h *DinoHandler) checkRun(user *model.User, req DinoResultRequest) (string, string) {
+	if user.DinoRunID == "" || req.RunID != user.DinoRunID {
+		return "RUN_MISMATCH", "ゲームの記録が見つかりません。もう一度スタートしてください"
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"error":          true,
-		"message":        "ゲームオーバー。待機列の最後尾からやり直しです。",
-		"redirect_delay": float64(3),
-	})
+	level := dino.Generate(user.DinoSeed, h.level)
+	switch level.Check(h.clock.Since(user.DinoStartedAt), req.Score, req.Moves) {
+	case nil:
+		return "", ""
+	case dino.ErrTooEarly:
+		return "RESULT_TOO_EARLY", "ゴールに着くには早すぎます"
+	case dino.ErrMoves:
+		return "MOVES_MISMATCH", "障害物の避け方がコースと一致しません"
+	default:
+		return "SCORE_MISMATCH", "スコアがコースと一致しません"
+	}
 }
@@ -2,22 +2,66 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/dino"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
 	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/slot"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 	"github.com/kyiku/hackz-ptera-back/internal/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// playRun hands a Dino run to the user and plays its level to the end on a
+// fake clock. Returns the result body clearing the run.
+func playRun(t *testing.T, h *DinoHandler, user *model.User) string {
+	t.Helper()
+
+	clk := clock.NewFake(time.Now())
+	h.SetClock(clk)
+	user.Lock()
+	resp := h.started(user)
+	user.Unlock()
+	level, ok := resp["level"].(*dino.Level)
+	require.True(t, ok, "Dinoゲートではレベルが配られるべき")
+
+	clk.Advance(level.Duration())
+	return clearBody(t, level.Score(), resp["run_id"].(string), clearingMoves(level))
+}
+
+// clearingMoves returns the moves clearing every obstacle of the level.
+func clearingMoves(level *dino.Level) []dino.Move {
+	moves := make([]dino.Move, len(level.Obstacles))
+	for i, o := range level.Obstacles {
+		moves[i] = dino.Move{AtMs: o.AtMs - 100, Action: o.Action()}
+	}
+	return moves
+}
+
+// clearBody returns the result body reporting a clear.
+func clearBody(t *testing.T, score int, runID string, moves []dino.Move) string {
+	t.Helper()
+
+	body, err := json.Marshal(DinoResultRequest{Result: "clear", Score: score, RunID: runID, Moves: moves})
+	require.NoError(t, err)
+	return string(body)
+}
+
 func TestDinoHandler_Result(t *testing.T) {
 	tests := []struct {
 		name           string
 		setupUser      func(*model.User)
 		requestBody    string
+		playRun        bool // the body clears a run handed out by start
 		hasCookie      bool
 		wantStatusCode int
 		wantError      bool
@@ -28,7 +72,7 @@ func TestDinoHandler_Result(t *testing.T) {
 			setupUser: func(u *model.User) {
 				u.Status = "stage1_dino"
 			},
-			requestBody:    `{"result": "clear", "score": 1000}`,
+			playRun:        true,
 			hasCookie:      true,
 			wantStatusCode: http.StatusOK,
 			wantError:      false,
@@ -101,8 +145,12 @@ func TestDinoHandler_Result(t *testing.T) {
 			}
 
 			h := NewDinoHandler(store)
+			body := tt.requestBody
+			if tt.playRun {
+				body = playRun(t, h, user)
+			}
 
-			tc := testutil.NewTestContext(http.MethodPost, "/api/game/dino/result", strings.NewReader(tt.requestBody))
+			tc := testutil.NewTestContext(http.MethodPost, "/api/game/dino/result", strings.NewReader(body))
 			tc.Request.Header.Set("Content-Type", "application/json")
 			if tt.hasCookie && sessionID != "" {
 				tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
@@ -148,3 +196,315 @@ func TestDinoHandler_Result_GameOver_QueueReset(t *testing.T) {
 	assert.Equal(t, true, resp["error"])
 	assert.Equal(t, float64(3), resp["redirect_delay"])
 }
+
+func TestDinoHandler_Start_Slots(t *testing.T) {
+	tests := []struct {
+		name       string
+		position   int // 1-indexed position of the requesting user among real users
+		phantoms   int // phantoms ahead of everyone
+		reserved   bool
+		slotHeld   bool
+		wantError  bool
+		wantCode   string
+		wantStatus string
+	}{
+		{
+			name:       "正常系: 先頭かつ空き枠あり",
+			position:   1,
+			slotHeld:   false,
+			wantError:  false,
+			wantStatus: model.StatusStage1Dino,
+		},
+		{
+			name:       "異常系: 先頭ではない",
+			position:   2,
+			slotHeld:   false,
+			wantError:  true,
+			wantCode:   "NOT_YOUR_TURN",
+			wantStatus: model.StatusWaiting,
+		},
+		{
+			name:       "正常系: ファントムと予約の後ろの先頭",
+			position:   1,
+			phantoms:   2,
+			reserved:   true,
+			wantError:  false,
+			wantStatus: model.StatusStage1Dino,
+		},
+		{
+			name:       "異常系: ファントムの後ろの2番目",
+			position:   2,
+			phantoms:   2,
+			wantError:  true,
+			wantCode:   "NOT_YOUR_TURN",
+			wantStatus: model.StatusWaiting,
+		},
+		{
+			name:       "異常系: 前の人がプレイ中",
+			position:   1,
+			slotHeld:   true,
+			wantError:  true,
+			wantCode:   "STAGE_BUSY",
+			wantStatus: model.StatusWaiting,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewSessionStore()
+			q := queue.NewWaitingQueue()
+			slots := slot.NewManager(1)
+
+			if tt.slotHeld {
+				slots.Acquire("player")
+			}
+			// ファントムと再接続待ちの予約は昇格できないので順番に数えない
+			q.InjectPhantoms(tt.phantoms)
+			if tt.reserved {
+				q.Reserve([]string{"away"})
+			}
+
+			var sessionID string
+			var user *model.User
+			for i := 1; i <= tt.position; i++ {
+				u, id := store.Create()
+				q.Add(id, testutil.NewMockWebSocketConn())
+				user, sessionID = u, id
+			}
+
+			h := NewDinoHandler(store)
+			h.SetQueue(q)
+			h.SetSlots(slots)
+
+			tc := testutil.NewTestContext(http.MethodPost, "/api/game/dino/start", nil)
+			tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+
+			err := h.Start(tc.Context)
+			require.NoError(t, err)
+
+			var resp map[string]interface{}
+			_ = json.Unmarshal(tc.Recorder.Body.Bytes(), &resp)
+
+			assert.Equal(t, tt.wantError, resp["error"])
+			if tt.wantCode != "" {
+				assert.Equal(t, tt.wantCode, resp["code"])
+			}
+			assert.Equal(t, tt.wantStatus, user.Status)
+			assert.Equal(t, !tt.wantError, slots.Holds(sessionID))
+		})
+	}
+}
+
+func TestDinoHandler_Result_ReleasesSlot(t *testing.T) {
+	for _, result := range []string{"clear", "gameover"} {
+		t.Run(result, func(t *testing.T) {
+			store := session.NewSessionStore()
+			slots := slot.NewManager(1)
+
+			user, sessionID := store.Create()
+			user.Status = model.StatusStage1Dino
+			held, _ := slots.Acquire(sessionID)
+			require.True(t, held)
+
+			// 枠の解放はステートマシンの退出フックで行う
+			machine := stage.NewMachine()
+			machine.OnExit(model.StatusStage1Dino, func(u *model.User, _ stage.Transition) {
+				slots.Release(u.SessionID)
+			})
+
+			h := NewDinoHandler(store)
+			h.SetSlots(slots)
+			h.SetMachine(machine)
+			body := `{"result": "gameover", "score": 100}`
+			if result == "clear" {
+				body = playRun(t, h, user)
+			}
+
+			tc := testutil.NewTestContext(http.MethodPost, "/api/game/dino/result", strings.NewReader(body))
+			tc.Request.Header.Set("Content-Type", "application/json")
+			tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+
+			require.NoError(t, h.Result(tc.Context))
+			assert.False(t, slots.Holds(sessionID))
+			assert.True(t, slots.IsFree())
+		})
+	}
+}
+
+func TestDinoHandler_Result_Pipeline(t *testing.T) {
+	// Dino は2回まで挑戦でき、クリア後は CAPTCHA ゲートに進む
+	p, err := stage.NewPipeline([]stage.Gate{
+		{Name: "dino", Kind: stage.KindDino, MaxAttempts: 2},
+		{Name: "captcha", Kind: stage.KindCaptcha},
+		{Name: "register", Kind: stage.KindRegister},
+	})
+	require.NoError(t, err)
+
+	store := session.NewSessionStore()
+	user, sessionID := store.Create()
+	user.Status = model.StatusStage1Dino
+
+	h := NewDinoHandler(store)
+	h.SetMachine(stage.NewMachineFor(p))
+
+	resp := postJSON(t, h.Result, sessionID, `{"result": "gameover", "score": 10}`)
+	assert.Equal(t, true, resp["error"])
+	assert.Equal(t, float64(1), resp["attempts_remaining"])
+	assert.Equal(t, model.StatusStage1Dino, user.Status)
+
+	resp = postJSON(t, h.Result, sessionID, playRun(t, h, user))
+	assert.Equal(t, false, resp["error"])
+	assert.Equal(t, "captcha", resp["next_stage"])
+	assert.Equal(t, model.StatusStage2Captcha, user.Status)
+	assert.Equal(t, 0, user.StageAttempts)
+}
+
+func TestDinoHandler_Start_Level(t *testing.T) {
+	store := session.NewSessionStore()
+	user, sessionID := store.Create()
+
+	cfg := dino.Config{Duration: 30 * time.Second}
+	h := NewDinoHandler(store)
+	h.SetLevelConfig(cfg)
+
+	// 開始するとサーバーが生成したレベルが配られる
+	resp := postJSON(t, h.Start, sessionID, `{}`)
+	assert.Equal(t, false, resp["error"])
+	assert.Equal(t, model.StatusStage1Dino, resp["status"])
+	require.NotEmpty(t, user.DinoRunID)
+	assert.Equal(t, user.DinoRunID, resp["run_id"])
+
+	var level dino.Level
+	raw, err := json.Marshal(resp["level"])
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(raw, &level))
+	assert.NotContains(t, resp["level"], "seed", "シードはクライアントに渡さない")
+	level.Seed = user.DinoSeed
+	assert.Equal(t, *dino.Generate(user.DinoSeed, cfg), level, "保存したシードから同じレベルが再現できる")
+	assert.Equal(t, int64(30000), level.DurationMs)
+	assert.NotEmpty(t, level.Obstacles)
+
+	// ゲート内で再度開始しても同じランとレベルが返る
+	firstRun, firstLevel := user.DinoRunID, resp["level"]
+	resp = postJSON(t, h.Start, sessionID, `{}`)
+	assert.Equal(t, false, resp["error"])
+	assert.Equal(t, firstRun, resp["run_id"])
+	assert.Equal(t, firstRun, user.DinoRunID)
+	assert.Equal(t, firstLevel, resp["level"])
+
+	// ゲームオーバーの後は新しいランになる
+	postJSON(t, h.Result, sessionID, fmt.Sprintf(`{"result": "gameover", "score": 1, "run_id": %q}`, firstRun))
+	resp = postJSON(t, h.Start, sessionID, `{}`)
+	assert.Equal(t, false, resp["error"])
+	assert.NotEqual(t, firstRun, resp["run_id"])
+	assert.Equal(t, user.DinoRunID, resp["run_id"])
+}
+
+func TestDinoHandler_Result_CheckRun(t *testing.T) {
+	tests := []struct {
+		name     string
+		elapsed  func(level *dino.Level) time.Duration
+		score    func(level *dino.Level) int
+		moves    func(level *dino.Level) []dino.Move
+		runID    func(runID string) string
+		wantCode string
+	}{
+		{
+			name:     "異常系: ランIDが違う",
+			runID:    func(string) string { return "other-run" },
+			wantCode: "RUN_MISMATCH",
+		},
+		{
+			name:     "異常系: ランIDなし",
+			runID:    func(string) string { return "" },
+			wantCode: "RUN_MISMATCH",
+		},
+		{
+			name:     "異常系: コースの終了前にクリア",
+			elapsed:  func(level *dino.Level) time.Duration { return level.Duration() / 2 },
+			wantCode: "RESULT_TOO_EARLY",
+		},
+		{
+			name:     "異常系: スコアが合わない",
+			score:    func(level *dino.Level) int { return level.Score() * 3 },
+			wantCode: "SCORE_MISMATCH",
+		},
+		{
+			name:     "異常系: 操作記録なし",
+			moves:    func(*dino.Level) []dino.Move { return nil },
+			wantCode: "MOVES_MISMATCH",
+		},
+		{
+			name: "異常系: 障害物を避けていない",
+			moves: func(level *dino.Level) []dino.Move {
+				moves := clearingMoves(level)
+				moves[len(moves)-1].AtMs = level.Obstacles[len(moves)-1].AtMs + 1
+				return moves
+			},
+			wantCode: "MOVES_MISMATCH",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewSessionStore()
+			user, sessionID := store.Create()
+			user.Status = model.StatusStage1Dino
+
+			clk := clock.NewFake(time.Now())
+			h := NewDinoHandler(store)
+			h.SetClock(clk)
+			user.Lock()
+			resp := h.started(user)
+			user.Unlock()
+			level := resp["level"].(*dino.Level)
+
+			elapsed, score, moves, runID := level.Duration(), level.Score(), clearingMoves(level), user.DinoRunID
+			played := runID
+			if tt.elapsed != nil {
+				elapsed = tt.elapsed(level)
+			}
+			if tt.score != nil {
+				score = tt.score(level)
+			}
+			if tt.moves != nil {
+				moves = tt.moves(level)
+			}
+			if tt.runID != nil {
+				runID = tt.runID(runID)
+			}
+			clk.Advance(elapsed)
+
+			resp = postJSON(t, h.Result, sessionID, clearBody(t, score, runID, moves))
+			assert.Equal(t, true, resp["error"])
+			assert.Equal(t, tt.wantCode, resp["code"])
+			assert.Equal(t, model.StatusStage1Dino, user.Status)
+
+			// 却下されたランは使えなくなり、やり直しには再スタートが必要
+			assert.Empty(t, user.DinoRunID)
+			resp = postJSON(t, h.Result, sessionID, clearBody(t, level.Score(), played, clearingMoves(level)))
+			assert.Equal(t, "RUN_MISMATCH", resp["code"])
+		})
+	}
+}
+
+func TestDinoHandler_ShortCookie(t *testing.T) {
+	// 8文字未満のCookieでもパニックせずINVALID_SESSIONを返す
+	for _, value := range []string{"x", "1234567"} {
+		h := NewDinoHandler(session.NewSessionStore())
+
+		for _, call := range []func(c echo.Context) error{h.Start, h.Result} {
+			tc := testutil.NewTestContext(http.MethodPost, "/api/game/dino/start", strings.NewReader(`{"result": "clear", "score": 1}`))
+			tc.Request.Header.Set("Content-Type", "application/json")
+			tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: value})
+
+			require.NotPanics(t, func() {
+				require.NoError(t, call(tc.Context))
+			})
+
+			var resp map[string]interface{}
+			require.NoError(t, json.Unmarshal(tc.Recorder.Body.Bytes(), &resp))
+			assert.Equal(t, "INVALID_SESSION", resp["code"])
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/penalty"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailurePipeline_SameFormat(t *testing.T) {
+	store := session.NewSessionStore()
+	mockS3 := testutil.NewMockS3Client()
+
+	metrics := failure.NewMetrics()
+	var mu sync.Mutex
+	var events []failure.Event
+	failures := failure.NewFailureHandler(nil)
+	failures.Use(metrics.Record, func(e failure.Event, u *model.User) {
+		mu.Lock()
+		defer mu.Unlock()
+		// 副作用はユーザーが待機状態に戻った後に呼ばれる
+		assert.Equal(t, model.StatusWaiting, u.Status)
+		events = append(events, e)
+	})
+
+	dino := NewDinoHandler(store)
+	dino.SetFailureHandler(failures)
+	captcha := NewCaptchaHandler(store, mockS3)
+	captcha.SetFailureHandler(failures)
+	captcha.SetTolerance(10)
+	register := NewRegisterHandler(store)
+	register.SetFailureHandler(failures)
+
+	tests := []struct {
+		name       string
+		setupUser  func(*model.User)
+		call       func(sessionID string) map[string]interface{}
+		wantReason failure.Reason
+		wantStage  string
+	}{
+		{
+			name:      "Dino ゲームオーバー",
+			setupUser: func(u *model.User) { u.Status = model.StatusStage1Dino },
+			call: func(sessionID string) map[string]interface{} {
+				return postJSON(t, dino.Result, sessionID, `{"result": "gameover", "score": 1}`)
+			},
+			wantReason: failure.ReasonGameOver,
+			wantStage:  model.StatusStage1Dino,
+		},
+		{
+			name: "CAPTCHA 3回失敗",
+			setupUser: func(u *model.User) {
+				u.Status = model.StatusRegistering
+				u.CaptchaTargetX = 512
+				u.CaptchaTargetY = 384
+				u.CaptchaAttempts = 2
+			},
+			call: func(sessionID string) map[string]interface{} {
+				return postJSON(t, captcha.Verify, sessionID, `{"x": 0, "y": 0}`)
+			},
+			wantReason: failure.ReasonCaptchaFailed,
+			wantStage:  model.StatusRegistering,
+		},
+		{
+			name: "登録のサーバーエラー",
+			setupUser: func(u *model.User) {
+				u.Status = model.StatusRegistering
+				u.RegisterToken = "valid-token"
+				u.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+				completeAllTasks(u)
+			},
+			call: func(sessionID string) map[string]interface{} {
+				return postJSON(t, register.Submit, sessionID, `{"token": "valid-token"}`)
+			},
+			wantReason: failure.ReasonServerError,
+			wantStage:  model.StatusRegistering,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, sessionID := store.Create()
+			tt.setupUser(user)
+			mockConn := testutil.NewMockWebSocketConn()
+			user.Conn = mockConn
+
+			resp := tt.call(sessionID)
+			assert.Equal(t, true, resp["error"])
+			assert.Equal(t, string(tt.wantReason), resp["code"])
+			assert.Equal(t, float64(failure.RedirectDelay), resp["redirect_delay"])
+			assert.NotEmpty(t, resp["message"])
+
+			msg := mockConn.GetLastMessageAsMap()
+			require.NotNil(t, msg)
+			assert.Equal(t, "failure", msg["type"])
+			assert.Equal(t, string(tt.wantReason), msg["code"])
+			assert.Equal(t, tt.wantStage, msg["stage"])
+			assert.Equal(t, resp["message"], msg["message"])
+			assert.Equal(t, float64(failure.RedirectDelay), msg["redirect_delay"])
+
+			assert.Equal(t, model.StatusWaiting, user.Status)
+			err := testutil.WaitFor(100*time.Millisecond, 10*time.Millisecond, mockConn.GetIsClosed)
+			require.NoError(t, err, "WebSocket接続が閉じられるべき")
+		})
+	}
+
+	mu.Lock()
+	require.Len(t, events, 3)
+	assert.Equal(t, 3, events[1].Attempts)
+	mu.Unlock()
+
+	stats := metrics.Stats()
+	assert.Equal(t, int64(3), stats["total"])
+	assert.Equal(t, int64(2), stats["by_stage"].(map[string]int64)[model.StatusRegistering])
+}
+
+// mockPlacer is a failure.Placer that records where users were placed.
+type mockPlacer struct {
+	placed map[string]int
+}
+
+func (p *mockPlacer) Place(user *model.User, position int) { p.placed[user.SessionID] = position }
+func (p *mockPlacer) QueueLen(*model.User) int             { return 10 }
+
+func TestFailurePipeline_Penalty(t *testing.T) {
+	store := session.NewSessionStore()
+	rules := penalty.NewRules(penalty.BackOfLine{})
+	rules.Set(model.StatusStage1Dino, string(failure.ReasonGameOver), penalty.Lives{Lives: 1, Then: penalty.MoveBack{Places: 4}})
+	rules.Set(penalty.Any, string(failure.ReasonTimeout), penalty.Cooldown{Duration: time.Minute, Then: penalty.MoveBack{Places: 2}})
+	placer := &mockPlacer{placed: make(map[string]int)}
+	clk := clock.NewFake(time.Now())
+
+	failures := failure.NewFailureHandler(nil)
+	failures.SetPolicy(rules)
+	failures.SetPlacer(placer)
+	failures.SetClock(clk)
+	dino := NewDinoHandler(store)
+	dino.SetFailureHandler(failures)
+
+	user, sessionID := store.Create()
+	user.Status = model.StatusStage1Dino
+	mockConn := testutil.NewMockWebSocketConn()
+	user.Conn = mockConn
+
+	// 1回目のゲームオーバーはライフが吸収し、ステージに残る
+	resp := postJSON(t, dino.Result, sessionID, `{"result": "gameover", "score": 1}`)
+	assert.Equal(t, true, resp["error"])
+	assert.Equal(t, string(failure.ReasonGameOver), resp["code"])
+	assert.Equal(t, float64(0), resp["lives_left"])
+	assert.Nil(t, resp["redirect_delay"])
+	assert.Equal(t, "life_lost", mockConn.GetLastMessageAsMap()["type"])
+	assert.Equal(t, model.StatusStage1Dino, user.Status)
+	assert.False(t, mockConn.GetIsClosed())
+
+	// 2回目は待機列の5番目に戻される
+	resp = postJSON(t, dino.Result, sessionID, `{"result": "gameover", "score": 1}`)
+	assert.Equal(t, "position", resp["placement"])
+	assert.Contains(t, resp["message"], "5番目")
+	assert.Equal(t, model.StatusWaiting, user.Status)
+	assert.Equal(t, 5, placer.placed[sessionID])
+	assert.Equal(t, 2, user.Failures)
+
+	// クールダウン中は並ばず、再接続したときに指定の位置に並ぶ
+	other, otherID := store.Create()
+	other.Status = model.StatusStage1Dino
+	require.NoError(t, store.Update(otherID, func(u *model.User) error {
+		e := failures.Fail(u, failure.ReasonTimeout, 0)
+		assert.Equal(t, 60, e.Response()["cooldown_sec"])
+		return nil
+	}))
+	_, placed := placer.placed[otherID]
+	assert.False(t, placed)
+	assert.Equal(t, clk.Now().Add(time.Minute), other.RejoinAt)
+	assert.Equal(t, 3, other.RejoinPosition)
+}
+
+func TestFailurePipeline_LivesIgnoreTimeouts(t *testing.T) {
+	store := session.NewSessionStore()
+	failures := failure.NewFailureHandler(nil)
+	failures.SetPolicy(penalty.Lives{Lives: 2, Then: penalty.BackOfLine{}})
+	failures.SetPlacer(&mockPlacer{placed: make(map[string]int)})
+
+	user, sessionID := store.Create()
+	fail := func(reason failure.Reason) failure.Event {
+		user.Status = model.StatusStage1Dino
+		var e failure.Event
+		require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+			e = failures.Fail(u, reason, 0)
+			return nil
+		}))
+		return e
+	}
+
+	// タイムアウトはライフを使わずに待機列へ戻す
+	for i := 0; i < 2; i++ {
+		e := fail(failure.ReasonTimeout)
+		assert.False(t, e.Penalty.Absorbed)
+	}
+	assert.Equal(t, 2, user.Failures)
+	assert.Equal(t, 0, user.Retries)
+
+	// その後のやり直せる失敗には2つのライフが残っている
+	e := fail(failure.ReasonGameOver)
+	assert.True(t, e.Penalty.Absorbed)
+	assert.Equal(t, 1, e.Penalty.LivesLeft)
+	e = fail(failure.ReasonGameOver)
+	assert.True(t, e.Penalty.Absorbed)
+	assert.Equal(t, 0, e.Penalty.LivesLeft)
+
+	e = fail(failure.ReasonTimeout)
+	assert.False(t, e.Penalty.Absorbed)
+	e = fail(failure.ReasonGameOver)
+	assert.False(t, e.Penalty.Absorbed)
+	assert.Equal(t, model.StatusWaiting, user.Status)
+	assert.Equal(t, 3, user.Retries)
+}
@@ -2,13 +2,18 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/labstack/echo/v4"
 	"github.com/kyiku/hackz-ptera-back/internal/calculus"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
-	"github.com/kyiku/hackz-ptera-back/internal/token"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 )
 
 // OTPHandler handles OTP-related requests.
@@ -16,6 +21,9 @@ type OTPHandler struct {
 	store         SessionStoreInterface
 	queue         QueueInterfaceForCaptcha
 	calcGenerator *calculus.Generator
+	machine       *stage.Machine
+	failures      *failure.FailureHandler
+	clock         clock.Clock
 }
 
 // NewOTPHandler creates a new OTPHandler.
@@ -23,19 +31,38 @@ func NewOTPHandler(store SessionStoreInterface, s3Client S3ClientInterface) *OTP
 	return &OTPHandler{
 		store:         store,
 		calcGenerator: calculus.NewGenerator(),
+		machine:       stage.NewMachine(),
+		failures:      failure.NewFailureHandler(nil),
+		clock:         clock.Real{},
 	}
 }
 
+// SetClock sets the clock task completions are timestamped with.
+func (h *OTPHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
 // SetQueue sets the waiting queue.
 func (h *OTPHandler) SetQueue(queue QueueInterfaceForCaptcha) {
 	h.queue = queue
 }
 
+// SetMachine sets the state machine that applies status changes.
+func (h *OTPHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+	h.failures.SetMachine(machine)
+}
+
+// SetFailureHandler sets the failure pipeline users who fail here go through.
+func (h *OTPHandler) SetFailureHandler(failures *failure.FailureHandler) {
+	h.failures = failures
+}
+
 // Send generates and returns a calculus problem.
 func (h *OTPHandler) Send(c echo.Context) error {
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
 		// CloudFrontのcustom_error_responseがHTMLを返すのを防ぐため、常に200を返す
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
@@ -44,17 +71,23 @@ func (h *OTPHandler) Send(c echo.Context) error {
 		})
 	}
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
+	err := h.store.Update(sessionID, func(user *model.User) error {
+		return h.send(c, user)
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "無効なセッション",
 			"code":    "INVALID_SESSION",
 		})
 	}
+	return err
+}
 
+// send generates a problem for the user. It runs with the user locked.
+func (h *OTPHandler) send(c echo.Context, user *model.User) error {
 	// Check user status
-	if user.Status != "registering" {
+	if !onDashboard(h.machine, user.Status) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "登録ステージではありません",
@@ -91,8 +124,8 @@ type OTPVerifyRequest struct {
 // Verify checks the OTP answer.
 func (h *OTPHandler) Verify(c echo.Context) error {
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "セッションが見つかりません",
@@ -100,45 +133,61 @@ func (h *OTPHandler) Verify(c echo.Context) error {
 		})
 	}
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
+	// Parse request (before locking the user, the body may be slow to arrive)
+	var req OTPVerifyRequest
+	if err := c.Bind(&req); err != nil {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
-			"message": "無効なセッション",
-			"code":    "INVALID_SESSION",
+			"message": "リクエストの解析に失敗しました",
+			"code":    "BAD_REQUEST",
 		})
 	}
 
-	// Parse request
-	var req OTPVerifyRequest
-	if err := c.Bind(&req); err != nil {
+	var resp map[string]interface{}
+	err := h.store.Update(sessionID, func(user *model.User) error {
+		resp = h.verify(user, req)
+		return nil
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
-			"message": "リクエストの解析に失敗しました",
-			"code":    "BAD_REQUEST",
+			"message": "無効なセッション",
+			"code":    "INVALID_SESSION",
 		})
 	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// verify checks the answer of the user and returns the response.
+// It runs with the user locked.
+func (h *OTPHandler) verify(user *model.User, req OTPVerifyRequest) map[string]interface{} {
 
 	// Parse answer as integer
 	answer, err := strconv.Atoi(req.Answer)
 	if err != nil {
-		return c.JSON(http.StatusOK, map[string]interface{}{
+		return map[string]interface{}{
 			"error":   true,
 			"message": "6桁の数字を入力してください",
 			"code":    "INVALID_ANSWER",
-		})
+		}
 	}
 
 	// Check answer
+	user.RecordTaskAttempt(model.TaskOTP)
 	if answer == user.OTPCode {
-		// Success - generate registration token
-		registerToken := token.GenerateRegisterToken(user)
+		// Success - complete the OTP task
+		// (the register token was issued when Dino was cleared)
+		completeTask(user, model.TaskOTP, h.clock.Now())
 
-		return c.JSON(http.StatusOK, map[string]interface{}{
+		return map[string]interface{}{
 			"error":          false,
 			"message":        "正解です！登録が完了しました",
-			"register_token": registerToken,
-		})
+			"register_token": user.RegisterToken,
+			"task_completed": model.TaskOTP,
+		}
 	}
 
 	// Failed attempt
@@ -146,7 +195,8 @@ func (h *OTPHandler) Verify(c echo.Context) error {
 
 	if exceeded {
 		// 3 failures - reset to waiting
-		return h.handleMaxAttempts(c, user)
+		e := h.failures.Fail(user, failure.ReasonOTPFailed, user.OTPAttempts)
+		return e.Response()
 	}
 
 	// Generate new problem for retry
@@ -155,41 +205,10 @@ func (h *OTPHandler) Verify(c echo.Context) error {
 
 	remaining := model.MaxOTPAttempts - user.OTPAttempts
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	return map[string]interface{}{
 		"error":              true,
 		"message":            "不正解です。もう一度試してください",
 		"attempts_remaining": remaining,
 		"new_problem_latex":  newProblem.ProblemLatex,
-	})
-}
-
-// handleMaxAttempts handles the case when max OTP attempts are exceeded.
-func (h *OTPHandler) handleMaxAttempts(c echo.Context, user *model.User) error {
-	// Send failure notification via WebSocket
-	if user.Conn != nil {
-		_ = user.Conn.WriteJSON(map[string]interface{}{
-			"type":           "failure",
-			"message":        "3回失敗しました。待機列の最後尾からやり直しです。",
-			"redirect_delay": float64(3),
-		})
 	}
-
-	// Reset user state
-	user.ResetToWaiting()
-
-	// Close WebSocket connection - user needs to reconnect fresh
-	// Don't add to queue here - the user will be added when they reconnect via WebSocket
-	if user.Conn != nil {
-		conn := user.Conn // Capture for goroutine
-		user.Conn = nil   // Clear the connection reference
-		go func() {
-			conn.Close()
-		}()
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"error":          true,
-		"message":        "3回失敗しました。待機列の最後尾からやり直しです。",
-		"redirect_delay": float64(3),
-	})
 }
@@ -6,6 +6,9 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/kyiku/hackz-ptera-back/internal/ai"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 )
 
 // BedrockClientInterface defines the interface for Bedrock operations.
@@ -17,6 +20,7 @@ type BedrockClientInterface interface {
 type PasswordHandler struct {
 	store         SessionStoreInterface
 	bedrockClient *ai.BedrockClient
+	machine       *stage.Machine
 }
 
 // NewPasswordHandler creates a new PasswordHandler.
@@ -25,9 +29,15 @@ func NewPasswordHandler(store SessionStoreInterface, bedrockClient BedrockClient
 	return &PasswordHandler{
 		store:         store,
 		bedrockClient: client,
+		machine:       stage.NewMachine(),
 	}
 }
 
+// SetMachine sets the state machine whose pipeline tells which gate a user is in.
+func (h *PasswordHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+}
+
 // EnableFallback enables or disables fallback mode.
 func (h *PasswordHandler) EnableFallback(enabled bool) {
 	h.bedrockClient.EnableFallback(enabled)
@@ -41,8 +51,8 @@ type PasswordAnalyzeRequest struct {
 // Analyze analyzes a password using AI.
 func (h *PasswordHandler) Analyze(c echo.Context) error {
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
 		// CloudFrontのcustom_error_responseがHTMLを返すのを防ぐため、常に200を返す
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
@@ -51,8 +61,13 @@ func (h *PasswordHandler) Analyze(c echo.Context) error {
 		})
 	}
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
+	// The user is not kept locked during the slow analysis below
+	var status string
+	err := h.store.View(sessionID, func(user *model.User) error {
+		status = user.Status
+		return nil
+	})
+	if err != nil {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "無効なセッション",
@@ -61,7 +76,7 @@ func (h *PasswordHandler) Analyze(c echo.Context) error {
 	}
 
 	// Check user status
-	if user.Status != "registering" {
+	if !onDashboard(h.machine, status) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "登録ステージではありません",
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/kyiku/hackz-ptera-back/internal/audit"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/penalty"
+	"github.com/labstack/echo/v4"
+)
+
+// PenaltyHandler lets admins change the penalty policy per stage and reason
+// while the server runs. Every change is written to the audit trail.
+type PenaltyHandler struct {
+	rules *penalty.Rules
+	audit *audit.Trail
+}
+
+// NewPenaltyHandler creates a new PenaltyHandler.
+func NewPenaltyHandler(rules *penalty.Rules, trail *audit.Trail) *PenaltyHandler {
+	return &PenaltyHandler{
+		rules: rules,
+		audit: trail,
+	}
+}
+
+// PenaltyRuleRequest is the request body for setting a penalty rule.
+// An empty stage or reason matches every stage or reason; an empty policy removes the rule.
+type PenaltyRuleRequest struct {
+	Stage  string `json:"stage"`
+	Reason string `json:"reason"`
+	Policy string `json:"policy"`
+}
+
+// State returns the penalty rules and the fallback policy.
+func (h *PenaltyHandler) State(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"error":    false,
+		"fallback": h.rules.String(),
+		"rules":    h.rules.List(),
+	})
+}
+
+// Set sets or removes the policy for failures of a stage with a reason.
+func (h *PenaltyHandler) Set(c echo.Context) error {
+	var req PenaltyRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "リクエストの解析に失敗しました",
+			"code":    "BAD_REQUEST",
+		})
+	}
+
+	detail := map[string]string{"stage": req.Stage, "reason": req.Reason}
+	if req.Policy == "" {
+		if !h.rules.Remove(req.Stage, req.Reason) {
+			return c.JSON(http.StatusOK, map[string]interface{}{
+				"error":   true,
+				"message": "ルールが見つかりません",
+				"code":    "RULE_NOT_FOUND",
+			})
+		}
+		h.record(c, "penalty.remove", detail)
+		return h.State(c)
+	}
+
+	policy, err := penalty.Parse(req.Policy)
+	if err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": err.Error(),
+			"code":    "INVALID_POLICY",
+		})
+	}
+	h.rules.Set(req.Stage, req.Reason, policy)
+	detail["policy"] = policy.String()
+	h.record(c, "penalty.set", detail)
+	return h.State(c)
+}
+
+// record writes an action to the audit trail under the authenticated admin's name.
+func (h *PenaltyHandler) record(c echo.Context, action string, detail map[string]string) {
+	if h.audit == nil {
+		return
+	}
+	actor, _ := c.Get(middleware.AdminActorKey).(string)
+	if actor == "" {
+		actor = "admin"
+	}
+	h.audit.Record(actor, action, "", detail)
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/kyiku/hackz-ptera-back/internal/audit"
+	"github.com/kyiku/hackz-ptera-back/internal/penalty"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPenaltyHandler_Set(t *testing.T) {
+	rules := penalty.NewRules(penalty.BackOfLine{})
+	trail := audit.NewTrail(10)
+	h := NewPenaltyHandler(rules, trail)
+
+	tests := []struct {
+		name      string
+		body      PenaltyRuleRequest
+		wantCode  string
+		wantRules int
+	}{
+		{
+			name:      "ルールを設定",
+			body:      PenaltyRuleRequest{Stage: "stage1_dino", Reason: "GAME_OVER", Policy: "lives:2>random"},
+			wantRules: 1,
+		},
+		{
+			name:      "理由を省略すると全ての理由に適用",
+			body:      PenaltyRuleRequest{Stage: "registering", Policy: "cooldown:30s"},
+			wantRules: 2,
+		},
+		{
+			name:     "不正なポリシー",
+			body:     PenaltyRuleRequest{Stage: "registering", Policy: "front"},
+			wantCode: "INVALID_POLICY",
+		},
+		{
+			name:      "空のポリシーでルールを削除",
+			body:      PenaltyRuleRequest{Stage: "registering"},
+			wantRules: 1,
+		},
+		{
+			name:     "存在しないルールは削除できない",
+			body:     PenaltyRuleRequest{Stage: "registering"},
+			wantCode: "RULE_NOT_FOUND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := testutil.NewTestContextWithJSON(http.MethodPost, "/api/admin/penalties", tt.body)
+			require.NoError(t, h.Set(tc.Context))
+
+			resp := tc.GetResponseBody()
+			if tt.wantCode != "" {
+				assert.Equal(t, true, resp["error"])
+				assert.Equal(t, tt.wantCode, resp["code"])
+				return
+			}
+			assert.Equal(t, false, resp["error"])
+			assert.Equal(t, "back", resp["fallback"])
+			assert.Len(t, resp["rules"], tt.wantRules)
+		})
+	}
+
+	// 変更はすぐに反映され、監査ログに残る
+	assert.Equal(t, penalty.Lives{Lives: 2, Then: penalty.Random{}}, rules.Policy("stage1_dino", "GAME_OVER"))
+	entries := trail.Entries()
+	require.Len(t, entries, 3)
+	assert.Equal(t, "penalty.set", entries[0].Action)
+	assert.Equal(t, "lives:2>random", entries[0].Detail["policy"])
+	assert.Equal(t, "penalty.remove", entries[2].Action)
+}
@@ -0,0 +1,81 @@
+// Package handler provides HTTP handlers for the API.
+package handler
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+)
+
+// QueueInterfaceForStatus defines the queue interface for QueueHandler.
+type QueueInterfaceForStatus interface {
+	GetPosition(userID string) (int, bool)
+	RealPosition(userID string) (int, bool)
+	Total() int
+}
+
+// QueueHandler handles waiting queue related requests.
+type QueueHandler struct {
+	store SessionStoreInterface
+	queue QueueInterfaceForStatus
+	eta   *queue.ETAEstimator
+}
+
+// NewQueueHandler creates a new QueueHandler.
+func NewQueueHandler(store SessionStoreInterface, q QueueInterfaceForStatus) *QueueHandler {
+	return &QueueHandler{
+		store: store,
+		queue: q,
+	}
+}
+
+// SetETAEstimator sets the wait time estimator.
+func (h *QueueHandler) SetETAEstimator(eta *queue.ETAEstimator) {
+	h.eta = eta
+}
+
+// Status returns the requesting user's place in line and estimated wait.
+func (h *QueueHandler) Status(c echo.Context) error {
+	// Get session
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "セッションが見つかりません",
+			"code":    "SESSION_NOT_FOUND",
+		})
+	}
+
+	var status string
+	err := h.store.View(sessionID, func(user *model.User) error {
+		status = user.Status
+		return nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "無効なセッション",
+			"code":    "INVALID_SESSION",
+		})
+	}
+
+	position, inQueue := h.queue.GetPosition(sessionID)
+	resp := map[string]interface{}{
+		"error":    false,
+		"status":   status,
+		"in_queue": inQueue,
+		"total":    h.queue.Total(),
+	}
+	if inQueue {
+		resp["position"] = position
+		// Phantoms ahead don't make the estimate any longer
+		if realPosition, ok := h.queue.RealPosition(sessionID); ok && h.eta != nil {
+			resp["eta"] = h.eta.Estimate(realPosition)
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueHandler_Status(t *testing.T) {
+	tests := []struct {
+		name         string
+		hasCookie    bool
+		inQueue      bool
+		phantoms     int
+		wantError    bool
+		wantCode     string
+		wantPosition float64
+		wantETA      float64
+	}{
+		{
+			name:         "正常系: 待機列の2番目",
+			hasCookie:    true,
+			inQueue:      true,
+			wantError:    false,
+			wantPosition: 2,
+			wantETA:      60,
+		},
+		{
+			name:         "正常系: ファントムの後ろでも待ち時間は実ユーザーで見積もる",
+			hasCookie:    true,
+			inQueue:      true,
+			phantoms:     3,
+			wantError:    false,
+			wantPosition: 5,
+			wantETA:      60,
+		},
+		{
+			name:      "正常系: 待機列にいない",
+			hasCookie: true,
+			inQueue:   false,
+			wantError: false,
+		},
+		{
+			name:      "異常系: セッションなし",
+			hasCookie: false,
+			wantError: true,
+			wantCode:  "SESSION_NOT_FOUND",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewSessionStore()
+			q := queue.NewWaitingQueue()
+			eta := queue.NewETAEstimator(1)
+			eta.Record(30 * time.Second)
+
+			q.Add("someone-else", testutil.NewMockWebSocketConn())
+			q.InjectPhantoms(tt.phantoms)
+			_, sessionID := store.Create()
+			if tt.inQueue {
+				q.Add(sessionID, testutil.NewMockWebSocketConn())
+			}
+
+			h := NewQueueHandler(store, q)
+			h.SetETAEstimator(eta)
+
+			tc := testutil.NewTestContext(http.MethodGet, "/api/queue/me", nil)
+			if tt.hasCookie {
+				tc.Request.AddCookie(&http.Cookie{Name: "session_id", Value: sessionID})
+			}
+
+			require.NoError(t, h.Status(tc.Context))
+
+			var resp map[string]interface{}
+			require.NoError(t, json.Unmarshal(tc.Recorder.Body.Bytes(), &resp))
+
+			assert.Equal(t, tt.wantError, resp["error"])
+			if tt.wantCode != "" {
+				assert.Equal(t, tt.wantCode, resp["code"])
+				return
+			}
+
+			assert.Equal(t, tt.inQueue, resp["in_queue"])
+			if tt.inQueue {
+				assert.Equal(t, tt.wantPosition, resp["position"])
+				etaResp, ok := resp["eta"].(map[string]interface{})
+				require.True(t, ok)
+				assert.Equal(t, tt.wantETA, etaResp["seconds"])
+			} else {
+				assert.NotContains(t, resp, "position")
+			}
+		})
+	}
+}
@@ -2,30 +2,59 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
 
-	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+	"github.com/kyiku/hackz-ptera-back/internal/token"
+	"github.com/labstack/echo/v4"
 )
 
 // RegisterHandler handles registration requests.
 type RegisterHandler struct {
-	store SessionStoreInterface
-	queue QueueInterfaceForCaptcha
+	store    SessionStoreInterface
+	queue    QueueInterfaceForCaptcha
+	machine  *stage.Machine
+	failures *failure.FailureHandler
+	clock    clock.Clock
 }
 
 // NewRegisterHandler creates a new RegisterHandler.
 func NewRegisterHandler(store SessionStoreInterface) *RegisterHandler {
 	return &RegisterHandler{
-		store: store,
+		store:    store,
+		machine:  stage.NewMachine(),
+		failures: failure.NewFailureHandler(nil),
+		clock:    clock.Real{},
 	}
 }
 
+// SetClock sets the clock register tokens are checked against.
+func (h *RegisterHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
 // SetQueue sets the waiting queue.
 func (h *RegisterHandler) SetQueue(queue QueueInterfaceForCaptcha) {
 	h.queue = queue
 }
 
+// SetMachine sets the state machine that applies status changes.
+func (h *RegisterHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+	h.failures.SetMachine(machine)
+}
+
+// SetFailureHandler sets the failure pipeline users who fail here go through.
+func (h *RegisterHandler) SetFailureHandler(failures *failure.FailureHandler) {
+	h.failures = failures
+}
+
 // RegisterRequest represents the registration request.
 type RegisterRequest struct {
 	Username string `json:"username"`
@@ -35,11 +64,12 @@ type RegisterRequest struct {
 }
 
 // Submit handles the registration form submission.
-// This is the "evil" handler - it ALWAYS fails with a server error.
+// This is the "evil" handler - a submission with a valid token and every
+// dashboard task completed ALWAYS fails with a server error.
 func (h *RegisterHandler) Submit(c echo.Context) error {
 	// Get session
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := middleware.SessionID(c)
+	if !ok {
 		// CloudFrontのcustom_error_responseがHTMLを返すのを防ぐため、常に200を返す
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
@@ -48,67 +78,77 @@ func (h *RegisterHandler) Submit(c echo.Context) error {
 		})
 	}
 
-	user, ok := h.store.Get(cookie.Value)
-	if !ok {
+	// Parse request (before locking the user, the body may be slow to arrive)
+	var req RegisterRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"error":   true,
+			"message": "リクエストの解析に失敗しました",
+			"code":    "BAD_REQUEST",
+		})
+	}
+
+	var resp map[string]interface{}
+	err := h.store.Update(sessionID, func(user *model.User) error {
+		resp = h.submit(user, sessionID, req)
+		return nil
+	})
+	if errors.Is(err, session.ErrSessionNotFound) {
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"error":   true,
 			"message": "無効なセッション",
 			"code":    "INVALID_SESSION",
 		})
 	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, resp)
+}
 
+// submit handles the form of the user and returns the response.
+// It runs with the user locked.
+func (h *RegisterHandler) submit(user *model.User, sessionID string, req RegisterRequest) map[string]interface{} {
 	// Check user status
-	if user.Status != "registering" {
-		return c.JSON(http.StatusOK, map[string]interface{}{
+	if !onDashboard(h.machine, user.Status) {
+		return map[string]interface{}{
 			"error":   true,
 			"message": "登録ステージではありません",
 			"code":    "WRONG_STAGE",
-		})
+		}
 	}
 
-	// Parse request
-	var req RegisterRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusOK, map[string]interface{}{
+	// Validate the register token against the session
+	if valid, code := token.ValidateRegisterToken(user, sessionID, req.Token, h.clock.Now()); !valid {
+		message := "登録トークンが無効です"
+		if code == "TOKEN_EXPIRED" {
+			message = "登録トークンの有効期限が切れました"
+		}
+		return map[string]interface{}{
 			"error":   true,
-			"message": "リクエストの解析に失敗しました",
-			"code":    "BAD_REQUEST",
-		})
+			"message": message,
+			"code":    code,
+		}
+	}
+
+	// Every dashboard task must be completed before registering
+	if missing := user.MissingTasks(); len(missing) > 0 {
+		return map[string]interface{}{
+			"error":         true,
+			"message":       "未完了のタスクがあります",
+			"code":          "TASKS_INCOMPLETE",
+			"missing_tasks": missing,
+		}
 	}
 
 	// EVIL: Always fail with server error
 	// This is the joke - the registration never succeeds
-	return h.handleFakeServerError(c, user)
+	e := h.failures.Fail(user, failure.ReasonServerError, 0)
+	return e.Response()
 }
 
-// handleFakeServerError simulates a server error and resets the user.
-func (h *RegisterHandler) handleFakeServerError(c echo.Context, user *model.User) error {
-	// Send failure notification via WebSocket
-	if user.Conn != nil {
-		_ = user.Conn.WriteJSON(map[string]interface{}{
-			"type":           "failure",
-			"message":        "サーバーエラーが発生しました。待機列の最後尾からやり直しです。",
-			"redirect_delay": float64(3),
-		})
-	}
-
-	// Reset user state
-	user.ResetToWaiting()
-
-	// Close WebSocket connection - user needs to reconnect fresh
-	// Don't add to queue here - the user will be added when they reconnect via WebSocket
-	if user.Conn != nil {
-		conn := user.Conn // Capture for goroutine
-		user.Conn = nil   // Clear the connection reference
-		go func() {
-			conn.Close()
-		}()
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"error":          true,
-		"message":        "サーバーエラーが発生しました。待機列の最後尾からやり直しです。",
-		"code":           "SERVER_ERROR",
-		"redirect_delay": float64(3),
-	})
+// onDashboard reports whether a user with the given status is on the registration dashboard.
+func onDashboard(machine *stage.Machine, status string) bool {
+	gate, ok := machine.Pipeline().Gate(status)
+	return ok && gate.Kind == stage.KindRegister
 }
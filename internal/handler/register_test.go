@@ -15,6 +15,13 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// completeAllTasks marks every dashboard task of the user as completed.
+func completeAllTasks(u *model.User) {
+	for _, task := range model.RegisterTasks {
+		u.CompleteTask(task, time.Now())
+	}
+}
+
 func TestRegisterHandler_Submit(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -32,6 +39,7 @@ func TestRegisterHandler_Submit(t *testing.T) {
 				u.Status = "registering"
 				u.RegisterToken = "valid-token"
 				u.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+				completeAllTasks(u)
 			},
 			requestBody: `{
 				"username": "testuser",
@@ -140,6 +148,7 @@ func TestRegisterHandler_AlwaysFails(t *testing.T) {
 	user.Status = "registering"
 	user.RegisterToken = "valid-token"
 	user.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+	completeAllTasks(user)
 	user.Conn = mockConn
 
 	h := NewRegisterHandler(store)
@@ -154,6 +163,7 @@ func TestRegisterHandler_AlwaysFails(t *testing.T) {
 			user.Status = "registering"
 			user.RegisterToken = "valid-token"
 			user.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+			completeAllTasks(user)
 			user.Conn = mockConn
 		}
 
@@ -190,6 +200,7 @@ func TestRegisterHandler_QueueReset(t *testing.T) {
 	user.Status = "registering"
 	user.RegisterToken = "valid-token"
 	user.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+	completeAllTasks(user)
 	user.Conn = mockConn
 
 	h := NewRegisterHandler(store)
@@ -226,6 +237,7 @@ func TestRegisterHandler_WebSocketFailureMessage(t *testing.T) {
 	user.Status = "registering"
 	user.RegisterToken = "valid-token"
 	user.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+	completeAllTasks(user)
 	user.Conn = mockConn
 
 	h := NewRegisterHandler(store)
@@ -250,3 +262,84 @@ func TestRegisterHandler_WebSocketFailureMessage(t *testing.T) {
 	assert.Equal(t, "failure", msg["type"])
 	assert.Equal(t, float64(3), msg["redirectDelay"])
 }
+
+func TestRegisterHandler_Submit_Checks(t *testing.T) {
+	tests := []struct {
+		name        string
+		setupUser   func(*model.User)
+		token       string
+		wantCode    string
+		wantMissing []interface{}
+		wantReset   bool
+	}{
+		{
+			name: "異常系: トークン不一致",
+			setupUser: func(u *model.User) {
+				u.RegisterToken = "valid-token"
+				u.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+				completeAllTasks(u)
+			},
+			token:    "forged-token",
+			wantCode: "INVALID_TOKEN",
+		},
+		{
+			name: "異常系: トークン期限切れ",
+			setupUser: func(u *model.User) {
+				u.RegisterToken = "valid-token"
+				u.RegisterTokenExp = time.Now().Add(-time.Second)
+				completeAllTasks(u)
+			},
+			token:    "valid-token",
+			wantCode: "TOKEN_EXPIRED",
+		},
+		{
+			name: "異常系: 未完了のタスク",
+			setupUser: func(u *model.User) {
+				u.RegisterToken = "valid-token"
+				u.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+				for _, task := range model.RegisterTasks {
+					if task != model.TaskTerms && task != model.TaskOTP {
+						u.CompleteTask(task, time.Now())
+					}
+				}
+			},
+			token:       "valid-token",
+			wantCode:    "TASKS_INCOMPLETE",
+			wantMissing: []interface{}{model.TaskTerms, model.TaskOTP},
+		},
+		{
+			name: "鬼畜仕様: 全て正しくてもサーバーエラー",
+			setupUser: func(u *model.User) {
+				u.RegisterToken = "valid-token"
+				u.RegisterTokenExp = time.Now().Add(10 * time.Minute)
+				completeAllTasks(u)
+			},
+			token:     "valid-token",
+			wantCode:  "SERVER_ERROR",
+			wantReset: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewSessionStore()
+			user, sessionID := store.Create()
+			user.Status = model.StatusRegistering
+			tt.setupUser(user)
+
+			h := NewRegisterHandler(store)
+			resp := postJSON(t, h.Submit, sessionID, `{"username": "ptera", "token": "`+tt.token+`"}`)
+
+			assert.Equal(t, true, resp["error"])
+			assert.Equal(t, tt.wantCode, resp["code"])
+			if tt.wantMissing != nil {
+				assert.Equal(t, tt.wantMissing, resp["missing_tasks"])
+			}
+			if tt.wantReset {
+				assert.Equal(t, model.StatusWaiting, user.Status)
+			} else {
+				assert.Equal(t, model.StatusRegistering, user.Status)
+			}
+		})
+	}
+}
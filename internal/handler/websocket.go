@@ -5,14 +5,16 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
-	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
 	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
 	ws "github.com/kyiku/hackz-ptera-back/internal/websocket"
+	"github.com/labstack/echo/v4"
 )
 
 // upgrader is the WebSocket upgrader with default settings.
@@ -26,28 +28,43 @@ var upgrader = websocket.Upgrader{
 }
 
 // WebSocketConn wraps gorilla/websocket.Conn to implement model.WebSocketConn.
+// Writes go through a per-connection send queue so callers never block on slow clients.
 type WebSocketConn struct {
-	conn *websocket.Conn
-	mu   sync.Mutex
+	conn   *websocket.Conn
+	sender *ws.Sender
+}
+
+// newWebSocketConn wraps a connection and starts its writer goroutine.
+func newWebSocketConn(conn *websocket.Conn) *WebSocketConn {
+	return &WebSocketConn{
+		conn:   conn,
+		sender: ws.NewSender(conn),
+	}
 }
 
-// WriteMessage sends a message with the given type.
+// WriteMessage queues a message with the given type.
 func (c *WebSocketConn) WriteMessage(messageType int, data []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.conn.WriteMessage(messageType, data)
+	return c.sender.WriteMessage(messageType, data)
 }
 
-// WriteJSON sends a JSON message.
+// WriteJSON queues a JSON message.
 func (c *WebSocketConn) WriteJSON(v interface{}) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	return c.conn.WriteJSON(v)
+	return c.sender.WriteJSON(v)
 }
 
-// Close closes the WebSocket connection.
+// Close closes the WebSocket connection once queued messages are written.
 func (c *WebSocketConn) Close() error {
-	return c.conn.Close()
+	return c.sender.Close()
+}
+
+// CloseWithReason sends a close frame with the given code and reason, then closes the connection.
+// Messages queued before it, such as the reason for a refusal, are written first.
+func (c *WebSocketConn) CloseWithReason(code int, reason string) error {
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := c.sender.WriteMessage(websocket.CloseMessage, msg); err != nil {
+		_ = c.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	}
+	return c.sender.Close()
 }
 
 // ReadMessage reads a message from the connection.
@@ -55,41 +72,105 @@ func (c *WebSocketConn) ReadMessage() (messageType int, p []byte, err error) {
 	return c.conn.ReadMessage()
 }
 
-// SessionStoreForWS defines the session store interface for WebSocket handler.
-type SessionStoreForWS interface {
-	Create() (*model.User, string)
-	Get(sessionID string) (*model.User, bool)
-}
-
 // WebSocketHandler handles WebSocket connections.
 type WebSocketHandler struct {
-	store SessionStoreForWS
-	queue *queue.WaitingQueue
+	store   SessionStoreInterface
+	queue   *queue.WaitingQueue
+	slots   StageSlotsInterface
+	conns   *ws.Registry
+	cookies *session.CookieSigner // nil leaves session cookies unsigned
+	name    string                // queue name sessions are bound to, empty for a single-queue server
+	machine *stage.Machine
+	clock   clock.Clock
 }
 
 // NewWebSocketHandler creates a new WebSocketHandler.
-func NewWebSocketHandler(store SessionStoreForWS, q *queue.WaitingQueue) *WebSocketHandler {
+// A session keeps one live socket; by default a new connection takes over the old one.
+func NewWebSocketHandler(store SessionStoreInterface, q *queue.WaitingQueue) *WebSocketHandler {
 	return &WebSocketHandler{
-		store: store,
-		queue: q,
+		store:   store,
+		queue:   q,
+		conns:   ws.NewRegistry(ws.PolicyTakeover),
+		machine: stage.NewMachine(),
+		clock:   clock.Real{},
 	}
 }
 
+// SetQueueName binds sessions connecting through this handler to the named queue.
+// A session bound to another queue is refused with ws.CloseWrongQueue.
+// (A session serving a penalty cooldown is refused with ws.CloseCooldown.)
+func (h *WebSocketHandler) SetQueueName(name string) {
+	h.name = name
+}
+
+// SetRegistry sets the registry of live connections per session.
+func (h *WebSocketHandler) SetRegistry(conns *ws.Registry) {
+	h.conns = conns
+}
+
+// SetCookieSigner sets the signer for session cookies.
+// When set, new cookies are signed and cookies with a bad signature are
+// treated as absent, so the client gets a fresh session.
+func (h *WebSocketHandler) SetCookieSigner(signer *session.CookieSigner) {
+	h.cookies = signer
+}
+
+// SetMachine sets the state machine that applies status changes.
+func (h *WebSocketHandler) SetMachine(machine *stage.Machine) {
+	h.machine = machine
+}
+
+// SetClock sets the clock rejoin cooldowns are checked against.
+func (h *WebSocketHandler) SetClock(c clock.Clock) {
+	h.clock = c
+}
+
+// SetSlots sets the stage slot manager.
+// When set, a user is only promoted if a slot can be taken for them.
+func (h *WebSocketHandler) SetSlots(slots StageSlotsInterface) {
+	h.slots = slots
+}
+
 // ValidateSession validates the session for WebSocket connection.
 func (h *WebSocketHandler) ValidateSession(c echo.Context) error {
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
+	sessionID, ok := h.sessionID(c)
+	if !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "no session cookie")
 	}
 
-	_, ok := h.store.Get(cookie.Value)
-	if !ok {
+	if _, ok := h.store.Get(sessionID); !ok {
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid session")
 	}
 
 	return nil
 }
 
+// sessionID returns the session ID carried by the request's cookie.
+// Returns false if there is no cookie or its signature does not verify.
+func (h *WebSocketHandler) sessionID(c echo.Context) (string, bool) {
+	cookie, err := c.Cookie(session.CookieName)
+	if err != nil || cookie == nil || cookie.Value == "" {
+		return "", false
+	}
+	if h.cookies == nil {
+		return cookie.Value, true
+	}
+	sessionID, err := h.cookies.Verify(cookie.Value)
+	if err != nil {
+		log.Printf("[WebSocket] Rejected session cookie: %v", err)
+		return "", false
+	}
+	return sessionID, true
+}
+
+// cookieValue returns the cookie value for a session ID, signed if a signer is set.
+func (h *WebSocketHandler) cookieValue(sessionID string) string {
+	if h.cookies == nil {
+		return sessionID
+	}
+	return h.cookies.Sign(sessionID)
+}
+
 // Connect handles the WebSocket upgrade and connection.
 func (h *WebSocketHandler) Connect(c echo.Context) error {
 	// Get or create session
@@ -107,36 +188,21 @@ func (h *WebSocketHandler) Connect(c echo.Context) error {
 	// Prepare response headers for WebSocket upgrade
 	responseHeaders := http.Header{}
 
-	cookie, err := c.Cookie("session_id")
-	if err != nil || cookie == nil {
-		// Create new session
+	// Reuse the session named by a valid cookie, otherwise create a new one
+	if id, ok := h.sessionID(c); ok {
+		user, _ = h.store.Get(id)
+	}
+	if user == nil {
 		user, sessionID = h.store.Create()
 		sessionCookie := &http.Cookie{
-			Name:     "session_id",
-			Value:    sessionID,
+			Name:     session.CookieName,
+			Value:    h.cookieValue(sessionID),
 			Path:     "/",
 			HttpOnly: true,
 			Secure:   cookieSecure,
 			SameSite: cookieSameSite,
 		}
 		responseHeaders.Add("Set-Cookie", sessionCookie.String())
-	} else {
-		// Get existing session
-		var ok bool
-		user, ok = h.store.Get(cookie.Value)
-		if !ok {
-			// Session not found, create new one
-			user, sessionID = h.store.Create()
-			sessionCookie := &http.Cookie{
-				Name:     "session_id",
-				Value:    sessionID,
-				Path:     "/",
-				HttpOnly: true,
-				Secure:   cookieSecure,
-				SameSite: cookieSameSite,
-			}
-			responseHeaders.Add("Set-Cookie", sessionCookie.String())
-		}
 	}
 
 	// Upgrade to WebSocket with response headers
@@ -147,11 +213,68 @@ func (h *WebSocketHandler) Connect(c echo.Context) error {
 	}
 
 	// Wrap the connection
-	conn := &WebSocketConn{conn: wsConn}
-	user.Conn = conn
+	conn := newWebSocketConn(wsConn)
+
+	// A session stays in the queue it first joined
+	if h.name != "" {
+		var bound string
+		_ = h.store.Update(user.SessionID, func(user *model.User) error {
+			if user.Queue == "" {
+				user.Queue = h.name
+			}
+			bound = user.Queue
+			return nil
+		})
+		if bound != h.name {
+			log.Printf("User %s rejected: bound to queue %q, not %q", user.ID, bound, h.name)
+			_ = conn.CloseWithReason(ws.CloseWrongQueue, "session bound to another queue")
+			return nil
+		}
+	}
+
+	// A failure penalty may keep the user out of the queue for a while
+	var rejoinAt time.Time
+	_ = h.store.View(user.SessionID, func(user *model.User) error {
+		rejoinAt = user.RejoinAt
+		return nil
+	})
+	if remaining := rejoinAt.Sub(h.clock.Now()); remaining > 0 {
+		log.Printf("User %s rejected: rejoin cooldown for %s", user.ID, remaining.Round(time.Second))
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":          "cooldown",
+			"message":       "ペナルティ中です。しばらくしてから再接続してください。",
+			"remaining_sec": int(remaining.Round(time.Second) / time.Second),
+			"rejoin_at":     rejoinAt,
+		})
+		_ = conn.CloseWithReason(ws.CloseCooldown, "rejoin cooldown")
+		return nil
+	}
+
+	// Only one live socket per session (second tab or early reconnect)
+	if _, err := h.conns.Register(user.SessionID, conn); err != nil {
+		log.Printf("User %s rejected: session already connected", user.ID)
+		_ = conn.CloseWithReason(ws.CloseSessionInUse, "session already connected")
+		return nil
+	}
+	var rejoinPosition int
+	_ = h.store.Update(user.SessionID, func(user *model.User) error {
+		user.Conn = conn
+		rejoinPosition = user.RejoinPosition
+		user.RejoinAt = time.Time{}
+		user.RejoinPosition = 0
+		return nil
+	})
+	h.store.Touch(user.SessionID)
 
 	// Add user to queue (use SessionID to link back to session store)
-	h.queue.Add(user.SessionID, conn)
+	// Users restored from a snapshot or reconnecting keep their place
+	if !h.queue.Reclaim(user.SessionID, conn) && !h.queue.Rebind(user.SessionID, conn) {
+		h.queue.Add(user.SessionID, conn)
+		// Rejoining after a cooldown, at the place the penalty gave them
+		if rejoinPosition > 0 {
+			h.queue.MoveTo(user.SessionID, rejoinPosition)
+		}
+	}
 	log.Printf("User %s connected, queue position: %d", user.ID, h.queue.Len())
 
 	// Broadcast positions to all users
@@ -178,13 +301,25 @@ func (h *WebSocketHandler) handleMessages(user *model.User, conn *WebSocketConn)
 	_ = conn.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	conn.conn.SetPongHandler(func(string) error {
 		_ = conn.conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		h.store.Touch(user.SessionID)
 		return nil
 	})
 
 	defer func() {
-		// Clean up on disconnect (use SessionID to match queue key)
-		h.queue.Remove(user.SessionID)
+		// A connection that was taken over leaves the session to its successor
+		if !h.conns.Unregister(user.SessionID, conn) {
+			conn.Close()
+			log.Printf("User %s: replaced connection closed", user.ID)
+			return
+		}
+
+		// Clean up on disconnect (use SessionID to match queue key).
+		// A place held for the user's return, e.g. after a failure, is kept
+		h.queue.Leave(user.SessionID)
 		h.queue.BroadcastPositions()
+		if h.slots != nil {
+			h.slots.Release(user.SessionID)
+		}
 		conn.Close()
 		log.Printf("User %s disconnected", user.ID)
 	}()
@@ -198,6 +333,9 @@ func (h *WebSocketHandler) handleMessages(user *model.User, conn *WebSocketConn)
 			break
 		}
 
+		// Any message counts as activity for the idle timeout
+		h.store.Touch(user.SessionID)
+
 		// Handle ping messages
 		if pingHandler.Handle(message) {
 			// Reset read deadline on ping
@@ -213,26 +351,45 @@ func (h *WebSocketHandler) handleMessages(user *model.User, conn *WebSocketConn)
 // PromoteFirstUser promotes the first user in the queue to the next stage.
 // This is called when the queue wait time is complete.
 func (h *WebSocketHandler) PromoteFirstUser() *model.User {
-	queueUser := h.queue.PopFront()
-	if queueUser == nil {
-		return nil
+	var queueUser *queue.QueueUser
+	acquired := false
+	if h.slots != nil {
+		// Take a slot for the head user before removing them from the queue
+		queueUser = h.queue.Peek()
+		if queueUser == nil {
+			return nil
+		}
+		var held bool
+		if held, acquired = h.slots.Acquire(queueUser.ID); !held {
+			return nil
+		}
+		h.queue.Remove(queueUser.ID)
+	} else {
+		queueUser = h.queue.PopFront()
+		if queueUser == nil {
+			return nil
+		}
 	}
 
 	// Get the user from session store and update their status
+	// (the machine notifies the user with a stage_change message)
 	// queueUser.ID is actually the sessionID
-	user, ok := h.store.Get(queueUser.ID)
-	if ok {
-		user.Status = model.StatusStage1Dino
+	var user *model.User
+	var status string
+	err := h.store.Update(queueUser.ID, func(u *model.User) error {
+		status = u.Status
+		if err := h.machine.Fire(u, stage.EventPromote); err != nil {
+			return err
+		}
+		user = u
+		return nil
+	})
+	if err == nil {
 		log.Printf("User %s promoted to stage1_dino", user.ID)
-	}
-
-	// Notify user via WebSocket
-	if queueUser.Conn != nil {
-		_ = queueUser.Conn.WriteJSON(map[string]interface{}{
-			"type":    "stage_change",
-			"status":  model.StatusStage1Dino,
-			"message": "あなたの番です！Dino Runを開始してください",
-		})
+	} else if acquired && status != h.machine.Pipeline().First().Status {
+		// Only give back a slot taken above; a user already in the
+		// first gate (e.g. promoted by a concurrent start) keeps theirs
+		h.slots.Release(queueUser.ID)
 	}
 
 	// Broadcast updated positions to remaining users
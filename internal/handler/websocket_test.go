@@ -2,12 +2,20 @@ package handler
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
 	"github.com/kyiku/hackz-ptera-back/internal/queue"
 	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/slot"
 	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	ws "github.com/kyiku/hackz-ptera-back/internal/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -160,3 +168,254 @@ func TestWebSocketHandler_Disconnect(t *testing.T) {
 
 	assert.Equal(t, 0, q.Len())
 }
+
+func TestWebSocketHandler_PromoteFirstUser_Slots(t *testing.T) {
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+	slots := slot.NewManager(1)
+
+	user1, sessionID1 := store.Create()
+	user2, sessionID2 := store.Create()
+	mockConn1 := testutil.NewMockWebSocketConn()
+	user1.Conn = mockConn1
+	q.Add(sessionID1, mockConn1)
+	q.Add(sessionID2, testutil.NewMockWebSocketConn())
+
+	h := NewWebSocketHandler(store, q)
+	h.SetSlots(slots)
+
+	// 先頭ユーザーが枠を取得して昇格
+	promoted := h.PromoteFirstUser()
+	require.NotNil(t, promoted)
+	assert.Equal(t, user1.ID, promoted.ID)
+	assert.Equal(t, model.StatusStage1Dino, user1.Status)
+	assert.True(t, slots.Holds(sessionID1))
+
+	msg := mockConn1.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, "stage_change", msg["type"])
+
+	// 枠が埋まっている間は次のユーザーは昇格しない
+	assert.Nil(t, h.PromoteFirstUser())
+	assert.Equal(t, model.StatusWaiting, user2.Status)
+	assert.Equal(t, 1, q.Len())
+
+	// 枠が空けば次のユーザーが昇格
+	slots.Release(sessionID1)
+	promoted = h.PromoteFirstUser()
+	require.NotNil(t, promoted)
+	assert.Equal(t, user2.ID, promoted.ID)
+}
+
+func TestWebSocketHandler_PromoteFirstUser_KeepsHeldSlot(t *testing.T) {
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+	slots := slot.NewManager(1)
+
+	// 並行した開始リクエストで既に昇格し、枠を持っているユーザー
+	user, sessionID := store.Create()
+	user.Status = model.StatusStage1Dino
+	q.Add(sessionID, testutil.NewMockWebSocketConn())
+	held, _ := slots.Acquire(sessionID)
+	require.True(t, held)
+
+	h := NewWebSocketHandler(store, q)
+	h.SetSlots(slots)
+
+	// 昇格に失敗しても、この呼び出しで取った枠ではないので解放しない
+	assert.Nil(t, h.PromoteFirstUser())
+	assert.True(t, slots.Holds(sessionID))
+	assert.Equal(t, model.StatusStage1Dino, user.Status)
+}
+
+// dialSession opens a WebSocket to the test server, reusing the session cookie if given.
+func dialSession(t *testing.T, server *httptest.Server, cookie string) (*websocket.Conn, string) {
+	t.Helper()
+
+	header := http.Header{}
+	if cookie != "" {
+		header.Set("Cookie", "session_id="+cookie)
+	}
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	conn, resp, err := websocket.DefaultDialer.Dial(url, header)
+	require.NoError(t, err)
+
+	for _, c := range resp.Cookies() {
+		if c.Name == "session_id" {
+			cookie = c.Value
+		}
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn, cookie
+}
+
+// readCloseCode reads until the server closes the connection and returns the close code.
+func readCloseCode(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			require.ErrorAs(t, err, &closeErr)
+			return closeErr.Code
+		}
+	}
+}
+
+func TestWebSocketHandler_OneConnectionPerSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     ws.Policy
+		closedConn int // 0 = 古い接続, 1 = 新しい接続
+		wantCode   int
+	}{
+		{
+			name:       "正常系: 新しい接続が引き継ぐ",
+			policy:     ws.PolicyTakeover,
+			closedConn: 0,
+			wantCode:   ws.CloseSessionTakenOver,
+		},
+		{
+			name:       "正常系: 新しい接続を拒否",
+			policy:     ws.PolicyReject,
+			closedConn: 1,
+			wantCode:   ws.CloseSessionInUse,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := session.NewSessionStore()
+			q := queue.NewWaitingQueue()
+			q.SetBroadcastInterval(0)
+			h := NewWebSocketHandler(store, q)
+			h.SetRegistry(ws.NewRegistry(tt.policy))
+
+			e := echo.New()
+			e.GET("/ws", h.Connect)
+			server := httptest.NewServer(e)
+			defer server.Close()
+
+			first, cookie := dialSession(t, server, "")
+			require.NotEmpty(t, cookie)
+			second, _ := dialSession(t, server, cookie)
+
+			conns := []*websocket.Conn{first, second}
+			assert.Equal(t, tt.wantCode, readCloseCode(t, conns[tt.closedConn]))
+
+			// 同じセッションが二重に並ぶことはなく、切断処理でも列から消えない
+			time.Sleep(50 * time.Millisecond)
+			assert.Equal(t, 1, q.Len())
+			_, found := q.GetPosition(cookie)
+			assert.True(t, found)
+		})
+	}
+}
+
+func TestWebSocketHandler_QueueBinding(t *testing.T) {
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+	h := NewWebSocketHandler(store, q)
+	h.SetQueueName("public")
+
+	e := echo.New()
+	e.GET("/ws", h.Connect)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	// 新しいセッションは接続した待機列に紐づく
+	_, cookie := dialSession(t, server, "")
+	user, ok := store.Get(cookie)
+	require.True(t, ok)
+	assert.Equal(t, "public", user.Queue)
+
+	// 別の待機列に紐づいたセッションは拒否
+	other, otherID := store.Create()
+	other.Queue = "staging"
+	conn, _ := dialSession(t, server, otherID)
+	assert.Equal(t, ws.CloseWrongQueue, readCloseCode(t, conn))
+
+	_, found := q.GetPosition(otherID)
+	assert.False(t, found)
+}
+
+func TestWebSocketHandler_RejoinCooldown(t *testing.T) {
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+	q.Add("a", nil)
+	q.Add("b", nil)
+	q.Add("c", nil)
+	clk := clock.NewFake(time.Now())
+	h := NewWebSocketHandler(store, q)
+	h.SetClock(clk)
+
+	e := echo.New()
+	e.GET("/ws", h.Connect)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	// クールダウン中は拒否され、待機列に入らない
+	user, sessionID := store.Create()
+	user.RejoinAt = clk.Now().Add(time.Minute)
+	user.RejoinPosition = 2
+	conn, _ := dialSession(t, server, sessionID)
+	var msg map[string]interface{}
+	require.NoError(t, conn.ReadJSON(&msg))
+	assert.Equal(t, "cooldown", msg["type"])
+	assert.Equal(t, float64(60), msg["remaining_sec"])
+	assert.Equal(t, ws.CloseCooldown, readCloseCode(t, conn))
+	_, found := q.GetPosition(sessionID)
+	assert.False(t, found)
+
+	// クールダウンが明けるとペナルティで決まった位置に並ぶ
+	clk.Advance(time.Minute)
+	dialSession(t, server, sessionID)
+	err := testutil.WaitFor(time.Second, 10*time.Millisecond, func() bool {
+		pos, ok := q.GetPosition(sessionID)
+		return ok && pos == 2
+	})
+	require.NoError(t, err)
+	require.NoError(t, store.View(sessionID, func(u *model.User) error {
+		assert.Zero(t, u.RejoinPosition)
+		assert.True(t, u.RejoinAt.IsZero())
+		return nil
+	}))
+}
+
+func TestWebSocketHandler_SignedCookies(t *testing.T) {
+	signer, err := session.NewCookieSigner([]byte("test-key-0123456789"))
+	require.NoError(t, err)
+
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+	h := NewWebSocketHandler(store, q)
+	h.SetCookieSigner(signer)
+
+	e := echo.New()
+	e.GET("/ws", h.Connect)
+	server := httptest.NewServer(e)
+	defer server.Close()
+
+	// 発行されるCookieは署名済み
+	_, cookie := dialSession(t, server, "")
+	sessionID, err := signer.Verify(cookie)
+	require.NoError(t, err)
+	_, ok := store.Get(sessionID)
+	assert.True(t, ok)
+
+	// 署名済みCookieで再接続すると同じセッション
+	_, again := dialSession(t, server, cookie)
+	assert.Equal(t, cookie, again)
+	assert.Equal(t, 1, store.Count())
+
+	// 生のセッションIDや短い値は新しいセッションになる
+	for _, forged := range []string{sessionID, "x"} {
+		_, fresh := dialSession(t, server, forged)
+		require.NotEqual(t, forged, fresh)
+		freshID, err := signer.Verify(fresh)
+		require.NoError(t, err)
+		assert.NotEqual(t, sessionID, freshID)
+	}
+	assert.Equal(t, 3, store.Count())
+}
@@ -0,0 +1,313 @@
+// Package kvfile provides a small embedded key-value store backed by a single
+// append-only file.
+//
+// Every Put and Delete appends a checksummed record to the file and the full
+// key space is kept in memory, so reads never touch the disk. On Open the log
+// is replayed; a torn record at the end (a crash mid-write) is truncated away.
+// Compact rewrites the file with only the live keys.
+package kvfile
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+)
+
+// Record operations.
+const (
+	opPut    byte = 1
+	opDelete byte = 2
+)
+
+// headerSize is the size of a record header: payload length and CRC32.
+const headerSize = 8
+
+// maxRecordSize bounds a single record so a corrupt length can't exhaust memory.
+const maxRecordSize = 16 << 20
+
+// compactMinGarbage is the number of stale records before automatic compaction is considered.
+const compactMinGarbage = 1024
+
+// ErrClosed is returned when using a closed DB.
+var ErrClosed = errors.New("kvfile: closed")
+
+// DB is an open key-value file. It is safe for concurrent use.
+type DB struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	data    map[string][]byte
+	garbage int // records in the file that no longer hold a live value
+	closed  bool
+}
+
+// Open opens or creates the key-value file at path.
+func Open(path string) (*DB, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("kvfile: open: %w", err)
+	}
+
+	db := &DB{
+		path: path,
+		file: file,
+		data: make(map[string][]byte),
+	}
+	if err := db.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Get returns a copy of the value stored under key.
+func (db *DB) Get(key string) ([]byte, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	value, ok := db.data[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), value...), true
+}
+
+// Put stores value under key.
+func (db *DB) Put(key string, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if err := db.append(opPut, key, value); err != nil {
+		return err
+	}
+	if _, existed := db.data[key]; existed {
+		db.garbage++
+	}
+	db.data[key] = append([]byte(nil), value...)
+	return db.maybeCompactLocked()
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (db *DB) Delete(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	if _, ok := db.data[key]; !ok {
+		return nil
+	}
+	if err := db.append(opDelete, key, nil); err != nil {
+		return err
+	}
+	delete(db.data, key)
+	db.garbage += 2 // the old value and the delete record itself
+	return db.maybeCompactLocked()
+}
+
+// ForEach calls fn for every key and value. fn must not modify the DB.
+func (db *DB) ForEach(fn func(key string, value []byte)) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for key, value := range db.data {
+		fn(key, value)
+	}
+}
+
+// Len returns the number of keys.
+func (db *DB) Len() int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return len(db.data)
+}
+
+// Compact rewrites the file so it only holds live keys.
+func (db *DB) Compact() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.compactLocked()
+}
+
+// Sync flushes the file to stable storage.
+func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrClosed
+	}
+	return db.file.Sync()
+}
+
+// Close syncs and closes the file. Closing twice is a no-op.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	if err := db.file.Sync(); err != nil {
+		db.file.Close()
+		return fmt.Errorf("kvfile: sync: %w", err)
+	}
+	return db.file.Close()
+}
+
+// replay loads the log into memory, truncating a torn tail.
+func (db *DB) replay() error {
+	reader := bufio.NewReader(db.file)
+	var offset int64
+
+	for {
+		op, key, value, n, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Everything after the last good record is discarded
+			if err := db.file.Truncate(offset); err != nil {
+				return fmt.Errorf("kvfile: truncate torn record: %w", err)
+			}
+			break
+		}
+		offset += n
+
+		switch op {
+		case opPut:
+			if _, existed := db.data[key]; existed {
+				db.garbage++
+			}
+			db.data[key] = value
+		case opDelete:
+			delete(db.data, key)
+			db.garbage += 2
+		}
+	}
+
+	if _, err := db.file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("kvfile: seek: %w", err)
+	}
+	return nil
+}
+
+// append writes one record at the end of the file. Caller must hold the write lock.
+func (db *DB) append(op byte, key string, value []byte) error {
+	if _, err := db.file.Write(encodeRecord(op, key, value)); err != nil {
+		return fmt.Errorf("kvfile: write: %w", err)
+	}
+	return nil
+}
+
+// maybeCompactLocked compacts once stale records outnumber live ones.
+func (db *DB) maybeCompactLocked() error {
+	if db.garbage < compactMinGarbage || db.garbage < len(db.data) {
+		return nil
+	}
+	return db.compactLocked()
+}
+
+// compactLocked writes live keys to a new file and renames it over the old one.
+func (db *DB) compactLocked() error {
+	tmpPath := db.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("kvfile: compact: %w", err)
+	}
+
+	w := bufio.NewWriter(tmp)
+	for key, value := range db.data {
+		if _, err := w.Write(encodeRecord(opPut, key, value)); err != nil {
+			tmp.Close()
+			os.Remove(tmpPath)
+			return fmt.Errorf("kvfile: compact: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("kvfile: compact: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("kvfile: compact: %w", err)
+	}
+	if err := os.Rename(tmpPath, db.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return fmt.Errorf("kvfile: compact: %w", err)
+	}
+
+	db.file.Close()
+	db.file = tmp
+	db.garbage = 0
+	return nil
+}
+
+// encodeRecord frames a record as
+// [payload length uint32][crc32 of payload uint32][op][key length uvarint][key][value].
+func encodeRecord(op byte, key string, value []byte) []byte {
+	payload := make([]byte, 0, 1+binary.MaxVarintLen64+len(key)+len(value))
+	payload = append(payload, op)
+	payload = binary.AppendUvarint(payload, uint64(len(key)))
+	payload = append(payload, key...)
+	payload = append(payload, value...)
+
+	record := make([]byte, headerSize, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	return append(record, payload...)
+}
+
+// readRecord reads one record and returns its size in bytes.
+// io.EOF means a clean end of file; any other error means a torn or corrupt record.
+func readRecord(r io.Reader) (op byte, key string, value []byte, n int64, err error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, "", nil, 0, io.EOF
+		}
+		return 0, "", nil, 0, err
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	if size == 0 || size > maxRecordSize {
+		return 0, "", nil, 0, errors.New("kvfile: bad record size")
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return 0, "", nil, 0, err
+	}
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(header[4:8]) {
+		return 0, "", nil, 0, errors.New("kvfile: checksum mismatch")
+	}
+
+	op = payload[0]
+	keyLen, m := binary.Uvarint(payload[1:])
+	if m <= 0 || uint64(len(payload)-1-m) < keyLen {
+		return 0, "", nil, 0, errors.New("kvfile: bad key length")
+	}
+	start := 1 + m
+	key = string(payload[start : start+int(keyLen)])
+	value = payload[start+int(keyLen):]
+	if op != opPut && op != opDelete {
+		return 0, "", nil, 0, errors.New("kvfile: unknown op")
+	}
+	return op, key, value, int64(headerSize) + int64(size), nil
+}
@@ -0,0 +1,125 @@
+package kvfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDB_PutGetDelete(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "data.kv"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	require.NoError(t, db.Put("a", []byte("1")))
+	require.NoError(t, db.Put("b", []byte("2")))
+	require.NoError(t, db.Put("a", []byte("3")))
+
+	value, ok := db.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, []byte("3"), value)
+	assert.Equal(t, 2, db.Len())
+
+	require.NoError(t, db.Delete("a"))
+	require.NoError(t, db.Delete("missing"))
+	_, ok = db.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, db.Len())
+}
+
+func TestDB_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.kv")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Put("keep", []byte("v1")))
+	require.NoError(t, db.Put("keep", []byte("v2")))
+	require.NoError(t, db.Put("gone", []byte("x")))
+	require.NoError(t, db.Delete("gone"))
+	require.NoError(t, db.Close())
+	require.NoError(t, db.Close())
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	got := map[string]string{}
+	db.ForEach(func(key string, value []byte) {
+		got[key] = string(value)
+	})
+	assert.Equal(t, map[string]string{"keep": "v2"}, got)
+}
+
+func TestDB_TruncatesTornRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.kv")
+
+	db, err := Open(path)
+	require.NoError(t, err)
+	require.NoError(t, db.Put("a", []byte("1")))
+	require.NoError(t, db.Close())
+
+	// 書き込み途中でクラッシュした状態を再現する
+	torn := encodeRecord(opPut, "b", []byte("2"))
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o600)
+	require.NoError(t, err)
+	_, err = f.Write(torn[:len(torn)-1])
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, db.Len())
+	_, ok := db.Get("b")
+	assert.False(t, ok)
+
+	// 続きの書き込みは壊れた末尾の後ろに残らない
+	require.NoError(t, db.Put("c", []byte("3")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 2, db.Len())
+	value, ok := db.Get("c")
+	require.True(t, ok)
+	assert.Equal(t, []byte("3"), value)
+}
+
+func TestDB_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.kv")
+	db, err := Open(path)
+	require.NoError(t, err)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, db.Put("key", []byte("value")))
+	}
+	before, err := os.Stat(path)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Compact())
+	after, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Less(t, after.Size(), before.Size())
+
+	// 圧縮後も書き込みと再読み込みができる
+	require.NoError(t, db.Put("other", []byte("x")))
+	require.NoError(t, db.Close())
+
+	db, err = Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, 2, db.Len())
+}
+
+func TestDB_Closed(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "data.kv"))
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	assert.ErrorIs(t, db.Put("a", []byte("1")), ErrClosed)
+	assert.ErrorIs(t, db.Delete("a"), ErrClosed)
+	assert.ErrorIs(t, db.Compact(), ErrClosed)
+}
@@ -0,0 +1,24 @@
+package middleware
+
+import (
+	"github.com/labstack/echo/v4"
+)
+
+// SessionToucher records activity on a session.
+// session.SessionStore satisfies this interface.
+type SessionToucher interface {
+	Touch(sessionID string)
+}
+
+// SessionActivityMiddleware returns a middleware that refreshes the idle
+// timeout of the request's session on every request.
+func SessionActivityMiddleware(store SessionToucher) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if sessionID, ok := SessionID(c); ok {
+				store.Touch(sessionID)
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// touchRecorder records touched session IDs.
+type touchRecorder struct {
+	touched []string
+}
+
+func (r *touchRecorder) Touch(sessionID string) {
+	r.touched = append(r.touched, sessionID)
+}
+
+func TestSessionActivityMiddleware(t *testing.T) {
+	tests := []struct {
+		name        string
+		cookie      string
+		wantTouched []string
+	}{
+		{
+			name:        "正常系: セッションの操作を記録",
+			cookie:      "session-1",
+			wantTouched: []string{"session-1"},
+		},
+		{
+			name:        "正常系: Cookieなしは何もしない",
+			wantTouched: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/queue/me", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: "session_id", Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			store := &touchRecorder{}
+			handler := func(c echo.Context) error {
+				return c.String(http.StatusOK, "OK")
+			}
+
+			err := SessionActivityMiddleware(store)(handler)(c)
+
+			assert.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantTouched, store.touched)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+// Package middleware provides HTTP middleware functions.
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AdminActorKey is the context key holding the name of the authenticated admin.
+const AdminActorKey = "admin_actor"
+
+// AdminAuthMiddleware returns a middleware that only lets through requests
+// carrying "Authorization: Bearer <token>". An empty token disables the admin
+// API entirely. The optional X-Admin-User header names the admin in the audit trail.
+func AdminAuthMiddleware(token string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if token == "" {
+				return c.JSON(http.StatusForbidden, map[string]interface{}{
+					"error":   true,
+					"code":    "ADMIN_DISABLED",
+					"message": "管理APIは無効です",
+				})
+			}
+
+			provided, ok := strings.CutPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"error":   true,
+					"code":    "UNAUTHORIZED",
+					"message": "認証に失敗しました",
+				})
+			}
+
+			actor := c.Request().Header.Get("X-Admin-User")
+			if actor == "" {
+				actor = "admin"
+			}
+			c.Set(AdminActorKey, actor)
+
+			return next(c)
+		}
+	}
+}
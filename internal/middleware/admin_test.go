@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		token     string
+		header    string
+		actor     string
+		wantCode  int
+		wantActor string
+	}{
+		{
+			name:      "正常系: 正しいトークン",
+			token:     "secret",
+			header:    "Bearer secret",
+			wantCode:  http.StatusOK,
+			wantActor: "admin",
+		},
+		{
+			name:      "正常系: 管理者名を指定",
+			token:     "secret",
+			header:    "Bearer secret",
+			actor:     "kyiku",
+			wantCode:  http.StatusOK,
+			wantActor: "kyiku",
+		},
+		{
+			name:     "異常系: トークン不一致",
+			token:    "secret",
+			header:   "Bearer wrong",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "異常系: ヘッダーなし",
+			token:    "secret",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "異常系: Bearerなし",
+			token:    "secret",
+			header:   "secret",
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "異常系: トークン未設定なら無効",
+			token:    "",
+			header:   "Bearer ",
+			wantCode: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/queue/pause", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.actor != "" {
+				req.Header.Set("X-Admin-User", tt.actor)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			var gotActor interface{}
+			handler := func(c echo.Context) error {
+				gotActor = c.Get(AdminActorKey)
+				return c.String(http.StatusOK, "OK")
+			}
+
+			err := AdminAuthMiddleware(tt.token)(handler)(c)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, tt.wantActor, gotActor)
+			} else {
+				assert.Nil(t, gotActor)
+			}
+		})
+	}
+}
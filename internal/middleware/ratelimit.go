@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/labstack/echo/v4"
 )
 
@@ -15,6 +16,7 @@ type RateLimiter struct {
 	mu       sync.RWMutex
 	limit    int           // max requests per window
 	window   time.Duration // time window
+	clock    clock.Clock
 }
 
 type requestInfo struct {
@@ -22,28 +24,28 @@ type requestInfo struct {
 	resetTime time.Time
 }
 
-// NewRateLimiter creates a new RateLimiter.
-func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+// NewRateLimiter creates a new RateLimiter whose windows are measured with c.
+func NewRateLimiter(limit int, window time.Duration, c clock.Clock) *RateLimiter {
 	rl := &RateLimiter{
 		requests: make(map[string]*requestInfo),
 		limit:    limit,
 		window:   window,
+		clock:    c,
 	}
 
 	// Start cleanup goroutine
-	go rl.cleanup()
+	go rl.cleanup(c.NewTicker(window))
 
 	return rl
 }
 
 // cleanup periodically removes expired entries.
-func (rl *RateLimiter) cleanup() {
-	ticker := time.NewTicker(rl.window)
+func (rl *RateLimiter) cleanup(ticker clock.Ticker) {
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for range ticker.C() {
 		rl.mu.Lock()
-		now := time.Now()
+		now := rl.clock.Now()
 		for ip, info := range rl.requests {
 			if now.After(info.resetTime) {
 				delete(rl.requests, ip)
@@ -58,7 +60,7 @@ func (rl *RateLimiter) isAllowed(ip string) bool {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
 
-	now := time.Now()
+	now := rl.clock.Now()
 	info, exists := rl.requests[ip]
 
 	if !exists || now.After(info.resetTime) {
@@ -78,9 +80,9 @@ func (rl *RateLimiter) isAllowed(ip string) bool {
 	return true
 }
 
-// RateLimitMiddleware returns a rate limiting middleware.
-func RateLimitMiddleware(limit int, window time.Duration) echo.MiddlewareFunc {
-	limiter := NewRateLimiter(limit, window)
+// RateLimitMiddleware returns a rate limiting middleware measuring windows with c.
+func RateLimitMiddleware(limit int, window time.Duration, c clock.Clock) echo.MiddlewareFunc {
+	limiter := NewRateLimiter(limit, window, c)
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
@@ -6,15 +6,18 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
 	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestRateLimitMiddleware(t *testing.T) {
 	e := echo.New()
 
 	// Create middleware with limit of 3 requests per second
-	rateLimitMW := RateLimitMiddleware(3, 1*time.Second)
+	rateLimitMW := RateLimitMiddleware(3, 1*time.Second, clock.Real{})
 
 	handler := func(c echo.Context) error {
 		return c.String(http.StatusOK, "OK")
@@ -75,7 +78,8 @@ func TestRateLimitMiddleware(t *testing.T) {
 }
 
 func TestRateLimiter_WindowReset(t *testing.T) {
-	limiter := NewRateLimiter(2, 100*time.Millisecond)
+	clk := clock.NewFake(time.Now())
+	limiter := NewRateLimiter(2, 100*time.Millisecond, clk)
 	ip := "test-ip"
 
 	// Use up the limit
@@ -84,8 +88,25 @@ func TestRateLimiter_WindowReset(t *testing.T) {
 	assert.False(t, limiter.isAllowed(ip))
 
 	// Wait for window to reset
-	time.Sleep(150 * time.Millisecond)
+	clk.Advance(150 * time.Millisecond)
 
 	// Should be allowed again
 	assert.True(t, limiter.isAllowed(ip))
 }
+
+func TestRateLimiter_Cleanup(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	limiter := NewRateLimiter(2, time.Minute, clk)
+	assert.True(t, limiter.isAllowed("10.0.0.1"))
+	assert.True(t, limiter.isAllowed("10.0.0.2"))
+
+	// Expired entries are dropped on the next cleanup tick
+	clk.BlockUntil(1)
+	clk.Advance(2 * time.Minute)
+	err := testutil.WaitFor(time.Second, 10*time.Millisecond, func() bool {
+		limiter.mu.RLock()
+		defer limiter.mu.RUnlock()
+		return len(limiter.requests) == 0
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+)
+
+// SessionIDKey is the context key holding the verified session ID.
+const SessionIDKey = "session_id"
+
+// SessionCookieMiddleware returns a middleware that verifies the signed
+// session cookie before any handler looks the session up. The session ID of a
+// valid cookie is stored under SessionIDKey; a tampered or malformed cookie is
+// rejected with INVALID_SESSION. Requests without a cookie pass through and
+// are reported by the handler.
+func SessionCookieMiddleware(signer *session.CookieSigner) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			cookie, err := c.Cookie(session.CookieName)
+			if err != nil || cookie.Value == "" {
+				return next(c)
+			}
+
+			sessionID, err := signer.Verify(cookie.Value)
+			if err != nil {
+				log.Printf("[Session] Rejected cookie from %s: %v", c.RealIP(), err)
+				// CloudFrontのcustom_error_responseがHTMLを返すのを防ぐため、常に200を返す
+				return c.JSON(http.StatusOK, map[string]interface{}{
+					"error":   true,
+					"message": "無効なセッション",
+					"code":    "INVALID_SESSION",
+				})
+			}
+
+			c.Set(SessionIDKey, sessionID)
+			return next(c)
+		}
+	}
+}
+
+// SessionID returns the session ID of the request: the one verified by
+// SessionCookieMiddleware or, where that middleware is not installed, the raw
+// cookie value. Returns false if the request has no session cookie.
+func SessionID(c echo.Context) (string, bool) {
+	if sessionID, ok := c.Get(SessionIDKey).(string); ok && sessionID != "" {
+		return sessionID, true
+	}
+	cookie, err := c.Cookie(session.CookieName)
+	if err != nil || cookie == nil || cookie.Value == "" {
+		return "", false
+	}
+	return cookie.Value, true
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+)
+
+func TestSessionCookieMiddleware(t *testing.T) {
+	signer, err := session.NewCookieSigner([]byte("test-key-0123456789"))
+	require.NoError(t, err)
+	other, err := session.NewCookieSigner([]byte("other-key-0123456789"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		cookie     string
+		wantCalled bool
+		wantID     string
+		wantFound  bool
+	}{
+		{
+			name:       "正常系: 署名済みCookie",
+			cookie:     signer.Sign("session-1"),
+			wantCalled: true,
+			wantID:     "session-1",
+			wantFound:  true,
+		},
+		{
+			name:       "正常系: Cookieなしはハンドラーに任せる",
+			wantCalled: true,
+		},
+		{
+			name:   "異常系: 署名なし",
+			cookie: "session-1",
+		},
+		{
+			name:   "異常系: 短い値",
+			cookie: "x",
+		},
+		{
+			name:   "異常系: 別の鍵で署名",
+			cookie: other.Sign("session-1"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/queue/me", nil)
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: session.CookieName, Value: tt.cookie})
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			called := false
+			var gotID string
+			var gotFound bool
+			handler := func(c echo.Context) error {
+				called = true
+				gotID, gotFound = SessionID(c)
+				return c.String(http.StatusOK, "OK")
+			}
+
+			err := SessionCookieMiddleware(signer)(handler)(c)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantCalled, called)
+
+			if !tt.wantCalled {
+				var resp map[string]interface{}
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+				assert.Equal(t, true, resp["error"])
+				assert.Equal(t, "INVALID_SESSION", resp["code"])
+				return
+			}
+			assert.Equal(t, tt.wantID, gotID)
+			assert.Equal(t, tt.wantFound, gotFound)
+		})
+	}
+}
+
+func TestSessionID_WithoutMiddleware(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: session.CookieName, Value: "raw-session"})
+	c := e.NewContext(req, httptest.NewRecorder())
+
+	sessionID, ok := SessionID(c)
+	assert.True(t, ok)
+	assert.Equal(t, "raw-session", sessionID)
+}
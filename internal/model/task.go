@@ -0,0 +1,115 @@
+package model
+
+import "time"
+
+// Registration dashboard tasks.
+const (
+	TaskName     = "name"
+	TaskBirthday = "birthday"
+	TaskPhone    = "phone"
+	TaskAddress  = "address"
+	TaskEmail    = "email"
+	TaskTerms    = "terms"
+	TaskPassword = "password"
+	TaskCaptcha  = "captcha"
+	TaskOTP      = "otp"
+)
+
+// RegisterTasks lists the nine dashboard tasks in display order.
+// All of them must be completed before registering.
+var RegisterTasks = []string{
+	TaskName,
+	TaskBirthday,
+	TaskPhone,
+	TaskAddress,
+	TaskEmail,
+	TaskTerms,
+	TaskPassword,
+	TaskCaptcha,
+	TaskOTP,
+}
+
+// IsRegisterTask reports whether the name is one of the dashboard tasks.
+func IsRegisterTask(task string) bool {
+	for _, t := range RegisterTasks {
+		if t == task {
+			return true
+		}
+	}
+	return false
+}
+
+// TaskState is the progress of one dashboard task.
+type TaskState struct {
+	Attempts    int       `json:"attempts"`
+	CompletedAt time.Time `json:"completed_at,omitempty"` // zero until completed
+}
+
+// Completed reports whether the task has been completed.
+func (s TaskState) Completed() bool {
+	return !s.CompletedAt.IsZero()
+}
+
+// Task returns the progress of a dashboard task.
+func (u *User) Task(task string) TaskState {
+	return u.Tasks[task]
+}
+
+// RecordTaskAttempt counts an attempt at a dashboard task.
+func (u *User) RecordTaskAttempt(task string) {
+	if u.Tasks == nil {
+		u.Tasks = make(map[string]TaskState)
+	}
+	state := u.Tasks[task]
+	state.Attempts++
+	u.Tasks[task] = state
+}
+
+// CompleteTask marks a dashboard task as completed at now.
+// Returns false if it was already completed, keeping the first completion time.
+func (u *User) CompleteTask(task string, now time.Time) bool {
+	if u.Tasks == nil {
+		u.Tasks = make(map[string]TaskState)
+	}
+	state := u.Tasks[task]
+	if state.Completed() {
+		return false
+	}
+	state.CompletedAt = now
+	u.Tasks[task] = state
+	return true
+}
+
+// CompletedTasks returns the number of completed dashboard tasks.
+func (u *User) CompletedTasks() int {
+	n := 0
+	for _, task := range RegisterTasks {
+		if u.Tasks[task].Completed() {
+			n++
+		}
+	}
+	return n
+}
+
+// MissingTasks returns the dashboard tasks not completed yet, in display order.
+func (u *User) MissingTasks() []string {
+	var missing []string
+	for _, task := range RegisterTasks {
+		if !u.Tasks[task].Completed() {
+			missing = append(missing, task)
+		}
+	}
+	return missing
+}
+
+// TaskLedger returns a copy of the user's task progress, or nil if there is none.
+func (u *User) TaskLedger() map[string]TaskState {
+	if len(u.Tasks) == 0 {
+		return nil
+	}
+	ledger := make(map[string]TaskState, len(u.Tasks))
+	for task, state := range u.Tasks {
+		ledger[task] = state
+	}
+	return ledger
+}
@@ -2,12 +2,14 @@
 package model
 
 import (
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 )
 
-// Status constants for user state
+// Status constants for user state.
+// Gates added through the stage pipeline config use their name as status.
 const (
 	StatusWaiting       = "waiting"
 	StatusStage1Dino    = "stage1_dino"
@@ -29,11 +31,30 @@ type WebSocketConn interface {
 }
 
 // User represents a user in the system.
+//
+// ID and SessionID never change after creation. Every other field is guarded
+// by the user's lock: go through the session store's Update or View, or hold
+// Lock/Unlock when only the user is at hand (e.g. in a timer goroutine).
 type User struct {
 	ID        string    // UUID
 	SessionID string    // Session ID (Cookie)
 	JoinedAt  time.Time // When the user joined the queue
 	Status    string    // Current status
+	Queue     string    // Name of the queue the session is bound to (empty until first connect)
+
+	StageAttempts int       // Failures in the current pipeline gate
+	PromotedAt    time.Time // When the user was promoted into the pipeline (zero while waiting)
+
+	// Penalty state, kept when the user goes back to waiting
+	Failures       int       // Failures so far, including those absorbed by lives
+	Retries        int       // Failures that could be retried in the gate, the only ones lives absorb
+	RejoinAt       time.Time // The user may not rejoin the queue before this (zero for no cooldown)
+	RejoinPosition int       // 1-indexed queue position taken when rejoining after a cooldown (0 for the back)
+
+	// Dino Run fields: the run handed out last, whose level is rebuilt from the seed to check the result
+	DinoRunID     string    // Run ID (UUID, empty while no run is in progress)
+	DinoSeed      int64     // Seed the run's level was generated from
+	DinoStartedAt time.Time // When the level was handed out
 
 	// CAPTCHA fields
 	CaptchaTargetX  int // Target X coordinate for CAPTCHA
@@ -48,20 +69,37 @@ type User struct {
 	RegisterToken    string    // Registration token (UUID)
 	RegisterTokenExp time.Time // Token expiration time (10 minutes)
 
+	// Registration dashboard progress, keyed by task (nil until the first attempt)
+	Tasks map[string]TaskState
+
 	// WebSocket connection
 	Conn WebSocketConn // WebSocket connection for real-time communication
+
+	mu sync.Mutex
 }
 
-// NewUser creates a new User with default values.
-func NewUser() *User {
+// NewUser creates a new User with default values, joining at joinedAt.
+func NewUser(joinedAt time.Time) *User {
 	return &User{
 		ID:       uuid.New().String(),
 		Status:   StatusWaiting,
-		JoinedAt: time.Now(),
+		JoinedAt: joinedAt,
 	}
 }
 
+// Lock locks the user's state. The lock is not reentrant.
+func (u *User) Lock() {
+	u.mu.Lock()
+}
+
+// Unlock unlocks the user's state.
+func (u *User) Unlock() {
+	u.mu.Unlock()
+}
+
 // validTransitions defines allowed status transitions.
+// Status changes themselves go through stage.Machine, whose transitions come
+// from the configured stage pipeline; this table describes the default one.
 var validTransitions = map[string][]string{
 	StatusWaiting:       {StatusStage1Dino},
 	StatusStage1Dino:    {StatusRegistering, StatusWaiting},
@@ -86,8 +124,14 @@ func (u *User) CanTransitionTo(status string) bool {
 
 // ResetToWaiting resets the user's state to waiting.
 // This is called when the user fails at any stage.
+// Failures, Retries and the Rejoin fields are kept: they outlive a single attempt.
 func (u *User) ResetToWaiting() {
 	u.Status = StatusWaiting
+	u.StageAttempts = 0
+	u.PromotedAt = time.Time{}
+
+	// Reset Dino Run state
+	u.EndDinoRun()
 
 	// Reset CAPTCHA state
 	u.CaptchaAttempts = 0
@@ -101,6 +145,16 @@ func (u *User) ResetToWaiting() {
 	// Reset registration token
 	u.RegisterToken = ""
 	u.RegisterTokenExp = time.Time{}
+
+	// Reset dashboard progress
+	u.Tasks = nil
+}
+
+// EndDinoRun forgets the user's Dino run, so its result can't be reported twice.
+func (u *User) EndDinoRun() {
+	u.DinoRunID = ""
+	u.DinoSeed = 0
+	u.DinoStartedAt = time.Time{}
 }
 
 // SetCaptchaTarget sets the CAPTCHA target coordinates.
@@ -23,7 +23,7 @@ func TestUser_NewUser(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user := NewUser()
+			user := NewUser(time.Now())
 
 			assert.NotEmpty(t, user.ID)
 			assert.Equal(t, tt.wantStatus, user.Status)
@@ -56,7 +56,7 @@ func TestUser_StatusTransitions(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user := NewUser()
+			user := NewUser(time.Now())
 			user.Status = tt.fromStatus
 
 			valid := user.CanTransitionTo(tt.toStatus)
@@ -106,7 +106,7 @@ func TestUser_ResetToWaiting(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user := NewUser()
+			user := NewUser(time.Now())
 			user.Status = tt.initialStatus
 			user.CaptchaAttempts = tt.captchaAttempts
 			user.OTPAttempts = tt.otpAttempts
@@ -150,7 +150,7 @@ func TestUser_SetCaptchaTarget(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user := NewUser()
+			user := NewUser(time.Now())
 
 			user.SetCaptchaTarget(tt.targetX, tt.targetY)
 
@@ -177,7 +177,7 @@ func TestUser_IncrementAttempts(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			user := NewUser()
+			user := NewUser(time.Now())
 
 			if tt.attemptType == "captcha" {
 				user.CaptchaAttempts = tt.initialAttempts
@@ -0,0 +1,102 @@
+package penalty
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse builds a policy from its spec. A spec is a chain of parts separated
+// by ">", where lives and cooldown wrap the rest of the chain (back of line
+// when they are last):
+//
+//	back                     the end of the queue
+//	moveback:N               N places behind the front
+//	random                   anywhere in the queue
+//	cooldown:DURATION        no rejoining for DURATION, e.g. cooldown:30s>random
+//	lives:N                  the first N retryable failures are absorbed, e.g. lives:2>back
+//	escalate:STEP,STEP,...   the n-th failure gets the n-th step, e.g. escalate:back,moveback:20,cooldown:1m
+//
+// Escalation steps are single parts, and escalate ends the chain.
+func Parse(spec string) (Policy, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ">")
+	return parseChain(parts)
+}
+
+// parseChain parses the first part of a chain, wrapping the rest if it is a wrapper.
+func parseChain(parts []string) (Policy, error) {
+	name, arg, _ := strings.Cut(strings.TrimSpace(parts[0]), ":")
+	rest := parts[1:]
+
+	switch name {
+	case "lives", "cooldown":
+		var then Policy = BackOfLine{}
+		if len(rest) > 0 {
+			var err error
+			if then, err = parseChain(rest); err != nil {
+				return nil, err
+			}
+		}
+		if name == "lives" {
+			n, err := positive(name, arg)
+			if err != nil {
+				return nil, err
+			}
+			return Lives{Lives: n, Then: then}, nil
+		}
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("cooldown: invalid duration %q", arg)
+		}
+		return Cooldown{Duration: d, Then: then}, nil
+	}
+
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("%s: must be the last part of %q", name, strings.Join(parts, ">"))
+	}
+	if name == "escalate" {
+		var steps []Policy
+		for _, step := range strings.Split(arg, ",") {
+			p, err := parseChain([]string{step})
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := p.(Escalating); ok {
+				return nil, fmt.Errorf("escalate: steps can't escalate")
+			}
+			steps = append(steps, p)
+		}
+		return Escalating{Steps: steps}, nil
+	}
+	return parseSimple(name, arg)
+}
+
+// parseSimple parses a part that places the user.
+func parseSimple(name, arg string) (Policy, error) {
+	switch name {
+	case "back":
+		return BackOfLine{}, nil
+	case "random":
+		return Random{}, nil
+	case "moveback":
+		n, err := positive(name, arg)
+		if err != nil {
+			return nil, err
+		}
+		return MoveBack{Places: n}, nil
+	case "":
+		return nil, fmt.Errorf("empty penalty policy")
+	default:
+		return nil, fmt.Errorf("unknown penalty policy %q", name)
+	}
+}
+
+// positive parses the positive integer argument of a part.
+func positive(name, arg string) (int, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s: invalid count %q", name, arg)
+	}
+	return n, nil
+}
@@ -0,0 +1,64 @@
+package penalty
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Policy
+	}{
+		{"back", BackOfLine{}},
+		{"moveback:10", MoveBack{Places: 10}},
+		{" random ", Random{}},
+		{"cooldown:30s", Cooldown{Duration: 30 * time.Second, Then: BackOfLine{}}},
+		{"cooldown:1m>random", Cooldown{Duration: time.Minute, Then: Random{}}},
+		{"lives:2", Lives{Lives: 2, Then: BackOfLine{}}},
+		{"lives:1>cooldown:30s>moveback:5", Lives{Lives: 1, Then: Cooldown{Duration: 30 * time.Second, Then: MoveBack{Places: 5}}}},
+		{"escalate:back,moveback:20,cooldown:1m", Escalating{Steps: []Policy{
+			BackOfLine{},
+			MoveBack{Places: 20},
+			Cooldown{Duration: time.Minute, Then: BackOfLine{}},
+		}}},
+		{"lives:3>escalate:back,random", Lives{Lives: 3, Then: Escalating{Steps: []Policy{BackOfLine{}, Random{}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			p, err := Parse(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, p)
+
+			// String は Parse で元に戻せる
+			again, err := Parse(p.String())
+			require.NoError(t, err)
+			assert.Equal(t, p, again)
+		})
+	}
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"front",
+		"moveback",
+		"moveback:0",
+		"lives:x",
+		"cooldown:soon",
+		"cooldown:-1s",
+		"back>random",
+		"escalate:back>random",
+		"escalate:back,escalate:random",
+		"escalate:back,,random",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			_, err := Parse(spec)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,164 @@
+// Package penalty decides what a failure costs a user: where they go back in
+// line, how long before they may rejoin, or whether a life absorbs it.
+package penalty
+
+import (
+	"fmt"
+	"time"
+)
+
+// Failure is what a policy knows about a failure.
+type Failure struct {
+	Stage     string // status the user failed in
+	Reason    string // failure reason code
+	Count     int    // failures of the user so far, this one included
+	Retries   int    // retryable failures of the user so far, this one included if retryable
+	Retryable bool   // the user can stay in their gate and try again
+}
+
+// Placement is where a penalized user goes back in line.
+type Placement string
+
+// Placements.
+const (
+	PlaceBack     Placement = "back"     // the end of the queue
+	PlacePosition Placement = "position" // the position in Penalty.Position
+	PlaceRandom   Placement = "random"   // anywhere in the queue
+)
+
+// Penalty is the outcome of a policy for one failure.
+type Penalty struct {
+	Absorbed  bool          `json:"absorbed,omitempty"`   // a life absorbed the failure, the user stays in their gate
+	LivesLeft int           `json:"lives_left,omitempty"` // lives remaining after an absorbed failure
+	Placement Placement     `json:"placement,omitempty"`
+	Position  int           `json:"position,omitempty"` // 1-indexed, for PlacePosition
+	Cooldown  time.Duration `json:"cooldown,omitempty"` // how long before the user may rejoin the queue
+}
+
+// Describe tells the user what the penalty means for them.
+func (p Penalty) Describe() string {
+	if p.Absorbed {
+		return fmt.Sprintf("ライフを1つ失いました（残り%d）。もう一度挑戦してください。", p.LivesLeft)
+	}
+
+	var place string
+	switch p.Placement {
+	case PlacePosition:
+		place = fmt.Sprintf("待機列の%d番目からやり直しです。", p.Position)
+	case PlaceRandom:
+		place = "待機列のどこかからやり直しです。"
+	default:
+		place = "待機列の最後尾からやり直しです。"
+	}
+	if p.Cooldown > 0 {
+		return fmt.Sprintf("%d秒後に", int(p.Cooldown.Round(time.Second)/time.Second)) + place
+	}
+	return place
+}
+
+// Policy decides the penalty for a failure.
+// String returns the policy's spec, as accepted by Parse.
+type Policy interface {
+	Penalty(f Failure) Penalty
+	String() string
+}
+
+// BackOfLine sends the user to the end of the queue.
+type BackOfLine struct{}
+
+// Penalty implements Policy.
+func (BackOfLine) Penalty(Failure) Penalty {
+	return Penalty{Placement: PlaceBack}
+}
+
+func (BackOfLine) String() string { return "back" }
+
+// MoveBack puts the user Places places behind the front of the queue,
+// where they were when they left it.
+type MoveBack struct {
+	Places int
+}
+
+// Penalty implements Policy.
+func (p MoveBack) Penalty(Failure) Penalty {
+	return Penalty{Placement: PlacePosition, Position: p.Places + 1}
+}
+
+func (p MoveBack) String() string { return fmt.Sprintf("moveback:%d", p.Places) }
+
+// Random puts the user anywhere in the queue.
+type Random struct{}
+
+// Penalty implements Policy.
+func (Random) Penalty(Failure) Penalty {
+	return Penalty{Placement: PlaceRandom}
+}
+
+func (Random) String() string { return "random" }
+
+// Cooldown keeps the user out of the queue for Duration, then places them as Then does.
+type Cooldown struct {
+	Duration time.Duration
+	Then     Policy
+}
+
+// Penalty implements Policy.
+func (p Cooldown) Penalty(f Failure) Penalty {
+	penalty := p.Then.Penalty(f)
+	if !penalty.Absorbed {
+		penalty.Cooldown = p.Duration
+	}
+	return penalty
+}
+
+func (p Cooldown) String() string {
+	if _, ok := p.Then.(BackOfLine); ok {
+		return "cooldown:" + p.Duration.String()
+	}
+	return "cooldown:" + p.Duration.String() + ">" + p.Then.String()
+}
+
+// Lives absorbs the first Lives retryable failures of a user: they stay in
+// their gate and try again. Failures that can't be retried, such as timeouts,
+// never use up a life. Then counts failures without the absorbed ones, so
+// escalation starts from 1 for the first failure no life covered.
+type Lives struct {
+	Lives int
+	Then  Policy
+}
+
+// Penalty implements Policy.
+func (p Lives) Penalty(f Failure) Penalty {
+	if f.Retryable && f.Retries <= p.Lives {
+		return Penalty{Absorbed: true, LivesLeft: p.Lives - f.Retries}
+	}
+	f.Count = max(f.Count-min(f.Retries, p.Lives), 1)
+	return p.Then.Penalty(f)
+}
+
+func (p Lives) String() string {
+	return fmt.Sprintf("lives:%d>%s", p.Lives, p.Then)
+}
+
+// Escalating applies harsher penalties to repeat offenders: the n-th failure
+// gets Steps[n-1], and every failure after the last step gets the last step.
+type Escalating struct {
+	Steps []Policy
+}
+
+// Penalty implements Policy.
+func (p Escalating) Penalty(f Failure) Penalty {
+	step := min(max(f.Count, 1), len(p.Steps)) - 1
+	return p.Steps[step].Penalty(f)
+}
+
+func (p Escalating) String() string {
+	spec := "escalate:"
+	for i, step := range p.Steps {
+		if i > 0 {
+			spec += ","
+		}
+		spec += step.String()
+	}
+	return spec
+}
@@ -0,0 +1,121 @@
+package penalty
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy_Penalty(t *testing.T) {
+	retry := func(count int) Failure {
+		return Failure{Stage: "stage1_dino", Reason: "GAME_OVER", Count: count, Retries: count, Retryable: true}
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		f      Failure
+		want   Penalty
+	}{
+		{
+			name:   "最後尾",
+			policy: BackOfLine{},
+			f:      retry(1),
+			want:   Penalty{Placement: PlaceBack},
+		},
+		{
+			name:   "先頭からN人後ろ",
+			policy: MoveBack{Places: 5},
+			f:      retry(1),
+			want:   Penalty{Placement: PlacePosition, Position: 6},
+		},
+		{
+			name:   "ランダム",
+			policy: Random{},
+			f:      retry(1),
+			want:   Penalty{Placement: PlaceRandom},
+		},
+		{
+			name:   "クールダウン後に最後尾",
+			policy: Cooldown{Duration: time.Minute, Then: BackOfLine{}},
+			f:      retry(1),
+			want:   Penalty{Placement: PlaceBack, Cooldown: time.Minute},
+		},
+		{
+			name:   "ライフが失敗を吸収",
+			policy: Lives{Lives: 2, Then: BackOfLine{}},
+			f:      retry(1),
+			want:   Penalty{Absorbed: true, LivesLeft: 1},
+		},
+		{
+			name:   "最後のライフ",
+			policy: Lives{Lives: 2, Then: BackOfLine{}},
+			f:      retry(2),
+			want:   Penalty{Absorbed: true},
+		},
+		{
+			name:   "ライフが尽きた",
+			policy: Lives{Lives: 2, Then: MoveBack{Places: 1}},
+			f:      retry(3),
+			want:   Penalty{Placement: PlacePosition, Position: 2},
+		},
+		{
+			name:   "やり直せない失敗はライフで吸収しない",
+			policy: Lives{Lives: 2, Then: BackOfLine{}},
+			f:      Failure{Reason: "STAGE_TIMEOUT", Count: 1},
+			want:   Penalty{Placement: PlaceBack},
+		},
+		{
+			name:   "タイムアウトはライフを減らさない",
+			policy: Lives{Lives: 2, Then: BackOfLine{}},
+			f:      Failure{Reason: "GAME_OVER", Count: 3, Retries: 1, Retryable: true},
+			want:   Penalty{Absorbed: true, LivesLeft: 1},
+		},
+		{
+			name:   "吸収した失敗は後続の段階に数えない",
+			policy: Lives{Lives: 1, Then: Escalating{Steps: []Policy{BackOfLine{}, MoveBack{Places: 1}}}},
+			f:      Failure{Reason: "STAGE_TIMEOUT", Count: 2, Retries: 1},
+			want:   Penalty{Placement: PlaceBack},
+		},
+		{
+			name:   "ライフで吸収した失敗にはクールダウンなし",
+			policy: Cooldown{Duration: time.Minute, Then: Lives{Lives: 1, Then: BackOfLine{}}},
+			f:      retry(1),
+			want:   Penalty{Absorbed: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.policy.Penalty(tt.f))
+		})
+	}
+}
+
+func TestEscalating_Penalty(t *testing.T) {
+	p := Escalating{Steps: []Policy{
+		BackOfLine{},
+		MoveBack{Places: 20},
+		Cooldown{Duration: time.Minute, Then: BackOfLine{}},
+	}}
+
+	assert.Equal(t, PlaceBack, p.Penalty(Failure{Count: 1}).Placement)
+	assert.Equal(t, 21, p.Penalty(Failure{Count: 2}).Position)
+	assert.Equal(t, time.Minute, p.Penalty(Failure{Count: 3}).Cooldown)
+	// 最後の段階以降は最後の段階のまま
+	assert.Equal(t, time.Minute, p.Penalty(Failure{Count: 10}).Cooldown)
+
+	// ライフが尽きてから段階を数える
+	lives := Lives{Lives: 1, Then: p}
+	assert.True(t, lives.Penalty(Failure{Count: 1, Retries: 1, Retryable: true}).Absorbed)
+	assert.Equal(t, PlaceBack, lives.Penalty(Failure{Count: 2, Retries: 2, Retryable: true}).Placement)
+	assert.Equal(t, 21, lives.Penalty(Failure{Count: 3, Retries: 3, Retryable: true}).Position)
+}
+
+func TestPenalty_Describe(t *testing.T) {
+	assert.Equal(t, "待機列の最後尾からやり直しです。", Penalty{Placement: PlaceBack}.Describe())
+	assert.Equal(t, "待機列の6番目からやり直しです。", Penalty{Placement: PlacePosition, Position: 6}.Describe())
+	assert.Equal(t, "30秒後に待機列のどこかからやり直しです。", Penalty{Placement: PlaceRandom, Cooldown: 30 * time.Second}.Describe())
+	assert.Contains(t, Penalty{Absorbed: true, LivesLeft: 1}.Describe(), "残り1")
+}
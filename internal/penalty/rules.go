@@ -0,0 +1,143 @@
+package penalty
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Any matches every stage or every reason in a rule.
+const Any = "*"
+
+// Rule is the policy used for failures of a stage with a reason.
+type Rule struct {
+	Stage  string `json:"stage"`  // status, or Any
+	Reason string `json:"reason"` // failure reason code, or Any
+	Policy string `json:"policy"` // spec of the policy
+}
+
+// Rules picks the policy of a failure by stage and reason.
+// It is itself a Policy and can be changed while the server runs.
+type Rules struct {
+	mu       sync.RWMutex
+	policies map[[2]string]Policy // {stage, reason} -> policy
+	fallback Policy
+}
+
+// NewRules creates rules that use fallback for failures no rule matches.
+func NewRules(fallback Policy) *Rules {
+	return &Rules{
+		policies: make(map[[2]string]Policy),
+		fallback: fallback,
+	}
+}
+
+// Set uses the policy for failures of the stage with the reason.
+// An empty stage or reason means Any.
+func (r *Rules) Set(stage, reason string, p Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policies[ruleKey(stage, reason)] = p
+}
+
+// Remove removes the rule for the stage and reason.
+// Returns false if there was none.
+func (r *Rules) Remove(stage, reason string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := ruleKey(stage, reason)
+	if _, ok := r.policies[key]; !ok {
+		return false
+	}
+	delete(r.policies, key)
+	return true
+}
+
+// SetFallback sets the policy used when no rule matches.
+func (r *Rules) SetFallback(p Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.fallback = p
+}
+
+// Policy returns the policy for a failure of the stage with the reason.
+// The most specific rule wins: stage and reason, then stage, then reason.
+func (r *Rules) Policy(stage, reason string) Policy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	for _, key := range [][2]string{{stage, reason}, {stage, Any}, {Any, reason}, {Any, Any}} {
+		if p, ok := r.policies[key]; ok {
+			return p
+		}
+	}
+	return r.fallback
+}
+
+// Penalty implements Policy.
+func (r *Rules) Penalty(f Failure) Penalty {
+	return r.Policy(f.Stage, f.Reason).Penalty(f)
+}
+
+// String returns the spec of the fallback policy.
+func (r *Rules) String() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.fallback.String()
+}
+
+// List returns the rules sorted by stage and reason.
+func (r *Rules) List() []Rule {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	rules := make([]Rule, 0, len(r.policies))
+	for key, p := range r.policies {
+		rules = append(rules, Rule{Stage: key[0], Reason: key[1], Policy: p.String()})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if rules[i].Stage != rules[j].Stage {
+			return rules[i].Stage < rules[j].Stage
+		}
+		return rules[i].Reason < rules[j].Reason
+	})
+	return rules
+}
+
+// Load sets a rule for each "STAGE:REASON=POLICY" spec,
+// e.g. "stage1_dino:GAME_OVER=lives:1>back" or "*:TOKEN_EXPIRED=cooldown:1m".
+// Nothing is set if any spec is invalid.
+func (r *Rules) Load(specs []string) error {
+	rules := make([]Rule, 0, len(specs))
+	policies := make([]Policy, 0, len(specs))
+	for _, spec := range specs {
+		key, policySpec, ok := strings.Cut(spec, "=")
+		stage, reason, hasReason := strings.Cut(key, ":")
+		if !ok || !hasReason {
+			return fmt.Errorf("penalty rule %q: want STAGE:REASON=POLICY", spec)
+		}
+		p, err := Parse(policySpec)
+		if err != nil {
+			return fmt.Errorf("penalty rule %q: %w", spec, err)
+		}
+		rules = append(rules, Rule{Stage: strings.TrimSpace(stage), Reason: strings.TrimSpace(reason)})
+		policies = append(policies, p)
+	}
+	for i, rule := range rules {
+		r.Set(rule.Stage, rule.Reason, policies[i])
+	}
+	return nil
+}
+
+// ruleKey normalizes an empty stage or reason to Any.
+func ruleKey(stage, reason string) [2]string {
+	if stage == "" {
+		stage = Any
+	}
+	if reason == "" {
+		reason = Any
+	}
+	return [2]string{stage, reason}
+}
@@ -0,0 +1,52 @@
+package penalty
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRules_Policy(t *testing.T) {
+	r := NewRules(BackOfLine{})
+	r.Set("stage1_dino", "GAME_OVER", Lives{Lives: 1, Then: BackOfLine{}})
+	r.Set("stage1_dino", "", Random{})
+	r.Set(Any, "TOKEN_EXPIRED", MoveBack{Places: 3})
+
+	// 最も具体的なルールが選ばれる
+	assert.Equal(t, Lives{Lives: 1, Then: BackOfLine{}}, r.Policy("stage1_dino", "GAME_OVER"))
+	assert.Equal(t, Random{}, r.Policy("stage1_dino", "STAGE_TIMEOUT"))
+	assert.Equal(t, MoveBack{Places: 3}, r.Policy("registering", "TOKEN_EXPIRED"))
+	assert.Equal(t, BackOfLine{}, r.Policy("registering", "OTP_FAILED"))
+
+	// 実行中に変更できる
+	assert.True(t, r.Remove("stage1_dino", Any))
+	assert.False(t, r.Remove("stage1_dino", Any))
+	r.SetFallback(Random{})
+	assert.Equal(t, Random{}, r.Policy("stage1_dino", "STAGE_TIMEOUT"))
+	assert.Equal(t, "random", r.String())
+
+	assert.Equal(t, []Rule{
+		{Stage: Any, Reason: "TOKEN_EXPIRED", Policy: "moveback:3"},
+		{Stage: "stage1_dino", Reason: "GAME_OVER", Policy: "lives:1>back"},
+	}, r.List())
+
+	// Rules 自体が Policy
+	p := r.Penalty(Failure{Stage: "stage1_dino", Reason: "GAME_OVER", Count: 1, Retryable: true})
+	assert.True(t, p.Absorbed)
+}
+
+func TestRules_Load(t *testing.T) {
+	r := NewRules(BackOfLine{})
+	require.NoError(t, r.Load([]string{
+		"stage1_dino:GAME_OVER=lives:1>back",
+		"*:TOKEN_EXPIRED=cooldown:1m",
+	}))
+	assert.Len(t, r.List(), 2)
+
+	// 1つでも不正なら何も設定しない
+	r = NewRules(BackOfLine{})
+	assert.Error(t, r.Load([]string{"stage1_dino:GAME_OVER=back", "stage1_dino=back"}))
+	assert.Error(t, r.Load([]string{"*:*=front"}))
+	assert.Empty(t, r.List())
+}
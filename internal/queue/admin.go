@@ -0,0 +1,101 @@
+package queue
+
+// SetFrozen suspends or resumes position broadcasts.
+// Unfreezing sends everyone their current position right away.
+func (q *WaitingQueue) SetFrozen(frozen bool) {
+	q.broadcastMu.Lock()
+	q.frozen = frozen
+	q.broadcastMu.Unlock()
+
+	if !frozen {
+		q.flush()
+	}
+}
+
+// IsFrozen returns whether position broadcasts are suspended.
+func (q *WaitingQueue) IsFrozen() bool {
+	q.broadcastMu.Lock()
+	defer q.broadcastMu.Unlock()
+	return q.frozen
+}
+
+// Users returns the real users (reserved places included) in queue order.
+func (q *WaitingQueue) Users() []*QueueUser {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	users := make([]*QueueUser, 0, q.count-len(q.phantoms))
+	for _, user := range q.slots[:q.next] {
+		if user != nil && !user.Phantom {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// MoveTo moves a user to the given 1-indexed position, counting phantoms.
+// Positions beyond the end move the user to the back.
+// Returns false if the user is not in the queue.
+//
+// Moving rebuilds the queue, which is O(n); it is meant for manual admin use.
+func (q *WaitingQueue) MoveTo(userID string, position int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	slots, ok := q.index[userID]
+	if !ok {
+		return false
+	}
+	moved := q.slots[slots[0]]
+
+	order := make([]*QueueUser, 0, q.count)
+	for _, user := range q.slots[:q.next] {
+		if user != nil && user != moved {
+			order = append(order, user)
+		}
+	}
+
+	at := min(max(position, 1), len(order)+1) - 1
+	order = append(order, nil)
+	copy(order[at+1:], order[at:])
+	order[at] = moved
+
+	copy(q.slots, order)
+	clear(q.slots[len(order):q.next])
+	q.next = len(order)
+	q.rebuild(len(q.slots))
+	return true
+}
+
+// Drain removes every entry, phantoms included, and returns the real users
+// that were waiting so the caller can notify them.
+func (q *WaitingQueue) Drain() []*QueueUser {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	drained := make([]*QueueUser, 0, q.count-len(q.phantoms))
+	for slot, user := range q.slots[:q.next] {
+		if user == nil {
+			continue
+		}
+		if !user.Phantom {
+			drained = append(drained, user)
+		}
+		q.removeSlot(slot)
+	}
+	return drained
+}
+
+// Announce sends a message to every connected user in the queue.
+// Returns the number of users the message was sent to.
+func (q *WaitingQueue) Announce(message interface{}) int {
+	sent := 0
+	for _, user := range q.Users() {
+		if user.Conn == nil {
+			continue
+		}
+		_ = user.Conn.WriteJSON(message)
+		sent++
+	}
+	return sent
+}
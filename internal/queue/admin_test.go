@@ -0,0 +1,124 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitingQueue_SetFrozen(t *testing.T) {
+	q := NewWaitingQueue()
+	conn := testutil.NewMockWebSocketConn()
+	q.Add("user1", conn)
+
+	q.SetFrozen(true)
+	assert.True(t, q.IsFrozen())
+	q.BroadcastPositions()
+	assert.Empty(t, conn.GetMessages(), "凍結中は順位を送らない")
+
+	// 解除すると現在の順位が送られる
+	q.SetFrozen(false)
+	msg := conn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, "queueUpdate", msg["type"])
+	assert.Equal(t, float64(1), msg["position"])
+}
+
+func TestWaitingQueue_MoveTo(t *testing.T) {
+	tests := []struct {
+		name      string
+		userID    string
+		position  int
+		wantOK    bool
+		wantOrder []string
+	}{
+		{
+			name:      "正常系: 先頭へ移動",
+			userID:    "user3",
+			position:  1,
+			wantOK:    true,
+			wantOrder: []string{"user3", "user1", "user2", "user4"},
+		},
+		{
+			name:      "正常系: 後ろへ移動",
+			userID:    "user1",
+			position:  3,
+			wantOK:    true,
+			wantOrder: []string{"user2", "user3", "user1", "user4"},
+		},
+		{
+			name:      "正常系: 範囲外は最後尾",
+			userID:    "user2",
+			position:  100,
+			wantOK:    true,
+			wantOrder: []string{"user1", "user3", "user4", "user2"},
+		},
+		{
+			name:      "異常系: 存在しないユーザー",
+			userID:    "unknown",
+			position:  1,
+			wantOK:    false,
+			wantOrder: []string{"user1", "user2", "user3", "user4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := NewWaitingQueue()
+			for _, id := range []string{"user1", "user2", "user3", "user4"} {
+				q.Add(id, testutil.NewMockWebSocketConn())
+			}
+
+			assert.Equal(t, tt.wantOK, q.MoveTo(tt.userID, tt.position))
+			assert.Equal(t, tt.wantOrder, q.IDs())
+
+			for i, id := range tt.wantOrder {
+				position, found := q.GetPosition(id)
+				require.True(t, found)
+				assert.Equal(t, i+1, position)
+			}
+		})
+	}
+}
+
+func TestWaitingQueue_Drain(t *testing.T) {
+	q := NewWaitingQueue()
+	q.Add("user1", testutil.NewMockWebSocketConn())
+	q.InjectPhantoms(2)
+	q.Add("user2", testutil.NewMockWebSocketConn())
+
+	drained := q.Drain()
+	require.Len(t, drained, 2)
+	assert.Equal(t, "user1", drained[0].ID)
+	assert.Equal(t, "user2", drained[1].ID)
+
+	assert.Equal(t, 0, q.Total())
+	assert.Equal(t, 0, q.PhantomStats().Active)
+	assert.Nil(t, q.Peek())
+
+	// 空にした後も通常通り使える
+	q.Add("user3", testutil.NewMockWebSocketConn())
+	position, _ := q.GetPosition("user3")
+	assert.Equal(t, 1, position)
+}
+
+func TestWaitingQueue_Announce(t *testing.T) {
+	q := NewWaitingQueue()
+	conn1 := testutil.NewMockWebSocketConn()
+	conn2 := testutil.NewMockWebSocketConn()
+	q.Add("user1", conn1)
+	q.InjectPhantoms(1)
+	q.Add("user2", conn2)
+	q.Reserve([]string{"user3"})
+
+	sent := q.Announce(map[string]interface{}{"type": "announcement", "message": "まもなく再開します"})
+	assert.Equal(t, 2, sent)
+
+	for _, conn := range []*testutil.MockWebSocketConn{conn1, conn2} {
+		msg := conn.GetLastMessageAsMap()
+		require.NotNil(t, msg)
+		assert.Equal(t, "announcement", msg["type"])
+	}
+}
@@ -0,0 +1,154 @@
+package queue
+
+import (
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// sentPosition is the queueUpdate last sent to a user.
+type sentPosition struct {
+	position int
+	total    int
+}
+
+// BroadcastStats reports how many queueUpdate messages were sent or saved.
+type BroadcastStats struct {
+	Flushes   uint64 `json:"flushes"`   // broadcasts actually performed
+	Coalesced uint64 `json:"coalesced"` // broadcast requests merged into a pending flush
+	Sent      uint64 `json:"sent"`      // queueUpdate messages sent
+	Skipped   uint64 `json:"skipped"`   // messages not sent because nothing changed for the user
+}
+
+// SetBroadcastInterval sets the coalescing tick for BroadcastPositions.
+// With a positive interval, all requests within one tick are merged into a
+// single broadcast. 0 (the default) broadcasts immediately.
+func (q *WaitingQueue) SetBroadcastInterval(interval time.Duration) {
+	q.broadcastMu.Lock()
+	defer q.broadcastMu.Unlock()
+	q.interval = interval
+}
+
+// SetClock sets the clock the coalescing tick is timed with.
+func (q *WaitingQueue) SetClock(c clock.Clock) {
+	q.broadcastMu.Lock()
+	defer q.broadcastMu.Unlock()
+	q.clock = c
+}
+
+// SetETAEstimator sets the estimator used to add wait estimates to queueUpdate messages.
+func (q *WaitingQueue) SetETAEstimator(eta *ETAEstimator) {
+	q.broadcastMu.Lock()
+	defer q.broadcastMu.Unlock()
+	q.eta = eta
+}
+
+// BroadcastPositions sends position updates to users whose position or total
+// changed since the last update they received.
+// Nothing is sent while broadcasts are frozen.
+func (q *WaitingQueue) BroadcastPositions() {
+	q.broadcastMu.Lock()
+	if q.frozen {
+		q.broadcastMu.Unlock()
+		return
+	}
+	if q.interval <= 0 {
+		q.broadcastMu.Unlock()
+		q.flush()
+		return
+	}
+	if q.pending {
+		q.stats.Coalesced++
+		q.broadcastMu.Unlock()
+		return
+	}
+	q.pending = true
+	q.clock.AfterFunc(q.interval, q.flushPending)
+	q.broadcastMu.Unlock()
+}
+
+// Stats returns the broadcast counters.
+func (q *WaitingQueue) Stats() BroadcastStats {
+	q.broadcastMu.Lock()
+	defer q.broadcastMu.Unlock()
+	return q.stats
+}
+
+// flushPending runs a coalesced broadcast at the end of a tick.
+func (q *WaitingQueue) flushPending() {
+	q.broadcastMu.Lock()
+	q.pending = false
+	q.broadcastMu.Unlock()
+
+	q.flush()
+}
+
+// queueUpdate is a pending queueUpdate message.
+type queueUpdate struct {
+	conn         model.WebSocketConn
+	message      map[string]interface{}
+	realPosition int // position among real users, which the wait estimate is based on
+}
+
+// flush sends queueUpdate messages to users whose position or total changed.
+// Messages are collected under the lock and written after releasing it, so
+// joins and leaves never wait on a connection.
+func (q *WaitingQueue) flush() {
+	// Serialize flushes so updates reach each connection in order
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	q.mu.Lock()
+	total := q.count
+	position := 0
+	realPosition := 0
+	var updates []queueUpdate
+	var skipped uint64
+	for _, user := range q.slots[:q.next] {
+		if user == nil {
+			continue
+		}
+		position++
+		if !user.Phantom {
+			realPosition++
+		}
+
+		current := sentPosition{position: position, total: total}
+		if last, ok := q.lastSent[user]; ok && last == current {
+			skipped++
+			continue
+		}
+		if user.Conn == nil {
+			continue
+		}
+		updates = append(updates, queueUpdate{
+			conn: user.Conn,
+			message: map[string]interface{}{
+				"type":     "queueUpdate",
+				"position": position,
+				"total":    total,
+			},
+			realPosition: realPosition,
+		})
+		q.lastSent[user] = current
+	}
+	q.mu.Unlock()
+
+	q.broadcastMu.Lock()
+	eta := q.eta
+	q.broadcastMu.Unlock()
+
+	for _, u := range updates {
+		if eta != nil {
+			u.message["eta"] = eta.Estimate(u.realPosition)
+		}
+		_ = u.conn.WriteJSON(u.message)
+	}
+
+	q.broadcastMu.Lock()
+	q.stats.Flushes++
+	q.stats.Sent += uint64(len(updates))
+	q.stats.Skipped += skipped
+	q.broadcastMu.Unlock()
+}
@@ -0,0 +1,165 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitingQueue_BroadcastPositions_OnlyChanged(t *testing.T) {
+	q := NewWaitingQueue()
+	conns := make([]*testutil.MockWebSocketConn, 3)
+	for i := range conns {
+		conns[i] = testutil.NewMockWebSocketConn()
+		q.AddUser(&QueueUser{ID: "user" + string(rune('0'+i)), Conn: conns[i]})
+	}
+
+	q.BroadcastPositions()
+	for _, conn := range conns {
+		assert.Len(t, conn.GetMessages(), 1)
+	}
+
+	// 何も変わっていなければ送信しない
+	q.BroadcastPositions()
+	for _, conn := range conns {
+		assert.Len(t, conn.GetMessages(), 1)
+	}
+	assert.Equal(t, uint64(3), q.Stats().Skipped)
+
+	// 先頭が抜けると全員の順番と合計が変わる
+	q.PopFront()
+	q.BroadcastPositions()
+	assert.Len(t, conns[1].GetMessages(), 2)
+	assert.Len(t, conns[2].GetMessages(), 2)
+
+	msg := conns[2].GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, float64(2), msg["position"])
+	assert.Equal(t, float64(2), msg["total"])
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(3), stats.Flushes)
+	assert.Equal(t, uint64(5), stats.Sent)
+}
+
+func TestWaitingQueue_BroadcastPositions_Coalesced(t *testing.T) {
+	q := NewWaitingQueue()
+	q.SetBroadcastInterval(30 * time.Millisecond)
+
+	first := testutil.NewMockWebSocketConn()
+	q.AddUser(&QueueUser{ID: "first", Conn: first})
+
+	// 連続した参加をまとめて1回のブロードキャストにする
+	for i := 0; i < 10; i++ {
+		q.AddUser(&QueueUser{ID: "burst" + string(rune('0'+i)), Conn: testutil.NewMockWebSocketConn()})
+		q.BroadcastPositions()
+	}
+
+	assert.Empty(t, first.GetMessages(), "ティック前は送信しない")
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(first.GetMessages()) > 0
+	})
+	require.NoError(t, err)
+
+	assert.Len(t, first.GetMessages(), 1)
+	msg := first.GetLastMessageAsMap()
+	assert.Equal(t, float64(11), msg["total"])
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(1), stats.Flushes)
+	assert.Equal(t, uint64(9), stats.Coalesced)
+	assert.Equal(t, uint64(11), stats.Sent)
+}
+
+func TestWaitingQueue_BroadcastPositions_CoalescedFakeClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	q := NewWaitingQueue()
+	q.SetClock(clk)
+	q.SetBroadcastInterval(time.Second)
+
+	first := testutil.NewMockWebSocketConn()
+	q.AddUser(&QueueUser{ID: "first", Conn: first})
+	q.BroadcastPositions()
+
+	// ティック内の要求はまとめられる
+	for i := 0; i < 3; i++ {
+		q.AddUser(&QueueUser{ID: "burst" + string(rune('0'+i)), Conn: testutil.NewMockWebSocketConn()})
+		q.BroadcastPositions()
+	}
+	clk.Advance(999 * time.Millisecond)
+	assert.Empty(t, first.GetMessages(), "ティック前は送信しない")
+
+	// ティックの終わりに1回だけ送信する
+	clk.Advance(time.Millisecond)
+	require.Len(t, first.GetMessages(), 1)
+	assert.Equal(t, float64(4), first.GetLastMessageAsMap()["total"])
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(1), stats.Flushes)
+	assert.Equal(t, uint64(3), stats.Coalesced)
+
+	// 送信後の要求は次のティックを予約する
+	q.AddUser(&QueueUser{ID: "late", Conn: testutil.NewMockWebSocketConn()})
+	q.BroadcastPositions()
+	assert.Equal(t, 1, clk.Pending())
+	clk.Advance(time.Second)
+	assert.Len(t, first.GetMessages(), 2)
+	assert.Equal(t, uint64(2), q.Stats().Flushes)
+}
+
+func TestWaitingQueue_BroadcastPositions_ForgetsRemovedUsers(t *testing.T) {
+	q := NewWaitingQueue()
+	conn := testutil.NewMockWebSocketConn()
+	user := &QueueUser{ID: "user1", Conn: conn}
+	q.AddUser(user)
+
+	q.BroadcastPositions()
+	q.Remove("user1")
+	assert.Empty(t, q.lastSent)
+
+	// 並び直したら新しい位置を受け取る
+	q.AddUser(user)
+	q.BroadcastPositions()
+	assert.Len(t, conn.GetMessages(), 2)
+}
+
+// stalledConn is a WebSocketConn whose writes block until released.
+type stalledConn struct {
+	*testutil.MockWebSocketConn
+	release chan struct{}
+}
+
+func (c *stalledConn) WriteJSON(v interface{}) error {
+	<-c.release
+	return c.MockWebSocketConn.WriteJSON(v)
+}
+
+func TestWaitingQueue_BroadcastPositions_DoesNotHoldLock(t *testing.T) {
+	q := NewWaitingQueue()
+	stalled := &stalledConn{MockWebSocketConn: testutil.NewMockWebSocketConn(), release: make(chan struct{})}
+	defer close(stalled.release)
+	q.AddUser(&QueueUser{ID: "slow", Conn: stalled})
+
+	go q.BroadcastPositions()
+	time.Sleep(10 * time.Millisecond)
+
+	// 遅いクライアントへの送信中でも参加・離脱は待たされない
+	done := make(chan struct{})
+	go func() {
+		q.Add("user2", testutil.NewMockWebSocketConn())
+		q.Remove("user2")
+		q.GetPosition("slow")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Add/Remove blocked on a slow connection")
+	}
+}
@@ -0,0 +1,206 @@
+package queue
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// DefaultPollInterval is how often the dispatcher re-checks the queue and stage.
+const DefaultPollInterval = 500 * time.Millisecond
+
+// DelaySource generates the tease delay applied before each promotion.
+// delay.DelayGenerator satisfies this interface.
+type DelaySource interface {
+	Generate() time.Duration
+}
+
+// Promoter promotes the first user in the queue to the Dino stage.
+// handler.WebSocketHandler satisfies this interface.
+type Promoter interface {
+	PromoteFirstUser() *model.User
+}
+
+// StageGate reports whether the Dino stage can accept another player.
+type StageGate interface {
+	IsFree() bool
+}
+
+// Dispatcher advances the waiting queue in the background.
+// It waits until the Dino stage is free, applies the tease delay and then
+// promotes the head of the queue.
+type Dispatcher struct {
+	queue        *WaitingQueue
+	delay        DelaySource
+	promoter     Promoter
+	gate         StageGate
+	pollInterval time.Duration
+
+	mu      sync.Mutex
+	clock   clock.Clock
+	current *model.User // last promoted user, used when no gate is set
+	paused  bool        // promotion held by an admin
+	running bool
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewDispatcher creates a new Dispatcher for the given queue.
+func NewDispatcher(q *WaitingQueue, delay DelaySource, promoter Promoter) *Dispatcher {
+	return &Dispatcher{
+		queue:        q,
+		delay:        delay,
+		promoter:     promoter,
+		pollInterval: DefaultPollInterval,
+		clock:        clock.Real{},
+	}
+}
+
+// SetStageGate sets the gate used to decide whether the stage is free.
+// Without a gate, the stage is free once the last promoted user has left stage1_dino.
+func (d *Dispatcher) SetStageGate(gate StageGate) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.gate = gate
+}
+
+// SetPollInterval sets how often the dispatcher re-checks the queue and stage.
+func (d *Dispatcher) SetPollInterval(interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pollInterval = interval
+}
+
+// SetClock sets the clock polls and tease delays are timed with. It applies from the next Start.
+func (d *Dispatcher) SetClock(c clock.Clock) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clock = c
+}
+
+// Start launches the dispatcher loop. Calling Start on a running dispatcher is a no-op.
+func (d *Dispatcher) Start() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.running {
+		return
+	}
+	d.running = true
+	d.stopCh = make(chan struct{})
+	d.doneCh = make(chan struct{})
+
+	go d.run(d.stopCh, d.doneCh, d.clock, d.pollInterval)
+}
+
+// Stop stops the dispatcher loop and waits for it to exit.
+// A pending tease delay is abandoned without promoting anyone.
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	if !d.running {
+		d.mu.Unlock()
+		return
+	}
+	d.running = false
+	stopCh, doneCh := d.stopCh, d.doneCh
+	d.mu.Unlock()
+
+	close(stopCh)
+	<-doneCh
+}
+
+// SetPaused holds or resumes promotion without stopping the loop.
+// A tease delay already running when the dispatcher is paused is abandoned at promotion time.
+func (d *Dispatcher) SetPaused(paused bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.paused = paused
+}
+
+// IsPaused returns whether promotion is held.
+func (d *Dispatcher) IsPaused() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.paused
+}
+
+// IsRunning returns whether the dispatcher loop is running.
+func (d *Dispatcher) IsRunning() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.running
+}
+
+// run is the dispatcher loop.
+func (d *Dispatcher) run(stopCh <-chan struct{}, doneCh chan<- struct{}, clk clock.Clock, pollInterval time.Duration) {
+	defer close(doneCh)
+
+	ticker := clk.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Wait until a real user can be promoted and the stage is free
+		if d.IsPaused() || d.queue.Peek() == nil || !d.stageFree() {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C():
+				continue
+			}
+		}
+
+		// Tease delay. Users joining meanwhile don't restart the timer (first come first served)
+		wait := d.delay.Generate()
+		log.Printf("[Dispatcher] Stage is free, promoting head of queue in %v", wait)
+
+		timer := clk.NewTimer(wait)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		d.promote()
+	}
+}
+
+// promote promotes the current head of the queue, if any.
+func (d *Dispatcher) promote() {
+	// The head may have disconnected, or an admin paused promotion, during the delay
+	if d.IsPaused() || d.queue.Peek() == nil {
+		return
+	}
+
+	user := d.promoter.PromoteFirstUser()
+	if user == nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.current = user
+	d.mu.Unlock()
+}
+
+// stageFree reports whether another user can be promoted.
+func (d *Dispatcher) stageFree() bool {
+	d.mu.Lock()
+	gate := d.gate
+	current := d.current
+	d.mu.Unlock()
+
+	if gate != nil {
+		return gate.IsFree()
+	}
+	if current == nil {
+		return true
+	}
+
+	// Status is written under the user's lock (session store Update)
+	current.Lock()
+	defer current.Unlock()
+	return current.Status != model.StatusStage1Dino
+}
@@ -0,0 +1,249 @@
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fixedDelay is a DelaySource that always returns the same duration.
+type fixedDelay time.Duration
+
+func (d fixedDelay) Generate() time.Duration {
+	return time.Duration(d)
+}
+
+// mockPromoter pops the head of the queue and records promoted users.
+type mockPromoter struct {
+	mu       sync.Mutex
+	queue    *WaitingQueue
+	promoted []*model.User
+}
+
+func (p *mockPromoter) PromoteFirstUser() *model.User {
+	qu := p.queue.PopFront()
+	if qu == nil {
+		return nil
+	}
+
+	user := &model.User{ID: qu.ID, SessionID: qu.ID, Status: model.StatusStage1Dino}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.promoted = append(p.promoted, user)
+	return user
+}
+
+func (p *mockPromoter) Promoted() []*model.User {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]*model.User(nil), p.promoted...)
+}
+
+// mockGate is a StageGate with a switchable state.
+type mockGate struct {
+	mu   sync.Mutex
+	free bool
+}
+
+func (g *mockGate) IsFree() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.free
+}
+
+func (g *mockGate) Set(free bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.free = free
+}
+
+func newTestDispatcher(q *WaitingQueue, wait time.Duration) (*Dispatcher, *mockPromoter) {
+	p := &mockPromoter{queue: q}
+	d := NewDispatcher(q, fixedDelay(wait), p)
+	d.SetPollInterval(5 * time.Millisecond)
+	return d, p
+}
+
+func TestDispatcher_PromotesHead(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	q.AddUser(&QueueUser{ID: "user2", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, 10*time.Millisecond)
+	d.Start()
+	defer d.Stop()
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 1
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "user1", p.Promoted()[0].ID)
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestDispatcher_WaitsForStage(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	q.AddUser(&QueueUser{ID: "user2", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, 0)
+	d.Start()
+	defer d.Stop()
+
+	// user1 is promoted and occupies the stage
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 1
+	})
+	require.NoError(t, err)
+
+	// user2 must wait while user1 is still playing
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, p.Promoted(), 1)
+
+	// user1 clears the game, the stage becomes free
+	user1 := p.Promoted()[0]
+	user1.Lock()
+	user1.Status = model.StatusRegistering
+	user1.Unlock()
+
+	err = testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 2
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "user2", p.Promoted()[1].ID)
+}
+
+func TestDispatcher_StageGate(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	gate := &mockGate{free: false}
+	d, p := newTestDispatcher(q, 0)
+	d.SetStageGate(gate)
+	d.Start()
+	defer d.Stop()
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, p.Promoted())
+
+	gate.Set(true)
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 1
+	})
+	require.NoError(t, err)
+}
+
+func TestDispatcher_TimerContinuesWhenUsersJoin(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "first", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, 100*time.Millisecond)
+	started := time.Now()
+	d.Start()
+	defer d.Stop()
+
+	// 焦らし時間中に新規ユーザーが来てもタイマーは継続（先着優先）
+	time.Sleep(30 * time.Millisecond)
+	q.AddUser(&QueueUser{ID: "second", Conn: testutil.NewMockWebSocketConn()})
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 1
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "first", p.Promoted()[0].ID)
+	assert.Less(t, time.Since(started), 200*time.Millisecond)
+}
+
+func TestDispatcher_HeadLeavesDuringDelay(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, 50*time.Millisecond)
+	d.Start()
+	defer d.Stop()
+
+	time.Sleep(10 * time.Millisecond)
+	q.Remove("user1")
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Empty(t, p.Promoted())
+}
+
+func TestDispatcher_Stop(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, time.Hour)
+	d.Start()
+	assert.True(t, d.IsRunning())
+
+	done := make(chan struct{})
+	go func() {
+		d.Stop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Stop did not return while a delay was pending")
+	}
+
+	assert.False(t, d.IsRunning())
+	assert.Empty(t, p.Promoted())
+
+	// Stop is idempotent
+	d.Stop()
+}
+
+func TestDispatcher_SkipsPhantoms(t *testing.T) {
+	q := NewWaitingQueue()
+	q.InjectPhantoms(1)
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, 0)
+	d.Start()
+	defer d.Stop()
+
+	// 先頭のファントムを待たずに後ろの実ユーザーを昇格させ、ファントムは昇格しない
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 1
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "user1", p.Promoted()[0].ID)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, p.Promoted(), 1)
+	assert.Equal(t, 1, q.Total())
+}
+
+func TestDispatcher_Paused(t *testing.T) {
+	q := NewWaitingQueue()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	d, p := newTestDispatcher(q, 0)
+	d.SetPaused(true)
+	d.Start()
+	defer d.Stop()
+
+	// 一時停止中は昇格しない
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, p.Promoted())
+	assert.True(t, d.IsPaused())
+
+	d.SetPaused(false)
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(p.Promoted()) == 1
+	})
+	require.NoError(t, err)
+}
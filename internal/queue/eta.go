@@ -0,0 +1,150 @@
+package queue
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// DefaultServiceTime is assumed per user until real service times are recorded.
+const DefaultServiceTime = time.Minute
+
+// defaultETAWindow is the number of recent service times kept for estimates.
+const defaultETAWindow = 20
+
+// ETA is an estimated wait time with a confidence range, in seconds.
+type ETA struct {
+	Seconds     int  `json:"seconds"`
+	Low         int  `json:"low"`
+	High        int  `json:"high"`
+	Pessimistic bool `json:"pessimistic,omitempty"`
+}
+
+// PessimismFormula inflates estimates on purpose:
+// inflated = estimate * Multiplier + position * PerPosition.
+type PessimismFormula struct {
+	Multiplier  float64
+	PerPosition time.Duration
+}
+
+// ETAEstimator estimates wait times from recently recorded service times.
+// A service time is how long a promoted user spent in the stage pipeline.
+type ETAEstimator struct {
+	mu        sync.Mutex
+	samples   []time.Duration // ring buffer of recent service times
+	next      int
+	filled    bool
+	slots     int           // users served at the same time
+	overhead  time.Duration // extra time per promotion (tease delay)
+	pessimism *PessimismFormula
+}
+
+// NewETAEstimator creates a new ETAEstimator for the given number of stage slots.
+func NewETAEstimator(slots int) *ETAEstimator {
+	if slots < 1 {
+		slots = 1
+	}
+	return &ETAEstimator{
+		samples: make([]time.Duration, defaultETAWindow),
+		slots:   slots,
+	}
+}
+
+// SetOverhead sets the extra time spent per promotion, such as the tease delay.
+func (e *ETAEstimator) SetOverhead(overhead time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.overhead = overhead
+}
+
+// SetPessimism enables pessimistic mode with the given formula. nil disables it.
+func (e *ETAEstimator) SetPessimism(formula *PessimismFormula) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.pessimism = formula
+}
+
+// Record adds a measured service time.
+func (e *ETAEstimator) Record(serviceTime time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.samples[e.next] = serviceTime
+	e.next = (e.next + 1) % len(e.samples)
+	if e.next == 0 {
+		e.filled = true
+	}
+}
+
+// SampleCount returns the number of service times currently used for estimates.
+func (e *ETAEstimator) SampleCount() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.sampleCountLocked()
+}
+
+// Estimate returns the estimated wait for the user at the given 1-indexed position.
+// The range is a 95% interval assuming independent service times.
+func (e *ETAEstimator) Estimate(position int) ETA {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if position < 1 {
+		return ETA{}
+	}
+
+	mean, stddev := e.statsLocked()
+	mean += e.overhead.Seconds()
+
+	// Users are served in rounds of `slots`, the user's own round included
+	rounds := float64((position + e.slots - 1) / e.slots)
+	estimate := rounds * mean
+	spread := 1.96 * stddev * math.Sqrt(rounds)
+	low := math.Max(0, estimate-spread)
+	high := estimate + spread
+
+	eta := ETA{}
+	if e.pessimism != nil {
+		inflate := func(v float64) float64 {
+			return v*e.pessimism.Multiplier + float64(position)*e.pessimism.PerPosition.Seconds()
+		}
+		estimate, low, high = inflate(estimate), inflate(low), inflate(high)
+		eta.Pessimistic = true
+	}
+
+	eta.Seconds = int(math.Round(estimate))
+	eta.Low = int(math.Round(low))
+	eta.High = int(math.Round(high))
+	return eta
+}
+
+// statsLocked returns the mean and standard deviation of service times in seconds.
+// Without samples, DefaultServiceTime is used with a wide spread.
+func (e *ETAEstimator) statsLocked() (float64, float64) {
+	n := e.sampleCountLocked()
+	if n == 0 {
+		mean := DefaultServiceTime.Seconds()
+		return mean, mean / 2
+	}
+
+	var sum float64
+	for _, s := range e.samples[:n] {
+		sum += s.Seconds()
+	}
+	mean := sum / float64(n)
+
+	var variance float64
+	for _, s := range e.samples[:n] {
+		d := s.Seconds() - mean
+		variance += d * d
+	}
+	return mean, math.Sqrt(variance / float64(n))
+}
+
+// sampleCountLocked returns the number of recorded samples. Caller must hold e.mu.
+func (e *ETAEstimator) sampleCountLocked() int {
+	if e.filled {
+		return len(e.samples)
+	}
+	return e.next
+}
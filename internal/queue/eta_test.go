@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestETAEstimator_Default(t *testing.T) {
+	e := NewETAEstimator(1)
+
+	eta := e.Estimate(3)
+	assert.Equal(t, int(3*DefaultServiceTime.Seconds()), eta.Seconds)
+	assert.Less(t, eta.Low, eta.Seconds)
+	assert.Greater(t, eta.High, eta.Seconds)
+	assert.False(t, eta.Pessimistic)
+
+	// 列にいなければ推定しない
+	assert.Equal(t, ETA{}, e.Estimate(0))
+}
+
+func TestETAEstimator_Recorded(t *testing.T) {
+	tests := []struct {
+		name     string
+		slots    int
+		overhead time.Duration
+		position int
+		want     int
+	}{
+		{
+			name:     "正常系: 1枠・先頭",
+			slots:    1,
+			position: 1,
+			want:     30,
+		},
+		{
+			name:     "正常系: 1枠・5番目",
+			slots:    1,
+			position: 5,
+			want:     150,
+		},
+		{
+			name:     "正常系: 2枠なら半分のラウンド",
+			slots:    2,
+			position: 4,
+			want:     60,
+		},
+		{
+			name:     "正常系: 焦らし時間を加算",
+			slots:    1,
+			overhead: 20 * time.Second,
+			position: 2,
+			want:     100,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewETAEstimator(tt.slots)
+			e.SetOverhead(tt.overhead)
+			for i := 0; i < 5; i++ {
+				e.Record(30 * time.Second)
+			}
+
+			eta := e.Estimate(tt.position)
+			assert.Equal(t, tt.want, eta.Seconds)
+			// サービス時間にばらつきがなければ幅もない
+			assert.Equal(t, tt.want, eta.Low)
+			assert.Equal(t, tt.want, eta.High)
+		})
+	}
+}
+
+func TestETAEstimator_Window(t *testing.T) {
+	e := NewETAEstimator(1)
+
+	for i := 0; i < defaultETAWindow; i++ {
+		e.Record(10 * time.Second)
+	}
+	for i := 0; i < defaultETAWindow; i++ {
+		e.Record(40 * time.Second)
+	}
+
+	// 古いサンプルは捨てられる
+	assert.Equal(t, defaultETAWindow, e.SampleCount())
+	assert.Equal(t, 40, e.Estimate(1).Seconds)
+}
+
+func TestETAEstimator_Range(t *testing.T) {
+	e := NewETAEstimator(1)
+	e.Record(20 * time.Second)
+	e.Record(40 * time.Second)
+
+	eta := e.Estimate(4)
+	assert.Equal(t, 120, eta.Seconds)
+	assert.Less(t, eta.Low, 120)
+	assert.Greater(t, eta.High, 120)
+	assert.GreaterOrEqual(t, eta.Low, 0)
+}
+
+func TestETAEstimator_Pessimistic(t *testing.T) {
+	e := NewETAEstimator(1)
+	e.Record(30 * time.Second)
+	e.SetPessimism(&PessimismFormula{Multiplier: 2, PerPosition: 10 * time.Second})
+
+	eta := e.Estimate(3)
+	// (3 * 30) * 2 + 3 * 10
+	assert.Equal(t, 210, eta.Seconds)
+	assert.True(t, eta.Pessimistic)
+
+	e.SetPessimism(nil)
+	assert.Equal(t, 90, e.Estimate(3).Seconds)
+}
+
+func TestWaitingQueue_BroadcastPositions_IncludesETA(t *testing.T) {
+	q := NewWaitingQueue()
+	e := NewETAEstimator(1)
+	e.Record(30 * time.Second)
+	q.SetETAEstimator(e)
+
+	conn := testutil.NewMockWebSocketConn()
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	q.AddUser(&QueueUser{ID: "user2", Conn: conn})
+	q.BroadcastPositions()
+
+	msg := conn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	eta, ok := msg["eta"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, float64(60), eta["seconds"])
+}
+
+func TestWaitingQueue_BroadcastPositions_ETAIgnoresPhantoms(t *testing.T) {
+	q := NewWaitingQueue()
+	e := NewETAEstimator(1)
+	e.Record(30 * time.Second)
+	q.SetETAEstimator(e)
+
+	conn := testutil.NewMockWebSocketConn()
+	q.InjectPhantoms(5)
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	q.InjectPhantoms(5)
+	q.AddUser(&QueueUser{ID: "user2", Conn: conn})
+	q.BroadcastPositions()
+
+	// 表示上は12番目でも、待ち時間は前にいる実ユーザー1人分で見積もる
+	msg := conn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, float64(12), msg["position"])
+	eta, ok := msg["eta"].(map[string]interface{})
+	require.True(t, ok)
+	assert.Equal(t, float64(60), eta["seconds"])
+}
@@ -0,0 +1,59 @@
+package queue
+
+// fenwick is a Fenwick (binary indexed) tree over queue slots.
+// Each live slot holds 1, so prefix sums give 1-indexed queue positions.
+type fenwick struct {
+	tree []int // 1-indexed internally
+}
+
+// newFenwick creates a Fenwick tree with n zeroed slots.
+func newFenwick(n int) *fenwick {
+	return &fenwick{tree: make([]int, n+1)}
+}
+
+// buildFenwick creates a Fenwick tree from per-slot values in O(n).
+func buildFenwick(values []int, n int) *fenwick {
+	f := newFenwick(n)
+	copy(f.tree[1:], values)
+	for i := 1; i <= n; i++ {
+		if j := i + (i & -i); j <= n {
+			f.tree[j] += f.tree[i]
+		}
+	}
+	return f
+}
+
+// add adds delta to slot i (0-indexed).
+func (f *fenwick) add(i, delta int) {
+	for i++; i < len(f.tree); i += i & -i {
+		f.tree[i] += delta
+	}
+}
+
+// prefix returns the sum of slots [0, i] (0-indexed).
+func (f *fenwick) prefix(i int) int {
+	sum := 0
+	for i++; i > 0; i -= i & -i {
+		sum += f.tree[i]
+	}
+	return sum
+}
+
+// find returns the smallest 0-indexed slot whose prefix sum reaches k.
+// k must be between 1 and the total sum.
+func (f *fenwick) find(k int) int {
+	n := len(f.tree) - 1
+	step := 1
+	for step*2 <= n {
+		step *= 2
+	}
+
+	pos := 0
+	for ; step > 0; step /= 2 {
+		if next := pos + step; next <= n && f.tree[next] < k {
+			pos = next
+			k -= f.tree[next]
+		}
+	}
+	return pos // pos+1 (1-indexed) converted to 0-indexed
+}
@@ -0,0 +1,41 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFenwick_PrefixAndFind(t *testing.T) {
+	f := newFenwick(10)
+	for _, i := range []int{1, 3, 4, 8} {
+		f.add(i, 1)
+	}
+
+	assert.Equal(t, 0, f.prefix(0))
+	assert.Equal(t, 1, f.prefix(1))
+	assert.Equal(t, 3, f.prefix(4))
+	assert.Equal(t, 4, f.prefix(9))
+
+	assert.Equal(t, 1, f.find(1))
+	assert.Equal(t, 3, f.find(2))
+	assert.Equal(t, 4, f.find(3))
+	assert.Equal(t, 8, f.find(4))
+
+	f.add(3, -1)
+	assert.Equal(t, 4, f.find(2))
+}
+
+func TestFenwick_Build(t *testing.T) {
+	values := []int{1, 0, 1, 1, 0, 0, 1}
+	built := buildFenwick(values, len(values))
+
+	incremental := newFenwick(len(values))
+	for i, v := range values {
+		incremental.add(i, v)
+	}
+
+	for i := range values {
+		assert.Equal(t, incremental.prefix(i), built.prefix(i))
+	}
+}
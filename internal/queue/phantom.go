@@ -0,0 +1,189 @@
+package queue
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+)
+
+// DefaultPhantomMeanLeave is the default mean time between phantom departures.
+const DefaultPhantomMeanLeave = 3 * time.Second
+
+// PhantomConfig configures the synthetic "Disneyland-class" crowd.
+type PhantomConfig struct {
+	Initial   int           // phantoms injected when the crowd starts
+	PerJoin   int           // phantoms injected ahead of each real user who joins
+	MeanLeave time.Duration // mean time between phantom departures
+}
+
+// PhantomStats reports phantom entries separately from real users.
+type PhantomStats struct {
+	Active   int    `json:"active"`
+	Injected uint64 `json:"injected"`
+	Departed uint64 `json:"departed"`
+}
+
+// InjectPhantoms adds n phantom entries to the end of the queue.
+func (q *WaitingQueue) InjectPhantoms(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		q.appendLocked(q.newPhantomLocked())
+	}
+}
+
+// SetPhantomsPerJoin sets how many phantoms are injected ahead of each real user.
+func (q *WaitingQueue) SetPhantomsPerJoin(n int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.phantomsPerJoin = n
+}
+
+// RemoveOldestPhantom removes the phantom closest to the head of the queue.
+// Returns false if there are no phantoms.
+func (q *WaitingQueue) RemoveOldestPhantom() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.phantoms) == 0 {
+		return false
+	}
+	slots := q.index[q.phantoms[0]]
+	q.removeSlot(slots[0])
+	q.phantomStats.Departed++
+	return true
+}
+
+// PhantomStats returns the phantom counters.
+func (q *WaitingQueue) PhantomStats() PhantomStats {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	stats := q.phantomStats
+	stats.Active = len(q.phantoms)
+	return stats
+}
+
+// newPhantomLocked creates a phantom entry. Caller must hold the write lock.
+// Phantoms are always appended, so q.phantoms stays in queue order.
+func (q *WaitingQueue) newPhantomLocked() *QueueUser {
+	q.phantomSeq++
+	id := fmt.Sprintf("phantom-%d", q.phantomSeq)
+	q.phantoms = append(q.phantoms, id)
+	q.phantomStats.Injected++
+	return &QueueUser{ID: id, Phantom: true}
+}
+
+// forgetPhantomLocked drops a removed phantom from q.phantoms.
+// Caller must hold the write lock.
+func (q *WaitingQueue) forgetPhantomLocked(id string) {
+	for i, p := range q.phantoms {
+		if p == id {
+			q.phantoms = append(q.phantoms[:i], q.phantoms[i+1:]...)
+			return
+		}
+	}
+}
+
+// PhantomCrowd injects phantom entries into a queue and makes them leave
+// over time, so the line looks busy even with few real users.
+type PhantomCrowd struct {
+	queue  *WaitingQueue
+	config PhantomConfig
+
+	mu      sync.Mutex
+	clock   clock.Clock
+	running bool
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewPhantomCrowd creates a new PhantomCrowd for the given queue.
+func NewPhantomCrowd(q *WaitingQueue, config PhantomConfig) *PhantomCrowd {
+	if config.MeanLeave <= 0 {
+		config.MeanLeave = DefaultPhantomMeanLeave
+	}
+	return &PhantomCrowd{
+		queue:  q,
+		config: config,
+		clock:  clock.Real{},
+	}
+}
+
+// SetClock sets the clock departures are timed with. It applies from the next Start.
+func (c *PhantomCrowd) SetClock(clk clock.Clock) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clk
+}
+
+// Start injects the initial phantoms and starts the departure loop.
+// Calling Start on a running crowd is a no-op.
+func (c *PhantomCrowd) Start() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.running {
+		return
+	}
+	c.running = true
+	c.stopCh = make(chan struct{})
+	c.doneCh = make(chan struct{})
+
+	c.queue.SetPhantomsPerJoin(c.config.PerJoin)
+	if c.config.Initial > 0 {
+		c.queue.InjectPhantoms(c.config.Initial)
+		c.queue.BroadcastPositions()
+	}
+
+	go c.run(c.stopCh, c.doneCh, c.clock)
+}
+
+// Stop stops injecting and removing phantoms and waits for the loop to exit.
+// Phantoms already in the queue stay until removed.
+func (c *PhantomCrowd) Stop() {
+	c.mu.Lock()
+	if !c.running {
+		c.mu.Unlock()
+		return
+	}
+	c.running = false
+	stopCh, doneCh := c.stopCh, c.doneCh
+	c.mu.Unlock()
+
+	c.queue.SetPhantomsPerJoin(0)
+	close(stopCh)
+	<-doneCh
+}
+
+// IsRunning returns whether the departure loop is running.
+func (c *PhantomCrowd) IsRunning() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.running
+}
+
+// run removes one phantom at a time with exponentially distributed gaps,
+// which looks like people leaving a real line.
+func (c *PhantomCrowd) run(stopCh <-chan struct{}, doneCh chan<- struct{}, clk clock.Clock) {
+	defer close(doneCh)
+
+	for {
+		wait := time.Duration(rand.ExpFloat64() * float64(c.config.MeanLeave))
+		timer := clk.NewTimer(wait)
+		select {
+		case <-stopCh:
+			timer.Stop()
+			return
+		case <-timer.C():
+		}
+
+		if c.queue.RemoveOldestPhantom() {
+			c.queue.BroadcastPositions()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitingQueue_Phantoms_PositionsAndTotals(t *testing.T) {
+	q := NewWaitingQueue()
+	q.InjectPhantoms(3)
+
+	conn := testutil.NewMockWebSocketConn()
+	q.AddUser(&QueueUser{ID: "user1", Conn: conn})
+
+	// 実ユーザーの数とファントム込みの表示人数は別
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, 4, q.Total())
+
+	position, found := q.GetPosition("user1")
+	require.True(t, found)
+	assert.Equal(t, 4, position)
+
+	q.BroadcastPositions()
+	msg := conn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, float64(4), msg["position"])
+	assert.Equal(t, float64(4), msg["total"])
+}
+
+func TestWaitingQueue_Phantoms_RealPosition(t *testing.T) {
+	q := NewWaitingQueue()
+	q.InjectPhantoms(2)
+	q.Add("user1", nil)
+	q.InjectPhantoms(2)
+	q.Add("user2", nil)
+
+	// ファントムは実ユーザーの順番に数えない
+	position, found := q.RealPosition("user2")
+	require.True(t, found)
+	assert.Equal(t, 2, position)
+	position, _ = q.GetPosition("user2")
+	assert.Equal(t, 6, position)
+
+	_, found = q.RealPosition("unknown")
+	assert.False(t, found)
+}
+
+func TestWaitingQueue_Phantoms_NeverPromoted(t *testing.T) {
+	q := NewWaitingQueue()
+	q.InjectPhantoms(2)
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	// 先頭のファントムは飛ばして、後ろの実ユーザーを取り出す
+	head := q.Peek()
+	require.NotNil(t, head)
+	assert.Equal(t, "user1", head.ID)
+
+	user := q.PopFront()
+	require.NotNil(t, user)
+	assert.Equal(t, "user1", user.ID)
+	assert.False(t, user.Phantom)
+
+	// ファントムは列に残るが、ファントムだけなら誰も取り出さない
+	assert.Equal(t, 2, q.Total())
+	assert.Nil(t, q.Peek())
+	assert.Nil(t, q.PopFront())
+	assert.Equal(t, 2, q.Total())
+}
+
+func TestWaitingQueue_Phantoms_PerJoin(t *testing.T) {
+	q := NewWaitingQueue()
+	q.SetPhantomsPerJoin(5)
+
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	q.AddUser(&QueueUser{ID: "user2", Conn: testutil.NewMockWebSocketConn()})
+
+	position, _ := q.GetPosition("user1")
+	assert.Equal(t, 6, position)
+	position, _ = q.GetPosition("user2")
+	assert.Equal(t, 12, position)
+
+	stats := q.PhantomStats()
+	assert.Equal(t, 10, stats.Active)
+	assert.Equal(t, uint64(10), stats.Injected)
+	assert.Equal(t, uint64(0), stats.Departed)
+	assert.Equal(t, 2, q.Len())
+}
+
+func TestWaitingQueue_Phantoms_RemovedWithRealUsers(t *testing.T) {
+	q := NewWaitingQueue()
+	q.SetPhantomsPerJoin(1)
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+
+	// ファントムを直接削除しても整合性を保つ
+	q.Remove("phantom-1")
+	assert.Equal(t, 0, q.PhantomStats().Active)
+	assert.Equal(t, "user1", q.Peek().ID)
+}
+
+func TestPhantomCrowd_Departures(t *testing.T) {
+	q := NewWaitingQueue()
+	conn := testutil.NewMockWebSocketConn()
+
+	crowd := NewPhantomCrowd(q, PhantomConfig{
+		Initial:   5,
+		PerJoin:   0,
+		MeanLeave: 5 * time.Millisecond,
+	})
+	crowd.Start()
+	defer crowd.Stop()
+	assert.True(t, crowd.IsRunning())
+
+	q.AddUser(&QueueUser{ID: "user1", Conn: conn})
+	position, _ := q.GetPosition("user1")
+	assert.LessOrEqual(t, position, 6)
+
+	// ファントムが去ると実ユーザーが先頭になる
+	err := testutil.WaitFor(time.Second, 5*time.Millisecond, func() bool {
+		position, _ := q.GetPosition("user1")
+		return position == 1
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "user1", q.Peek().ID)
+	assert.Equal(t, uint64(5), q.PhantomStats().Departed)
+
+	msg := conn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, float64(1), msg["position"])
+}
+
+func TestPhantomCrowd_Stop(t *testing.T) {
+	q := NewWaitingQueue()
+	crowd := NewPhantomCrowd(q, PhantomConfig{Initial: 3, PerJoin: 2, MeanLeave: time.Hour})
+	crowd.Start()
+	crowd.Stop()
+	assert.False(t, crowd.IsRunning())
+
+	// 停止後は参加時の注入もしない
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	assert.Equal(t, 4, q.Total())
+
+	// Stop is idempotent
+	crowd.Stop()
+}
@@ -3,41 +3,119 @@ package queue
 
 import (
 	"sync"
+	"time"
 
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
 )
 
+// minCapacity is the smallest number of slots the queue keeps allocated.
+const minCapacity = 64
+
 // QueueUser represents a user in the waiting queue.
 type QueueUser struct {
-	ID   string
-	Conn model.WebSocketConn // WebSocket connection
+	ID       string
+	Conn     model.WebSocketConn // WebSocket connection
+	Phantom  bool                // Synthetic entry, never promoted (see phantom.go)
+	Reserved bool                // Entry holding a place until its user reconnects (see reserve.go)
+	Restored bool                // Reserved by a snapshot restore, dropped if not reclaimed in time
+}
+
+// promotable reports whether the entry may leave the queue for the next stage.
+func (u *QueueUser) promotable() bool {
+	return !u.Phantom && !u.Reserved
 }
 
 // WaitingQueue manages users waiting in line.
+//
+// Every join gets the next slot (join sequence number). A Fenwick tree over the
+// slots counts live users, so Add, Remove and GetPosition are O(log n).
+// Slots are compacted once most of them are empty.
 type WaitingQueue struct {
-	users []*QueueUser
+	slots []*QueueUser     // slot -> user, nil once removed
+	tree  *fenwick         // 1 for each live slot
+	index map[string][]int // userID -> live slots, oldest first
+	next  int              // next unused slot
+	count int              // number of live entries, phantoms included
 	mu    sync.RWMutex
+
+	// Phantom entries (see phantom.go)
+	phantoms        []string // phantom IDs in queue order
+	phantomsPerJoin int      // phantoms injected ahead of each real user
+	phantomSeq      int
+	phantomStats    PhantomStats
+
+	// Position broadcasting (see broadcast.go)
+	lastSent    map[*QueueUser]sentPosition // last queueUpdate sent to each user
+	flushMu     sync.Mutex
+	broadcastMu sync.Mutex
+	interval    time.Duration // coalescing tick, 0 sends immediately
+	pending     bool          // a coalesced flush is scheduled
+	frozen      bool          // broadcasts suspended by an admin (see admin.go)
+	stats       BroadcastStats
+	eta         *ETAEstimator // optional, adds wait estimates to queueUpdate
+	clock       clock.Clock   // times the coalescing tick
 }
 
 // NewWaitingQueue creates a new empty waiting queue.
 func NewWaitingQueue() *WaitingQueue {
 	return &WaitingQueue{
-		users: make([]*QueueUser, 0),
+		slots:    make([]*QueueUser, minCapacity),
+		tree:     newFenwick(minCapacity),
+		index:    make(map[string][]int),
+		lastSent: make(map[*QueueUser]sentPosition),
+		clock:    clock.Real{},
 	}
 }
 
 // Add adds a user to the end of the queue by userID and connection.
 func (q *WaitingQueue) Add(userID string, conn model.WebSocketConn) {
-	q.mu.Lock()
-	defer q.mu.Unlock()
-	q.users = append(q.users, &QueueUser{ID: userID, Conn: conn})
+	q.AddUser(&QueueUser{ID: userID, Conn: conn})
 }
 
 // AddUser adds a QueueUser to the end of the queue.
 func (q *WaitingQueue) AddUser(user *QueueUser) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.users = append(q.users, user)
+
+	if !user.Phantom {
+		for i := 0; i < q.phantomsPerJoin; i++ {
+			q.appendLocked(q.newPhantomLocked())
+		}
+	}
+	q.appendLocked(user)
+}
+
+// appendLocked adds an entry to the end of the queue. Caller must hold the write lock.
+func (q *WaitingQueue) appendLocked(user *QueueUser) {
+	if q.next == len(q.slots) {
+		q.rebuild(max(minCapacity, 2*(q.count+1)))
+	}
+
+	slot := q.next
+	q.next++
+	q.slots[slot] = user
+	q.tree.add(slot, 1)
+	q.index[user.ID] = append(q.index[user.ID], slot)
+	q.count++
+}
+
+// Rebind replaces the connection of a user already in the queue, keeping
+// their place. The next broadcast is sent to the new connection even if the
+// position hasn't changed. Returns false if the user is not queued or only
+// holds a reserved place (see Reclaim).
+func (q *WaitingQueue) Rebind(userID string, conn model.WebSocketConn) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, slot := range q.index[userID] {
+		if user := q.slots[slot]; !user.Reserved {
+			user.Conn = conn
+			delete(q.lastSent, user)
+			return true
+		}
+	}
+	return false
 }
 
 // Remove removes a user from the queue by ID.
@@ -45,63 +123,160 @@ func (q *WaitingQueue) Remove(userID string) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	for i, u := range q.users {
-		if u.ID == userID {
-			q.users = append(q.users[:i], q.users[i+1:]...)
-			return
-		}
+	slots, ok := q.index[userID]
+	if !ok {
+		return
 	}
+	q.removeSlot(slots[0])
+	q.compactLocked()
 }
 
 // GetPosition returns the position of a user in the queue (1-indexed).
+// Phantom entries ahead of the user count towards the position.
 // Returns 0 and false if the user is not found.
 func (q *WaitingQueue) GetPosition(userID string) (int, bool) {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
 
-	for i, u := range q.users {
-		if u.ID == userID {
-			return i + 1, true
+	slots, ok := q.index[userID]
+	if !ok {
+		return 0, false
+	}
+	return q.tree.prefix(slots[0]), true
+}
+
+// RealPosition returns the 1-indexed position of the user counting only real
+// users, so phantoms ahead don't push it back.
+func (q *WaitingQueue) RealPosition(userID string) (int, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	slots, ok := q.index[userID]
+	if !ok {
+		return 0, false
+	}
+	position := q.tree.prefix(slots[0])
+	for _, id := range q.phantoms {
+		if q.index[id][0] < slots[0] {
+			position--
 		}
 	}
-	return 0, false
+	return position, true
 }
 
-// Len returns the number of users in the queue.
+// Len returns the number of real users in the queue.
 func (q *WaitingQueue) Len() int {
 	q.mu.RLock()
 	defer q.mu.RUnlock()
-	return len(q.users)
+	return q.count - len(q.phantoms)
 }
 
-// PopFront removes and returns the first user in the queue.
-// Returns nil if the queue is empty.
+// Total returns the number of entries shown to users, phantoms included.
+func (q *WaitingQueue) Total() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.count
+}
+
+// Peek returns the first user in the queue who can be promoted, without removing it.
+// Phantoms and reserved places whose user hasn't reconnected are skipped and
+// keep their place. Returns nil if no entry can be promoted.
+func (q *WaitingQueue) Peek() *QueueUser {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	slot, ok := q.firstPromotableLocked()
+	if !ok {
+		return nil
+	}
+	return q.slots[slot]
+}
+
+// PopFront removes and returns the first user in the queue who can be promoted.
+// Like Peek it skips phantoms and reserved places, so they are never promoted
+// and don't hold up the users behind them.
+// Returns nil if no entry can be promoted.
 func (q *WaitingQueue) PopFront() *QueueUser {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	if len(q.users) == 0 {
+	slot, ok := q.firstPromotableLocked()
+	if !ok {
 		return nil
 	}
-
-	user := q.users[0]
-	q.users = q.users[1:]
+	user := q.slots[slot]
+	q.removeSlot(slot)
 	return user
 }
 
-// BroadcastPositions sends position updates to all users in the queue.
-func (q *WaitingQueue) BroadcastPositions() {
-	q.mu.RLock()
-	defer q.mu.RUnlock()
+// firstPromotableLocked returns the slot of the first entry that can be promoted.
+// It scans forward from the head, so its cost grows with the number of
+// phantoms and reserved places ahead. Caller must hold the lock.
+func (q *WaitingQueue) firstPromotableLocked() (int, bool) {
+	if q.count == 0 {
+		return 0, false
+	}
+	for slot := q.tree.find(1); slot < q.next; slot++ {
+		if user := q.slots[slot]; user != nil && user.promotable() {
+			return slot, true
+		}
+	}
+	return 0, false
+}
+
+// removeSlot frees a live slot. Caller must hold the write lock.
+func (q *WaitingQueue) removeSlot(slot int) {
+	user := q.slots[slot]
+	q.slots[slot] = nil
+	q.tree.add(slot, -1)
+	delete(q.lastSent, user)
+	q.count--
+	if user.Phantom {
+		q.forgetPhantomLocked(user.ID)
+	}
 
-	total := len(q.users)
-	for i, user := range q.users {
-		if user.Conn != nil {
-			_ = user.Conn.WriteJSON(map[string]interface{}{
-				"type":     "queueUpdate",
-				"position": i + 1,
-				"total":    total,
-			})
+	slots := q.index[user.ID]
+	for i, s := range slots {
+		if s == slot {
+			slots = append(slots[:i], slots[i+1:]...)
+			break
 		}
 	}
+	if len(slots) == 0 {
+		delete(q.index, user.ID)
+	} else {
+		q.index[user.ID] = slots
+	}
+}
+
+// compactLocked rebuilds the queue once the live users only fill a quarter
+// of the used slots. Caller must hold the write lock.
+func (q *WaitingQueue) compactLocked() {
+	if q.next > minCapacity && q.count*4 < q.next {
+		q.rebuild(max(minCapacity, 2*q.count))
+	}
+}
+
+// rebuild renumbers live users into a fresh slot array of the given capacity.
+// Caller must hold the write lock.
+func (q *WaitingQueue) rebuild(capacity int) {
+	slots := make([]*QueueUser, capacity)
+	values := make([]int, capacity)
+	index := make(map[string][]int, len(q.index))
+
+	next := 0
+	for _, user := range q.slots[:q.next] {
+		if user == nil {
+			continue
+		}
+		slots[next] = user
+		values[next] = 1
+		index[user.ID] = append(index[user.ID], next)
+		next++
+	}
+
+	q.slots = slots
+	q.tree = buildFenwick(values, capacity)
+	q.index = index
+	q.next = next
 }
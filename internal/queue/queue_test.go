@@ -1,6 +1,8 @@
 package queue
 
 import (
+	"fmt"
+	"math/rand"
 	"sync"
 	"testing"
 	"time"
@@ -176,6 +178,21 @@ func TestWaitingQueue_PopFront(t *testing.T) {
 	assert.Nil(t, user)
 }
 
+func TestWaitingQueue_Peek(t *testing.T) {
+	q := NewWaitingQueue()
+
+	// 空のキュー
+	assert.Nil(t, q.Peek())
+
+	q.AddUser(&QueueUser{ID: "user1", Conn: testutil.NewMockWebSocketConn()})
+	q.AddUser(&QueueUser{ID: "user2", Conn: testutil.NewMockWebSocketConn()})
+
+	// 先頭を参照しても取り出さない
+	user := q.Peek()
+	assert.Equal(t, "user1", user.ID)
+	assert.Equal(t, 2, q.Len())
+}
+
 func TestWaitingQueue_Concurrent(t *testing.T) {
 	q := NewWaitingQueue()
 	var wg sync.WaitGroup
@@ -207,3 +224,111 @@ func TestWaitingQueue_Concurrent(t *testing.T) {
 	// 正常に完了したことを確認（パニックしていない）
 	assert.True(t, true)
 }
+
+func TestWaitingQueue_MatchesReference(t *testing.T) {
+	// 圧縮・再構築を跨いでも単純なスライス実装と同じ順序になること
+	q := NewWaitingQueue()
+	var ref []string
+	rng := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		switch op := rng.Intn(10); {
+		case op < 5:
+			id := fmt.Sprintf("user%d", i)
+			q.Add(id, nil)
+			ref = append(ref, id)
+		case op < 8 && len(ref) > 0:
+			j := rng.Intn(len(ref))
+			q.Remove(ref[j])
+			ref = append(ref[:j], ref[j+1:]...)
+		case len(ref) > 0:
+			user := q.PopFront()
+			require.NotNil(t, user)
+			assert.Equal(t, ref[0], user.ID)
+			ref = ref[1:]
+		}
+
+		require.Equal(t, len(ref), q.Len())
+		if len(ref) > 0 {
+			j := rng.Intn(len(ref))
+			position, found := q.GetPosition(ref[j])
+			require.True(t, found)
+			require.Equal(t, j+1, position)
+			require.Equal(t, ref[0], q.Peek().ID)
+		}
+	}
+}
+
+func benchmarkQueue(b *testing.B, size int) *WaitingQueue {
+	b.Helper()
+	q := NewWaitingQueue()
+	for i := 0; i < size; i++ {
+		q.Add(fmt.Sprintf("user%d", i), nil)
+	}
+	return q
+}
+
+func BenchmarkWaitingQueue_Add(b *testing.B) {
+	for _, size := range []int{10000, 100000} {
+		b.Run(fmt.Sprintf("users=%d", size), func(b *testing.B) {
+			q := benchmarkQueue(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := fmt.Sprintf("bench%d", i)
+				q.Add(id, nil)
+				q.Remove(id)
+			}
+		})
+	}
+}
+
+func BenchmarkWaitingQueue_Remove(b *testing.B) {
+	for _, size := range []int{10000, 100000} {
+		b.Run(fmt.Sprintf("users=%d", size), func(b *testing.B) {
+			q := benchmarkQueue(b, size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// 中間ユーザーを抜いて最後尾に並び直す
+				id := fmt.Sprintf("user%d", (i*7919)%size)
+				q.Remove(id)
+				q.Add(id, nil)
+			}
+		})
+	}
+}
+
+func BenchmarkWaitingQueue_GetPosition(b *testing.B) {
+	for _, size := range []int{10000, 100000} {
+		b.Run(fmt.Sprintf("users=%d", size), func(b *testing.B) {
+			q := benchmarkQueue(b, size)
+			ids := make([]string, size)
+			for i := range ids {
+				ids[i] = fmt.Sprintf("user%d", i)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				q.GetPosition(ids[(i*7919)%size])
+			}
+		})
+	}
+}
+
+func TestWaitingQueue_Rebind(t *testing.T) {
+	q := NewWaitingQueue()
+	q.SetBroadcastInterval(0)
+	oldConn := testutil.NewMockWebSocketConn()
+	q.Add("user1", oldConn)
+	q.Add("user2", testutil.NewMockWebSocketConn())
+	q.BroadcastPositions()
+
+	newConn := testutil.NewMockWebSocketConn()
+	assert.True(t, q.Rebind("user2", newConn))
+	assert.False(t, q.Rebind("unknown", newConn))
+	assert.Equal(t, 2, q.Len(), "二重登録されない")
+
+	// 順位が変わらなくても新しい接続に通知される
+	q.BroadcastPositions()
+	msg := newConn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, float64(2), msg["position"])
+}
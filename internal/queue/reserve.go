@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// IDs returns the IDs of real users (reserved places included) in queue order.
+func (q *WaitingQueue) IDs() []string {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	ids := make([]string, 0, q.count-len(q.phantoms))
+	for _, user := range q.slots[:q.next] {
+		if user == nil || user.Phantom {
+			continue
+		}
+		ids = append(ids, user.ID)
+	}
+	return ids
+}
+
+// Reserve appends places for users who are expected to reconnect, such as
+// users sent back by a failure. A reserved place keeps its position but is
+// not promoted until Reclaim attaches a connection.
+func (q *WaitingQueue) Reserve(userIDs []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, id := range userIDs {
+		q.appendLocked(&QueueUser{ID: id, Reserved: true})
+	}
+}
+
+// Restore appends reserved places for users restored from a snapshot.
+// Unlike other reservations, DropRestored removes them if they aren't reclaimed.
+func (q *WaitingQueue) Restore(userIDs []string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, id := range userIDs {
+		q.appendLocked(&QueueUser{ID: id, Reserved: true, Restored: true})
+	}
+}
+
+// Reclaim attaches a connection to the user's reserved place.
+// Returns false if the user has no reserved place.
+func (q *WaitingQueue) Reclaim(userID string, conn model.WebSocketConn) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, slot := range q.index[userID] {
+		if user := q.slots[slot]; user.Reserved {
+			user.Reserved = false
+			user.Restored = false
+			user.Conn = conn
+			return true
+		}
+	}
+	return false
+}
+
+// Leave removes the user's connected entry when their socket closes.
+// A reserved place, such as one a failure penalty gave them, stays for
+// Reclaim. Returns false if the user had no connected entry.
+func (q *WaitingQueue) Leave(userID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, slot := range q.index[userID] {
+		if !q.slots[slot].Reserved {
+			q.removeSlot(slot)
+			q.compactLocked()
+			return true
+		}
+	}
+	return false
+}
+
+// DropRestored removes the places reserved by Restore that were never
+// reclaimed. Other reservations stay. Returns the number of places removed.
+func (q *WaitingQueue) DropRestored() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	dropped := 0
+	for slot, user := range q.slots[:q.next] {
+		if user != nil && user.Restored {
+			q.removeSlot(slot)
+			dropped++
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitingQueue_IDs(t *testing.T) {
+	q := NewWaitingQueue()
+	q.Add("user1", testutil.NewMockWebSocketConn())
+	q.InjectPhantoms(2)
+	q.Add("user2", testutil.NewMockWebSocketConn())
+	q.Reserve([]string{"user3"})
+
+	// ファントムは含まない
+	assert.Equal(t, []string{"user1", "user2", "user3"}, q.IDs())
+}
+
+func TestWaitingQueue_ReserveAndReclaim(t *testing.T) {
+	q := NewWaitingQueue()
+	q.Reserve([]string{"user1", "user2"})
+	q.Add("user3", testutil.NewMockWebSocketConn())
+
+	// 予約された場所は順番を保つ
+	position, found := q.GetPosition("user2")
+	require.True(t, found)
+	assert.Equal(t, 2, position)
+
+	// 再接続前の予約は飛ばして、後ろの接続中のユーザーを昇格させる
+	head := q.Peek()
+	require.NotNil(t, head)
+	assert.Equal(t, "user3", head.ID)
+
+	conn := testutil.NewMockWebSocketConn()
+	assert.True(t, q.Reclaim("user1", conn))
+	assert.False(t, q.Reclaim("user1", conn), "二重の再接続は新規扱い")
+	assert.False(t, q.Reclaim("user3", conn), "予約のないユーザー")
+
+	head = q.Peek()
+	require.NotNil(t, head)
+	assert.Equal(t, "user1", head.ID)
+	assert.Equal(t, conn, head.Conn)
+}
+
+func TestWaitingQueue_PopFront_SkipsUnpromotableHead(t *testing.T) {
+	q := NewWaitingQueue()
+	q.Reserve([]string{"reserved"})
+	q.InjectPhantoms(1)
+	q.Add("user1", testutil.NewMockWebSocketConn())
+	q.Add("user2", testutil.NewMockWebSocketConn())
+
+	// 予約とファントムが先頭でも、待っている実ユーザーが順に昇格する
+	user := q.PopFront()
+	require.NotNil(t, user)
+	assert.Equal(t, "user1", user.ID)
+	user = q.PopFront()
+	require.NotNil(t, user)
+	assert.Equal(t, "user2", user.ID)
+	assert.Nil(t, q.PopFront())
+
+	// 飛ばされた予約は元の位置のまま
+	position, found := q.GetPosition("reserved")
+	require.True(t, found)
+	assert.Equal(t, 1, position)
+	assert.Equal(t, 2, q.Total())
+}
+
+func TestWaitingQueue_DropRestored(t *testing.T) {
+	q := NewWaitingQueue()
+	q.Restore([]string{"user1", "user2", "user3"})
+	q.Reclaim("user2", testutil.NewMockWebSocketConn())
+	q.Reserve([]string{"requeued"})
+
+	// 復元した予約だけが消え、失敗後に確保した場所は残る
+	assert.Equal(t, 2, q.DropRestored())
+	assert.Equal(t, []string{"user2", "requeued"}, q.IDs())
+	assert.Equal(t, "user2", q.Peek().ID)
+	assert.True(t, q.Reclaim("requeued", testutil.NewMockWebSocketConn()))
+}
+
+func TestWaitingQueue_Leave(t *testing.T) {
+	q := NewWaitingQueue()
+	q.Add("user1", testutil.NewMockWebSocketConn())
+	q.Reserve([]string{"user2"})
+
+	// 接続中の場所だけ外れ、確保された場所は残る
+	assert.True(t, q.Leave("user1"))
+	assert.False(t, q.Leave("user2"))
+	assert.Equal(t, []string{"user2"}, q.IDs())
+	assert.False(t, q.Leave("unknown"))
+}
@@ -0,0 +1,272 @@
+package room
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/kyiku/hackz-ptera-back/internal/middleware"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	ws "github.com/kyiku/hackz-ptera-back/internal/websocket"
+)
+
+// SessionLookup reads the user behind a session.
+type SessionLookup interface {
+	View(sessionID string, fn func(user *model.User) error) error
+}
+
+// Manager holds the rooms of a server and routes session-scoped calls to the
+// room each session is bound to. It satisfies the queue and slot interfaces
+// the shared handlers depend on, so those handlers don't need to know about rooms.
+//
+// Rooms must be registered before the server starts serving.
+type Manager struct {
+	store SessionLookup
+	rooms map[string]*Room
+	order []*Room // registration order, the first room is the default
+}
+
+// NewManager creates a new Manager.
+func NewManager(store SessionLookup) *Manager {
+	return &Manager{
+		store: store,
+		rooms: make(map[string]*Room),
+	}
+}
+
+// Register adds a room. The first registered room is the default.
+func (m *Manager) Register(r *Room) {
+	if _, exists := m.rooms[r.Name]; exists {
+		return
+	}
+	m.rooms[r.Name] = r
+	m.order = append(m.order, r)
+}
+
+// Get returns the named room.
+func (m *Manager) Get(name string) (*Room, bool) {
+	r, ok := m.rooms[name]
+	return r, ok
+}
+
+// Default returns the room used when none is requested.
+func (m *Manager) Default() *Room {
+	if len(m.order) == 0 {
+		return nil
+	}
+	return m.order[0]
+}
+
+// Rooms returns all rooms in registration order.
+func (m *Manager) Rooms() []*Room {
+	return append([]*Room(nil), m.order...)
+}
+
+// ForSession returns the room the session is bound to, or the default room.
+// It locks the session's user, so it must not be called from within the
+// session store's Update or View for that session.
+func (m *Manager) ForSession(sessionID string) *Room {
+	var name string
+	_ = m.store.View(sessionID, func(user *model.User) error {
+		name = user.Queue
+		return nil
+	})
+	if r, ok := m.rooms[name]; ok {
+		return r
+	}
+	return m.Default()
+}
+
+// Evict cleans up after a session removed from the store: it leaves its
+// queue, frees its Dino slot and has its socket closed with ws.CloseSessionExpired.
+// It has the signature of session.SessionStore's eviction callback.
+func (m *Manager) Evict(e session.Eviction) {
+	e.User.Lock()
+	name, conn := e.User.Queue, e.User.Conn
+	e.User.Conn = nil
+	e.User.Unlock()
+
+	r, ok := m.rooms[name]
+	if !ok {
+		r = m.Default()
+	}
+	r.Queue.Remove(e.SessionID)
+	r.Slots.Release(e.SessionID)
+	r.Queue.BroadcastPositions()
+
+	if conn != nil {
+		_ = conn.WriteJSON(map[string]interface{}{
+			"type":    "session_expired",
+			"reason":  e.Reason,
+			"message": "セッションの有効期限が切れました",
+		})
+		if closable, ok := conn.(ws.ClosableConn); ok {
+			_ = closable.CloseWithReason(ws.CloseSessionExpired, "session expired")
+		} else {
+			_ = conn.Close()
+		}
+	}
+}
+
+// Start starts every room.
+func (m *Manager) Start() {
+	for _, r := range m.order {
+		r.Start()
+	}
+}
+
+// Stop stops every room.
+func (m *Manager) Stop() {
+	for _, r := range m.order {
+		r.Stop()
+	}
+}
+
+// Add adds the user to the queue of their room.
+func (m *Manager) Add(userID string, conn model.WebSocketConn) {
+	m.ForSession(userID).Queue.Add(userID, conn)
+}
+
+// Remove removes the user from the queue of their room.
+func (m *Manager) Remove(userID string) {
+	m.ForSession(userID).Queue.Remove(userID)
+}
+
+// GetPosition returns the user's position in the queue of their room.
+func (m *Manager) GetPosition(userID string) (int, bool) {
+	return m.ForSession(userID).Queue.GetPosition(userID)
+}
+
+// BroadcastPositions broadcasts positions in every room.
+func (m *Manager) BroadcastPositions() {
+	for _, r := range m.order {
+		r.Queue.BroadcastPositions()
+	}
+}
+
+// Acquire takes a Dino slot in the session's room. See slot.Manager.Acquire.
+func (m *Manager) Acquire(sessionID string) (held, acquired bool) {
+	return m.ForSession(sessionID).Slots.Acquire(sessionID)
+}
+
+// Release frees the session's Dino slot in its room.
+func (m *Manager) Release(sessionID string) bool {
+	return m.ForSession(sessionID).Slots.Release(sessionID)
+}
+
+// Requeue puts a failed user back at the end of the queue they are bound to,
+// unless they are still in it. Unlike Add it does not lock the user, so it
+// can run as a failure.Effect. The place is reserved: closing the failed
+// user's socket keeps it, and they reclaim it when they reconnect.
+func (m *Manager) Requeue(user *model.User) {
+	m.reserveHeld(user)
+}
+
+// Place puts a penalized user at the 1-indexed position of the queue they are
+// bound to, moving them there if they are still in it. Like Requeue it does
+// not lock the user, and a new place is reserved until they reconnect.
+// It implements failure.Placer.
+func (m *Manager) Place(user *model.User, position int) {
+	if r := m.reserveHeld(user); r != nil {
+		r.Queue.MoveTo(user.SessionID, position)
+	}
+}
+
+// QueueLen returns the number of entries, phantoms included, of the queue
+// the user is bound to. It implements failure.Placer.
+func (m *Manager) QueueLen(user *model.User) int {
+	if r := m.roomOf(user); r != nil {
+		return r.Queue.Total()
+	}
+	return 0
+}
+
+// reserveHeld reserves a place at the end of the user's queue unless they
+// are in it already, and returns their room. The caller must hold the user's lock.
+func (m *Manager) reserveHeld(user *model.User) *Room {
+	r := m.roomOf(user)
+	if r == nil {
+		return nil
+	}
+	if _, queued := r.Queue.GetPosition(user.SessionID); !queued {
+		r.Queue.Reserve([]string{user.SessionID})
+	}
+	return r
+}
+
+// roomOf returns the room the user is bound to, read from the user directly.
+// The caller must hold the user's lock.
+func (m *Manager) roomOf(user *model.User) *Room {
+	if r, ok := m.rooms[user.Queue]; ok {
+		return r
+	}
+	return m.Default()
+}
+
+// IDs returns the queued session IDs of every room, room by room.
+func (m *Manager) IDs() []string {
+	var ids []string
+	for _, r := range m.order {
+		ids = append(ids, r.Queue.IDs()...)
+	}
+	return ids
+}
+
+// Restore reserves places for restored sessions in the queue of their rooms,
+// keeping their relative order.
+func (m *Manager) Restore(userIDs []string) {
+	byRoom := make(map[*Room][]string)
+	for _, id := range userIDs {
+		r := m.ForSession(id)
+		byRoom[r] = append(byRoom[r], id)
+	}
+	for r, ids := range byRoom {
+		r.Queue.Restore(ids)
+	}
+}
+
+// DropRestored drops unclaimed restored places in every room.
+func (m *Manager) DropRestored() int {
+	dropped := 0
+	for _, r := range m.order {
+		dropped += r.Queue.DropRestored()
+	}
+	return dropped
+}
+
+// BySession returns a handler that runs the session's room handler.
+// Requests without a valid session go to the default room, whose handler reports the error.
+func (m *Manager) BySession(fn func(r *Room) echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		r := m.Default()
+		if sessionID, ok := middleware.SessionID(c); ok {
+			r = m.ForSession(sessionID)
+		}
+		return fn(r)(c)
+	}
+}
+
+// ByName returns a handler that runs the handler of the room named by the
+// "queue" path parameter or query parameter, or the default room if neither is set.
+func (m *Manager) ByName(fn func(r *Room) echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		name := c.Param("queue")
+		if name == "" {
+			name = c.QueryParam("queue")
+		}
+
+		r := m.Default()
+		if name != "" {
+			var ok bool
+			if r, ok = m.rooms[name]; !ok {
+				return c.JSON(http.StatusNotFound, map[string]interface{}{
+					"error":   true,
+					"code":    "QUEUE_NOT_FOUND",
+					"message": "指定された待機列は存在しません",
+				})
+			}
+		}
+		return fn(r)(c)
+	}
+}
@@ -0,0 +1,431 @@
+package room
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/failure"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/snapshot"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	ws "github.com/kyiku/hackz-ptera-back/internal/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestManager returns a manager with a "public" (default) and a "staging" room.
+func newTestManager(t *testing.T) (*Manager, *session.SessionStore) {
+	t.Helper()
+
+	store := session.NewSessionStore()
+	conns := ws.NewRegistry(ws.PolicyTakeover)
+	m := NewManager(store)
+	m.Register(New(Config{Name: "public", DinoSlots: 1}, store, conns))
+	m.Register(New(Config{Name: "staging", DinoSlots: 2}, store, conns))
+	return m, store
+}
+
+func TestManager_Rooms(t *testing.T) {
+	m, _ := newTestManager(t)
+
+	assert.Equal(t, "public", m.Default().Name)
+	require.Len(t, m.Rooms(), 2)
+
+	staging, ok := m.Get("staging")
+	require.True(t, ok)
+	assert.Equal(t, 2, staging.Slots.Size())
+
+	_, ok = m.Get("unknown")
+	assert.False(t, ok)
+
+	// 同名の登録は無視
+	m.Register(New(Config{Name: "public"}, session.NewSessionStore(), nil))
+	assert.Len(t, m.Rooms(), 2)
+}
+
+func TestManager_RoutesBySession(t *testing.T) {
+	m, store := newTestManager(t)
+	staging, _ := m.Get("staging")
+
+	user, sessionID := store.Create()
+	user.Queue = "staging"
+	_, unbound := store.Create()
+
+	m.Add(sessionID, testutil.NewMockWebSocketConn())
+	m.Add(unbound, testutil.NewMockWebSocketConn())
+
+	// セッションが紐づく待機列に入る
+	assert.Equal(t, 1, staging.Queue.Len())
+	assert.Equal(t, 1, m.Default().Queue.Len())
+	position, found := m.GetPosition(sessionID)
+	require.True(t, found)
+	assert.Equal(t, 1, position)
+
+	held, acquired := m.Acquire(sessionID)
+	assert.True(t, held)
+	assert.True(t, acquired)
+	assert.True(t, staging.Slots.Holds(sessionID))
+	assert.False(t, m.Default().Slots.Holds(sessionID))
+	assert.True(t, m.Release(sessionID))
+
+	m.Remove(sessionID)
+	assert.Equal(t, 0, staging.Queue.Len())
+	assert.Equal(t, 1, m.Default().Queue.Len())
+}
+
+func TestManager_RestoreAndIDs(t *testing.T) {
+	m, store := newTestManager(t)
+	staging, _ := m.Get("staging")
+
+	var ids []string
+	for _, name := range []string{"staging", "public", "staging"} {
+		user, sessionID := store.Create()
+		user.Queue = name
+		ids = append(ids, sessionID)
+	}
+
+	m.Restore(ids)
+	assert.Equal(t, []string{ids[0], ids[2]}, staging.Queue.IDs())
+	assert.Equal(t, []string{ids[1]}, m.Default().Queue.IDs())
+	assert.ElementsMatch(t, ids, m.IDs())
+
+	assert.Equal(t, 3, m.DropRestored())
+	assert.Empty(t, m.IDs())
+}
+
+func TestManager_ByName(t *testing.T) {
+	tests := []struct {
+		name      string
+		param     string
+		query     string
+		wantRoom  string
+		wantError bool
+	}{
+		{name: "正常系: パスで指定", param: "staging", wantRoom: "staging"},
+		{name: "正常系: クエリで指定", query: "staging", wantRoom: "staging"},
+		{name: "正常系: 指定なしはデフォルト", wantRoom: "public"},
+		{name: "異常系: 存在しない待機列", param: "unknown", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, _ := newTestManager(t)
+
+			path := "/ws"
+			if tt.query != "" {
+				path += "?queue=" + tt.query
+			}
+			tc := testutil.NewTestContext(http.MethodGet, path, nil)
+			if tt.param != "" {
+				tc.Context.SetParamNames("queue")
+				tc.Context.SetParamValues(tt.param)
+			}
+
+			var got string
+			err := m.ByName(func(r *Room) echo.HandlerFunc {
+				return func(c echo.Context) error {
+					got = r.Name
+					return nil
+				}
+			})(tc.Context)
+			require.NoError(t, err)
+
+			if tt.wantError {
+				assert.Equal(t, http.StatusNotFound, tc.GetResponseCode())
+				assert.Equal(t, "QUEUE_NOT_FOUND", tc.GetResponseBody()["code"])
+				assert.Empty(t, got)
+				return
+			}
+			assert.Equal(t, tt.wantRoom, got)
+		})
+	}
+}
+
+func TestManager_BySession(t *testing.T) {
+	m, store := newTestManager(t)
+	user, sessionID := store.Create()
+	user.Queue = "staging"
+
+	var got string
+	h := m.BySession(func(r *Room) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			got = r.Name
+			return nil
+		}
+	})
+
+	tc := testutil.NewTestContext(http.MethodGet, "/api/queue/me", nil)
+	tc.SetCookie("session_id", sessionID)
+	require.NoError(t, h(tc.Context))
+	assert.Equal(t, "staging", got)
+
+	// セッションなしはデフォルト
+	tc = testutil.NewTestContext(http.MethodGet, "/api/queue/me", nil)
+	require.NoError(t, h(tc.Context))
+	assert.Equal(t, "public", got)
+}
+
+func TestRoom_StartStop(t *testing.T) {
+	m, _ := newTestManager(t)
+	m.Start()
+	for _, r := range m.Rooms() {
+		assert.True(t, r.Dispatcher.IsRunning())
+	}
+
+	stats := m.Default().Stats()
+	assert.Equal(t, "public", stats["queue"])
+	assert.Equal(t, 1, stats["slots"])
+
+	m.Stop()
+	for _, r := range m.Rooms() {
+		assert.False(t, r.Dispatcher.IsRunning())
+	}
+}
+
+func TestManager_Evict(t *testing.T) {
+	m, store := newTestManager(t)
+	staging, _ := m.Get("staging")
+
+	user, sessionID := store.Create()
+	user.Queue = "staging"
+	conn := testutil.NewMockWebSocketConn()
+	user.Conn = conn
+	staging.Queue.Add(sessionID, conn)
+	held, _ := staging.Slots.Acquire(sessionID)
+	require.True(t, held)
+
+	store.Delete(sessionID)
+	m.Evict(session.Eviction{SessionID: sessionID, User: user, Reason: session.ExpiredIdle})
+
+	// 待機列と枠から外れ、接続は閉じられる
+	assert.Equal(t, 0, staging.Queue.Len())
+	assert.False(t, staging.Slots.Holds(sessionID))
+	assert.True(t, conn.GetIsClosed())
+
+	msgs := conn.GetMessages()
+	require.NotEmpty(t, msgs)
+	assert.Contains(t, string(msgs[len(msgs)-1]), "session_expired")
+}
+
+func TestRoom_ReleasesSlotOnLeavingDino(t *testing.T) {
+	m, store := newTestManager(t)
+	staging, _ := m.Get("staging")
+
+	_, sessionID := store.Create()
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		u.Queue = "staging"
+		return nil
+	}))
+	held, _ := staging.Slots.Acquire(sessionID)
+	require.True(t, held)
+
+	// ユーザーをロックしたままでも Dino ステージを出れば枠が解放される
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		require.NoError(t, staging.Machine.Fire(u, stage.EventPromote))
+		return staging.Machine.Fire(u, stage.EventPass)
+	}))
+	assert.False(t, staging.Slots.Holds(sessionID))
+}
+
+func TestRoom_Pipeline(t *testing.T) {
+	pipeline, err := stage.NewPipeline([]stage.Gate{
+		{Name: "dino", Kind: stage.KindDino},
+		{Name: "captcha", Kind: stage.KindCaptcha},
+		{Name: "register", Kind: stage.KindRegister},
+	})
+	require.NoError(t, err)
+
+	store := session.NewSessionStore()
+	public := New(Config{Name: "public", DinoSlots: 1}, store, nil)
+	staging := New(Config{Name: "staging", DinoSlots: 1, Pipeline: pipeline}, store, nil)
+
+	// 待機列ごとに自分のパイプラインで動く
+	assert.Len(t, public.Machine.Pipeline().Gates(), 2)
+	assert.Equal(t, pipeline, staging.Machine.Pipeline())
+
+	user, _ := store.Create()
+	require.NoError(t, staging.Machine.Fire(user, stage.EventPromote))
+	require.NoError(t, staging.Machine.Fire(user, stage.EventPass))
+	gate, ok := staging.Machine.Current(user)
+	require.True(t, ok)
+	assert.Equal(t, "captcha", gate.Name)
+}
+
+func TestManager_Requeue(t *testing.T) {
+	m, store := newTestManager(t)
+	staging, _ := m.Get("staging")
+
+	_, sessionID := store.Create()
+
+	// ユーザーをロックしたまま、所属する待機列の最後尾に戻す（二重には追加しない）
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		u.Queue = "staging"
+		m.Requeue(u)
+		m.Requeue(u)
+		return nil
+	}))
+	assert.Equal(t, 1, staging.Queue.Len())
+	assert.Equal(t, 0, m.Default().Queue.Len())
+
+	// 再接続するまで昇格しない場所を確保する
+	assert.Nil(t, staging.Queue.Peek())
+	assert.True(t, staging.Queue.Reclaim(sessionID, testutil.NewMockWebSocketConn()))
+}
+
+func TestManager_RequeueSurvivesDisconnect(t *testing.T) {
+	store := session.NewSessionStore()
+	conns := ws.NewRegistry(ws.PolicyTakeover)
+	m := NewManager(store)
+	r := New(Config{Name: "public", DinoSlots: 1}, store, conns)
+	m.Register(r)
+
+	failures := failure.NewFailureHandler(m)
+	failures.SetMachine(r.Machine)
+	failures.Use(failure.Requeue(m))
+
+	e := echo.New()
+	e.GET("/ws", r.WebSocket.Connect)
+	server := httptest.NewServer(e)
+	defer server.Close()
+	dial := func() (*websocket.Conn, string) {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/ws", nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		for _, c := range resp.Cookies() {
+			if c.Name == "session_id" {
+				return conn, c.Value
+			}
+		}
+		t.Fatal("no session cookie")
+		return nil, ""
+	}
+
+	// 1人目が昇格し、2人目が待っている
+	conn, sessionID := dial()
+	require.Eventually(t, func() bool { return r.Queue.Len() == 1 }, time.Second, 5*time.Millisecond)
+	promoted := r.WebSocket.PromoteFirstUser()
+	require.NotNil(t, promoted)
+	dial()
+	require.Eventually(t, func() bool { return r.Queue.Len() == 1 }, time.Second, 5*time.Millisecond)
+
+	// 失敗すると最後尾に戻され、接続が閉じられる
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		failures.Fail(u, failure.ReasonGameOver, 1)
+		return nil
+	}))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			break
+		}
+	}
+
+	// 切断の後片付けが終わっても場所は残る
+	require.Eventually(t, func() bool {
+		_, connected := conns.Get(sessionID)
+		return !connected
+	}, time.Second, 5*time.Millisecond)
+	assert.Never(t, func() bool {
+		position, found := r.Queue.GetPosition(sessionID)
+		return !found || position != 2
+	}, 100*time.Millisecond, 5*time.Millisecond)
+}
+
+func TestManager_Place(t *testing.T) {
+	m, store := newTestManager(t)
+	q := m.Default().Queue
+	q.Add("a", testutil.NewMockWebSocketConn())
+	q.Add("b", testutil.NewMockWebSocketConn())
+	q.Add("c", testutil.NewMockWebSocketConn())
+
+	_, sessionID := store.Create()
+
+	// ペナルティで指定された位置に並ばせる
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		assert.Equal(t, 3, m.QueueLen(u))
+		m.Place(u, 2)
+		return nil
+	}))
+	pos, ok := q.GetPosition(sessionID)
+	require.True(t, ok)
+	assert.Equal(t, 2, pos)
+	assert.Equal(t, 4, q.Len())
+
+	// 既に並んでいれば移動するだけ
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		m.Place(u, 100)
+		return nil
+	}))
+	pos, _ = q.GetPosition(sessionID)
+	assert.Equal(t, 4, pos)
+	assert.Equal(t, 4, q.Len())
+
+	// 再接続するまでは昇格せず、切断の後片付けでも消えない
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		m.Place(u, 1)
+		return nil
+	}))
+	assert.Equal(t, "a", q.Peek().ID)
+	assert.False(t, q.Leave(sessionID))
+	pos, ok = q.GetPosition(sessionID)
+	require.True(t, ok)
+	assert.Equal(t, 1, pos)
+}
+
+func TestRoom_ServiceTimeSpansPipeline(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	store := session.NewSessionStore()
+	r := New(Config{Name: "public", DinoSlots: 1, Clock: clk}, store, nil)
+
+	user, _ := store.Create()
+	require.NoError(t, r.Machine.Fire(user, stage.EventPromote))
+	clk.Advance(20 * time.Second)
+	require.NoError(t, r.Machine.Fire(user, stage.EventPass))
+	assert.Equal(t, 0, r.ETA.SampleCount(), "Dinoを抜けただけでは計測しない")
+
+	// 登録画面にいた時間も含めて、待機列に戻るまでを1件として記録する
+	clk.Advance(40 * time.Second)
+	require.NoError(t, r.Machine.Fire(user, stage.EventFail))
+	assert.Equal(t, 1, r.ETA.SampleCount())
+	assert.Equal(t, 60, r.ETA.Estimate(1).Seconds)
+	assert.True(t, user.PromotedAt.IsZero())
+}
+
+func TestManager_PlaceSurvivesSnapshotGrace(t *testing.T) {
+	m, store := newTestManager(t)
+	q := m.Default().Queue
+
+	// 再起動前に待機していたユーザーのスナップショット
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	_, waitingID := store.Create()
+	q.Add(waitingID, testutil.NewMockWebSocketConn())
+	require.NoError(t, snapshot.NewSnapshotter(path, store, m).Save())
+	q.Remove(waitingID)
+
+	clk := clock.NewFake(time.Now())
+	s := snapshot.NewSnapshotter(path, store, m)
+	s.SetClock(clk)
+	s.SetGraceWindow(time.Minute)
+	_, err := s.Restore()
+	require.NoError(t, err)
+
+	// 猶予中にペナルティで先頭に戻されたユーザーは、再接続前でも猶予明けに消えない
+	_, penalizedID := store.Create()
+	require.NoError(t, store.Update(penalizedID, func(u *model.User) error {
+		m.Place(u, 1)
+		return nil
+	}))
+	clk.Advance(time.Minute)
+
+	assert.Equal(t, []string{penalizedID}, q.IDs())
+	assert.True(t, q.Reclaim(penalizedID, testutil.NewMockWebSocketConn()))
+}
@@ -0,0 +1,182 @@
+// Package room runs several independent named queues (rooms) in one server.
+// Each room has its own waiting queue, Dino slots, tease delay, dispatcher and
+// stage pipeline, and every session is bound to the room it first joined.
+package room
+
+import (
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/audit"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/delay"
+	"github.com/kyiku/hackz-ptera-back/internal/handler"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/slot"
+	"github.com/kyiku/hackz-ptera-back/internal/stage"
+	ws "github.com/kyiku/hackz-ptera-back/internal/websocket"
+)
+
+// DefaultName is the name of the room used when none is requested.
+const DefaultName = "default"
+
+// Config holds the settings of one room.
+type Config struct {
+	Name              string
+	DinoSlots         int                     // concurrent Dino players
+	DelayMinSec       int                     // tease delay range before each promotion
+	DelayMaxSec       int                     //
+	PollInterval      time.Duration           // dispatcher poll interval, 0 for the default
+	BroadcastInterval time.Duration           // queueUpdate coalescing tick
+	Pessimism         *queue.PessimismFormula // nil for honest wait estimates
+	Phantoms          queue.PhantomConfig     // zero disables phantoms
+	Cookies           *session.CookieSigner   // nil leaves session cookies unsigned
+	Pipeline          *stage.Pipeline         // gates after the queue, nil for the default pipeline
+	Clock             clock.Clock             // nil for the wall clock
+}
+
+// Room is one independent queue with its own stage and handlers.
+type Room struct {
+	Name       string
+	Queue      *queue.WaitingQueue
+	Slots      *slot.Manager
+	ETA        *queue.ETAEstimator
+	Dispatcher *queue.Dispatcher
+	Phantoms   *queue.PhantomCrowd // nil when disabled
+	Audit      *audit.Trail
+	Machine    *stage.Machine // state machine of the room's pipeline
+
+	WebSocket *handler.WebSocketHandler
+	Status    *handler.QueueHandler
+	Admin     *handler.AdminHandler
+}
+
+// New creates a room from its config. Sessions live in the shared store and
+// the connection registry is shared so a session has one socket server-wide.
+func New(cfg Config, store handler.SessionStoreInterface, conns *ws.Registry) *Room {
+	if cfg.Name == "" {
+		cfg.Name = DefaultName
+	}
+	if cfg.Pipeline == nil {
+		cfg.Pipeline = stage.DefaultPipeline()
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = clock.Real{}
+	}
+
+	q := queue.NewWaitingQueue()
+	q.SetBroadcastInterval(cfg.BroadcastInterval)
+	q.SetClock(cfg.Clock)
+
+	slots := slot.NewManager(cfg.DinoSlots)
+	slots.SetClock(cfg.Clock)
+
+	teaseDelay := delay.NewDelayGenerator(cfg.DelayMinSec, cfg.DelayMaxSec)
+
+	// Wait time estimates (service time = from promotion until the user leaves the pipeline)
+	eta := queue.NewETAEstimator(slots.Size())
+	eta.SetOverhead(teaseDelay.Average())
+	if cfg.Pessimism != nil {
+		eta.SetPessimism(cfg.Pessimism)
+	}
+	q.SetETAEstimator(eta)
+
+	// Leaving the first gate, whichever way, frees the Dino slot. Hooks run
+	// under the user's lock, so release directly rather than through the store
+	machine := stage.NewMachineFor(cfg.Pipeline)
+	machine.OnExit(cfg.Pipeline.First().Status, func(user *model.User, _ stage.Transition) {
+		slots.Release(user.SessionID)
+	})
+
+	// Every gate is left back to the queue sooner or later (registration
+	// never succeeds), which ends the user's service time
+	machine.OnEnter(cfg.Pipeline.First().Status, func(user *model.User, tr stage.Transition) {
+		if tr.Event == stage.EventPromote {
+			user.PromotedAt = cfg.Clock.Now()
+		}
+	})
+	for _, gate := range cfg.Pipeline.Gates() {
+		machine.OnExit(gate.Status, func(user *model.User, tr stage.Transition) {
+			if tr.To == model.StatusWaiting && !user.PromotedAt.IsZero() {
+				eta.Record(cfg.Clock.Since(user.PromotedAt))
+			}
+		})
+	}
+
+	var phantoms *queue.PhantomCrowd
+	if cfg.Phantoms.Initial > 0 || cfg.Phantoms.PerJoin > 0 {
+		phantoms = queue.NewPhantomCrowd(q, cfg.Phantoms)
+		phantoms.SetClock(cfg.Clock)
+	}
+
+	wsHandler := handler.NewWebSocketHandler(store, q)
+	wsHandler.SetSlots(slots)
+	wsHandler.SetQueueName(cfg.Name)
+	if cfg.Cookies != nil {
+		wsHandler.SetCookieSigner(cfg.Cookies)
+	}
+	if conns != nil {
+		wsHandler.SetRegistry(conns)
+	}
+	wsHandler.SetMachine(machine)
+	wsHandler.SetClock(cfg.Clock)
+
+	dispatcher := queue.NewDispatcher(q, teaseDelay, wsHandler)
+	dispatcher.SetStageGate(slots)
+	dispatcher.SetClock(cfg.Clock)
+	if cfg.PollInterval > 0 {
+		dispatcher.SetPollInterval(cfg.PollInterval)
+	}
+
+	statusHandler := handler.NewQueueHandler(store, q)
+	statusHandler.SetETAEstimator(eta)
+
+	trail := audit.NewTrail(audit.DefaultCapacity)
+	trail.SetClock(cfg.Clock)
+
+	return &Room{
+		Name:       cfg.Name,
+		Queue:      q,
+		Slots:      slots,
+		ETA:        eta,
+		Dispatcher: dispatcher,
+		Phantoms:   phantoms,
+		Audit:      trail,
+		Machine:    machine,
+		WebSocket:  wsHandler,
+		Status:     statusHandler,
+		Admin:      handler.NewAdminHandler(q, dispatcher, trail),
+	}
+}
+
+// Start launches the room's background workers.
+func (r *Room) Start() {
+	r.Dispatcher.Start()
+	if r.Phantoms != nil {
+		r.Phantoms.Start()
+	}
+}
+
+// Stop stops the room's background workers.
+func (r *Room) Stop() {
+	r.Dispatcher.Stop()
+	if r.Phantoms != nil {
+		r.Phantoms.Stop()
+	}
+}
+
+// Stats returns the room's queue metrics.
+func (r *Room) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"queue":        r.Name,
+		"queue_length": r.Queue.Len(),
+		"total":        r.Queue.Total(),
+		"phantoms":     r.Queue.PhantomStats(),
+		"broadcast":    r.Queue.Stats(),
+		"slots_in_use": r.Slots.InUse(),
+		"slots":        r.Slots.Size(),
+		"paused":       r.Dispatcher.IsPaused(),
+		"frozen":       r.Queue.IsFrozen(),
+	}
+}
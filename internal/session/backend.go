@@ -0,0 +1,95 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// Backend names accepted by NewBackend.
+const (
+	BackendMemory = "memory" // sessions are lost on restart
+	BackendFile   = "file"   // sessions are persisted to an embedded key-value file
+)
+
+// Backend stores user sessions. Handlers, the janitor and snapshots all use
+// this interface so the storage can be swapped by configuration.
+type Backend interface {
+	// Create creates a new session and returns the user and session ID.
+	Create() (*model.User, string)
+	// Get returns the user of a live session. Expired sessions are evicted and not returned.
+	// Read or change the user's fields through Update or View.
+	Get(sessionID string) (*model.User, bool)
+	// Update runs fn with the session's user locked. Returns ErrSessionNotFound
+	// for a missing or expired session, otherwise fn's error.
+	Update(sessionID string, fn func(user *model.User) error) error
+	// View runs fn with the session's user locked, for reading only.
+	View(sessionID string, fn func(user *model.User) error) error
+	// Put stores a user under an existing session ID, replacing any previous entry.
+	Put(sessionID string, user *model.User, createdAt time.Time)
+	// Delete removes a session. Deleting a missing session is a no-op.
+	Delete(sessionID string)
+	// Touch records activity on the session, pushing back its idle expiry.
+	Touch(sessionID string)
+	// Count returns the number of stored sessions.
+	Count() int
+	// ForEach calls fn for every stored session. fn must not call back into the backend.
+	ForEach(fn func(sessionID string, user *model.User, createdAt time.Time))
+
+	// SetIdleTimeout sets the sliding idle timeout. 0 disables idle expiry.
+	SetIdleTimeout(idle time.Duration)
+	// SetClock sets the clock session lifetimes are measured with.
+	SetClock(c clock.Clock)
+	// SetOnEvict sets the callback invoked after an expired session is removed.
+	SetOnEvict(fn func(Eviction))
+	// Sweep removes every expired session and returns how many were removed.
+	Sweep() int
+	// EvictionStats returns how many expired sessions have been removed.
+	EvictionStats() EvictionStats
+
+	// Close releases the backend, persisting pending changes if it has storage.
+	Close() error
+}
+
+var (
+	_ Backend = (*SessionStore)(nil)
+	_ Backend = (*FileStore)(nil)
+)
+
+// Options configures NewBackend.
+type Options struct {
+	Backend       string        // BackendMemory (default) or BackendFile
+	Path          string        // file for BackendFile
+	MaxAge        time.Duration // absolute session lifetime, 0 means no expiry
+	IdleTimeout   time.Duration // sliding idle timeout, 0 disables it
+	FlushInterval time.Duration // how often BackendFile persists changed sessions
+	Clock         clock.Clock   // nil for the wall clock
+}
+
+// NewBackend creates the session backend selected by opts.Backend.
+func NewBackend(opts Options) (Backend, error) {
+	var backend Backend
+	switch opts.Backend {
+	case "", BackendMemory:
+		backend = NewSessionStoreWithExpiry(opts.MaxAge)
+	case BackendFile:
+		if opts.Path == "" {
+			return nil, fmt.Errorf("session: %s backend needs a path", BackendFile)
+		}
+		store, err := OpenFileStore(opts.Path, opts.MaxAge, opts.FlushInterval, opts.Clock)
+		if err != nil {
+			return nil, err
+		}
+		backend = store
+	default:
+		return nil, fmt.Errorf("session: unknown backend %q", opts.Backend)
+	}
+
+	backend.SetIdleTimeout(opts.IdleTimeout)
+	if opts.Clock != nil {
+		backend.SetClock(opts.Clock)
+	}
+	return backend, nil
+}
@@ -0,0 +1,229 @@
+package session
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backendFactory creates an empty backend with the given absolute expiry (0 for none).
+type backendFactory func(t *testing.T, expiry time.Duration) Backend
+
+// testBackendConformance runs the behaviour every Backend must provide.
+func testBackendConformance(t *testing.T, newBackend backendFactory) {
+	t.Run("正常系: 作成したセッションを取得できる", func(t *testing.T) {
+		b := newBackend(t, 0)
+		user, sessionID := b.Create()
+
+		require.NotEmpty(t, sessionID)
+		assert.Equal(t, sessionID, user.SessionID)
+		assert.Equal(t, model.StatusWaiting, user.Status)
+
+		got, ok := b.Get(sessionID)
+		require.True(t, ok)
+		assert.Same(t, user, got)
+		assert.Equal(t, 1, b.Count())
+	})
+
+	t.Run("異常系: 存在しないセッション", func(t *testing.T) {
+		b := newBackend(t, 0)
+		user, ok := b.Get("missing")
+		assert.False(t, ok)
+		assert.Nil(t, user)
+	})
+
+	t.Run("正常系: 取得したユーザーへの変更が反映される", func(t *testing.T) {
+		b := newBackend(t, 0)
+		user, sessionID := b.Create()
+		user.Status = model.StatusStage1Dino
+
+		got, ok := b.Get(sessionID)
+		require.True(t, ok)
+		assert.Equal(t, model.StatusStage1Dino, got.Status)
+	})
+
+	t.Run("正常系: 削除", func(t *testing.T) {
+		b := newBackend(t, 0)
+		_, sessionID := b.Create()
+		b.Delete(sessionID)
+		b.Delete("missing")
+
+		_, ok := b.Get(sessionID)
+		assert.False(t, ok)
+		assert.Equal(t, 0, b.Count())
+	})
+
+	t.Run("正常系: PutとForEachは作成時刻を保つ", func(t *testing.T) {
+		b := newBackend(t, 0)
+		createdAt := time.Now().Add(-5 * time.Minute).Truncate(time.Millisecond)
+		b.Put("restored", &model.User{ID: "user-restored", Status: model.StatusRegistering}, createdAt)
+
+		user, ok := b.Get("restored")
+		require.True(t, ok)
+		assert.Equal(t, "restored", user.SessionID)
+
+		seen := map[string]time.Time{}
+		b.ForEach(func(sessionID string, _ *model.User, at time.Time) {
+			seen[sessionID] = at
+		})
+		require.Contains(t, seen, "restored")
+		assert.True(t, seen["restored"].Equal(createdAt))
+	})
+
+	t.Run("異常系: 絶対期限切れはGetで削除され通知される", func(t *testing.T) {
+		b := newBackend(t, time.Minute)
+		var evicted []Eviction
+		b.SetOnEvict(func(e Eviction) { evicted = append(evicted, e) })
+
+		b.Put("old", &model.User{ID: "old"}, time.Now().Add(-2*time.Minute))
+		_, ok := b.Get("old")
+		assert.False(t, ok)
+
+		require.Len(t, evicted, 1)
+		assert.Equal(t, ExpiredAbsolute, evicted[0].Reason)
+		assert.Equal(t, uint64(1), b.EvictionStats().Absolute)
+		assert.Equal(t, 0, b.Count())
+	})
+
+	t.Run("正常系: Touchでアイドル期限が延び、Sweepで削除される", func(t *testing.T) {
+		b := newBackend(t, 0)
+		b.SetIdleTimeout(50 * time.Millisecond)
+		var mu sync.Mutex
+		var evicted []string
+		b.SetOnEvict(func(e Eviction) {
+			mu.Lock()
+			defer mu.Unlock()
+			evicted = append(evicted, e.SessionID)
+		})
+
+		_, active := b.Create()
+		_, idle := b.Create()
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			b.Touch(active)
+		}
+
+		assert.Equal(t, 1, b.Sweep())
+		assert.Equal(t, 0, b.Sweep())
+		_, ok := b.Get(active)
+		assert.True(t, ok)
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []string{idle}, evicted)
+		assert.Equal(t, uint64(1), b.EvictionStats().Idle)
+	})
+
+	t.Run("正常系: 並行アクセス", func(t *testing.T) {
+		b := newBackend(t, 0)
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user, sessionID := b.Create()
+				b.Touch(sessionID)
+				got, ok := b.Get(sessionID)
+				assert.True(t, ok)
+				assert.Equal(t, user.ID, got.ID)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, 50, b.Count())
+	})
+
+	t.Run("正常系: Updateは同じユーザーへの変更を直列化する", func(t *testing.T) {
+		b := newBackend(t, 0)
+		_, sessionID := b.Create()
+
+		var wg sync.WaitGroup
+		for i := 0; i < 100; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, b.Update(sessionID, func(u *model.User) error {
+					u.OTPAttempts++
+					return nil
+				}))
+			}()
+		}
+		wg.Wait()
+
+		require.NoError(t, b.View(sessionID, func(u *model.User) error {
+			assert.Equal(t, 100, u.OTPAttempts)
+			return nil
+		}))
+	})
+
+	t.Run("異常系: Updateのエラー", func(t *testing.T) {
+		b := newBackend(t, 0)
+		_, sessionID := b.Create()
+
+		assert.ErrorIs(t, b.Update("missing", func(*model.User) error { return nil }), ErrSessionNotFound)
+		assert.ErrorIs(t, b.View("missing", func(*model.User) error { return nil }), ErrSessionNotFound)
+
+		errStop := errors.New("stop")
+		assert.ErrorIs(t, b.Update(sessionID, func(*model.User) error { return errStop }), errStop)
+	})
+
+	t.Run("正常系: Closeは二度呼べる", func(t *testing.T) {
+		b := newBackend(t, 0)
+		b.Create()
+		assert.NoError(t, b.Close())
+		assert.NoError(t, b.Close())
+	})
+}
+
+func TestSessionStore_Conformance(t *testing.T) {
+	testBackendConformance(t, func(t *testing.T, expiry time.Duration) Backend {
+		return NewSessionStoreWithExpiry(expiry)
+	})
+}
+
+func TestFileStore_Conformance(t *testing.T) {
+	testBackendConformance(t, func(t *testing.T, expiry time.Duration) Backend {
+		store, err := OpenFileStore(filepath.Join(t.TempDir(), "sessions.db"), expiry, 10*time.Millisecond, nil)
+		require.NoError(t, err)
+		t.Cleanup(func() { store.Close() })
+		return store
+	})
+}
+
+func TestNewBackend(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     Options
+		wantFile bool
+		wantErr  bool
+	}{
+		{name: "正常系: 既定はメモリ", opts: Options{}},
+		{name: "正常系: メモリ", opts: Options{Backend: BackendMemory}},
+		{name: "正常系: ファイル", opts: Options{Backend: BackendFile, Path: "sessions.db"}, wantFile: true},
+		{name: "異常系: ファイルのパスがない", opts: Options{Backend: BackendFile}, wantErr: true},
+		{name: "異常系: 不明なバックエンド", opts: Options{Backend: "redis"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.opts.Path != "" {
+				tt.opts.Path = filepath.Join(t.TempDir(), tt.opts.Path)
+			}
+			b, err := NewBackend(tt.opts)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer b.Close()
+
+			_, isFile := b.(*FileStore)
+			assert.Equal(t, tt.wantFile, isFile)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+)
+
+// CookieName is the name of the session cookie.
+const CookieName = "session_id"
+
+// MinCookieKeyLength is the minimum length of a cookie signing key in bytes.
+const MinCookieKeyLength = 16
+
+// MaxCookieSkew is how far in the future a cookie's issue time may be, to
+// allow for clocks of several servers drifting apart.
+const MaxCookieSkew = time.Minute
+
+// Cookie verification errors.
+var (
+	ErrCookieMalformed = errors.New("session: malformed cookie")
+	ErrCookieSignature = errors.New("session: bad cookie signature")
+	ErrCookieExpired   = errors.New("session: cookie expired")
+	ErrCookieFuture    = errors.New("session: cookie issued in the future")
+)
+
+// CookieSigner signs and verifies session cookie values.
+//
+// A signed value is "<session ID>.<issued-at unix seconds>.<HMAC-SHA256>",
+// with the MAC base64url encoded. New values are signed with the first key;
+// values signed with any of the keys are accepted, so a key can be rotated by
+// putting the new key first and dropping the old one once its cookies are gone.
+// Cookies older than the max age are rejected, like the sessions they carry.
+type CookieSigner struct {
+	keys   [][]byte
+	maxAge time.Duration
+	clock  clock.Clock
+}
+
+// NewCookieSigner creates a signer. The first key signs, all keys verify.
+func NewCookieSigner(keys ...[]byte) (*CookieSigner, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: no cookie keys")
+	}
+	signer := &CookieSigner{clock: clock.Real{}}
+	for _, key := range keys {
+		if len(key) < MinCookieKeyLength {
+			return nil, errors.New("session: cookie key too short")
+		}
+		signer.keys = append(signer.keys, append([]byte(nil), key...))
+	}
+	return signer, nil
+}
+
+// NewRandomCookieSigner creates a signer with a random key.
+// Cookies it signs become invalid when the process restarts.
+func NewRandomCookieSigner() (*CookieSigner, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return NewCookieSigner(key)
+}
+
+// SetClock sets the clock cookies are stamped and checked with.
+func (s *CookieSigner) SetClock(c clock.Clock) {
+	s.clock = c
+}
+
+// SetMaxAge sets how long after being issued a cookie is accepted.
+// 0 (the default) accepts cookies of any age.
+func (s *CookieSigner) SetMaxAge(maxAge time.Duration) {
+	s.maxAge = maxAge
+}
+
+// Sign returns the signed cookie value for the session ID.
+func (s *CookieSigner) Sign(sessionID string) string {
+	return s.signAt(sessionID, s.clock.Now())
+}
+
+// Verify checks a cookie value and its age and returns the session ID it carries.
+func (s *CookieSigner) Verify(value string) (string, error) {
+	sessionID, issuedAt, mac, ok := splitCookie(value)
+	if !ok {
+		return "", ErrCookieMalformed
+	}
+	got, err := base64.RawURLEncoding.DecodeString(mac)
+	if err != nil {
+		return "", ErrCookieMalformed
+	}
+
+	payload := sessionID + "." + issuedAt
+	if !s.validMAC(got, payload) {
+		return "", ErrCookieSignature
+	}
+
+	unix, _ := strconv.ParseInt(issuedAt, 10, 64) // checked by splitCookie
+	issued, now := time.Unix(unix, 0), s.clock.Now()
+	if issued.After(now.Add(MaxCookieSkew)) {
+		return "", ErrCookieFuture
+	}
+	if s.maxAge > 0 && now.Sub(issued) > s.maxAge {
+		return "", ErrCookieExpired
+	}
+	return sessionID, nil
+}
+
+// validMAC reports whether mac signs payload with any of the keys.
+func (s *CookieSigner) validMAC(mac []byte, payload string) bool {
+	for _, key := range s.keys {
+		if hmac.Equal(mac, computeMAC(key, payload)) {
+			return true
+		}
+	}
+	return false
+}
+
+// signAt signs the session ID with the given issue time.
+func (s *CookieSigner) signAt(sessionID string, issuedAt time.Time) string {
+	payload := sessionID + "." + strconv.FormatInt(issuedAt.Unix(), 10)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(computeMAC(s.keys[0], payload))
+}
+
+// splitCookie splits a cookie value into its three non-empty parts.
+func splitCookie(value string) (sessionID, issuedAt, mac string, ok bool) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return "", "", "", false
+	}
+	if _, err := strconv.ParseInt(parts[1], 10, 64); err != nil {
+		return "", "", "", false
+	}
+	return parts[0], parts[1], parts[2], true
+}
+
+// computeMAC returns the HMAC-SHA256 of payload.
+func computeMAC(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// ShortID returns the first 8 characters of a session ID for logging.
+// Shorter values are returned unchanged.
+func ShortID(sessionID string) string {
+	if len(sessionID) <= 8 {
+		return sessionID
+	}
+	return sessionID[:8] + "..."
+}
@@ -0,0 +1,136 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	testKeyOld = []byte("old-key-0123456789")
+	testKeyNew = []byte("new-key-0123456789")
+)
+
+func TestCookieSigner_SignAndVerify(t *testing.T) {
+	signer, err := NewCookieSigner(testKeyNew)
+	require.NoError(t, err)
+
+	value := signer.Sign("session-1")
+	assert.True(t, strings.HasPrefix(value, "session-1."))
+
+	sessionID, err := signer.Verify(value)
+	require.NoError(t, err)
+	assert.Equal(t, "session-1", sessionID)
+}
+
+func TestCookieSigner_Verify(t *testing.T) {
+	signer, err := NewCookieSigner(testKeyNew)
+	require.NoError(t, err)
+	valid := signer.signAt("session-1", time.Unix(1700000000, 0))
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr error
+	}{
+		{name: "異常系: 空", value: "", wantErr: ErrCookieMalformed},
+		{name: "異常系: 短い値", value: "abc", wantErr: ErrCookieMalformed},
+		{name: "異常系: 署名なしのUUID", value: "6f1c2a4e-0000-4000-8000-000000000000", wantErr: ErrCookieMalformed},
+		{name: "異常系: 発行時刻が数値でない", value: parts[0] + ".x." + parts[2], wantErr: ErrCookieMalformed},
+		{name: "異常系: 署名がbase64でない", value: parts[0] + "." + parts[1] + ".!!!", wantErr: ErrCookieMalformed},
+		{name: "異常系: IDの改ざん", value: "session-2." + parts[1] + "." + parts[2], wantErr: ErrCookieSignature},
+		{name: "異常系: 発行時刻の改ざん", value: parts[0] + ".1700000001." + parts[2], wantErr: ErrCookieSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionID, err := signer.Verify(tt.value)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Empty(t, sessionID)
+		})
+	}
+}
+
+func TestCookieSigner_MaxAge(t *testing.T) {
+	clk := clock.NewFake(time.Unix(1700000000, 0))
+	signer, err := NewCookieSigner(testKeyNew)
+	require.NoError(t, err)
+	signer.SetClock(clk)
+	signer.SetMaxAge(time.Hour)
+
+	value := signer.Sign("session-1")
+	assert.True(t, strings.HasPrefix(value, "session-1.1700000000."))
+
+	// 最大有効期間内は受け付ける
+	clk.Advance(time.Hour)
+	sessionID, err := signer.Verify(value)
+	require.NoError(t, err)
+	assert.Equal(t, "session-1", sessionID)
+
+	// 最大有効期間を過ぎた署名は正しくても受け付けない
+	clk.Advance(time.Second)
+	_, err = signer.Verify(value)
+	assert.ErrorIs(t, err, ErrCookieExpired)
+
+	// 許容範囲を超えて未来の発行時刻も受け付けない
+	now := clk.Now()
+	_, err = signer.Verify(signer.signAt("session-1", now.Add(MaxCookieSkew)))
+	assert.NoError(t, err)
+	_, err = signer.Verify(signer.signAt("session-1", now.Add(MaxCookieSkew+time.Second)))
+	assert.ErrorIs(t, err, ErrCookieFuture)
+
+	// 最大有効期間なしなら古くても受け付ける
+	signer.SetMaxAge(0)
+	_, err = signer.Verify(value)
+	assert.NoError(t, err)
+}
+
+func TestCookieSigner_KeyRotation(t *testing.T) {
+	oldSigner, err := NewCookieSigner(testKeyOld)
+	require.NoError(t, err)
+	issued := oldSigner.Sign("session-1")
+
+	// 新しい鍵を先頭に置き、古い鍵は検証用に残す
+	rotated, err := NewCookieSigner(testKeyNew, testKeyOld)
+	require.NoError(t, err)
+
+	sessionID, err := rotated.Verify(issued)
+	require.NoError(t, err)
+	assert.Equal(t, "session-1", sessionID)
+
+	// 新しいCookieは新しい鍵で署名される
+	fresh := rotated.Sign("session-2")
+	newOnly, err := NewCookieSigner(testKeyNew)
+	require.NoError(t, err)
+	_, err = newOnly.Verify(fresh)
+	assert.NoError(t, err)
+
+	// 古い鍵を外すと古いCookieは無効になる
+	_, err = newOnly.Verify(issued)
+	assert.ErrorIs(t, err, ErrCookieSignature)
+}
+
+func TestNewCookieSigner_Errors(t *testing.T) {
+	_, err := NewCookieSigner()
+	assert.Error(t, err)
+
+	_, err = NewCookieSigner([]byte("short"))
+	assert.Error(t, err)
+
+	random, err := NewRandomCookieSigner()
+	require.NoError(t, err)
+	_, err = random.Verify(random.Sign("session-1"))
+	assert.NoError(t, err)
+}
+
+func TestShortID(t *testing.T) {
+	assert.Equal(t, "", ShortID(""))
+	assert.Equal(t, "abc", ShortID("abc"))
+	assert.Equal(t, "12345678", ShortID("12345678"))
+	assert.Equal(t, "12345678...", ShortID("123456789"))
+}
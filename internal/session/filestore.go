@@ -0,0 +1,360 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/kvfile"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// DefaultFlushInterval is how often a FileStore persists changed sessions.
+const DefaultFlushInterval = time.Second
+
+// fileRecord is the persisted form of a session.
+// WebSocket connections are not persisted; users reconnect after a restart.
+type fileRecord struct {
+	UserID           string                     `json:"user_id"`
+	JoinedAt         time.Time                  `json:"joined_at"`
+	Status           string                     `json:"status"`
+	Queue            string                     `json:"queue,omitempty"`
+	StageAttempts    int                        `json:"stage_attempts,omitempty"`
+	PromotedAt       time.Time                  `json:"promoted_at,omitempty"`
+	Failures         int                        `json:"failures,omitempty"`
+	Retries          int                        `json:"retries,omitempty"`
+	RejoinAt         time.Time                  `json:"rejoin_at,omitempty"`
+	RejoinPosition   int                        `json:"rejoin_position,omitempty"`
+	DinoRunID        string                     `json:"dino_run_id,omitempty"`
+	DinoSeed         int64                      `json:"dino_seed,omitempty"`
+	DinoStartedAt    time.Time                  `json:"dino_started_at,omitempty"`
+	CaptchaTargetX   int                        `json:"captcha_target_x"`
+	CaptchaTargetY   int                        `json:"captcha_target_y"`
+	CaptchaAttempts  int                        `json:"captcha_attempts"`
+	OTPCode          int                        `json:"otp_code"`
+	OTPAttempts      int                        `json:"otp_attempts"`
+	RegisterToken    string                     `json:"register_token,omitempty"`
+	RegisterTokenExp time.Time                  `json:"register_token_exp,omitempty"`
+	Tasks            map[string]model.TaskState `json:"tasks,omitempty"`
+	CreatedAt        time.Time                  `json:"created_at"`
+}
+
+// encodeRecord serializes the persistable fields of a user.
+func encodeRecord(user *model.User, createdAt time.Time) ([]byte, error) {
+	return json.Marshal(fileRecord{
+		UserID:           user.ID,
+		JoinedAt:         user.JoinedAt,
+		Status:           user.Status,
+		Queue:            user.Queue,
+		StageAttempts:    user.StageAttempts,
+		PromotedAt:       user.PromotedAt,
+		Failures:         user.Failures,
+		Retries:          user.Retries,
+		RejoinAt:         user.RejoinAt,
+		RejoinPosition:   user.RejoinPosition,
+		DinoRunID:        user.DinoRunID,
+		DinoSeed:         user.DinoSeed,
+		DinoStartedAt:    user.DinoStartedAt,
+		CaptchaTargetX:   user.CaptchaTargetX,
+		CaptchaTargetY:   user.CaptchaTargetY,
+		CaptchaAttempts:  user.CaptchaAttempts,
+		OTPCode:          user.OTPCode,
+		OTPAttempts:      user.OTPAttempts,
+		RegisterToken:    user.RegisterToken,
+		RegisterTokenExp: user.RegisterTokenExp,
+		Tasks:            user.TaskLedger(),
+		CreatedAt:        createdAt,
+	})
+}
+
+// decodeRecord rebuilds a user and its creation time.
+func decodeRecord(sessionID string, data []byte) (*model.User, time.Time, error) {
+	var r fileRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, time.Time{}, err
+	}
+	return &model.User{
+		ID:               r.UserID,
+		SessionID:        sessionID,
+		JoinedAt:         r.JoinedAt,
+		Status:           r.Status,
+		Queue:            r.Queue,
+		StageAttempts:    r.StageAttempts,
+		PromotedAt:       r.PromotedAt,
+		Failures:         r.Failures,
+		Retries:          r.Retries,
+		RejoinAt:         r.RejoinAt,
+		RejoinPosition:   r.RejoinPosition,
+		DinoRunID:        r.DinoRunID,
+		DinoSeed:         r.DinoSeed,
+		DinoStartedAt:    r.DinoStartedAt,
+		CaptchaTargetX:   r.CaptchaTargetX,
+		CaptchaTargetY:   r.CaptchaTargetY,
+		CaptchaAttempts:  r.CaptchaAttempts,
+		OTPCode:          r.OTPCode,
+		OTPAttempts:      r.OTPAttempts,
+		RegisterToken:    r.RegisterToken,
+		RegisterTokenExp: r.RegisterTokenExp,
+		Tasks:            r.Tasks,
+	}, r.CreatedAt, nil
+}
+
+// FileStore is a session backend persisted to an embedded key-value file.
+//
+// Sessions are served from an in-memory SessionStore. New, replaced and
+// deleted sessions are written through at once; changes handlers make to a
+// user in place are picked up by a background flush every flush interval
+// and on Close. A session's record is only written under its user's lock,
+// so writes of one session are ordered without holding up the others.
+type FileStore struct {
+	*SessionStore
+	db *kvfile.DB
+
+	mu      sync.Mutex // guards onEvict, written and closed, never held during file I/O
+	onEvict func(Eviction)
+	written map[string][]byte // last record persisted per session
+	closed  bool
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// OpenFileStore opens or creates the session file at path, loads its
+// sessions and starts the background flush. Sessions that expired while the
+// server was down are evicted on first Get or Sweep.
+// A flushInterval of 0 or less falls back to DefaultFlushInterval, and a nil
+// clk to the wall clock. clk measures session lifetimes and times the flush.
+func OpenFileStore(path string, expiry, flushInterval time.Duration, clk clock.Clock) (*FileStore, error) {
+	db, err := kvfile.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("session: %w", err)
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	if clk == nil {
+		clk = clock.Real{}
+	}
+
+	s := &FileStore{
+		SessionStore: NewSessionStoreWithExpiry(expiry),
+		db:           db,
+		written:      make(map[string][]byte),
+		stopCh:       make(chan struct{}),
+		doneCh:       make(chan struct{}),
+	}
+	s.SessionStore.SetClock(clk)
+	s.SessionStore.SetOnEvict(s.handleEviction)
+
+	db.ForEach(func(sessionID string, data []byte) {
+		user, createdAt, err := decodeRecord(sessionID, data)
+		if err != nil {
+			log.Printf("[Session] Skipping unreadable session %s: %v", sessionID, err)
+			return
+		}
+		s.SessionStore.Put(sessionID, user, createdAt)
+		s.written[sessionID] = append([]byte(nil), data...)
+	})
+	log.Printf("[Session] Loaded %d sessions from %s", s.SessionStore.Count(), path)
+
+	go s.run(clk, flushInterval)
+	return s, nil
+}
+
+// Create creates a new session and persists it.
+func (s *FileStore) Create() (*model.User, string) {
+	user, sessionID := s.SessionStore.Create()
+	createdAt, _ := s.SessionStore.createdAt(sessionID)
+	s.persist(sessionID, user, createdAt)
+	return user, sessionID
+}
+
+// Put stores a user under an existing session ID and persists it.
+func (s *FileStore) Put(sessionID string, user *model.User, createdAt time.Time) {
+	s.SessionStore.Put(sessionID, user, createdAt)
+	s.persist(sessionID, user, createdAt)
+}
+
+// Update runs fn with the session's user locked and persists the user if fn succeeds.
+// The record is written before the user is unlocked.
+func (s *FileStore) Update(sessionID string, fn func(user *model.User) error) error {
+	createdAt, ok := s.SessionStore.createdAt(sessionID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	return s.SessionStore.Update(sessionID, func(user *model.User) error {
+		if err := fn(user); err != nil {
+			return err
+		}
+		data, err := encodeRecord(user, createdAt)
+		if err != nil {
+			return err
+		}
+		if err := s.writeHeld(sessionID, data); err != nil {
+			return fmt.Errorf("session: persist: %w", err)
+		}
+		return nil
+	})
+}
+
+// Delete removes a session from memory and from the file.
+func (s *FileStore) Delete(sessionID string) {
+	s.SessionStore.Delete(sessionID)
+	s.remove(sessionID)
+}
+
+// SetOnEvict sets the callback invoked after an expired session is removed.
+func (s *FileStore) SetOnEvict(fn func(Eviction)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = fn
+}
+
+// Flush persists every session that changed since it was last written.
+func (s *FileStore) Flush() error {
+	type stored struct {
+		sessionID string
+		user      *model.User
+		createdAt time.Time
+	}
+	var sessions []stored
+	s.SessionStore.ForEach(func(sessionID string, user *model.User, createdAt time.Time) {
+		sessions = append(sessions, stored{sessionID, user, createdAt})
+	})
+
+	for _, st := range sessions {
+		if err := s.flushOne(st.sessionID, st.user, st.createdAt); err != nil {
+			return fmt.Errorf("session: flush: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close stops the background flush, persists pending changes and closes the file.
+// Closing twice is a no-op.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.mu.Unlock()
+
+	close(s.stopCh)
+	<-s.doneCh
+
+	if err := s.Flush(); err != nil {
+		s.db.Close()
+		return err
+	}
+	return s.db.Close()
+}
+
+// run flushes changed sessions until Close.
+func (s *FileStore) run(clk clock.Clock, interval time.Duration) {
+	defer close(s.doneCh)
+
+	ticker := clk.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-ticker.C():
+			if err := s.Flush(); err != nil {
+				log.Printf("[Session] Flush failed: %v", err)
+			}
+		}
+	}
+}
+
+// persist writes one session through to the file.
+func (s *FileStore) persist(sessionID string, user *model.User, createdAt time.Time) {
+	user.Lock()
+	defer user.Unlock()
+
+	data, err := encodeRecord(user, createdAt)
+	if err != nil {
+		log.Printf("[Session] Failed to encode session %s: %v", sessionID, err)
+		return
+	}
+	if err := s.writeHeld(sessionID, data); err != nil {
+		log.Printf("[Session] Failed to persist session %s: %v", sessionID, err)
+	}
+}
+
+// flushOne writes one session if its record differs from the last one written.
+func (s *FileStore) flushOne(sessionID string, user *model.User, createdAt time.Time) error {
+	user.Lock()
+	defer user.Unlock()
+
+	data, err := encodeRecord(user, createdAt)
+	if err != nil {
+		log.Printf("[Session] Failed to encode session %s: %v", sessionID, err)
+		return nil
+	}
+	s.mu.Lock()
+	unchanged := bytes.Equal(s.written[sessionID], data)
+	s.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+	return s.writeHeld(sessionID, data)
+}
+
+// writeHeld writes a session's record unless the session is gone.
+// The caller must hold the user's lock.
+func (s *FileStore) writeHeld(sessionID string, data []byte) error {
+	if !s.SessionStore.has(sessionID) {
+		return nil
+	}
+	if err := s.db.Put(sessionID, data); err != nil {
+		if errors.Is(err, kvfile.ErrClosed) {
+			return nil
+		}
+		return err
+	}
+
+	// Deleting or evicting a session doesn't lock the user, so it may have
+	// removed the record before the put above landed
+	if !s.SessionStore.has(sessionID) {
+		s.remove(sessionID)
+		return nil
+	}
+	s.mu.Lock()
+	s.written[sessionID] = data
+	s.mu.Unlock()
+	return nil
+}
+
+// handleEviction removes an expired session from the file, then forwards the eviction.
+func (s *FileStore) handleEviction(e Eviction) {
+	s.remove(e.SessionID)
+
+	s.mu.Lock()
+	onEvict := s.onEvict
+	s.mu.Unlock()
+
+	if onEvict != nil {
+		onEvict(e)
+	}
+}
+
+// remove deletes a session from the file.
+func (s *FileStore) remove(sessionID string) {
+	s.mu.Lock()
+	delete(s.written, sessionID)
+	s.mu.Unlock()
+
+	if err := s.db.Delete(sessionID); err != nil && !errors.Is(err, kvfile.ErrClosed) {
+		log.Printf("[Session] Failed to delete session %s: %v", sessionID, err)
+	}
+}
@@ -0,0 +1,182 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+
+	store, err := OpenFileStore(path, 0, time.Hour, nil)
+	require.NoError(t, err)
+	user, sessionID := store.Create()
+	_, deletedID := store.Create()
+	store.Delete(deletedID)
+
+	// ハンドラーによる直接の変更はCloseで書き出される
+	user.Status = model.StatusRegistering
+	user.Queue = "staging"
+	user.OTPAttempts = 2
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenFileStore(path, 0, time.Hour, nil)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, 1, reopened.Count())
+	got, ok := reopened.Get(sessionID)
+	require.True(t, ok)
+	assert.Equal(t, user.ID, got.ID)
+	assert.Equal(t, sessionID, got.SessionID)
+	assert.Equal(t, model.StatusRegistering, got.Status)
+	assert.Equal(t, "staging", got.Queue)
+	assert.Equal(t, 2, got.OTPAttempts)
+	assert.Nil(t, got.Conn)
+
+	_, ok = reopened.Get(deletedID)
+	assert.False(t, ok)
+}
+
+func TestFileStore_FlushesInBackground(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := OpenFileStore(path, 0, 10*time.Millisecond, nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	user, sessionID := store.Create()
+	user.Status = model.StatusStage1Dino
+
+	require.Eventually(t, func() bool {
+		data, ok := store.db.Get(sessionID)
+		if !ok {
+			return false
+		}
+		got, _, err := decodeRecord(sessionID, data)
+		return err == nil && got.Status == model.StatusStage1Dino
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestFileStore_FlushesOnClockTick(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := OpenFileStore(path, 0, time.Minute, clk)
+	require.NoError(t, err)
+	defer store.Close()
+
+	// ハンドラーによる直接の変更はフラッシュまで書き出されない
+	user, sessionID := store.Create()
+	user.Status = model.StatusStage1Dino
+	storedStatus := func() string {
+		data, ok := store.db.Get(sessionID)
+		require.True(t, ok)
+		got, _, err := decodeRecord(sessionID, data)
+		require.NoError(t, err)
+		return got.Status
+	}
+
+	// ティック前は作成時の内容のまま
+	clk.BlockUntil(1)
+	clk.Advance(59 * time.Second)
+	assert.Equal(t, model.StatusWaiting, storedStatus())
+
+	// ティックで変更が書き出される
+	clk.Advance(time.Second)
+	require.Eventually(t, func() bool {
+		return storedStatus() == model.StatusStage1Dino
+	}, time.Second, 5*time.Millisecond)
+}
+
+func TestFileStore_EvictionRemovesFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := OpenFileStore(path, time.Minute, time.Hour, nil)
+	require.NoError(t, err)
+
+	store.Put("old", &model.User{ID: "old"}, time.Now().Add(-2*time.Minute))
+	_, fresh := store.Create()
+	assert.Equal(t, 1, store.Sweep())
+	require.NoError(t, store.Close())
+
+	reopened, err := OpenFileStore(path, time.Minute, time.Hour, nil)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, 1, reopened.Count())
+	_, ok := reopened.Get(fresh)
+	assert.True(t, ok)
+}
+
+func TestFileStore_UpdatePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := OpenFileStore(path, 0, time.Hour, nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, sessionID := store.Create()
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		u.Status = model.StatusRegistering
+		return nil
+	}))
+
+	// フラッシュを待たずに書き込まれる
+	data, ok := store.db.Get(sessionID)
+	require.True(t, ok)
+	got, _, err := decodeRecord(sessionID, data)
+	require.NoError(t, err)
+	assert.Equal(t, model.StatusRegistering, got.Status)
+}
+
+func TestFileStore_UpdateLocksOnlyItsSession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := OpenFileStore(path, 0, time.Hour, nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	busy, _ := store.Create()
+	_, sessionID := store.Create()
+
+	// 他のセッションの書き出し中（ユーザーのロック待ち）でも待たされない
+	busy.Lock()
+	defer busy.Unlock()
+	busy.Status = model.StatusStage1Dino
+	go store.Flush()
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- store.Update(sessionID, func(u *model.User) error {
+			u.Status = model.StatusRegistering
+			return nil
+		})
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Update waited for another session")
+	}
+}
+
+func TestFileStore_DeletedDuringUpdate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.db")
+	store, err := OpenFileStore(path, 0, time.Hour, nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	_, sessionID := store.Create()
+
+	// 更新中に削除されたセッションはファイルに戻らない
+	require.NoError(t, store.Update(sessionID, func(u *model.User) error {
+		store.Delete(sessionID)
+		u.Status = model.StatusRegistering
+		return nil
+	}))
+	_, ok := store.db.Get(sessionID)
+	assert.False(t, ok)
+}
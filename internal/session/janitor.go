@@ -0,0 +1,133 @@
+package session
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+)
+
+// DefaultSweepInterval is how often the janitor sweeps expired sessions.
+const DefaultSweepInterval = time.Minute
+
+// Janitor periodically removes expired sessions from a Backend, so
+// abandoned sessions don't pile up until someone happens to look them up.
+// Cleanup of queues and sockets is done by the store's eviction callback.
+type Janitor struct {
+	store    Backend
+	interval time.Duration
+
+	mu      sync.Mutex
+	clock   clock.Clock
+	sweeps  uint64
+	running bool
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// JanitorStats reports the janitor's activity.
+type JanitorStats struct {
+	Sweeps  uint64        `json:"sweeps"`
+	Evicted EvictionStats `json:"evicted"`
+	Active  int           `json:"active"`
+}
+
+// NewJanitor creates a new Janitor for the store.
+// An interval of 0 or less falls back to DefaultSweepInterval.
+func NewJanitor(store Backend, interval time.Duration) *Janitor {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	return &Janitor{
+		store:    store,
+		interval: interval,
+		clock:    clock.Real{},
+	}
+}
+
+// SetClock sets the clock the sweep loop ticks on. It applies from the next Start.
+func (j *Janitor) SetClock(c clock.Clock) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.clock = c
+}
+
+// Start launches the sweep loop. Calling Start on a running janitor is a no-op.
+func (j *Janitor) Start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.running {
+		return
+	}
+	j.running = true
+	j.stopCh = make(chan struct{})
+	j.doneCh = make(chan struct{})
+
+	go j.run(j.stopCh, j.doneCh, j.clock.NewTicker(j.interval))
+}
+
+// Stop stops the sweep loop and waits for it to exit.
+func (j *Janitor) Stop() {
+	j.mu.Lock()
+	if !j.running {
+		j.mu.Unlock()
+		return
+	}
+	j.running = false
+	stopCh, doneCh := j.stopCh, j.doneCh
+	j.mu.Unlock()
+
+	close(stopCh)
+	<-doneCh
+}
+
+// IsRunning returns whether the sweep loop is running.
+func (j *Janitor) IsRunning() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.running
+}
+
+// Sweep removes expired sessions now and returns how many were removed.
+func (j *Janitor) Sweep() int {
+	removed := j.store.Sweep()
+
+	j.mu.Lock()
+	j.sweeps++
+	j.mu.Unlock()
+
+	if removed > 0 {
+		log.Printf("[Janitor] Evicted %d expired sessions", removed)
+	}
+	return removed
+}
+
+// Stats returns the number of sweeps, evictions and remaining sessions.
+func (j *Janitor) Stats() JanitorStats {
+	j.mu.Lock()
+	sweeps := j.sweeps
+	j.mu.Unlock()
+
+	return JanitorStats{
+		Sweeps:  sweeps,
+		Evicted: j.store.EvictionStats(),
+		Active:  j.store.Count(),
+	}
+}
+
+// run is the sweep loop.
+func (j *Janitor) run(stopCh <-chan struct{}, doneCh chan<- struct{}, ticker clock.Ticker) {
+	defer close(doneCh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C():
+			j.Sweep()
+		}
+	}
+}
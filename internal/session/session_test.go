@@ -4,6 +4,9 @@ import (
 	"testing"
 	"time"
 
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -122,10 +125,12 @@ func TestSessionStore_Expiry(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Now())
 			store := NewSessionStoreWithExpiry(tt.expiry)
+			store.SetClock(clk)
 			_, sessionID := store.Create()
 
-			time.Sleep(tt.waitTime)
+			clk.Advance(tt.waitTime)
 
 			_, found := store.Get(sessionID)
 			assert.Equal(t, tt.wantFound, found)
@@ -158,3 +163,135 @@ func TestSessionStore_Concurrent(t *testing.T) {
 		<-done
 	}
 }
+
+func TestSessionStore_PutAndForEach(t *testing.T) {
+	store := NewSessionStore()
+	_, createdID := store.Create()
+
+	restored := &model.User{ID: "user-restored", Status: model.StatusRegistering}
+	createdAt := time.Now().Add(-5 * time.Minute)
+	store.Put("restored-session", restored, createdAt)
+
+	user, ok := store.Get("restored-session")
+	require.True(t, ok)
+	assert.Equal(t, "restored-session", user.SessionID)
+	assert.Equal(t, model.StatusRegistering, user.Status)
+
+	seen := map[string]time.Time{}
+	store.ForEach(func(sessionID string, _ *model.User, at time.Time) {
+		seen[sessionID] = at
+	})
+
+	assert.Len(t, seen, 2)
+	assert.Contains(t, seen, createdID)
+	assert.True(t, seen["restored-session"].Equal(createdAt))
+}
+
+func TestSessionStore_PutKeepsExpiry(t *testing.T) {
+	store := NewSessionStoreWithExpiry(time.Minute)
+
+	// 作成時刻ごと復元するので、期限切れのセッションは復元後も期限切れ
+	store.Put("old-session", &model.User{ID: "old"}, time.Now().Add(-2*time.Minute))
+
+	_, ok := store.Get("old-session")
+	assert.False(t, ok)
+}
+
+func TestSessionStore_IdleTimeout(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	store := NewSessionStore()
+	store.SetClock(clk)
+	store.SetIdleTimeout(50 * time.Millisecond)
+
+	_, active := store.Create()
+	_, idle := store.Create()
+
+	// 操作のあるセッションは期限が延びる
+	for i := 0; i < 4; i++ {
+		clk.Advance(20 * time.Millisecond)
+		store.Touch(active)
+	}
+
+	_, found := store.Get(active)
+	assert.True(t, found)
+	_, found = store.Get(idle)
+	assert.False(t, found)
+}
+
+func TestSessionStore_Sweep(t *testing.T) {
+	store := NewSessionStoreWithExpiry(time.Hour)
+	store.SetIdleTimeout(time.Minute)
+
+	var evicted []Eviction
+	store.SetOnEvict(func(e Eviction) {
+		evicted = append(evicted, e)
+	})
+
+	store.Put("too-old", &model.User{ID: "old"}, time.Now().Add(-2*time.Hour))
+	_, fresh := store.Create()
+	store.Put("idle", &model.User{ID: "idle"}, time.Now())
+	store.mu.Lock()
+	store.sessions["idle"].LastSeen = time.Now().Add(-2 * time.Minute)
+	store.mu.Unlock()
+
+	assert.Equal(t, 2, store.Sweep())
+	assert.Equal(t, 1, store.Count())
+	_, found := store.Get(fresh)
+	assert.True(t, found)
+
+	require.Len(t, evicted, 2)
+	reasons := map[string]string{}
+	for _, e := range evicted {
+		reasons[e.SessionID] = e.Reason
+		require.NotNil(t, e.User)
+	}
+	assert.Equal(t, ExpiredAbsolute, reasons["too-old"])
+	assert.Equal(t, ExpiredIdle, reasons["idle"])
+
+	assert.Equal(t, EvictionStats{Absolute: 1, Idle: 1}, store.EvictionStats())
+
+	// 二度目は何もしない
+	assert.Equal(t, 0, store.Sweep())
+}
+
+func TestSessionStore_GetNotifiesEviction(t *testing.T) {
+	store := NewSessionStoreWithExpiry(time.Minute)
+
+	var evicted []string
+	store.SetOnEvict(func(e Eviction) {
+		evicted = append(evicted, e.SessionID)
+	})
+
+	store.Put("old-session", &model.User{ID: "old"}, time.Now().Add(-2*time.Minute))
+	_, ok := store.Get("old-session")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"old-session"}, evicted)
+	assert.Equal(t, uint64(1), store.EvictionStats().Absolute)
+}
+
+func TestJanitor_SweepsInBackground(t *testing.T) {
+	store := NewSessionStore()
+	store.SetIdleTimeout(20 * time.Millisecond)
+	store.Create()
+	store.Create()
+
+	j := NewJanitor(store, 10*time.Millisecond)
+	j.Start()
+	defer j.Stop()
+	assert.True(t, j.IsRunning())
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return store.Count() == 0
+	})
+	require.NoError(t, err)
+
+	stats := j.Stats()
+	assert.Equal(t, uint64(2), stats.Evicted.Idle)
+	assert.Positive(t, stats.Sweeps)
+	assert.Equal(t, 0, stats.Active)
+
+	j.Stop()
+	assert.False(t, j.IsRunning())
+	j.Stop()
+}
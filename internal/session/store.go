@@ -2,25 +2,54 @@
 package session
 
 import (
+	"errors"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
 )
 
+// Expiry reasons reported to the eviction callback.
+const (
+	ExpiredAbsolute = "absolute" // the session outlived its maximum lifetime
+	ExpiredIdle     = "idle"     // no activity within the idle timeout
+)
+
+// ErrSessionNotFound is returned by Update and View when the session does not exist or has expired.
+var ErrSessionNotFound = errors.New("session: not found")
+
 // sessionEntry holds a user and its creation time for expiry checking.
 type sessionEntry struct {
 	User      *model.User
 	CreatedAt time.Time
+	LastSeen  time.Time // last REST or WebSocket activity
+}
+
+// Eviction describes a session removed because it expired.
+type Eviction struct {
+	SessionID string
+	User      *model.User
+	Reason    string // ExpiredAbsolute or ExpiredIdle
+}
+
+// EvictionStats counts expired sessions removed from the store.
+type EvictionStats struct {
+	Absolute uint64 `json:"absolute"`
+	Idle     uint64 `json:"idle"`
 }
 
 // SessionStore manages user sessions in memory.
 type SessionStore struct {
 	sessions map[string]*sessionEntry
 	mu       sync.RWMutex
-	expiry   time.Duration // 0 means no expiry
+	expiry   time.Duration // absolute lifetime, 0 means no expiry
+	idle     time.Duration // sliding idle timeout, 0 means no idle expiry
+	onEvict  func(Eviction)
+	evicted  EvictionStats
+	clock    clock.Clock
 }
 
 // NewSessionStore creates a new SessionStore with no expiry.
@@ -28,6 +57,7 @@ func NewSessionStore() *SessionStore {
 	return &SessionStore{
 		sessions: make(map[string]*sessionEntry),
 		expiry:   0,
+		clock:    clock.Real{},
 	}
 }
 
@@ -36,6 +66,7 @@ func NewSessionStoreWithExpiry(expiry time.Duration) *SessionStore {
 	return &SessionStore{
 		sessions: make(map[string]*sessionEntry),
 		expiry:   expiry,
+		clock:    clock.Real{},
 	}
 }
 
@@ -44,13 +75,15 @@ func (s *SessionStore) Create() (*model.User, string) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	user := model.NewUser()
+	now := s.clock.Now()
+	user := model.NewUser(now)
 	sessionID := uuid.New().String()
 	user.SessionID = sessionID
 
 	s.sessions[sessionID] = &sessionEntry{
 		User:      user,
-		CreatedAt: time.Now(),
+		CreatedAt: now,
+		LastSeen:  now,
 	}
 
 	return user, sessionID
@@ -61,6 +94,7 @@ func (s *SessionStore) Create() (*model.User, string) {
 func (s *SessionStore) Get(sessionID string) (*model.User, bool) {
 	s.mu.RLock()
 	entry, exists := s.sessions[sessionID]
+	expired := exists && s.expiredReason(entry, s.clock.Now()) != ""
 	s.mu.RUnlock()
 
 	if !exists {
@@ -68,17 +102,136 @@ func (s *SessionStore) Get(sessionID string) (*model.User, bool) {
 	}
 
 	// Check expiry if set
-	if s.expiry > 0 && time.Since(entry.CreatedAt) > s.expiry {
+	if expired {
 		// Session has expired, delete it
-		s.mu.Lock()
-		delete(s.sessions, sessionID)
-		s.mu.Unlock()
+		s.evict(sessionID, entry)
 		return nil, false
 	}
 
 	return entry.User, true
 }
 
+// Update runs fn with the session's user locked, so concurrent requests on
+// the same session apply their changes one at a time. It returns
+// ErrSessionNotFound if the session does not exist or has expired, otherwise
+// the error returned by fn. fn must not call Update or View for the same session.
+func (s *SessionStore) Update(sessionID string, fn func(user *model.User) error) error {
+	user, ok := s.Get(sessionID)
+	if !ok {
+		return ErrSessionNotFound
+	}
+
+	user.Lock()
+	defer user.Unlock()
+	return fn(user)
+}
+
+// View runs fn with the session's user locked for reading a consistent state.
+// fn must not modify the user; use Update for that.
+func (s *SessionStore) View(sessionID string, fn func(user *model.User) error) error {
+	return s.Update(sessionID, fn)
+}
+
+// SetIdleTimeout sets the sliding idle timeout. A session expires once it has
+// not been touched for this long. 0 disables idle expiry.
+func (s *SessionStore) SetIdleTimeout(idle time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idle = idle
+}
+
+// SetClock sets the clock session lifetimes are measured with.
+func (s *SessionStore) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+// SetOnEvict sets the callback invoked after an expired session is removed,
+// whether by Get or by Sweep. It is called without the store lock held.
+func (s *SessionStore) SetOnEvict(fn func(Eviction)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvict = fn
+}
+
+// Touch records activity on the session, pushing back its idle expiry.
+func (s *SessionStore) Touch(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if entry, ok := s.sessions[sessionID]; ok {
+		entry.LastSeen = s.clock.Now()
+	}
+}
+
+// Sweep removes every expired session and returns how many were removed.
+func (s *SessionStore) Sweep() int {
+	s.mu.RLock()
+	now := s.clock.Now()
+	var expired []string
+	for sessionID, entry := range s.sessions {
+		if s.expiredReason(entry, now) != "" {
+			expired = append(expired, sessionID)
+		}
+	}
+	s.mu.RUnlock()
+
+	removed := 0
+	for _, sessionID := range expired {
+		s.mu.RLock()
+		entry, ok := s.sessions[sessionID]
+		s.mu.RUnlock()
+		if ok && s.evict(sessionID, entry) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// EvictionStats returns how many expired sessions have been removed.
+func (s *SessionStore) EvictionStats() EvictionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.evicted
+}
+
+// evict removes an expired entry and notifies the eviction callback.
+// Returns false if the entry was already removed, replaced or touched meanwhile.
+func (s *SessionStore) evict(sessionID string, entry *sessionEntry) bool {
+	s.mu.Lock()
+	current, ok := s.sessions[sessionID]
+	reason := s.expiredReason(entry, s.clock.Now())
+	if !ok || current != entry || reason == "" {
+		s.mu.Unlock()
+		return false
+	}
+	delete(s.sessions, sessionID)
+	if reason == ExpiredAbsolute {
+		s.evicted.Absolute++
+	} else {
+		s.evicted.Idle++
+	}
+	onEvict := s.onEvict
+	s.mu.Unlock()
+
+	if onEvict != nil {
+		onEvict(Eviction{SessionID: sessionID, User: entry.User, Reason: reason})
+	}
+	return true
+}
+
+// expiredReason returns why the entry has expired, or "" if it is still valid.
+// Caller must hold the lock.
+func (s *SessionStore) expiredReason(entry *sessionEntry, now time.Time) string {
+	if s.expiry > 0 && now.Sub(entry.CreatedAt) > s.expiry {
+		return ExpiredAbsolute
+	}
+	if s.idle > 0 && now.Sub(entry.LastSeen) > s.idle {
+		return ExpiredIdle
+	}
+	return ""
+}
+
 // Delete removes a session by ID.
 func (s *SessionStore) Delete(sessionID string) {
 	s.mu.Lock()
@@ -92,3 +245,53 @@ func (s *SessionStore) Count() int {
 	defer s.mu.RUnlock()
 	return len(s.sessions)
 }
+
+// ForEach calls fn for every stored session with its creation time.
+// fn must not call back into the store or lock the user; collect the users
+// and lock them after ForEach returns.
+func (s *SessionStore) ForEach(fn func(sessionID string, user *model.User, createdAt time.Time)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for sessionID, entry := range s.sessions {
+		fn(sessionID, entry.User, entry.CreatedAt)
+	}
+}
+
+// Put stores a user under an existing session ID, replacing any previous entry.
+// This is used to restore sessions from a snapshot.
+func (s *SessionStore) Put(sessionID string, user *model.User, createdAt time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user.SessionID = sessionID
+	s.sessions[sessionID] = &sessionEntry{
+		User:      user,
+		CreatedAt: createdAt,
+		LastSeen:  s.clock.Now(),
+	}
+}
+
+// Close is a no-op; the in-memory store has nothing to release.
+func (s *SessionStore) Close() error {
+	return nil
+}
+
+// createdAt returns the creation time of a stored session.
+func (s *SessionStore) createdAt(sessionID string) (time.Time, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entry, ok := s.sessions[sessionID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return entry.CreatedAt, true
+}
+
+// has reports whether the session is stored, without checking expiry.
+func (s *SessionStore) has(sessionID string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.sessions[sessionID]
+	return ok
+}
@@ -0,0 +1,125 @@
+// Package slot provides stage slot management for limiting concurrent players.
+//
+// A slot is held until it is released. Slots don't expire on their own: the
+// first gate's deadline fails players who take too long, and leaving the
+// gate releases their slot.
+package slot
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+)
+
+// DefaultSize is the default number of concurrent Dino players.
+const DefaultSize = 1
+
+// Manager hands out a fixed number of stage slots to sessions.
+type Manager struct {
+	mu        sync.Mutex
+	size      int
+	leases    map[string]time.Time // sessionID -> when the slot was acquired
+	onRelease func(sessionID string, held time.Duration)
+	clock     clock.Clock
+}
+
+// NewManager creates a new Manager with the given number of slots.
+// A size below 1 falls back to DefaultSize.
+func NewManager(size int) *Manager {
+	if size < 1 {
+		size = DefaultSize
+	}
+	return &Manager{
+		size:   size,
+		leases: make(map[string]time.Time),
+		clock:  clock.Real{},
+	}
+}
+
+// SetClock sets the clock slot hold times are measured with.
+func (m *Manager) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clock = c
+}
+
+// SetOnRelease sets the callback invoked whenever a slot is freed, with how
+// long the slot was held.
+func (m *Manager) SetOnRelease(fn func(sessionID string, held time.Duration)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onRelease = fn
+}
+
+// Acquire takes a slot for the session.
+// held reports whether the session holds a slot after the call, and acquired
+// whether this call took it. A session that already held a slot gets
+// held but not acquired, so callers undoing a failed promotion only release
+// slots they took themselves.
+func (m *Manager) Acquire(sessionID string) (held, acquired bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.leases[sessionID]; ok {
+		return true, false
+	}
+	if len(m.leases) >= m.size {
+		return false, false
+	}
+
+	m.leases[sessionID] = m.clock.Now()
+	return true, true
+}
+
+// Release frees the slot held by the session.
+// Returns false if the session did not hold a slot.
+func (m *Manager) Release(sessionID string) bool {
+	m.mu.Lock()
+	acquiredAt, ok := m.leases[sessionID]
+	if !ok {
+		m.mu.Unlock()
+		return false
+	}
+	delete(m.leases, sessionID)
+	onRelease, held := m.onRelease, m.clock.Since(acquiredAt)
+	m.mu.Unlock()
+
+	if onRelease != nil {
+		onRelease(sessionID, held)
+	}
+	return true
+}
+
+// Holds returns whether the session currently holds a slot.
+func (m *Manager) Holds(sessionID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.leases[sessionID]
+	return ok
+}
+
+// Size returns the total number of slots.
+func (m *Manager) Size() int {
+	return m.size
+}
+
+// InUse returns the number of slots currently held.
+func (m *Manager) InUse() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.leases)
+}
+
+// Available returns the number of free slots.
+func (m *Manager) Available() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.size - len(m.leases)
+}
+
+// IsFree returns whether at least one slot is free.
+// This lets the Manager act as the queue dispatcher's stage gate.
+func (m *Manager) IsFree() bool {
+	return m.Available() > 0
+}
@@ -0,0 +1,129 @@
+package slot
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewManager(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int
+		wantSize int
+	}{
+		{
+			name:     "正常系: デフォルト1枠",
+			size:     1,
+			wantSize: 1,
+		},
+		{
+			name:     "正常系: 複数枠",
+			size:     3,
+			wantSize: 3,
+		},
+		{
+			name:     "異常系: 0以下はデフォルト",
+			size:     0,
+			wantSize: DefaultSize,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewManager(tt.size)
+			assert.Equal(t, tt.wantSize, m.Size())
+			assert.Equal(t, tt.wantSize, m.Available())
+			assert.True(t, m.IsFree())
+		})
+	}
+}
+
+func TestManager_AcquireRelease(t *testing.T) {
+	m := NewManager(2)
+
+	held, acquired := m.Acquire("user1")
+	assert.True(t, held)
+	assert.True(t, acquired)
+	held, _ = m.Acquire("user2")
+	assert.True(t, held)
+	held, acquired = m.Acquire("user3")
+	assert.False(t, held, "満席なら取得できない")
+	assert.False(t, acquired)
+	assert.False(t, m.IsFree())
+
+	// 既に保持しているセッションは保持したまま、新たに取得したことにはならない
+	held, acquired = m.Acquire("user1")
+	assert.True(t, held)
+	assert.False(t, acquired)
+	assert.Equal(t, 2, m.InUse())
+
+	assert.True(t, m.Release("user1"))
+	assert.False(t, m.Holds("user1"))
+	assert.True(t, m.IsFree())
+
+	// 二重解放は影響なし
+	assert.False(t, m.Release("user1"))
+	assert.Equal(t, 1, m.InUse())
+
+	held, acquired = m.Acquire("user3")
+	assert.True(t, held)
+	assert.True(t, acquired)
+	assert.True(t, m.Holds("user3"))
+}
+
+func TestManager_Concurrent(t *testing.T) {
+	m := NewManager(3)
+	var wg sync.WaitGroup
+	var acquired int32
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			if _, ok := m.Acquire("user" + string(rune('A'+id))); ok {
+				atomic.AddInt32(&acquired, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(3), atomic.LoadInt32(&acquired))
+	assert.Equal(t, 0, m.Available())
+}
+
+func TestManager_OnRelease(t *testing.T) {
+	m := NewManager(1)
+
+	var mu sync.Mutex
+	released := map[string]time.Duration{}
+	m.SetOnRelease(func(sessionID string, held time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		released[sessionID] = held
+	})
+
+	acquire(t, m, "user1")
+	time.Sleep(20 * time.Millisecond)
+	m.Release("user1")
+
+	// 保持していないセッションの解放は通知しない
+	m.Release("user2")
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Contains(t, released, "user1")
+	assert.GreaterOrEqual(t, released["user1"], 20*time.Millisecond)
+	assert.NotContains(t, released, "user2")
+}
+
+// acquire takes a slot for the session and fails the test if none is free.
+func acquire(t *testing.T, m *Manager, sessionID string) {
+	t.Helper()
+	held, _ := m.Acquire(sessionID)
+	require.True(t, held)
+}
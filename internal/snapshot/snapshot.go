@@ -0,0 +1,420 @@
+// Package snapshot persists sessions and the waiting queue so a restart
+// doesn't throw everyone out of line.
+package snapshot
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// FormatVersion is the version of the snapshot file format written by this build.
+// Bump it whenever the payload layout changes incompatibly.
+const FormatVersion = 1
+
+// DefaultInterval is how often snapshots are written in the background.
+const DefaultInterval = 30 * time.Second
+
+// DefaultGraceWindow is how long restored queue places are held for their
+// users to reconnect before they are dropped.
+const DefaultGraceWindow = 2 * time.Minute
+
+var (
+	// ErrCorrupt is returned when a snapshot file can't be decoded or fails its checksum.
+	ErrCorrupt = errors.New("snapshot: corrupt file")
+	// ErrUnsupportedVersion is returned when a snapshot was written with another format version.
+	ErrUnsupportedVersion = errors.New("snapshot: unsupported format version")
+)
+
+// Store is the session store being snapshotted.
+// session.SessionStore satisfies this interface.
+type Store interface {
+	ForEach(fn func(sessionID string, user *model.User, createdAt time.Time))
+	Put(sessionID string, user *model.User, createdAt time.Time)
+}
+
+// Queue is the waiting queue being snapshotted.
+// queue.WaitingQueue satisfies this interface, as does room.Manager for several queues.
+type Queue interface {
+	IDs() []string
+	Restore(userIDs []string)
+	DropRestored() int
+	BroadcastPositions()
+}
+
+// envelope is the on-disk layout. The checksum covers the raw payload.
+type envelope struct {
+	Version   int             `json:"version"`
+	CreatedAt time.Time       `json:"created_at"`
+	Checksum  string          `json:"checksum"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// payload is the snapshotted state.
+type payload struct {
+	Sessions []SessionRecord `json:"sessions"`
+	Queue    []string        `json:"queue"` // session IDs in queue order
+}
+
+// SessionRecord is the persisted form of a session.
+// WebSocket connections are not persisted; users reconnect after a restart.
+type SessionRecord struct {
+	SessionID        string                     `json:"session_id"`
+	UserID           string                     `json:"user_id"`
+	JoinedAt         time.Time                  `json:"joined_at"`
+	Status           string                     `json:"status"`
+	Queue            string                     `json:"queue,omitempty"`
+	StageAttempts    int                        `json:"stage_attempts,omitempty"`
+	PromotedAt       time.Time                  `json:"promoted_at,omitempty"`
+	Failures         int                        `json:"failures,omitempty"`
+	Retries          int                        `json:"retries,omitempty"`
+	RejoinAt         time.Time                  `json:"rejoin_at,omitempty"`
+	RejoinPosition   int                        `json:"rejoin_position,omitempty"`
+	DinoRunID        string                     `json:"dino_run_id,omitempty"`
+	DinoSeed         int64                      `json:"dino_seed,omitempty"`
+	DinoStartedAt    time.Time                  `json:"dino_started_at,omitempty"`
+	CaptchaTargetX   int                        `json:"captcha_target_x"`
+	CaptchaTargetY   int                        `json:"captcha_target_y"`
+	CaptchaAttempts  int                        `json:"captcha_attempts"`
+	OTPCode          int                        `json:"otp_code"`
+	OTPAttempts      int                        `json:"otp_attempts"`
+	RegisterToken    string                     `json:"register_token,omitempty"`
+	RegisterTokenExp time.Time                  `json:"register_token_exp,omitempty"`
+	Tasks            map[string]model.TaskState `json:"tasks,omitempty"`
+	CreatedAt        time.Time                  `json:"created_at"`
+}
+
+// newSessionRecord copies the persistable fields of a user.
+func newSessionRecord(sessionID string, user *model.User, createdAt time.Time) SessionRecord {
+	return SessionRecord{
+		SessionID:        sessionID,
+		UserID:           user.ID,
+		JoinedAt:         user.JoinedAt,
+		Status:           user.Status,
+		Queue:            user.Queue,
+		StageAttempts:    user.StageAttempts,
+		PromotedAt:       user.PromotedAt,
+		Failures:         user.Failures,
+		Retries:          user.Retries,
+		RejoinAt:         user.RejoinAt,
+		RejoinPosition:   user.RejoinPosition,
+		DinoRunID:        user.DinoRunID,
+		DinoSeed:         user.DinoSeed,
+		DinoStartedAt:    user.DinoStartedAt,
+		CaptchaTargetX:   user.CaptchaTargetX,
+		CaptchaTargetY:   user.CaptchaTargetY,
+		CaptchaAttempts:  user.CaptchaAttempts,
+		OTPCode:          user.OTPCode,
+		OTPAttempts:      user.OTPAttempts,
+		RegisterToken:    user.RegisterToken,
+		RegisterTokenExp: user.RegisterTokenExp,
+		Tasks:            user.TaskLedger(),
+		CreatedAt:        createdAt,
+	}
+}
+
+// user rebuilds the user described by the record.
+func (r SessionRecord) user() *model.User {
+	return &model.User{
+		ID:               r.UserID,
+		SessionID:        r.SessionID,
+		JoinedAt:         r.JoinedAt,
+		Status:           r.Status,
+		Queue:            r.Queue,
+		StageAttempts:    r.StageAttempts,
+		PromotedAt:       r.PromotedAt,
+		Failures:         r.Failures,
+		Retries:          r.Retries,
+		RejoinAt:         r.RejoinAt,
+		RejoinPosition:   r.RejoinPosition,
+		DinoRunID:        r.DinoRunID,
+		DinoSeed:         r.DinoSeed,
+		DinoStartedAt:    r.DinoStartedAt,
+		CaptchaTargetX:   r.CaptchaTargetX,
+		CaptchaTargetY:   r.CaptchaTargetY,
+		CaptchaAttempts:  r.CaptchaAttempts,
+		OTPCode:          r.OTPCode,
+		OTPAttempts:      r.OTPAttempts,
+		RegisterToken:    r.RegisterToken,
+		RegisterTokenExp: r.RegisterTokenExp,
+		Tasks:            r.Tasks,
+	}
+}
+
+// Snapshotter periodically writes sessions and the queue to a file and
+// restores them at boot.
+type Snapshotter struct {
+	path  string
+	store Store
+	queue Queue
+
+	mu          sync.Mutex
+	saveMu      sync.Mutex // serializes writes to path
+	interval    time.Duration
+	graceWindow time.Duration
+	graceTimer  clock.Timer
+	clock       clock.Clock
+	running     bool
+	stopCh      chan struct{}
+	doneCh      chan struct{}
+}
+
+// NewSnapshotter creates a new Snapshotter writing to path.
+func NewSnapshotter(path string, store Store, q Queue) *Snapshotter {
+	return &Snapshotter{
+		path:        path,
+		store:       store,
+		queue:       q,
+		interval:    DefaultInterval,
+		graceWindow: DefaultGraceWindow,
+		clock:       clock.Real{},
+	}
+}
+
+// SetClock sets the clock snapshots are stamped and scheduled with.
+// It applies from the next Start.
+func (s *Snapshotter) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+}
+
+// SetInterval sets how often snapshots are written. It applies from the next Start.
+func (s *Snapshotter) SetInterval(interval time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.interval = interval
+}
+
+// SetGraceWindow sets how long restored queue places wait for their users to reconnect.
+func (s *Snapshotter) SetGraceWindow(window time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.graceWindow = window
+}
+
+// Save writes the current state to the snapshot file atomically.
+func (s *Snapshotter) Save() error {
+	p := payload{Queue: s.queue.IDs()}
+	users := make(map[string]*model.User)
+	s.store.ForEach(func(sessionID string, user *model.User, createdAt time.Time) {
+		users[sessionID] = user
+		p.Sessions = append(p.Sessions, SessionRecord{SessionID: sessionID, CreatedAt: createdAt})
+	})
+	// Users are locked one at a time, outside the store's lock
+	for i, r := range p.Sessions {
+		user := users[r.SessionID]
+		user.Lock()
+		p.Sessions[i] = newSessionRecord(r.SessionID, user, r.CreatedAt)
+		user.Unlock()
+	}
+
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return fmt.Errorf("snapshot: encode payload: %w", err)
+	}
+	data, err := json.Marshal(envelope{
+		Version:   FormatVersion,
+		CreatedAt: s.now(),
+		Checksum:  checksum(raw),
+		Payload:   raw,
+	})
+	if err != nil {
+		return fmt.Errorf("snapshot: encode envelope: %w", err)
+	}
+
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return writeFileAtomic(s.path, data)
+}
+
+// Restore loads the snapshot file into the store and queue.
+// A missing file is not an error. A corrupt file is moved aside to
+// path+".corrupt" and a file with another format version is ignored;
+// in both cases the server starts fresh and the error is returned for logging.
+// Restored queue places are held for the grace window, then dropped if
+// their users haven't reconnected.
+func (s *Snapshotter) Restore() (int, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("snapshot: read: %w", err)
+	}
+
+	p, err := decode(data)
+	if errors.Is(err, ErrCorrupt) {
+		if renameErr := os.Rename(s.path, s.path+".corrupt"); renameErr != nil {
+			log.Printf("[Snapshot] Failed to move corrupt snapshot aside: %v", renameErr)
+		}
+		return 0, err
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	known := make(map[string]bool, len(p.Sessions))
+	for _, r := range p.Sessions {
+		s.store.Put(r.SessionID, r.user(), r.CreatedAt)
+		known[r.SessionID] = true
+	}
+
+	// Only sessions that still exist can hold a place
+	ids := make([]string, 0, len(p.Queue))
+	for _, id := range p.Queue {
+		if known[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > 0 {
+		s.queue.Restore(ids)
+		s.scheduleGrace()
+	}
+
+	return len(p.Sessions), nil
+}
+
+// Start launches the periodic snapshot loop. Calling Start on a running snapshotter is a no-op.
+func (s *Snapshotter) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running || s.interval <= 0 {
+		return
+	}
+	s.running = true
+	s.stopCh = make(chan struct{})
+	s.doneCh = make(chan struct{})
+
+	go s.run(s.stopCh, s.doneCh, s.clock.NewTicker(s.interval))
+}
+
+// Stop stops the periodic loop and writes a final snapshot.
+func (s *Snapshotter) Stop() error {
+	s.mu.Lock()
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+	}
+	if s.running {
+		s.running = false
+		close(s.stopCh)
+		doneCh := s.doneCh
+		s.mu.Unlock()
+		<-doneCh
+	} else {
+		s.mu.Unlock()
+	}
+
+	return s.Save()
+}
+
+// IsRunning returns whether the periodic loop is running.
+func (s *Snapshotter) IsRunning() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.running
+}
+
+// run is the periodic snapshot loop.
+func (s *Snapshotter) run(stopCh <-chan struct{}, doneCh chan<- struct{}, ticker clock.Ticker) {
+	defer close(doneCh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C():
+			if err := s.Save(); err != nil {
+				log.Printf("[Snapshot] Failed to save: %v", err)
+			}
+		}
+	}
+}
+
+// now returns the time of the snapshotter's clock.
+func (s *Snapshotter) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now()
+}
+
+// scheduleGrace drops the restored queue places still unclaimed once the
+// grace window has passed. Places reserved since, e.g. after a failure, stay.
+func (s *Snapshotter) scheduleGrace() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.graceTimer != nil {
+		s.graceTimer.Stop()
+	}
+	s.graceTimer = s.clock.AfterFunc(s.graceWindow, func() {
+		if dropped := s.queue.DropRestored(); dropped > 0 {
+			log.Printf("[Snapshot] Dropped %d restored queue places that were not reclaimed", dropped)
+			s.queue.BroadcastPositions()
+		}
+	})
+}
+
+// decode validates and decodes a snapshot file.
+func decode(data []byte) (*payload, error) {
+	var env envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if env.Version != FormatVersion {
+		return nil, fmt.Errorf("%w: got %d, want %d", ErrUnsupportedVersion, env.Version, FormatVersion)
+	}
+	if env.Checksum != checksum(env.Payload) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+
+	var p payload
+	if err := json.Unmarshal(env.Payload, &p); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	return &p, nil
+}
+
+// checksum returns the hex SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so a crash mid-write never leaves a truncated snapshot.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("snapshot: create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("snapshot: write: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("snapshot: sync: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("snapshot: close: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("snapshot: rename: %w", err)
+	}
+	return nil
+}
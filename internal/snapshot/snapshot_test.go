@@ -0,0 +1,212 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/queue"
+	"github.com/kyiku/hackz-ptera-back/internal/session"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newPopulated returns a store and queue with two waiting users and one in the Dino stage.
+func newPopulated(t *testing.T) (*session.SessionStore, *queue.WaitingQueue, []string) {
+	t.Helper()
+
+	store := session.NewSessionStore()
+	q := queue.NewWaitingQueue()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		_, sessionID := store.Create()
+		ids = append(ids, sessionID)
+	}
+
+	playing, _ := store.Get(ids[0])
+	playing.Status = model.StatusStage1Dino
+	playing.CaptchaAttempts = 2
+	playing.Queue = "public"
+
+	q.Add(ids[1], testutil.NewMockWebSocketConn())
+	q.Add(ids[2], testutil.NewMockWebSocketConn())
+
+	return store, q, ids
+}
+
+func TestSnapshotter_SaveAndRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	store, q, ids := newPopulated(t)
+
+	require.NoError(t, NewSnapshotter(path, store, q).Save())
+
+	restoredStore := session.NewSessionStore()
+	restoredQueue := queue.NewWaitingQueue()
+	s := NewSnapshotter(path, restoredStore, restoredQueue)
+
+	n, err := s.Restore()
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+
+	playing, ok := restoredStore.Get(ids[0])
+	require.True(t, ok)
+	assert.Equal(t, model.StatusStage1Dino, playing.Status)
+	assert.Equal(t, 2, playing.CaptchaAttempts)
+	assert.Equal(t, "public", playing.Queue)
+	assert.Equal(t, ids[0], playing.SessionID)
+
+	// 待機列の順番が復元される
+	assert.Equal(t, []string{ids[1], ids[2]}, restoredQueue.IDs())
+	position, found := restoredQueue.GetPosition(ids[2])
+	require.True(t, found)
+	assert.Equal(t, 2, position)
+}
+
+func TestSnapshotter_RestoreMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.json")
+	s := NewSnapshotter(path, session.NewSessionStore(), queue.NewWaitingQueue())
+
+	n, err := s.Restore()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestSnapshotter_RestoreCorrupt(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(t *testing.T, data []byte) []byte
+	}{
+		{
+			name: "異常系: JSONとして不正",
+			mutate: func(t *testing.T, data []byte) []byte {
+				return data[:len(data)/2]
+			},
+		},
+		{
+			name: "異常系: チェックサム不一致",
+			mutate: func(t *testing.T, data []byte) []byte {
+				var env envelope
+				require.NoError(t, json.Unmarshal(data, &env))
+				env.Checksum = checksum([]byte("tampered"))
+				out, err := json.Marshal(env)
+				require.NoError(t, err)
+				return out
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "snapshot.json")
+			store, q, _ := newPopulated(t)
+			require.NoError(t, NewSnapshotter(path, store, q).Save())
+
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(path, tt.mutate(t, data), 0o600))
+
+			restoredStore := session.NewSessionStore()
+			restoredQueue := queue.NewWaitingQueue()
+			n, err := NewSnapshotter(path, restoredStore, restoredQueue).Restore()
+
+			assert.ErrorIs(t, err, ErrCorrupt)
+			assert.Equal(t, 0, n)
+			assert.Equal(t, 0, restoredQueue.Len())
+
+			// 壊れたファイルは退避される
+			_, err = os.Stat(path + ".corrupt")
+			assert.NoError(t, err)
+			_, err = os.Stat(path)
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestSnapshotter_RestoreUnsupportedVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	raw := json.RawMessage(`{"sessions":[],"queue":[]}`)
+	data, err := json.Marshal(envelope{Version: FormatVersion + 1, Checksum: checksum(raw), Payload: raw})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	_, err = NewSnapshotter(path, session.NewSessionStore(), queue.NewWaitingQueue()).Restore()
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestSnapshotter_GraceWindow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	store, q, ids := newPopulated(t)
+	require.NoError(t, NewSnapshotter(path, store, q).Save())
+
+	restoredQueue := queue.NewWaitingQueue()
+	s := NewSnapshotter(path, session.NewSessionStore(), restoredQueue)
+	s.SetGraceWindow(30 * time.Millisecond)
+
+	_, err := s.Restore()
+	require.NoError(t, err)
+
+	// 猶予時間内に再接続したユーザーは場所を取り戻す
+	require.True(t, restoredQueue.Reclaim(ids[2], testutil.NewMockWebSocketConn()))
+
+	err = testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return restoredQueue.Len() == 1
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{ids[2]}, restoredQueue.IDs())
+}
+
+func TestSnapshotter_GraceWindowKeepsOtherReservations(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	store, q, ids := newPopulated(t)
+	require.NoError(t, NewSnapshotter(path, store, q).Save())
+
+	clk := clock.NewFake(time.Now())
+	restoredQueue := queue.NewWaitingQueue()
+	s := NewSnapshotter(path, session.NewSessionStore(), restoredQueue)
+	s.SetClock(clk)
+	s.SetGraceWindow(time.Minute)
+
+	_, err := s.Restore()
+	require.NoError(t, err)
+
+	// 猶予中に失敗して待機列に戻されたユーザーの場所は、猶予が明けても残る
+	restoredQueue.Reserve([]string{"requeued"})
+	clk.Advance(time.Minute)
+
+	assert.Equal(t, []string{"requeued"}, restoredQueue.IDs())
+	assert.True(t, restoredQueue.Reclaim("requeued", testutil.NewMockWebSocketConn()))
+	_, found := restoredQueue.GetPosition(ids[1])
+	assert.False(t, found)
+}
+
+func TestSnapshotter_PeriodicAndStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	store, q, _ := newPopulated(t)
+
+	s := NewSnapshotter(path, store, q)
+	s.SetInterval(10 * time.Millisecond)
+	s.Start()
+	assert.True(t, s.IsRunning())
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		_, err := os.Stat(path)
+		return err == nil
+	})
+	require.NoError(t, err)
+
+	// 停止時に最終スナップショットを書く
+	store.Create()
+	require.NoError(t, s.Stop())
+	assert.False(t, s.IsRunning())
+
+	restoredStore := session.NewSessionStore()
+	n, err := NewSnapshotter(path, restoredStore, queue.NewWaitingQueue()).Restore()
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+}
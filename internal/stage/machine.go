@@ -0,0 +1,220 @@
+package stage
+
+import (
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// ErrInvalidTransition is returned when an event is not allowed in the user's current status.
+var ErrInvalidTransition = errors.New("INVALID_TRANSITION")
+
+// Event is something that happened to a user and may move them to another status.
+type Event string
+
+// Events driving the status of a user.
+const (
+	EventPromote Event = "promote" // the user's turn came up in the queue
+	EventPass    Event = "pass"    // the user cleared their gate and moves to the next one
+	EventFail    Event = "fail"    // the user failed or timed out and goes back to the queue
+)
+
+// Transition is one declared edge of the state machine.
+type Transition struct {
+	From  string
+	Event Event
+	To    string
+}
+
+// Hook runs when a user leaves or enters a status.
+// Hooks run with the user's lock held, so they must not lock the user again
+// (directly or through the session store).
+type Hook func(user *model.User, tr Transition)
+
+// Machine is the only place a user's status changes.
+// It applies declared transitions, runs exit and entry hooks around them and
+// counts both applied and rejected transitions.
+type Machine struct {
+	pipeline *Pipeline
+
+	mu          sync.RWMutex
+	transitions map[string]map[Event]string // from -> event -> to
+	onExit      map[string][]Hook
+	onEnter     map[string][]Hook
+
+	statsMu sync.Mutex
+	applied map[Transition]int64
+	illegal map[string]int64 // "from:event" -> count
+}
+
+// NewMachine creates a Machine for the default pipeline.
+func NewMachine() *Machine {
+	return NewMachineFor(DefaultPipeline())
+}
+
+// NewMachineFor creates a Machine for the pipeline.
+// Entering waiting resets the user's stage state, and entering a gate resets
+// the gate's attempt count and sends a stage_change message over the user's WebSocket.
+func NewMachineFor(p *Pipeline) *Machine {
+	m := &Machine{
+		pipeline:    p,
+		transitions: make(map[string]map[Event]string),
+		onExit:      make(map[string][]Hook),
+		onEnter:     make(map[string][]Hook),
+		applied:     make(map[Transition]int64),
+		illegal:     make(map[string]int64),
+	}
+	for _, tr := range p.Transitions() {
+		if m.transitions[tr.From] == nil {
+			m.transitions[tr.From] = make(map[Event]string)
+		}
+		m.transitions[tr.From][tr.Event] = tr.To
+	}
+
+	m.OnEnter(model.StatusWaiting, resetOnWaiting)
+	for _, g := range p.gates {
+		m.OnEnter(g.Status, m.enterGate)
+	}
+	return m
+}
+
+// Pipeline returns the pipeline the machine drives.
+func (m *Machine) Pipeline() *Pipeline {
+	return m.pipeline
+}
+
+// Current returns the gate the user is in. The caller must hold the user's lock.
+func (m *Machine) Current(user *model.User) (Gate, bool) {
+	return m.pipeline.Gate(user.Status)
+}
+
+// OnExit registers a hook run when a user leaves the status.
+func (m *Machine) OnExit(status string, hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExit[status] = append(m.onExit[status], hook)
+}
+
+// OnEnter registers a hook run when a user enters the status.
+func (m *Machine) OnEnter(status string, hook Hook) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onEnter[status] = append(m.onEnter[status], hook)
+}
+
+// Can reports whether the event is allowed in the given status.
+func (m *Machine) Can(from string, event Event) bool {
+	_, ok := m.next(from, event)
+	return ok
+}
+
+// EventTo returns the event leading from one status to another.
+func (m *Machine) EventTo(from, to string) (Event, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for event, target := range m.transitions[from] {
+		if target == to {
+			return event, true
+		}
+	}
+	return "", false
+}
+
+// Fire applies the event to the user: exit hooks of the current status run,
+// the status changes, then entry hooks of the new status run.
+// An event that is not allowed in the current status is logged, counted and
+// rejected with ErrInvalidTransition, leaving the user untouched.
+// The caller must hold the user's lock.
+func (m *Machine) Fire(user *model.User, event Event) error {
+	from := user.Status
+	to, ok := m.next(from, event)
+	if !ok {
+		log.Printf("[stage] Illegal transition for user %s: %q in status %s", user.ID, event, from)
+		m.statsMu.Lock()
+		m.illegal[from+":"+string(event)]++
+		m.statsMu.Unlock()
+		return ErrInvalidTransition
+	}
+
+	tr := Transition{From: from, Event: event, To: to}
+
+	m.mu.RLock()
+	exit := m.onExit[from]
+	enter := m.onEnter[to]
+	m.mu.RUnlock()
+
+	for _, hook := range exit {
+		hook(user, tr)
+	}
+	user.Status = to
+	for _, hook := range enter {
+		hook(user, tr)
+	}
+
+	m.statsMu.Lock()
+	m.applied[tr]++
+	m.statsMu.Unlock()
+	return nil
+}
+
+// Stats returns the gates of the pipeline and the number of applied transitions and rejected events.
+func (m *Machine) Stats() map[string]interface{} {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+
+	applied := make(map[string]int64, len(m.applied))
+	for tr, n := range m.applied {
+		applied[tr.From+" -> "+tr.To] += n
+	}
+	illegal := make(map[string]int64, len(m.illegal))
+	var illegalTotal int64
+	for key, n := range m.illegal {
+		illegal[key] = n
+		illegalTotal += n
+	}
+	pipeline := make([]string, 0, len(m.pipeline.gates))
+	for _, g := range m.pipeline.gates {
+		pipeline = append(pipeline, g.Name)
+	}
+	return map[string]interface{}{
+		"pipeline":      pipeline,
+		"transitions":   applied,
+		"illegal":       illegal,
+		"illegal_total": illegalTotal,
+	}
+}
+
+// next returns the status the event leads to from the given status.
+func (m *Machine) next(from string, event Event) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	to, ok := m.transitions[from][event]
+	return to, ok
+}
+
+// resetOnWaiting clears the stage state of a user sent back to the queue.
+func resetOnWaiting(user *model.User, _ Transition) {
+	user.ResetToWaiting()
+}
+
+// enterGate starts the user's attempts at a gate afresh and tells them which stage they have entered.
+func (m *Machine) enterGate(user *model.User, tr Transition) {
+	user.StageAttempts = 0
+	if user.Conn == nil {
+		return
+	}
+	gate, _ := m.pipeline.Gate(tr.To)
+	message, ok := stageMessages[gate.Kind]
+	if !ok {
+		message = "ステージが変更されました"
+	}
+	_ = user.Conn.WriteJSON(map[string]interface{}{
+		"type":    "stage_change",
+		"stage":   tr.To,
+		"status":  tr.To,
+		"gate":    gate.Name,
+		"message": message,
+	})
+}
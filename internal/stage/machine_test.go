@@ -0,0 +1,147 @@
+package stage
+
+import (
+	"testing"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMachine_Fire(t *testing.T) {
+	tests := []struct {
+		name       string
+		fromStatus string
+		event      Event
+		wantStatus string
+		wantErr    bool
+	}{
+		{"waiting -> stage1_dino", model.StatusWaiting, EventPromote, model.StatusStage1Dino, false},
+		{"stage1_dino -> registering", model.StatusStage1Dino, EventPass, model.StatusRegistering, false},
+		{"stage1_dino -> waiting（失敗）", model.StatusStage1Dino, EventFail, model.StatusWaiting, false},
+		{"registering -> waiting（失敗）", model.StatusRegistering, EventFail, model.StatusWaiting, false},
+		{"waiting で失敗（何もしない）", model.StatusWaiting, EventFail, model.StatusWaiting, false},
+		{"waiting でクリア（不正）", model.StatusWaiting, EventPass, model.StatusWaiting, true},
+		{"registering で昇格（不正）", model.StatusRegistering, EventPromote, model.StatusRegistering, true},
+		{"registering で通過（最後のゲート）", model.StatusRegistering, EventPass, model.StatusRegistering, true},
+		{"stage2_captcha（パイプラインにない）", model.StatusStage2Captcha, EventPass, model.StatusStage2Captcha, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &model.User{ID: "user1", Status: tt.fromStatus}
+
+			err := NewMachine().Fire(user, tt.event)
+
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidTransition)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantStatus, user.Status)
+		})
+	}
+}
+
+func TestMachine_Hooks(t *testing.T) {
+	m := NewMachine()
+
+	var calls []string
+	m.OnExit(model.StatusStage1Dino, func(u *model.User, tr Transition) {
+		assert.Equal(t, model.StatusStage1Dino, u.Status)
+		calls = append(calls, "exit:"+tr.From)
+	})
+	m.OnEnter(model.StatusRegistering, func(u *model.User, tr Transition) {
+		assert.Equal(t, model.StatusRegistering, u.Status)
+		calls = append(calls, "enter:"+tr.To+":"+string(tr.Event))
+	})
+
+	user := &model.User{ID: "user1", Status: model.StatusStage1Dino}
+	require.NoError(t, m.Fire(user, EventPass))
+
+	assert.Equal(t, []string{"exit:stage1_dino", "enter:registering:pass"}, calls)
+
+	// 不正な遷移ではフックは動かない
+	calls = nil
+	assert.Error(t, m.Fire(user, EventPromote))
+	assert.Empty(t, calls)
+}
+
+func TestMachine_DefaultHooks(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	user := &model.User{
+		ID:              "user1",
+		Status:          model.StatusWaiting,
+		CaptchaAttempts: 2,
+		Conn:            mockConn,
+	}
+	m := NewMachine()
+
+	// 昇格すると stage_change が送られる
+	require.NoError(t, m.Fire(user, EventPromote))
+	msg := mockConn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, "stage_change", msg["type"])
+	assert.Equal(t, model.StatusStage1Dino, msg["stage"])
+	assert.Equal(t, model.StatusStage1Dino, msg["status"])
+	assert.Equal(t, "dino", msg["gate"])
+
+	// 待機列に戻るとステージの状態がリセットされ、stage_change は送られない
+	sent := len(mockConn.GetMessages())
+	require.NoError(t, m.Fire(user, EventFail))
+	assert.Equal(t, 0, user.CaptchaAttempts)
+	assert.Len(t, mockConn.GetMessages(), sent)
+}
+
+func TestMachine_Stats(t *testing.T) {
+	m := NewMachine()
+
+	user := &model.User{ID: "user1", Status: model.StatusWaiting}
+	require.NoError(t, m.Fire(user, EventPromote))
+	require.NoError(t, m.Fire(user, EventFail))
+	require.NoError(t, m.Fire(user, EventPromote))
+	assert.Error(t, m.Fire(user, EventPromote))
+
+	stats := m.Stats()
+	transitions := stats["transitions"].(map[string]int64)
+	assert.Equal(t, int64(2), transitions["waiting -> stage1_dino"])
+	assert.Equal(t, int64(1), transitions["stage1_dino -> waiting"])
+	assert.Equal(t, int64(1), stats["illegal"].(map[string]int64)["stage1_dino:promote"])
+	assert.Equal(t, int64(1), stats["illegal_total"])
+}
+
+func TestMachine_ConfiguredPipeline(t *testing.T) {
+	// Dino の後に CAPTCHA ゲートと2つ目のゲームを挟む
+	p, err := NewPipeline([]Gate{
+		{Name: "dino", Kind: KindDino},
+		{Name: "captcha", Kind: KindCaptcha, MaxAttempts: 5},
+		{Name: "dino-night", Kind: KindDino},
+		{Name: "register", Kind: KindRegister},
+	})
+	require.NoError(t, err)
+	m := NewMachineFor(p)
+
+	user := &model.User{ID: "user1", Status: model.StatusWaiting}
+	require.NoError(t, m.Fire(user, EventPromote))
+	assert.Equal(t, model.StatusStage1Dino, user.Status)
+
+	require.NoError(t, m.Fire(user, EventPass))
+	assert.Equal(t, model.StatusStage2Captcha, user.Status)
+	gate, ok := m.Current(user)
+	require.True(t, ok)
+	assert.Equal(t, KindCaptcha, gate.Kind)
+	assert.Equal(t, 5, gate.MaxAttempts)
+
+	// ゲートに入るたびに試行回数はリセットされる
+	user.StageAttempts = 2
+	require.NoError(t, m.Fire(user, EventPass))
+	assert.Equal(t, "dino-night", user.Status)
+	assert.Equal(t, 0, user.StageAttempts)
+
+	require.NoError(t, m.Fire(user, EventPass))
+	assert.Equal(t, model.StatusRegistering, user.Status)
+
+	require.NoError(t, m.Fire(user, EventFail))
+	assert.Equal(t, model.StatusWaiting, user.Status)
+}
@@ -0,0 +1,144 @@
+package stage
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+)
+
+// Gate kinds, i.e. which handler runs a gate.
+const (
+	KindDino     = "dino"     // a Dino Run game
+	KindCaptcha  = "captcha"  // a standalone CAPTCHA gate
+	KindRegister = "register" // the registration dashboard, always the last gate
+)
+
+// Gate is one stage of the pipeline a user goes through after the queue.
+type Gate struct {
+	Name        string        // unique name, reported to clients as next_stage
+	Kind        string        // KindDino, KindCaptcha or KindRegister
+	Status      string        // user status while in the gate
+	Timeout     time.Duration // how long a user may stay in the gate, 0 for no limit
+	MaxAttempts int           // failures allowed before the user goes back to the queue
+}
+
+// Pipeline is the ordered list of gates between the queue and registration.
+type Pipeline struct {
+	gates    []Gate
+	byStatus map[string]int // status -> index in gates
+}
+
+// DefaultPipeline returns the Dino -> registration pipeline.
+func DefaultPipeline() *Pipeline {
+	p, _ := NewPipeline([]Gate{
+		{Name: "dino", Kind: KindDino, Timeout: 3 * time.Minute, MaxAttempts: 1},
+		{Name: "register", Kind: KindRegister, Timeout: 10 * time.Minute, MaxAttempts: 1},
+	})
+	return p
+}
+
+// NewPipeline creates a pipeline from its gates in order.
+// A gate without a status gets StatusFor its name, and MaxAttempts below 1 means 1.
+// The last gate must be the registration dashboard and gate names and statuses must be unique.
+func NewPipeline(gates []Gate) (*Pipeline, error) {
+	if len(gates) == 0 {
+		return nil, fmt.Errorf("stage pipeline is empty")
+	}
+
+	p := &Pipeline{
+		gates:    make([]Gate, len(gates)),
+		byStatus: make(map[string]int, len(gates)),
+	}
+	names := make(map[string]bool, len(gates))
+	for i, g := range gates {
+		switch g.Kind {
+		case KindDino, KindCaptcha:
+		case KindRegister:
+			if i != len(gates)-1 {
+				return nil, fmt.Errorf("stage %q: the register gate must be last", g.Name)
+			}
+		default:
+			return nil, fmt.Errorf("stage %q: unknown kind %q", g.Name, g.Kind)
+		}
+		if g.Status == "" {
+			g.Status = StatusFor(g.Name)
+		}
+		if g.MaxAttempts < 1 {
+			g.MaxAttempts = 1
+		}
+		if g.Name == "" || names[g.Name] {
+			return nil, fmt.Errorf("stage name %q is empty or repeated", g.Name)
+		}
+		if _, dup := p.byStatus[g.Status]; dup || g.Status == model.StatusWaiting {
+			return nil, fmt.Errorf("stage %q: status %q is already in use", g.Name, g.Status)
+		}
+		names[g.Name] = true
+		p.byStatus[g.Status] = i
+		p.gates[i] = g
+	}
+	if p.gates[len(gates)-1].Kind != KindRegister {
+		return nil, fmt.Errorf("stage pipeline must end with a %q gate", KindRegister)
+	}
+	return p, nil
+}
+
+// StatusFor returns the user status of a gate with the given name.
+// The built-in gates keep their historical statuses, other gates use their name.
+func StatusFor(name string) string {
+	switch name {
+	case "dino":
+		return model.StatusStage1Dino
+	case "captcha":
+		return model.StatusStage2Captcha
+	case "register":
+		return model.StatusRegistering
+	}
+	return name
+}
+
+// Gates returns the gates in order.
+func (p *Pipeline) Gates() []Gate {
+	return append([]Gate(nil), p.gates...)
+}
+
+// First returns the gate users are promoted into from the queue.
+func (p *Pipeline) First() Gate {
+	return p.gates[0]
+}
+
+// Gate returns the gate a user with the given status is in.
+func (p *Pipeline) Gate(status string) (Gate, bool) {
+	i, ok := p.byStatus[status]
+	if !ok {
+		return Gate{}, false
+	}
+	return p.gates[i], true
+}
+
+// Next returns the gate after the one a user with the given status is in.
+func (p *Pipeline) Next(status string) (Gate, bool) {
+	i, ok := p.byStatus[status]
+	if !ok || i+1 >= len(p.gates) {
+		return Gate{}, false
+	}
+	return p.gates[i+1], true
+}
+
+// Transitions returns the state machine transitions of the pipeline:
+// promotion into the first gate, passing each gate into the next one and
+// failing any gate back to the queue.
+func (p *Pipeline) Transitions() []Transition {
+	transitions := []Transition{
+		{From: model.StatusWaiting, Event: EventPromote, To: p.gates[0].Status},
+		// A failure reported after the user was already sent back only clears leftover state
+		{From: model.StatusWaiting, Event: EventFail, To: model.StatusWaiting},
+	}
+	for i, g := range p.gates {
+		if i+1 < len(p.gates) {
+			transitions = append(transitions, Transition{From: g.Status, Event: EventPass, To: p.gates[i+1].Status})
+		}
+		transitions = append(transitions, Transition{From: g.Status, Event: EventFail, To: model.StatusWaiting})
+	}
+	return transitions
+}
@@ -0,0 +1,68 @@
+package stage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDefaultPipeline(t *testing.T) {
+	p := DefaultPipeline()
+
+	gates := p.Gates()
+	require.Len(t, gates, 2)
+	assert.Equal(t, Gate{Name: "dino", Kind: KindDino, Status: model.StatusStage1Dino, Timeout: 3 * time.Minute, MaxAttempts: 1}, gates[0])
+	assert.Equal(t, Gate{Name: "register", Kind: KindRegister, Status: model.StatusRegistering, Timeout: 10 * time.Minute, MaxAttempts: 1}, gates[1])
+
+	assert.Equal(t, "dino", p.First().Name)
+	next, ok := p.Next(model.StatusStage1Dino)
+	require.True(t, ok)
+	assert.Equal(t, "register", next.Name)
+	_, ok = p.Next(model.StatusRegistering)
+	assert.False(t, ok)
+	_, ok = p.Gate(model.StatusWaiting)
+	assert.False(t, ok)
+}
+
+func TestNewPipeline_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		gates []Gate
+	}{
+		{"空", nil},
+		{"register で終わらない", []Gate{{Name: "dino", Kind: KindDino}}},
+		{"register が途中にある", []Gate{{Name: "register", Kind: KindRegister}, {Name: "dino", Kind: KindDino}}},
+		{"不明な種類", []Gate{{Name: "quiz", Kind: "quiz"}, {Name: "register", Kind: KindRegister}}},
+		{"名前の重複", []Gate{{Name: "dino", Kind: KindDino}, {Name: "dino", Kind: KindDino}, {Name: "register", Kind: KindRegister}}},
+		{"waiting をステータスに使う", []Gate{{Name: "waiting", Kind: KindDino}, {Name: "register", Kind: KindRegister}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewPipeline(tt.gates)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPipeline_Transitions(t *testing.T) {
+	p, err := NewPipeline([]Gate{
+		{Name: "dino", Kind: KindDino},
+		{Name: "captcha", Kind: KindCaptcha},
+		{Name: "register", Kind: KindRegister},
+	})
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []Transition{
+		{From: model.StatusWaiting, Event: EventPromote, To: model.StatusStage1Dino},
+		{From: model.StatusWaiting, Event: EventFail, To: model.StatusWaiting},
+		{From: model.StatusStage1Dino, Event: EventPass, To: model.StatusStage2Captcha},
+		{From: model.StatusStage1Dino, Event: EventFail, To: model.StatusWaiting},
+		{From: model.StatusStage2Captcha, Event: EventPass, To: model.StatusRegistering},
+		{From: model.StatusStage2Captcha, Event: EventFail, To: model.StatusWaiting},
+		{From: model.StatusRegistering, Event: EventFail, To: model.StatusWaiting},
+	}, p.Transitions())
+}
@@ -2,58 +2,47 @@
 package stage
 
 import (
-	"errors"
-
 	"github.com/kyiku/hackz-ptera-back/internal/model"
 )
 
-// stageMessages contains the WebSocket messages for each stage.
+// stageMessages contains the WebSocket messages for each kind of gate.
 var stageMessages = map[string]string{
-	"stage1_dino":    "Dino Run ゲームを開始してください",
-	"stage2_captcha": "CAPTCHAを解いてください",
-	"registering":    "登録フォームに入力してください",
+	KindDino:     "Dino Run ゲームを開始してください",
+	KindCaptcha:  "CAPTCHAを解いてください",
+	KindRegister: "登録フォームに入力してください",
 }
 
-// TransitionManager manages stage transitions.
-type TransitionManager struct{}
+// TransitionManager moves users to a target status through a Machine.
+type TransitionManager struct {
+	machine *Machine
+}
 
-// NewTransitionManager creates a new TransitionManager.
+// NewTransitionManager creates a new TransitionManager backed by a default Machine.
 func NewTransitionManager() *TransitionManager {
-	return &TransitionManager{}
+	return &TransitionManager{machine: NewMachine()}
+}
+
+// NewTransitionManagerFor creates a TransitionManager backed by the given Machine.
+func NewTransitionManagerFor(machine *Machine) *TransitionManager {
+	return &TransitionManager{machine: machine}
 }
 
 // CanTransition checks if the user can transition to the target status.
 // Returns (valid, errorCode).
 func (m *TransitionManager) CanTransition(user *model.User, toStatus string) (bool, string) {
-	if user.CanTransitionTo(toStatus) {
+	if _, ok := m.machine.EventTo(user.Status, toStatus); ok {
 		return true, ""
 	}
-	return false, "INVALID_TRANSITION"
+	return false, ErrInvalidTransition.Error()
 }
 
 // Execute performs the stage transition and notifies the user.
+// The caller must hold the user's lock.
 func (m *TransitionManager) Execute(user *model.User, toStatus string) error {
-	valid, errCode := m.CanTransition(user, toStatus)
-	if !valid {
-		return errors.New(errCode)
+	event, ok := m.machine.EventTo(user.Status, toStatus)
+	if !ok {
+		// Let the machine log and count the rejection
+		event = Event("to_" + toStatus)
 	}
-
-	// Update user status
-	user.Status = toStatus
-
-	// Send WebSocket notification
-	if user.Conn != nil {
-		message, ok := stageMessages[toStatus]
-		if !ok {
-			message = "ステージが変更されました"
-		}
-
-		_ = user.Conn.WriteJSON(map[string]interface{}{
-			"type":    "stage_change",
-			"stage":   toStatus,
-			"message": message,
-		})
-	}
-
-	return nil
+	return m.machine.Fire(user, event)
 }
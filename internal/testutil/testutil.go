@@ -42,6 +42,7 @@ type MockWebSocketConn struct {
 	CloseChan   chan struct{}
 	WriteErr    error
 	CloseErr    error
+	Deadline    time.Time // last write deadline set
 }
 
 // NewMockWebSocketConn creates a new MockWebSocketConn.
@@ -104,6 +105,14 @@ func (m *MockWebSocketConn) Close() error {
 	return nil
 }
 
+// SetWriteDeadline mocks setting the write deadline.
+func (m *MockWebSocketConn) SetWriteDeadline(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Deadline = t
+	return nil
+}
+
 // GetIsClosed returns the closed state of the connection in a thread-safe manner.
 func (m *MockWebSocketConn) GetIsClosed() bool {
 	m.mu.Lock()
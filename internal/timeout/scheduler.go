@@ -0,0 +1,290 @@
+// Package timeout runs named deadlines per user on one hashed timing wheel,
+// so thousands of deadlines cost one goroutine and a few map entries
+// instead of a timer each.
+package timeout
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+)
+
+// Defaults of the wheel: deadlines fire at most DefaultTick late, and
+// DefaultSlots slots cover DefaultTick*DefaultSlots before deadlines wrap around.
+const (
+	DefaultTick  = 100 * time.Millisecond
+	DefaultSlots = 512
+)
+
+// entry is one scheduled deadline.
+type entry struct {
+	id       string
+	name     string
+	deadline time.Time
+	fn       func()
+	slot     int
+	rounds   int // full turns of the wheel left before it fires
+}
+
+// Scheduler fires named deadlines per user. Each user (id) has at most one
+// deadline per name; scheduling a name again replaces it.
+//
+// Deadlines are kept in the slot of the tick they fall in. Every tick the
+// wheel moves one slot and fires the due entries of that slot, so scheduling,
+// cancelling and extending are O(1) and a tick only looks at one slot.
+type Scheduler struct {
+	mu      sync.Mutex
+	clock   clock.Clock
+	tick    time.Duration
+	slots   []map[*entry]struct{}
+	entries map[string]map[string]*entry // id -> name -> entry
+	pending int
+	fired   int64
+	cursor  int       // slot of the last tick
+	last    time.Time // time of the last tick
+	stopCh  chan struct{}
+}
+
+// NewScheduler creates a scheduler whose wheel moves every tick over the
+// given number of slots. Values below 1 use the defaults.
+func NewScheduler(tick time.Duration, slots int) *Scheduler {
+	if tick <= 0 {
+		tick = DefaultTick
+	}
+	if slots < 1 {
+		slots = DefaultSlots
+	}
+	s := &Scheduler{
+		clock:   clock.Real{},
+		tick:    tick,
+		slots:   make([]map[*entry]struct{}, slots),
+		entries: make(map[string]map[string]*entry),
+	}
+	for i := range s.slots {
+		s.slots[i] = make(map[*entry]struct{})
+	}
+	s.last = s.clock.Now()
+	return s
+}
+
+// SetClock sets the clock the scheduler reads and ticks on. Call it before
+// scheduling anything or starting the scheduler. Tests pass a clock.Fake and
+// call Advance after moving it.
+func (s *Scheduler) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = c
+	s.last = c.Now()
+}
+
+// Now returns the time of the scheduler's clock.
+func (s *Scheduler) Now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clock.Now()
+}
+
+// Tick returns how often the wheel moves, i.e. how late a deadline may fire.
+func (s *Scheduler) Tick() time.Duration {
+	return s.tick
+}
+
+// Schedule runs fn once the named deadline of the user passes, after the given duration.
+// It replaces the user's deadline of the same name. fn runs on the scheduler's
+// goroutine without any lock held, so it may schedule or cancel deadlines.
+func (s *Scheduler) Schedule(id, name string, after time.Duration, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduleLocked(id, name, s.clock.Now().Add(after), fn)
+}
+
+// ScheduleAt is like Schedule with the deadline as a point in time.
+func (s *Scheduler) ScheduleAt(id, name string, deadline time.Time, fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduleLocked(id, name, deadline, fn)
+}
+
+// Extend moves the named deadline of the user by d (negative to shorten it).
+// Returns false if there is no such deadline.
+func (s *Scheduler) Extend(id, name string, d time.Duration) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id][name]
+	if !ok {
+		return false
+	}
+	s.scheduleLocked(id, name, e.deadline.Add(d), e.fn)
+	return true
+}
+
+// Cancel removes the named deadline of the user.
+// Returns false if there was none.
+func (s *Scheduler) Cancel(id, name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id][name]
+	if !ok {
+		return false
+	}
+	s.removeLocked(e)
+	return true
+}
+
+// CancelAll removes every deadline of the user and returns how many there were.
+func (s *Scheduler) CancelAll(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for _, e := range s.entries[id] {
+		s.removeLocked(e)
+		n++
+	}
+	return n
+}
+
+// Deadline returns when the named deadline of the user passes.
+func (s *Scheduler) Deadline(id, name string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[id][name]
+	if !ok {
+		return time.Time{}, false
+	}
+	return e.deadline, true
+}
+
+// Pending returns the number of deadlines waiting to fire.
+func (s *Scheduler) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending
+}
+
+// Stats returns the number of pending and fired deadlines for the status endpoint.
+func (s *Scheduler) Stats() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return map[string]interface{}{
+		"pending": s.pending,
+		"fired":   s.fired,
+		"tick_ms": s.tick.Milliseconds(),
+	}
+}
+
+// Advance moves the wheel up to the clock's current time and runs the
+// deadlines that passed, earliest first. The scheduler's goroutine calls it
+// every tick; tests call it after moving a fake clock.
+func (s *Scheduler) Advance() {
+	s.mu.Lock()
+	now := s.clock.Now()
+	var due []*entry
+	if s.pending == 0 {
+		// Nothing to fire: jump straight to the current tick
+		if n := int(now.Sub(s.last) / s.tick); n > 0 {
+			s.last = s.last.Add(time.Duration(n) * s.tick)
+			s.cursor = (s.cursor + n) % len(s.slots)
+		}
+	}
+	for !s.last.Add(s.tick).After(now) {
+		s.last = s.last.Add(s.tick)
+		s.cursor = (s.cursor + 1) % len(s.slots)
+		for e := range s.slots[s.cursor] {
+			if e.rounds > 0 {
+				e.rounds--
+				continue
+			}
+			s.removeLocked(e)
+			due = append(due, e)
+		}
+	}
+	s.fired += int64(len(due))
+	s.mu.Unlock()
+
+	sort.Slice(due, func(i, j int) bool { return due[i].deadline.Before(due[j].deadline) })
+	for _, e := range due {
+		e.fn()
+	}
+}
+
+// Start runs the wheel on its own goroutine until Stop.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopCh != nil {
+		return
+	}
+	s.stopCh = make(chan struct{})
+	go s.run(s.clock.NewTicker(s.tick), s.stopCh)
+}
+
+// Stop stops the wheel's goroutine. Pending deadlines are kept but don't fire.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopCh != nil {
+		close(s.stopCh)
+		s.stopCh = nil
+	}
+}
+
+// run advances the wheel on every tick of the ticker.
+func (s *Scheduler) run(ticker clock.Ticker, stopCh chan struct{}) {
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C():
+			s.Advance()
+		}
+	}
+}
+
+// scheduleLocked puts a deadline in the slot of the tick it falls in,
+// replacing the user's deadline of the same name. Caller must hold s.mu.
+func (s *Scheduler) scheduleLocked(id, name string, deadline time.Time, fn func()) {
+	if old, ok := s.entries[id][name]; ok {
+		s.removeLocked(old)
+	}
+
+	// Ticks from the last one until the deadline, rounded up; past deadlines fire on the next tick
+	ticks := max(int((deadline.Sub(s.last)+s.tick-1)/s.tick), 1)
+	e := &entry{
+		id:       id,
+		name:     name,
+		deadline: deadline,
+		fn:       fn,
+		slot:     (s.cursor + ticks) % len(s.slots),
+		rounds:   (ticks - 1) / len(s.slots),
+	}
+	s.slots[e.slot][e] = struct{}{}
+	if s.entries[id] == nil {
+		s.entries[id] = make(map[string]*entry)
+	}
+	s.entries[id][name] = e
+	s.pending++
+}
+
+// removeLocked takes a deadline off the wheel. Caller must hold s.mu.
+func (s *Scheduler) removeLocked(e *entry) {
+	delete(s.slots[e.slot], e)
+	byName := s.entries[e.id]
+	if byName[e.name] != e {
+		return
+	}
+	delete(byName, e.name)
+	if len(byName) == 0 {
+		delete(s.entries, e.id)
+	}
+	s.pending--
+}
@@ -0,0 +1,156 @@
+package timeout
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestScheduler returns a scheduler with a 100ms tick over 8 slots on a fake clock.
+func newTestScheduler() (*Scheduler, *clock.Fake) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	s := NewScheduler(100*time.Millisecond, 8)
+	s.SetClock(clk)
+	return s, clk
+}
+
+func TestScheduler_Fire(t *testing.T) {
+	tests := []struct {
+		name  string
+		after time.Duration
+	}{
+		{name: "1ティック以内", after: 50 * time.Millisecond},
+		{name: "ちょうどティック境界", after: 300 * time.Millisecond},
+		{name: "ホイールを何周もする", after: 3 * time.Minute},
+		{name: "過去の期限は次のティック", after: -time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, clk := newTestScheduler()
+			start := clk.Now()
+			var firedAt time.Time
+			s.Schedule("user1", "dino", tt.after, func() { firedAt = clk.Now() })
+
+			for firedAt.IsZero() && clk.Now().Sub(start) < max(tt.after, 0)+time.Second {
+				clk.Advance(10 * time.Millisecond)
+				s.Advance()
+			}
+			require.False(t, firedAt.IsZero(), "期限が発火するべき")
+			deadline := start.Add(max(tt.after, 0))
+			assert.False(t, firedAt.Before(deadline), "期限より前に発火してはいけない")
+			assert.LessOrEqual(t, firedAt.Sub(deadline), s.Tick(), "遅れは1ティック以内")
+			assert.Equal(t, 0, s.Pending())
+		})
+	}
+}
+
+func TestScheduler_NamedDeadlines(t *testing.T) {
+	s, clk := newTestScheduler()
+	var fired []string
+	record := func(name string) func() { return func() { fired = append(fired, name) } }
+
+	s.Schedule("user1", "dino", time.Second, record("user1/dino"))
+	s.Schedule("user1", "register", 2*time.Second, record("user1/register"))
+	s.Schedule("user2", "dino", 500*time.Millisecond, record("user2/dino"))
+	assert.Equal(t, 3, s.Pending())
+
+	// 同じ名前で登録し直すと置き換わる
+	s.Schedule("user2", "dino", 1500*time.Millisecond, record("user2/dino again"))
+	assert.Equal(t, 3, s.Pending())
+
+	// 延長・キャンセル
+	assert.True(t, s.Extend("user1", "dino", 2*time.Second))
+	deadline, ok := s.Deadline("user1", "dino")
+	require.True(t, ok)
+	assert.Equal(t, clk.Now().Add(3*time.Second), deadline)
+	assert.True(t, s.Cancel("user1", "register"))
+	assert.False(t, s.Cancel("user1", "register"))
+	assert.False(t, s.Extend("user3", "dino", time.Second))
+
+	clk.Advance(10 * time.Second)
+	s.Advance()
+	assert.Equal(t, []string{"user2/dino again", "user1/dino"}, fired, "期限の早い順に発火する")
+	assert.Equal(t, 0, s.Pending())
+	assert.Equal(t, int64(2), s.Stats()["fired"])
+}
+
+func TestScheduler_CancelAll(t *testing.T) {
+	s, clk := newTestScheduler()
+	fired := 0
+	s.Schedule("user1", "dino", time.Second, func() { fired++ })
+	s.Schedule("user1", "task:captcha", time.Second, func() { fired++ })
+	s.Schedule("user2", "dino", time.Second, func() { fired++ })
+
+	assert.Equal(t, 2, s.CancelAll("user1"))
+	assert.Equal(t, 0, s.CancelAll("user1"))
+
+	clk.Advance(2 * time.Second)
+	s.Advance()
+	assert.Equal(t, 1, fired)
+}
+
+func TestScheduler_RescheduleFromCallback(t *testing.T) {
+	s, clk := newTestScheduler()
+
+	// コールバックの中から次の期限を登録できる（繰り返しのカウントダウンなど）
+	count := 0
+	var tick func()
+	tick = func() {
+		count++
+		if count < 3 {
+			s.Schedule("user1", "countdown", time.Second, tick)
+		}
+	}
+	s.Schedule("user1", "countdown", time.Second, tick)
+
+	for i := 0; i < 50; i++ {
+		clk.Advance(100 * time.Millisecond)
+		s.Advance()
+	}
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 0, s.Pending())
+}
+
+func TestScheduler_StartStop(t *testing.T) {
+	s := NewScheduler(5*time.Millisecond, 0)
+	s.Start()
+	s.Start()
+	defer s.Stop()
+
+	done := make(chan struct{})
+	s.Schedule("user1", "dino", 20*time.Millisecond, func() { close(done) })
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("期限が発火しなかった")
+	}
+}
+
+func TestScheduler_StartOnClock(t *testing.T) {
+	s, clk := newTestScheduler()
+	s.Start()
+	defer s.Stop()
+
+	// 注入した時計のティッカーでホイールが回る
+	done := make(chan struct{})
+	s.Schedule("user1", "dino", time.Minute, func() { close(done) })
+	clk.BlockUntil(1)
+	clk.Advance(time.Minute + s.Tick())
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("期限が発火しなかった")
+	}
+}
+
+func BenchmarkScheduler_ScheduleCancel(b *testing.B) {
+	s := NewScheduler(DefaultTick, DefaultSlots)
+	for i := 0; i < b.N; i++ {
+		s.Schedule("user", "dino", 3*time.Minute, func() {})
+		s.Cancel("user", "dino")
+	}
+}
@@ -2,11 +2,16 @@
 package token
 
 import (
+	"fmt"
+	"log"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
+	"github.com/kyiku/hackz-ptera-back/internal/timeout"
 )
 
 // TokenExpiry is the duration after which a register token expires.
@@ -17,18 +22,18 @@ type QueueInterface interface {
 	Add(userID string, conn *interface{})
 }
 
-// GenerateRegisterToken generates a new register token for the user.
-// Sets the token and expiration time on the user.
-func GenerateRegisterToken(user *model.User) string {
+// GenerateRegisterToken generates a new register token that expires at exp.
+// Sets the token and expiration time on the user. The caller must hold the user's lock.
+func GenerateRegisterToken(user *model.User, exp time.Time) string {
 	token := uuid.New().String()
 	user.RegisterToken = token
-	user.RegisterTokenExp = time.Now().Add(TokenExpiry)
+	user.RegisterTokenExp = exp
 	return token
 }
 
-// ValidateRegisterToken validates the register token.
-// Returns (valid, errorCode).
-func ValidateRegisterToken(user *model.User, sessionID, token string) (bool, string) {
+// ValidateRegisterToken validates the register token as of now.
+// Returns (valid, errorCode). The caller must hold the user's lock.
+func ValidateRegisterToken(user *model.User, sessionID, token string, now time.Time) (bool, string) {
 	// Check session ID
 	if user.SessionID != sessionID {
 		return false, "INVALID_SESSION"
@@ -40,27 +45,47 @@ func ValidateRegisterToken(user *model.User, sessionID, token string) (bool, str
 	}
 
 	// Check expiration
-	if IsTokenExpired(user) {
+	if IsTokenExpired(user, now) {
 		return false, "TOKEN_EXPIRED"
 	}
 
 	return true, ""
 }
 
-// IsTokenExpired checks if the register token has expired.
-func IsTokenExpired(user *model.User) bool {
+// IsTokenExpired checks if the register token has expired as of now.
+// The caller must hold the user's lock.
+func IsTokenExpired(user *model.User, now time.Time) bool {
 	if user.RegisterTokenExp.IsZero() {
 		return true
 	}
-	return time.Now().After(user.RegisterTokenExp) || time.Now().Equal(user.RegisterTokenExp)
+	return !now.Before(user.RegisterTokenExp)
 }
 
+// Deadline is the name of the register token's deadline on the timeout scheduler.
+const Deadline = "register"
+
 // TokenMonitor monitors register tokens for expiration.
+// Each watched user has one deadline on a timeout.Scheduler, set to the next
+// moment something happens: a countdown tick, a warning or the expiry.
 type TokenMonitor struct {
 	mu            sync.Mutex
-	checkInterval time.Duration
+	checkInterval time.Duration // How often a disconnected user is checked again
+	expiry        time.Duration // Lifetime of tokens issued with Issue
+	countdown     time.Duration // How often a countdown tick is pushed (0 disables)
+	warnings      []time.Duration
+	onExpire      func(user *model.User) error
 	queue         WaitingQueueInterface
-	watchers      map[string]chan struct{} // userID -> stop channel
+	watches       map[string]*watch // userID -> watch
+	scheduler     *timeout.Scheduler
+	ownScheduler  bool // the scheduler was created by the monitor, which starts and stops it
+}
+
+// watch is the state of one watched user. Only the scheduler goroutine
+// touches nextTick and nextWarning.
+type watch struct {
+	user        *model.User
+	nextTick    time.Time // when the next countdown tick is due
+	nextWarning int       // index of the next warning to push
 }
 
 // WaitingQueueInterface defines the queue interface for token monitor.
@@ -68,11 +93,34 @@ type WaitingQueueInterface interface {
 	Add(userID string, conn model.WebSocketConn)
 }
 
-// NewTokenMonitor creates a new token monitor.
+// NewTokenMonitor creates a new token monitor on its own scheduler ticking
+// every checkInterval, started with the first Watch. Use SetScheduler to share one.
 func NewTokenMonitor(checkInterval time.Duration) *TokenMonitor {
 	return &TokenMonitor{
 		checkInterval: checkInterval,
-		watchers:      make(map[string]chan struct{}),
+		expiry:        TokenExpiry,
+		watches:       make(map[string]*watch),
+		scheduler:     timeout.NewScheduler(checkInterval, 0),
+		ownScheduler:  true,
+	}
+}
+
+// SetScheduler runs the monitor's deadlines on a shared scheduler, which the
+// caller starts and stops. Call it before watching anyone.
+func (m *TokenMonitor) SetScheduler(scheduler *timeout.Scheduler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scheduler = scheduler
+	m.ownScheduler = false
+}
+
+// SetClock sets the clock of the monitor's own scheduler, which also dates
+// the tokens issued with Issue. With a shared scheduler, set its clock instead.
+func (m *TokenMonitor) SetClock(c clock.Clock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ownScheduler {
+		m.scheduler.SetClock(c)
 	}
 }
 
@@ -83,36 +131,185 @@ func (m *TokenMonitor) SetQueue(queue WaitingQueueInterface) {
 	m.queue = queue
 }
 
+// SetExpiry sets the lifetime of tokens issued with Issue.
+func (m *TokenMonitor) SetExpiry(expiry time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if expiry > 0 {
+		m.expiry = expiry
+	}
+}
+
+// SetCountdown sets how often a register_countdown tick is pushed (0 disables).
+func (m *TokenMonitor) SetCountdown(interval time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.countdown = interval
+}
+
+// SetWarnings sets the remaining times at which a register_warning is pushed.
+func (m *TokenMonitor) SetWarnings(warnings ...time.Duration) {
+	sorted := append([]time.Duration(nil), warnings...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] > sorted[j] })
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.warnings = sorted
+}
+
+// SetOnExpire sets the function that fails a user whose token expired.
+// It runs with the user's lock held. By default the user is reset to waiting
+// and disconnected.
+func (m *TokenMonitor) SetOnExpire(fn func(user *model.User) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onExpire = fn
+}
+
+// Issue generates a register token for the user, dated by the scheduler's
+// clock, and starts watching it.
+// The caller must hold the user's lock.
+func (m *TokenMonitor) Issue(user *model.User) string {
+	m.mu.Lock()
+	expiry, scheduler := m.expiry, m.scheduler
+	m.mu.Unlock()
+
+	token := GenerateRegisterToken(user, scheduler.Now().Add(expiry))
+	m.Watch(user)
+	return token
+}
+
 // Watch starts monitoring a user's token for expiration.
+// The first check runs on the scheduler's next tick.
 func (m *TokenMonitor) Watch(user *model.User) {
 	m.mu.Lock()
-	stopCh := make(chan struct{})
-	m.watchers[user.ID] = stopCh
+	w := &watch{user: user}
+	m.watches[user.ID] = w
+	scheduler := m.scheduler
+	if m.ownScheduler {
+		scheduler.Start()
+	}
 	m.mu.Unlock()
 
-	go m.watchUser(user, stopCh)
+	scheduler.Schedule(user.ID, Deadline, 0, func() { m.fire(w) })
 }
 
-// watchUser monitors a user's token in the background.
-func (m *TokenMonitor) watchUser(user *model.User, stopCh chan struct{}) {
-	ticker := time.NewTicker(m.checkInterval)
-	defer ticker.Stop()
+// fire checks a watched user and schedules the next check.
+// It runs on the scheduler's goroutine.
+func (m *TokenMonitor) fire(w *watch) {
+	m.mu.Lock()
+	if m.watches[w.user.ID] != w {
+		// Unwatched or watched again in the meantime
+		m.mu.Unlock()
+		return
+	}
+	countdown, warnings, scheduler := m.countdown, m.warnings, m.scheduler
+	m.mu.Unlock()
 
-	for {
-		select {
-		case <-stopCh:
-			return
-		case <-ticker.C:
-			if IsTokenExpired(user) {
-				m.handleExpiration(user)
-				return
-			}
-		}
+	next, done := m.check(w, scheduler.Now(), countdown, warnings)
+	if done {
+		m.remove(w)
+		return
+	}
+	scheduler.ScheduleAt(w.user.ID, Deadline, next, func() { m.fire(w) })
+}
+
+// check pushes the countdown of one user and expires its token.
+// Returns when to check again, or true once the user no longer needs watching.
+func (m *TokenMonitor) check(w *watch, now time.Time, countdown time.Duration, warnings []time.Duration) (time.Time, bool) {
+	user := w.user
+	user.Lock()
+	defer user.Unlock()
+
+	// The token was cleared, e.g. because the user failed a gate
+	if user.RegisterToken == "" {
+		return time.Time{}, true
+	}
+	deadline := user.RegisterTokenExp
+	if !now.Before(deadline) {
+		m.handleExpiration(user)
+		return time.Time{}, true
+	}
+
+	remaining := deadline.Sub(now)
+	remainingSec := int((remaining + time.Second - 1) / time.Second)
+	if user.Conn == nil {
+		// Nothing to push: look again soon, warnings crossed meanwhile are pushed then
+		return earliest(deadline, now.Add(m.checkInterval)), false
+	}
+
+	if countdown > 0 && !now.Before(w.nextTick) {
+		w.nextTick = now.Add(countdown)
+		_ = user.Conn.WriteJSON(map[string]interface{}{
+			"type":          "register_countdown",
+			"remaining_sec": remainingSec,
+			"deadline":      deadline,
+		})
+	}
+
+	// Only the most urgent of the warnings crossed since the last check is pushed
+	crossed := false
+	for w.nextWarning < len(warnings) && remaining <= warnings[w.nextWarning] {
+		w.nextWarning++
+		crossed = true
+	}
+	if crossed {
+		_ = user.Conn.WriteJSON(map[string]interface{}{
+			"type":          "register_warning",
+			"remaining_sec": remainingSec,
+			"message":       warningMessage(remainingSec),
+		})
+	}
+
+	next := deadline
+	if countdown > 0 {
+		next = earliest(next, w.nextTick)
+	}
+	if w.nextWarning < len(warnings) {
+		next = earliest(next, deadline.Add(-warnings[w.nextWarning]))
+	}
+	return next, false
+}
+
+// earliest returns the earlier of two times.
+func earliest(a, b time.Time) time.Time {
+	if b.Before(a) {
+		return b
+	}
+	return a
+}
+
+// warningMessage tells the user how long is left to register.
+func warningMessage(remainingSec int) string {
+	if remainingSec >= 60 {
+		return fmt.Sprintf("登録の制限時間が残り%d分です", remainingSec/60)
+	}
+	return fmt.Sprintf("登録の制限時間が残り%d秒です", remainingSec)
+}
+
+// remove stops watching w, unless the user was watched again in the meantime.
+func (m *TokenMonitor) remove(w *watch) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.watches[w.user.ID] == w {
+		delete(m.watches, w.user.ID)
 	}
 }
 
 // handleExpiration handles token expiration for a user.
+// The caller must hold the user's lock.
 func (m *TokenMonitor) handleExpiration(user *model.User) {
+	m.mu.Lock()
+	onExpire := m.onExpire
+	m.mu.Unlock()
+
+	if onExpire != nil {
+		if err := onExpire(user); err != nil {
+			log.Printf("[TokenMonitor] Failed to expire user %s: %v", user.ID, err)
+		}
+		return
+	}
+
 	// Send notification via WebSocket
 	if user.Conn != nil {
 		_ = user.Conn.WriteJSON(map[string]interface{}{
@@ -133,21 +330,30 @@ func (m *TokenMonitor) handleExpiration(user *model.User) {
 // Unwatch stops monitoring a user's token.
 func (m *TokenMonitor) Unwatch(user *model.User) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
+	delete(m.watches, user.ID)
+	scheduler := m.scheduler
+	m.mu.Unlock()
 
-	if stopCh, ok := m.watchers[user.ID]; ok {
-		close(stopCh)
-		delete(m.watchers, user.ID)
-	}
+	scheduler.Cancel(user.ID, Deadline)
 }
 
-// Stop stops all monitoring.
+// Watching returns the number of watched users.
+func (m *TokenMonitor) Watching() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.watches)
+}
+
+// Stop stops all monitoring, and the scheduler if the monitor created it.
 func (m *TokenMonitor) Stop() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for _, stopCh := range m.watchers {
-		close(stopCh)
+	for id := range m.watches {
+		m.scheduler.Cancel(id, Deadline)
+	}
+	if m.ownScheduler {
+		m.scheduler.Stop()
 	}
-	m.watchers = make(map[string]chan struct{})
+	m.watches = make(map[string]*watch)
 }
@@ -1,13 +1,17 @@
 package token
 
 import (
+	"encoding/json"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/kyiku/hackz-ptera-back/internal/clock"
 	"github.com/kyiku/hackz-ptera-back/internal/model"
 	"github.com/kyiku/hackz-ptera-back/internal/queue"
 	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/kyiku/hackz-ptera-back/internal/timeout"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -33,7 +37,7 @@ func TestRegisterToken_Generate(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			user := &model.User{ID: tt.userID, SessionID: tt.sessionID}
 
-			token := GenerateRegisterToken(user)
+			token := GenerateRegisterToken(user, time.Now().Add(TokenExpiry))
 
 			// トークンが空でないことを確認
 			assert.NotEmpty(t, token)
@@ -55,7 +59,7 @@ func TestRegisterToken_Generate(t *testing.T) {
 func TestRegisterToken_Validate(t *testing.T) {
 	// 有効なユーザーとトークンを作成
 	user := &model.User{ID: "user1", SessionID: "session1"}
-	validToken := GenerateRegisterToken(user)
+	validToken := GenerateRegisterToken(user, time.Now().Add(TokenExpiry))
 
 	tests := []struct {
 		name      string
@@ -96,7 +100,7 @@ func TestRegisterToken_Validate(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			valid, errCode := ValidateRegisterToken(user, tt.sessionID, tt.token)
+			valid, errCode := ValidateRegisterToken(user, tt.sessionID, tt.token, time.Now())
 
 			assert.Equal(t, tt.wantValid, valid)
 			if tt.wantError != "" {
@@ -131,19 +135,37 @@ func TestRegisterToken_Expired(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
 			user := &model.User{
 				ID:               "user1",
 				SessionID:        "session1",
 				RegisterToken:    "test-token",
-				RegisterTokenExp: time.Now().Add(tt.expOffset),
+				RegisterTokenExp: now.Add(tt.expOffset),
 			}
 
-			expired := IsTokenExpired(user)
+			expired := IsTokenExpired(user, now)
 			assert.Equal(t, tt.wantExpired, expired)
 		})
 	}
 }
 
+func TestRegisterToken_ValidateWithClock(t *testing.T) {
+	clk := clock.NewFake(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC))
+	user := &model.User{ID: "user1", SessionID: "session1"}
+	registerToken := GenerateRegisterToken(user, clk.Now().Add(TokenExpiry))
+
+	clk.Advance(TokenExpiry - time.Second)
+	valid, code := ValidateRegisterToken(user, "session1", registerToken, clk.Now())
+	assert.True(t, valid)
+	assert.Empty(t, code)
+
+	// 期限ちょうどで失効する
+	clk.Advance(time.Second)
+	valid, code = ValidateRegisterToken(user, "session1", registerToken, clk.Now())
+	assert.False(t, valid)
+	assert.Equal(t, "TOKEN_EXPIRED", code)
+}
+
 func TestRegisterToken_Monitor(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -228,3 +250,168 @@ func TestRegisterToken_MonitorCancel(t *testing.T) {
 	assert.Error(t, err, "監視キャンセル後は接続が閉じられないべき")
 	assert.False(t, mockConn.GetIsClosed())
 }
+
+func TestRegisterToken_MonitorCountdown(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	user := &model.User{
+		ID:        "user1",
+		SessionID: "session1",
+		Status:    "registering",
+		Conn:      mockConn,
+	}
+
+	monitor := NewTokenMonitor(10 * time.Millisecond)
+	monitor.SetExpiry(300 * time.Millisecond)
+	monitor.SetCountdown(100 * time.Millisecond)
+	monitor.SetWarnings(150*time.Millisecond, 250*time.Millisecond)
+	defer monitor.Stop()
+
+	var expired []string
+	var mu sync.Mutex
+	monitor.SetOnExpire(func(u *model.User) error {
+		mu.Lock()
+		defer mu.Unlock()
+		expired = append(expired, u.ID)
+		u.ResetToWaiting()
+		return nil
+	})
+
+	user.Lock()
+	registerToken := monitor.Issue(user)
+	user.Unlock()
+	assert.NotEmpty(t, registerToken)
+	assert.Equal(t, 1, monitor.Watching())
+
+	// 期限切れでonExpireが1回だけ呼ばれ、監視が終わる
+	err := testutil.WaitFor(time.Second, 10*time.Millisecond, func() bool {
+		return monitor.Watching() == 0
+	})
+	require.NoError(t, err)
+	mu.Lock()
+	assert.Equal(t, []string{"user1"}, expired)
+	mu.Unlock()
+
+	var ticks, warnings int
+	for _, raw := range mockConn.GetMessages() {
+		var msg map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		switch msg["type"] {
+		case "register_countdown":
+			ticks++
+		case "register_warning":
+			warnings++
+			assert.Contains(t, msg["message"], "残り")
+		}
+	}
+	assert.GreaterOrEqual(t, ticks, 2, "カウントダウンが送信されるべき")
+	assert.LessOrEqual(t, ticks, 4)
+	assert.Equal(t, 2, warnings, "警告は閾値ごとに1回")
+}
+
+func TestRegisterToken_MonitorTokenCleared(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	user := &model.User{
+		ID:               "user1",
+		SessionID:        "session1",
+		Status:           "registering",
+		RegisterToken:    "test-token",
+		RegisterTokenExp: time.Now().Add(time.Minute),
+		Conn:             mockConn,
+	}
+
+	monitor := NewTokenMonitor(10 * time.Millisecond)
+	monitor.Watch(user)
+	defer monitor.Stop()
+
+	// 別の理由で待機列に戻ったユーザーは監視対象から外れる
+	user.Lock()
+	user.ResetToWaiting()
+	user.Unlock()
+
+	err := testutil.WaitFor(200*time.Millisecond, 10*time.Millisecond, func() bool {
+		return monitor.Watching() == 0
+	})
+	require.NoError(t, err)
+	assert.False(t, mockConn.GetIsClosed())
+}
+
+func TestRegisterToken_MonitorSharedScheduler(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	scheduler := timeout.NewScheduler(100*time.Millisecond, 64)
+	scheduler.SetClock(clk)
+
+	mockConn := testutil.NewMockWebSocketConn()
+	user := &model.User{
+		ID:               "user1",
+		SessionID:        "session1",
+		Status:           "registering",
+		RegisterToken:    "test-token",
+		RegisterTokenExp: clk.Now().Add(10 * time.Second),
+		Conn:             mockConn,
+	}
+
+	monitor := NewTokenMonitor(time.Second)
+	monitor.SetScheduler(scheduler)
+	monitor.SetCountdown(time.Second)
+	monitor.SetWarnings(5 * time.Second)
+	expired := 0
+	monitor.SetOnExpire(func(u *model.User) error {
+		expired++
+		return nil
+	})
+	monitor.Watch(user)
+
+	// 期限は共有スケジューラーに1件だけ載る
+	assert.Equal(t, 1, scheduler.Pending())
+	_, ok := scheduler.Deadline("user1", Deadline)
+	assert.True(t, ok)
+
+	for i := 0; i < 120; i++ {
+		clk.Advance(100 * time.Millisecond)
+		scheduler.Advance()
+	}
+
+	var ticks, warnings int
+	for _, raw := range mockConn.GetMessages() {
+		var msg map[string]interface{}
+		require.NoError(t, json.Unmarshal(raw, &msg))
+		switch msg["type"] {
+		case "register_countdown":
+			ticks++
+		case "register_warning":
+			warnings++
+			assert.Equal(t, float64(5), msg["remaining_sec"])
+		}
+	}
+	assert.Equal(t, 10, ticks, "1秒ごとにカウントダウン")
+	assert.Equal(t, 1, warnings)
+	assert.Equal(t, 1, expired)
+	assert.Equal(t, 0, monitor.Watching())
+	assert.Equal(t, 0, scheduler.Pending())
+}
+
+func TestRegisterToken_MonitorClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	mockConn := testutil.NewMockWebSocketConn()
+	user := &model.User{ID: "user1", SessionID: "session1", Status: "registering", Conn: mockConn}
+
+	monitor := NewTokenMonitor(time.Second)
+	monitor.SetClock(clk)
+	monitor.SetExpiry(time.Minute)
+	defer monitor.Stop()
+
+	// 発行されたトークンの期限は注入した時計で決まる
+	user.Lock()
+	monitor.Issue(user)
+	assert.Equal(t, clk.Now().Add(time.Minute), user.RegisterTokenExp)
+	user.Unlock()
+
+	clk.Advance(time.Minute - time.Second)
+	assert.False(t, mockConn.GetIsClosed())
+
+	// 時計を進めると実時間を待たずに期限切れになる
+	clk.Advance(2 * time.Second)
+	err := testutil.WaitFor(time.Second, 10*time.Millisecond, mockConn.GetIsClosed)
+	require.NoError(t, err, "トークン期限切れ処理が完了しなかった")
+	assert.Equal(t, 0, monitor.Watching())
+}
@@ -0,0 +1,124 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Close codes sent when a session's connection is replaced or refused.
+// They use the 4000-4999 range reserved for applications.
+const (
+	// CloseSessionTakenOver is sent to the old connection when a newer one takes over the session.
+	CloseSessionTakenOver = 4001
+	// CloseSessionInUse is sent to a new connection refused because the session is already connected.
+	CloseSessionInUse = 4002
+	// CloseWrongQueue is sent to a connection for a queue other than the one its session is bound to.
+	CloseWrongQueue = 4003
+	// CloseSessionExpired is sent when the session janitor evicts an expired session.
+	CloseSessionExpired = 4004
+	// CloseCooldown is sent to a connection refused because its user is serving a rejoin cooldown.
+	CloseCooldown = 4005
+)
+
+// Policy decides what happens when a session connects while it already has a live socket.
+type Policy string
+
+const (
+	// PolicyTakeover closes the old connection and keeps the new one.
+	PolicyTakeover Policy = "takeover"
+	// PolicyReject keeps the old connection and refuses the new one.
+	PolicyReject Policy = "reject"
+)
+
+// ParsePolicy parses a policy name. Empty means PolicyTakeover.
+func ParsePolicy(name string) (Policy, error) {
+	switch Policy(name) {
+	case "", PolicyTakeover:
+		return PolicyTakeover, nil
+	case PolicyReject:
+		return PolicyReject, nil
+	default:
+		return "", fmt.Errorf("websocket: unknown session policy %q", name)
+	}
+}
+
+// ErrSessionInUse is returned by Register when the session already has a
+// live connection and the policy is PolicyReject.
+var ErrSessionInUse = errors.New("websocket: session already connected")
+
+// ClosableConn is a connection that can be closed with a close frame.
+type ClosableConn interface {
+	CloseWithReason(code int, reason string) error
+}
+
+// Registry tracks the single live connection of each session.
+type Registry struct {
+	mu     sync.Mutex
+	policy Policy
+	conns  map[string]ClosableConn // sessionID -> live connection
+}
+
+// NewRegistry creates a new Registry with the given policy.
+func NewRegistry(policy Policy) *Registry {
+	if policy == "" {
+		policy = PolicyTakeover
+	}
+	return &Registry{
+		policy: policy,
+		conns:  make(map[string]ClosableConn),
+	}
+}
+
+// Policy returns the registry's policy.
+func (r *Registry) Policy() Policy {
+	return r.policy
+}
+
+// Register makes conn the live connection of the session.
+// Under PolicyTakeover the previous connection is closed with CloseSessionTakenOver
+// and returned. Under PolicyReject ErrSessionInUse is returned and nothing changes.
+func (r *Registry) Register(sessionID string, conn ClosableConn) (ClosableConn, error) {
+	r.mu.Lock()
+	previous, exists := r.conns[sessionID]
+	if exists && r.policy == PolicyReject {
+		r.mu.Unlock()
+		return nil, ErrSessionInUse
+	}
+	r.conns[sessionID] = conn
+	r.mu.Unlock()
+
+	if exists {
+		_ = previous.CloseWithReason(CloseSessionTakenOver, "session taken over by a new connection")
+	}
+	return previous, nil
+}
+
+// Unregister removes conn if it is still the live connection of the session.
+// Returns false if the session has since been taken over by another connection,
+// in which case the caller must not tear down session state.
+func (r *Registry) Unregister(sessionID string, conn ClosableConn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current, ok := r.conns[sessionID]; !ok || current != conn {
+		return false
+	}
+	delete(r.conns, sessionID)
+	return true
+}
+
+// Get returns the live connection of the session.
+func (r *Registry) Get(sessionID string) (ClosableConn, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	conn, ok := r.conns[sessionID]
+	return conn, ok
+}
+
+// Len returns the number of live connections.
+func (r *Registry) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
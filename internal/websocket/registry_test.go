@@ -0,0 +1,125 @@
+package websocket
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// closeRecorder records the close frame it was sent.
+type closeRecorder struct {
+	mu     sync.Mutex
+	code   int
+	reason string
+}
+
+func (c *closeRecorder) CloseWithReason(code int, reason string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.code = code
+	c.reason = reason
+	return nil
+}
+
+func (c *closeRecorder) Code() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.code
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    Policy
+		wantErr bool
+	}{
+		{name: "正常系: 空はtakeover", input: "", want: PolicyTakeover},
+		{name: "正常系: takeover", input: "takeover", want: PolicyTakeover},
+		{name: "正常系: reject", input: "reject", want: PolicyReject},
+		{name: "異常系: 不明なポリシー", input: "kick", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePolicy(tt.input)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRegistry_Takeover(t *testing.T) {
+	r := NewRegistry(PolicyTakeover)
+	old := &closeRecorder{}
+	newer := &closeRecorder{}
+
+	previous, err := r.Register("session1", old)
+	require.NoError(t, err)
+	assert.Nil(t, previous)
+
+	previous, err = r.Register("session1", newer)
+	require.NoError(t, err)
+	assert.Equal(t, old, previous)
+
+	// 古い接続は理由コード付きで閉じられる
+	assert.Equal(t, CloseSessionTakenOver, old.Code())
+	assert.Equal(t, 0, newer.Code())
+
+	current, ok := r.Get("session1")
+	require.True(t, ok)
+	assert.Equal(t, newer, current)
+
+	// 古い接続の切断処理はセッションを消さない
+	assert.False(t, r.Unregister("session1", old))
+	assert.Equal(t, 1, r.Len())
+
+	assert.True(t, r.Unregister("session1", newer))
+	assert.Equal(t, 0, r.Len())
+}
+
+func TestRegistry_Reject(t *testing.T) {
+	r := NewRegistry(PolicyReject)
+	old := &closeRecorder{}
+	newer := &closeRecorder{}
+
+	_, err := r.Register("session1", old)
+	require.NoError(t, err)
+
+	_, err = r.Register("session1", newer)
+	assert.ErrorIs(t, err, ErrSessionInUse)
+
+	// 既存の接続はそのまま
+	assert.Equal(t, 0, old.Code())
+	current, _ := r.Get("session1")
+	assert.Equal(t, old, current)
+
+	// 切断後は新しい接続を受け付ける
+	assert.True(t, r.Unregister("session1", old))
+	_, err = r.Register("session1", newer)
+	assert.NoError(t, err)
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	r := NewRegistry(PolicyTakeover)
+	var wg sync.WaitGroup
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := &closeRecorder{}
+			_, _ = r.Register("session1", conn)
+			r.Unregister("session1", conn)
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, r.Len(), 1)
+}
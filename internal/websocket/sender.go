@@ -0,0 +1,167 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// TextMessage is the WebSocket text message type (same value as gorilla/websocket).
+const TextMessage = 1
+
+// DefaultSendBuffer is the default number of messages queued per connection.
+const DefaultSendBuffer = 32
+
+// DefaultWriteTimeout is the default deadline for a single write.
+const DefaultWriteTimeout = 10 * time.Second
+
+// ErrClosed is returned when writing to a closed or evicted Sender.
+var ErrClosed = errors.New("websocket: sender closed")
+
+// ErrSlowConsumer is returned when a message is dropped because the client
+// fell too far behind and was evicted.
+var ErrSlowConsumer = errors.New("websocket: slow consumer evicted")
+
+// Conn is the low-level connection written by a Sender.
+// gorilla/websocket.Conn satisfies this interface.
+type Conn interface {
+	WriteMessage(messageType int, data []byte) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// outbound is a message waiting to be written.
+type outbound struct {
+	messageType int
+	data        []byte
+}
+
+// Sender queues outgoing messages for one connection and writes them from
+// its own goroutine, so callers never block on network I/O.
+// A client whose queue fills up is evicted and its connection closed.
+type Sender struct {
+	conn         Conn
+	writeTimeout time.Duration
+	sendCh       chan outbound
+	done         chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	evicted bool
+}
+
+// NewSender creates a Sender with the default buffer size and write timeout.
+func NewSender(conn Conn) *Sender {
+	return NewSenderWithOptions(conn, DefaultSendBuffer, DefaultWriteTimeout)
+}
+
+// NewSenderWithOptions creates a Sender with the given buffer size and write timeout.
+func NewSenderWithOptions(conn Conn, bufferSize int, writeTimeout time.Duration) *Sender {
+	if bufferSize < 1 {
+		bufferSize = DefaultSendBuffer
+	}
+	s := &Sender{
+		conn:         conn,
+		writeTimeout: writeTimeout,
+		sendCh:       make(chan outbound, bufferSize),
+		done:         make(chan struct{}),
+	}
+	go s.writeLoop()
+	return s
+}
+
+// WriteMessage queues a message with the given type.
+func (s *Sender) WriteMessage(messageType int, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	select {
+	case s.sendCh <- outbound{messageType: messageType, data: data}:
+		return nil
+	default:
+		// The client can't keep up - drop it rather than buffer without bound
+		s.evictLocked()
+		return ErrSlowConsumer
+	}
+}
+
+// WriteJSON queues a JSON text message.
+func (s *Sender) WriteJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.WriteMessage(TextMessage, data)
+}
+
+// Close stops accepting messages. Messages already queued are still written
+// before the underlying connection is closed.
+func (s *Sender) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.sendCh)
+	return nil
+}
+
+// Done returns a channel that is closed once the underlying connection is closed.
+func (s *Sender) Done() <-chan struct{} {
+	return s.done
+}
+
+// Evicted returns whether the connection was dropped as a slow consumer.
+func (s *Sender) Evicted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.evicted
+}
+
+// Pending returns the number of queued messages not yet written.
+func (s *Sender) Pending() int {
+	return len(s.sendCh)
+}
+
+// evictLocked drops queued messages and closes the connection right away.
+// Caller must hold s.mu.
+func (s *Sender) evictLocked() {
+	log.Printf("[Sender] Evicting slow consumer (%d messages pending)", len(s.sendCh))
+	s.evicted = true
+	s.closed = true
+	close(s.sendCh)
+	// Closing the connection also unblocks a write stuck on the network
+	_ = s.conn.Close()
+}
+
+// writeLoop writes queued messages until the queue is closed or a write fails.
+func (s *Sender) writeLoop() {
+	defer close(s.done)
+	defer s.conn.Close()
+
+	for msg := range s.sendCh {
+		if s.Evicted() {
+			return
+		}
+		if s.writeTimeout > 0 {
+			_ = s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+		}
+		if err := s.conn.WriteMessage(msg.messageType, msg.data); err != nil {
+			s.mu.Lock()
+			if !s.closed {
+				s.closed = true
+				close(s.sendCh)
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
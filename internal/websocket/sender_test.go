@@ -0,0 +1,167 @@
+package websocket
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kyiku/hackz-ptera-back/internal/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingConn is a Conn whose writes block until released or closed.
+type blockingConn struct {
+	mu      sync.Mutex
+	release chan struct{}
+	closed  chan struct{}
+	once    sync.Once
+	writes  int
+}
+
+func newBlockingConn() *blockingConn {
+	return &blockingConn{
+		release: make(chan struct{}),
+		closed:  make(chan struct{}),
+	}
+}
+
+func (c *blockingConn) WriteMessage(int, []byte) error {
+	select {
+	case <-c.release:
+		c.mu.Lock()
+		c.writes++
+		c.mu.Unlock()
+		return nil
+	case <-c.closed:
+		return errors.New("closed")
+	}
+}
+
+func (c *blockingConn) SetWriteDeadline(time.Time) error { return nil }
+
+func (c *blockingConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *blockingConn) IsClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestSender_WritesInOrder(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	s := NewSender(mockConn)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, s.WriteJSON(map[string]interface{}{"type": "queueUpdate", "position": i}))
+	}
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(mockConn.GetMessages()) == 5
+	})
+	require.NoError(t, err)
+
+	msg := mockConn.GetLastMessageAsMap()
+	assert.Equal(t, float64(4), msg["position"])
+	assert.False(t, mockConn.GetIsClosed())
+}
+
+func TestSender_CloseFlushesQueued(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	s := NewSender(mockConn)
+
+	// 失敗通知の直後に切断しても通知は届く
+	require.NoError(t, s.WriteJSON(map[string]interface{}{"type": "failure"}))
+	require.NoError(t, s.Close())
+
+	select {
+	case <-s.Done():
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("sender did not close")
+	}
+
+	msg := mockConn.GetLastMessageAsMap()
+	require.NotNil(t, msg)
+	assert.Equal(t, "failure", msg["type"])
+	assert.True(t, mockConn.GetIsClosed())
+
+	assert.ErrorIs(t, s.WriteJSON(map[string]interface{}{"type": "late"}), ErrClosed)
+}
+
+func TestSender_NeverBlocksCaller(t *testing.T) {
+	conn := newBlockingConn()
+	s := NewSenderWithOptions(conn, 4, time.Second)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			_ = s.WriteJSON(map[string]interface{}{"n": i})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("WriteJSON blocked on a stalled connection")
+	}
+}
+
+func TestSender_EvictsSlowConsumer(t *testing.T) {
+	conn := newBlockingConn()
+	s := NewSenderWithOptions(conn, 2, time.Second)
+
+	var lastErr error
+	for i := 0; i < 10 && lastErr == nil; i++ {
+		lastErr = s.WriteJSON(map[string]interface{}{"n": i})
+	}
+
+	assert.ErrorIs(t, lastErr, ErrSlowConsumer)
+	assert.True(t, s.Evicted())
+	assert.True(t, conn.IsClosed())
+
+	select {
+	case <-s.Done():
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("writer goroutine did not exit after eviction")
+	}
+}
+
+func TestSender_WriteErrorStopsSender(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	mockConn.WriteErr = errors.New("broken pipe")
+	s := NewSender(mockConn)
+
+	_ = s.WriteJSON(map[string]interface{}{"type": "queueUpdate"})
+
+	select {
+	case <-s.Done():
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("sender did not stop after write error")
+	}
+	assert.True(t, mockConn.GetIsClosed())
+	assert.ErrorIs(t, s.WriteJSON(map[string]interface{}{"type": "queueUpdate"}), ErrClosed)
+}
+
+func TestSender_SetsWriteDeadline(t *testing.T) {
+	mockConn := testutil.NewMockWebSocketConn()
+	s := NewSenderWithOptions(mockConn, 4, time.Minute)
+
+	require.NoError(t, s.WriteJSON(map[string]interface{}{"type": "pong"}))
+
+	err := testutil.WaitFor(500*time.Millisecond, 5*time.Millisecond, func() bool {
+		return len(mockConn.GetMessages()) == 1
+	})
+	require.NoError(t, err)
+
+	s.Close()
+	<-s.Done()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), mockConn.Deadline, 5*time.Second)
+}